@@ -0,0 +1,103 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+)
+
+// executarAvaliacao roda o extrator sobre um diretório de fixtures e imprime as métricas.
+// Uso: go run . avaliar -dir fixtures [-prompt prompt.txt] [-modelo gpt-4o] [-json relatorio.json]
+func executarAvaliacao(args []string) int {
+	flags := flag.NewFlagSet("avaliar", flag.ContinueOnError)
+	dir := flags.String("dir", "", "diretório com os PDFs/XMLs e as respostas esperadas <nome>.json")
+	promptArquivo := flags.String("prompt", "", "arquivo com um prompt de sistema alternativo")
+	modelo := flags.String("modelo", "", "modelo da OpenAI (padrão: OPENAI_MODEL ou gpt-4o)")
+	precoEntrada := flags.Float64("preco-entrada", 2.50, "US$ por milhão de tokens de entrada")
+	precoSaida := flags.Float64("preco-saida", 10.00, "US$ por milhão de tokens de saída")
+	saidaJSON := flags.String("json", "", "grava o relatório completo em JSON neste arquivo")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "informe o diretório de fixtures com -dir")
+		return 2
+	}
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		fmt.Fprintln(os.Stderr, "aviso: OPENAI_API_KEY não configurada; apenas XMLs poderão ser extraídos")
+	}
+
+	extrator := handlers.NovoExtratorOpenAI(apiKey)
+	if *modelo != "" {
+		extrator.Modelo = *modelo
+	}
+	if *promptArquivo != "" {
+		prompt, err := os.ReadFile(*promptArquivo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "erro ao ler prompt: %v\n", err)
+			return 1
+		}
+		extrator.PromptSistema = string(prompt)
+	}
+
+	// Ctrl+C interrompe a extração em andamento e remove os arquivos temporários
+	ctx, parar := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer parar()
+
+	relatorio, err := handlers.AvaliarExtracao(ctx, handlers.OpcoesAvaliacao{
+		Diretorio:    *dir,
+		Extrator:     extrator,
+		PrecoEntrada: *precoEntrada,
+		PrecoSaida:   *precoSaida,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro na avaliação: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Extrator: %s\n\n", relatorio.Versao)
+	fmt.Fprintln(w, "ARQUIVO\tNOTAS\tEXATO\tLATÊNCIA\tCUSTO (US$)\tERRO")
+	for _, arquivo := range relatorio.Arquivos {
+		fmt.Fprintf(w, "%s\t%d/%d\t%v\t%s\t%.4f\t%s\n", arquivo.Arquivo, arquivo.Extraidas, arquivo.Esperadas, arquivo.Exato, arquivo.Duracao.Round(1e6), arquivo.Custo, arquivo.Erro)
+		for _, divergencia := range arquivo.Divergencia {
+			fmt.Fprintf(w, "  - %s\t\t\t\t\t\n", divergencia)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CAMPO\tPRECISÃO\tACERTO EXATO\tCORRETOS/ESPERADOS")
+	for _, campo := range relatorio.Campos {
+		precisao := "n/d"
+		if campo.Preenchidos > 0 {
+			precisao = fmt.Sprintf("%.1f%%", campo.Precisao*100)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%d/%d\n", campo.Campo, precisao, campo.TaxaAcerto*100, campo.Corretos, campo.Esperados)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Documentos:\t%d (%d com falha)\n", relatorio.Documentos, relatorio.Falhas)
+	fmt.Fprintf(w, "Documentos exatos:\t%.1f%%\n", relatorio.TaxaExata*100)
+	fmt.Fprintf(w, "Tokens:\t%d entrada / %d saída\n", relatorio.TokensEntrada, relatorio.TokensSaida)
+	fmt.Fprintf(w, "Custo total:\tUS$ %.4f\n", relatorio.CustoTotal)
+	fmt.Fprintf(w, "Latência:\tmédia %s / p95 %s / máx %s\n", relatorio.LatenciaMedia.Round(1e6), relatorio.LatenciaP95.Round(1e6), relatorio.LatenciaMaxima.Round(1e6))
+	w.Flush()
+
+	if *saidaJSON != "" {
+		conteudo, err := json.MarshalIndent(relatorio, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*saidaJSON, conteudo, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "erro ao gravar relatório JSON: %v\n", err)
+			return 1
+		}
+	}
+
+	return 0
+}
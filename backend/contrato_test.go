@@ -0,0 +1,607 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestContrato confere a especificação handlers/openapi.json com a API, para que go test
+// falhe quando as rotas, os handlers ou as respostas divergirem dela:
+//   - as rotas registradas no Gin e as operações documentadas coincidem, com o mesmo handler (x-handler),
+//     tanto em /api/v1 quanto nas rotas legadas sem prefixo;
+//   - todas as referências ($ref) da especificação existem;
+//   - um roteiro de requisições, executado com repositório e arquivos temporários, só recebe
+//     status e corpos previstos na especificação (campos ausentes ou não documentados divergem),
+//     com o X-Request-ID em todas as respostas;
+//   - o cliente gerado para o frontend está atualizado.
+//
+// Com go test -v, mostra cada requisição e o log dos handlers.
+func TestContrato(t *testing.T) {
+	spec, err := carregarEspecificacao()
+	if err != nil {
+		t.Fatalf("erro ao ler a especificação: %v", err)
+	}
+	v := &verificacaoContrato{spec: spec, detalhado: testing.Verbose(), exercitadas: map[string]bool{}}
+
+	t.Run("referencias", func(t *testing.T) {
+		for _, ref := range spec.referenciasQuebradas() {
+			v.divergir("referência inexistente: %s", ref)
+		}
+		v.relatar(t)
+	})
+	t.Run("operacoes", func(t *testing.T) {
+		v.conferirOperacoes()
+		v.relatar(t)
+	})
+	t.Run("cliente", func(t *testing.T) {
+		v.conferirCliente(caminhoClientePadrao)
+		v.relatar(t)
+	})
+	t.Run("roteiro", func(t *testing.T) {
+		v.executarRoteiro(t)
+		v.relatar(t)
+
+		var naoExercitadas []string
+		spec.operacoes(func(caminho, metodo string, _ *operacaoAPI) {
+			if !v.exercitadas[strings.ToUpper(metodo)+" "+caminho] {
+				naoExercitadas = append(naoExercitadas, strings.ToUpper(metodo)+" "+caminho)
+			}
+		})
+		if len(naoExercitadas) > 0 {
+			t.Logf("Operações sem requisição no roteiro: %s", strings.Join(naoExercitadas, ", "))
+		}
+		t.Logf("%d rotas, %d requisições", v.rotas, v.requisicoes)
+	})
+}
+
+// verificacaoContrato acumula as divergências encontradas
+type verificacaoContrato struct {
+	spec         *especificacao
+	router       http.Handler
+	detalhado    bool
+	divergencias []string
+	rotas        int
+	requisicoes  int
+	// exercitadas são as operações ("MÉTODO /caminho/{param}") com alguma requisição no roteiro
+	exercitadas map[string]bool
+	// tokenAdmin é enviado em Authorization nas requisições; vazio, a requisição vai sem token
+	tokenAdmin string
+}
+
+func (v *verificacaoContrato) divergir(formato string, args ...any) {
+	v.divergencias = append(v.divergencias, fmt.Sprintf(formato, args...))
+}
+
+// relatar reporta as divergências acumuladas como falhas do teste e as descarta
+func (v *verificacaoContrato) relatar(t *testing.T) {
+	t.Helper()
+	for _, divergencia := range v.divergencias {
+		t.Error(divergencia)
+	}
+	v.divergencias = nil
+}
+
+// parametroGin encontra os parâmetros de uma rota do Gin (:id ou *arquivo)
+var parametroGin = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// conferirOperacoes confere a consistência interna das operações documentadas
+func (v *verificacaoContrato) conferirOperacoes() {
+	ids := map[string]string{}
+	v.spec.operacoes(func(caminho, metodo string, op *operacaoAPI) {
+		nome := strings.ToUpper(metodo) + " " + caminho
+		switch outro, repetido := ids[op.OperationID]; {
+		case op.OperationID == "":
+			v.divergir("%s: sem operationId", nome)
+		case repetido:
+			v.divergir("%s: operationId %q repetido em %s", nome, op.OperationID, outro)
+		}
+		ids[op.OperationID] = nome
+		if op.Handler == "" {
+			v.divergir("%s: sem x-handler", nome)
+		}
+		if len(op.Responses) == 0 {
+			v.divergir("%s: sem respostas", nome)
+		}
+
+		noCaminho := map[string]bool{}
+		for _, m := range parametroCaminho.FindAllStringSubmatch(caminho, -1) {
+			noCaminho[m[1]] = true
+		}
+		declarados := map[string]bool{}
+		for _, p := range op.Parameters {
+			p = v.spec.parametro(p)
+			if p.In != "path" {
+				continue
+			}
+			declarados[p.Name] = true
+			if !noCaminho[p.Name] {
+				v.divergir("%s: parâmetro de caminho %q fora do caminho", nome, p.Name)
+			}
+		}
+		for parametro := range noCaminho {
+			if !declarados[parametro] {
+				v.divergir("%s: parâmetro de caminho %q não declarado", nome, parametro)
+			}
+		}
+	})
+}
+
+// conferirRotas compara as rotas registradas no Gin com as operações documentadas. Cada
+// operação deve estar registrada com o prefixo da especificação e, para clientes antigos, sem ele.
+func (v *verificacaoContrato) conferirRotas(rotas gin.RoutesInfo) {
+	registradas := map[string]bool{}
+	for _, rota := range rotas {
+		caminho := parametroGin.ReplaceAllString(rota.Path, "{$1}")
+		if semPrefixo, ok := strings.CutPrefix(caminho, v.spec.base()+"/"); ok {
+			caminho = "/" + semPrefixo
+		} else {
+			registradas["legada "+rota.Method+" "+caminho] = true
+		}
+		nome := rota.Method + " " + caminho
+		registradas[nome] = true
+		v.rotas++
+
+		op := v.spec.Paths[caminho][strings.ToLower(rota.Method)]
+		if op == nil {
+			v.divergir("rota %s não documentada", nome)
+			continue
+		}
+		if handler := rota.Handler[strings.LastIndex(rota.Handler, ".")+1:]; handler != op.Handler {
+			v.divergir("rota %s: handler %s, documentado %s", nome, handler, op.Handler)
+		}
+	}
+	v.spec.operacoes(func(caminho, metodo string, _ *operacaoAPI) {
+		nome := strings.ToUpper(metodo) + " " + caminho
+		if !registradas[nome] {
+			v.divergir("operação %s documentada sem rota", nome)
+		}
+		if !registradas["legada "+nome] {
+			v.divergir("operação %s sem a rota legada, sem o prefixo %s", nome, v.spec.base())
+		}
+	})
+}
+
+// conferirCliente confere se o cliente gerado corresponde à especificação atual
+func (v *verificacaoContrato) conferirCliente(caminho string) {
+	atual, err := os.ReadFile(caminho)
+	if errors.Is(err, os.ErrNotExist) {
+		v.divergir("cliente %s não encontrado: execute `go run . gerar-cliente`", caminho)
+		return
+	}
+	if err != nil {
+		v.divergir("erro ao ler o cliente %s: %v", caminho, err)
+		return
+	}
+	if !bytes.Equal(atual, gerarCliente(v.spec)) {
+		v.divergir("cliente %s desatualizado: execute `go run . gerar-cliente`", caminho)
+	}
+}
+
+// operacao encontra a operação documentada para a requisição, preferindo os caminhos com
+// mais segmentos fixos (/notas-fiscais/duplicadas antes de /notas-fiscais/{id})
+func (v *verificacaoContrato) operacao(metodo, caminho string) (string, *operacaoAPI) {
+	segmentos := strings.Split(caminho, "/")
+	melhor, melhorFixos := "", -1
+	for modelo, operacoes := range v.spec.Paths {
+		if _, ok := operacoes[strings.ToLower(metodo)]; !ok {
+			continue
+		}
+		partes := strings.Split(modelo, "/")
+		if len(partes) != len(segmentos) {
+			continue
+		}
+		fixos := 0
+		for i, parte := range partes {
+			if parametroCaminho.MatchString(parte) {
+				continue
+			}
+			if parte != segmentos[i] {
+				fixos = -1
+				break
+			}
+			fixos++
+		}
+		if fixos > melhorFixos {
+			melhor, melhorFixos = modelo, fixos
+		}
+	}
+	if melhor == "" {
+		return "", nil
+	}
+	return melhor, v.spec.Paths[melhor][strings.ToLower(metodo)]
+}
+
+// corpoRequisicao é o corpo de uma requisição do roteiro
+type corpoRequisicao struct {
+	dados []byte
+	tipo  string
+}
+
+func corpoJSON(valor any) *corpoRequisicao {
+	dados, _ := json.Marshal(valor)
+	return &corpoRequisicao{dados: dados, tipo: "application/json"}
+}
+
+// arquivoFormulario é um arquivo enviado em um formulário multipart
+type arquivoFormulario struct {
+	campo, nome string
+	conteudo    []byte
+}
+
+func corpoFormulario(campos map[string]string, arquivos ...arquivoFormulario) *corpoRequisicao {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	nomes := make([]string, 0, len(campos))
+	for nome := range campos {
+		nomes = append(nomes, nome)
+	}
+	sort.Strings(nomes)
+	for _, nome := range nomes {
+		w.WriteField(nome, campos[nome])
+	}
+	for _, arquivo := range arquivos {
+		parte, _ := w.CreateFormFile(arquivo.campo, arquivo.nome)
+		parte.Write(arquivo.conteudo)
+	}
+	w.Close()
+	return &corpoRequisicao{dados: buf.Bytes(), tipo: w.FormDataContentType()}
+}
+
+// requisitar executa um passo do roteiro e confere a resposta com a especificação. Retorna o
+// corpo JSON decodificado (nil se não for JSON), para os passos seguintes usarem os IDs criados.
+// Os caminhos do roteiro são os da especificação, sem o prefixo.
+func (v *verificacaoContrato) requisitar(metodo, caminho string, corpo *corpoRequisicao, esperado int) any {
+	w := v.executar(metodo, v.spec.base()+caminho, corpo)
+	descricao := metodo + " " + caminho
+	modelo, op := v.operacao(metodo, strings.SplitN(caminho, "?", 2)[0])
+	if op == nil {
+		v.divergir("%s: operação não documentada", descricao)
+		return nil
+	}
+	v.exercitadas[metodo+" "+modelo] = true
+
+	// Corpos inválidos de propósito só são enviados nos passos que esperam erro
+	if corpo != nil && corpo.tipo == "application/json" && op.RequestBody != nil && esperado < 300 {
+		var enviado any
+		json.Unmarshal(corpo.dados, &enviado)
+		for _, divergencia := range v.spec.validar(op.RequestBody.Content["application/json"].Schema, enviado, "$") {
+			v.divergir("%s: corpo da requisição: %s", descricao, divergencia)
+		}
+	}
+	if w.Code != esperado {
+		v.divergir("%s: status %d, esperado %d: %s", descricao, w.Code, esperado, strings.TrimSpace(w.Body.String()))
+	}
+	return v.conferirResposta(descricao, op, w)
+}
+
+// executar envia a requisição ao roteador
+func (v *verificacaoContrato) executar(metodo, caminho string, corpo *corpoRequisicao) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(metodo, caminho, http.NoBody)
+	if corpo != nil {
+		req = httptest.NewRequest(metodo, caminho, bytes.NewReader(corpo.dados))
+		req.Header.Set("Content-Type", corpo.tipo)
+	}
+	req.Header.Set("X-Usuario", "teste-contrato")
+	if v.tokenAdmin != "" {
+		req.Header.Set("Authorization", "Bearer "+v.tokenAdmin)
+	}
+	w := httptest.NewRecorder()
+	v.router.ServeHTTP(w, req)
+	v.requisicoes++
+	if v.detalhado {
+		fmt.Printf("%s %s -> %d\n", metodo, caminho, w.Code)
+	}
+	return w
+}
+
+// conferirLegada confere uma requisição de erro à rota sem prefixo: o erro mantém o formato
+// anterior ({"error": "mensagem"}) e a resposta aponta a rota substituta
+func (v *verificacaoContrato) conferirLegada(metodo, caminho string, corpo *corpoRequisicao, esperado int) {
+	w := v.executar(metodo, caminho, corpo)
+	descricao := metodo + " " + caminho + " (legada)"
+	if w.Code != esperado {
+		v.divergir("%s: status %d, esperado %d: %s", descricao, w.Code, esperado, strings.TrimSpace(w.Body.String()))
+	}
+	var resposta struct {
+		Erro any `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resposta); err != nil {
+		v.divergir("%s: resposta não é JSON válido: %v", descricao, err)
+	} else if _, ok := resposta.Erro.(string); !ok {
+		v.divergir("%s: error deveria ser a mensagem, veio %s", descricao, tipoJSON(resposta.Erro))
+	}
+	if w.Header().Get("Deprecation") == "" || !strings.Contains(w.Header().Get("Link"), v.spec.base()+caminho) {
+		v.divergir("%s: sem os cabeçalhos Deprecation e Link para a rota substituta", descricao)
+	}
+}
+
+// conferirResposta confere status, Content-Type e corpo da resposta com a operação
+func (v *verificacaoContrato) conferirResposta(descricao string, op *operacaoAPI, w *httptest.ResponseRecorder) any {
+	requestID := w.Header().Get(handlers.CabecalhoRequestID)
+	if requestID == "" {
+		v.divergir("%s: sem o cabeçalho %s", descricao, handlers.CabecalhoRequestID)
+	}
+	resposta, ok := op.Responses[strconv.Itoa(w.Code)]
+	if !ok {
+		resposta, ok = op.Responses[fmt.Sprintf("%dXX", w.Code/100)]
+	}
+	if !ok {
+		resposta, ok = op.Responses["default"]
+	}
+	if !ok {
+		v.divergir("%s: status %d não documentado", descricao, w.Code)
+		return nil
+	}
+	resposta = v.spec.resposta(resposta)
+
+	if len(resposta.Content) == 0 {
+		if w.Body.Len() > 0 {
+			v.divergir("%s: resposta %d com corpo, documentada sem conteúdo", descricao, w.Code)
+		}
+		return nil
+	}
+	tipo, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	midia, ok := resposta.Content[tipo]
+	if !ok {
+		v.divergir("%s: Content-Type %q não documentado para o status %d", descricao, tipo, w.Code)
+		return nil
+	}
+	if tipo != "application/json" {
+		return nil
+	}
+
+	var corpo any
+	if err := json.Unmarshal(w.Body.Bytes(), &corpo); err != nil {
+		v.divergir("%s: resposta não é JSON válido: %v", descricao, err)
+		return nil
+	}
+	for _, divergencia := range v.spec.validar(midia.Schema, corpo, "$") {
+		v.divergir("%s (%d): %s", descricao, w.Code, divergencia)
+	}
+	if w.Code >= 400 && campoJSON(corpo, "error", "request_id") != requestID {
+		v.divergir("%s: request_id do erro difere do cabeçalho %s", descricao, handlers.CabecalhoRequestID)
+	}
+	return corpo
+}
+
+// campoJSON percorre o JSON decodificado por chaves de objeto e índices de lista
+func campoJSON(valor any, caminho ...any) string {
+	for _, passo := range caminho {
+		switch passo := passo.(type) {
+		case string:
+			m, _ := valor.(map[string]any)
+			valor = m[passo]
+		case int:
+			lista, _ := valor.([]any)
+			if passo >= len(lista) {
+				return ""
+			}
+			valor = lista[passo]
+		}
+	}
+	texto, _ := valor.(string)
+	return texto
+}
+
+// executarRoteiro prepara um ambiente temporário (repositório SQLite, arquivos e coleções em
+// um diretório descartável, sem chave da OpenAI) e executa as requisições do roteiro
+func (v *verificacaoContrato) executarRoteiro(t *testing.T) {
+	// As coleções JSON (contratos, webhooks) usam caminhos relativos a uploads/
+	t.Chdir(t.TempDir())
+
+	// Sem a chave, nenhuma requisição chega à OpenAI
+	t.Setenv("OPENAI_API_KEY", "")
+	v.tokenAdmin = "teste-contrato"
+	t.Setenv("ADMIN_TOKEN", v.tokenAdmin)
+	if !v.detalhado {
+		log.SetOutput(io.Discard)
+		t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	}
+	gin.SetMode(gin.ReleaseMode)
+	gin.DefaultWriter = io.Discard
+
+	ctx, cancelar := context.WithCancel(context.Background())
+	t.Cleanup(cancelar)
+
+	handlers.ConfigurarCofre(nil)
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := handlers.NovoRepositorioSQLite(filepath.Join("uploads", "notas.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	handlers.ConfigurarRepositorio(repo)
+	arquivos, err := handlers.NovoBlobLocal(filepath.Join("uploads", "arquivos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.ConfigurarBlobStore(arquivos)
+
+	// O receptor local está em loopback, recusado sem PermitirRedePrivada
+	receptor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(receptor.Close)
+	if err := handlers.IniciarWebhooks(ctx, handlers.ConfigWebhooks{MaxTentativas: 1, EsperaInicial: time.Second, EsperaMaxima: time.Second, Timeout: time.Second, PermitirRedePrivada: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := novoRoteador(ctx)
+	v.router = router
+	v.conferirRotas(router.Routes())
+
+	notas, chave, err := semearNotas(ctx, repo, arquivos)
+	if err != nil {
+		t.Fatalf("erro ao preparar o roteiro: %v", err)
+	}
+	v.roteiro(notas, chave, receptor.URL)
+}
+
+// semearNotas grava duas notas do mesmo titular, cada uma com o seu PDF, para os passos que
+// consultam notas; retorna os IDs e a chave do PDF da primeira
+func semearNotas(ctx context.Context, repo handlers.NotaFiscalRepository, arquivos handlers.BlobStore) ([]string, string, error) {
+	competencia, _ := handlers.ParseCompetencia("09/2026")
+	var ids, chaves []string
+	for i, numero := range []string{"1001", "1002"} {
+		// Cada nota tem o seu PDF: o repositório rejeita notas ativas com o mesmo arquivo
+		pdf := []byte("%PDF-1.4\n% teste-contrato " + numero + "\n%%EOF\n")
+		soma := sha256.Sum256(pdf)
+		hash := hex.EncodeToString(soma[:])
+		chave := hash + ".pdf"
+		if err := arquivos.Gravar(ctx, chave, bytes.NewReader(pdf), int64(len(pdf)), "application/pdf"); err != nil {
+			return nil, "", err
+		}
+
+		dataNota, _ := handlers.ParseData(fmt.Sprintf("%02d/09/2026", 10+i))
+		nota := handlers.NotaFiscalData{
+			ID:             fmt.Sprintf("%032x", i+1),
+			Email:          "titular@exemplo.com",
+			NumeroNota:     numero,
+			Serie:          "1",
+			Competencia:    competencia,
+			Prestador:      "Prestador Exemplo LTDA",
+			CNPJ:           "12.345.678/0001-90",
+			ValorServicos:  1000 * float64(i+1),
+			DataNota:       dataNota,
+			ISSRetido:      50,
+			Arquivo:        chave,
+			HashArquivo:    hash,
+			NomeArquivo:    "nota-" + numero + ".pdf",
+			VersaoExtrator: "teste-contrato",
+			Status:         handlers.StatusPendente,
+			CriadoEm:       time.Now(),
+		}
+		evento := handlers.EventoAuditoria{NotaID: nota.ID, Operacao: handlers.OperacaoUpload, Ator: "teste-contrato", Momento: time.Now()}
+		if err := repo.Salvar(ctx, nota, evento); err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, nota.ID)
+		chaves = append(chaves, chave)
+	}
+	return ids, chaves[0], nil
+}
+
+// roteiro exercita as operações documentadas, com respostas de sucesso e de erro
+func (v *verificacaoContrato) roteiro(notas []string, chave, urlReceptor string) {
+	pdf := arquivoFormulario{campo: "files", nome: "nota.pdf", conteudo: []byte("%PDF-1.4\n%%EOF\n")}
+	nota, outra := "/notas-fiscais/"+notas[0], "/notas-fiscais/"+notas[1]
+
+	v.requisitar("GET", "/openapi.json", nil, http.StatusOK)
+	v.requisitar("GET", "/docs", nil, http.StatusOK)
+
+	// Extração: sem OPENAI_API_KEY no servidor
+	v.requisitar("POST", "/upload", corpoFormulario(nil, pdf), http.StatusServiceUnavailable)
+	v.requisitar("POST", "/jobs", corpoFormulario(nil, pdf), http.StatusServiceUnavailable)
+	v.requisitar("GET", "/jobs", nil, http.StatusOK)
+	v.requisitar("GET", "/jobs/inexistente", nil, http.StatusNotFound)
+	v.requisitar("POST", "/save-nota-fiscal", corpoFormulario(map[string]string{"email": "titular@exemplo.com"}), http.StatusBadRequest)
+
+	// Consultas
+	v.requisitar("GET", "/buscar-notas-fiscais?competencia=09/2026", nil, http.StatusOK)
+	v.requisitar("GET", "/buscar-notas-fiscais", nil, http.StatusBadRequest)
+	pagina := v.requisitar("GET", "/notas-fiscais?ordenar=-valorServicos&limite=1", nil, http.StatusOK)
+	if cursor := campoJSON(pagina, "proximo_cursor"); cursor != "" {
+		v.requisitar("GET", "/notas-fiscais?ordenar=-valorServicos&limite=1&cursor="+cursor, nil, http.StatusOK)
+	} else {
+		v.divergir("GET /notas-fiscais: primeira página sem proximo_cursor")
+	}
+	v.requisitar("GET", "/notas-fiscais?limite=0", nil, http.StatusBadRequest)
+	v.requisitar("GET", "/notas-fiscais/duplicadas", nil, http.StatusOK)
+	v.requisitar("GET", nota, nil, http.StatusOK)
+	v.requisitar("GET", "/notas-fiscais/inexistente", nil, http.StatusNotFound)
+	v.requisitar("GET", nota+"/historico", nil, http.StatusOK)
+	v.requisitar("GET", nota+"/arquivo?download=true", nil, http.StatusOK)
+	v.requisitar("GET", nota+"/preview?pagina=0", nil, http.StatusBadRequest)
+	v.requisitar("GET", "/arquivos/"+chave, nil, http.StatusOK)
+	v.requisitar("GET", "/arquivos/invalida", nil, http.StatusBadRequest)
+
+	// Webhooks e contratos, antes da revisão, para receberem os eventos
+	assinatura := campoJSON(v.requisitar("POST", "/webhooks", corpoJSON(map[string]any{
+		"url": urlReceptor, "eventos": []string{"*"},
+	}), http.StatusCreated), "id")
+	v.requisitar("POST", "/webhooks", corpoJSON(map[string]any{"url": "ftp://exemplo", "eventos": []string{"*"}}), http.StatusBadRequest)
+	v.requisitar("GET", "/webhooks", nil, http.StatusOK)
+	token := v.tokenAdmin
+	v.tokenAdmin = ""
+	v.requisitar("GET", "/webhooks", nil, http.StatusUnauthorized)
+	v.tokenAdmin = token
+
+	contrato := campoJSON(v.requisitar("POST", "/contratos", corpoJSON(map[string]any{
+		"numero": "CT-1", "cnpjFornecedor": "12.345.678/0001-90", "valorTeto": 1500,
+		"vigenciaInicio": "01/01/2026", "vigenciaFim": "31/12/2026",
+	}), http.StatusCreated), "id")
+	v.requisitar("POST", "/contratos", corpoJSON(map[string]any{"cnpjFornecedor": "1"}), http.StatusBadRequest)
+	v.requisitar("GET", "/contratos", nil, http.StatusOK)
+	v.requisitar("GET", "/contratos/"+contrato, nil, http.StatusOK)
+	v.requisitar("PUT", "/contratos/"+contrato, corpoJSON(map[string]any{
+		"numero": "CT-1", "cnpjFornecedor": "12.345.678/0001-90", "valorTeto": 2500,
+		"vigenciaInicio": "01/01/2026", "vigenciaFim": "31/12/2026", "competencias": []string{"09/2026"},
+	}), http.StatusOK)
+	v.requisitar("GET", "/contratos/inexistente", nil, http.StatusNotFound)
+	v.requisitar("GET", "/contratos/conciliacao?competencia=09/2026", nil, http.StatusOK)
+
+	// Revisão
+	v.requisitar("PATCH", nota+"/campos", corpoJSON(map[string]any{
+		"revisor": "teste-contrato", "campos": map[string]any{"valorServicos": 1500},
+	}), http.StatusOK)
+	v.requisitar("PATCH", nota, corpoJSON(map[string]any{"revisor": "", "campos": map[string]any{}}), http.StatusBadRequest)
+	v.requisitar("POST", nota+"/aprovar", corpoJSON(map[string]any{"revisor": "teste-contrato"}), http.StatusOK)
+	v.requisitar("POST", nota+"/aprovar", corpoJSON(map[string]any{"revisor": "teste-contrato"}), http.StatusConflict)
+	v.requisitar("POST", outra+"/rejeitar", corpoJSON(map[string]any{"revisor": "teste-contrato"}), http.StatusBadRequest)
+	v.requisitar("POST", outra+"/rejeitar", corpoJSON(map[string]any{"revisor": "teste-contrato", "motivo": "teste"}), http.StatusOK)
+	v.requisitar("GET", "/notas-fiscais/exportar?competencia=09/2026", nil, http.StatusOK)
+	v.requisitar("GET", "/notas-fiscais/exportar?formato=json", nil, http.StatusOK)
+
+	// Entregas dos eventos da revisão
+	entregas := v.requisitar("GET", "/webhooks/"+assinatura+"/entregas", nil, http.StatusOK)
+	if entrega := campoJSON(entregas, 0, "id"); entrega != "" {
+		v.requisitar("POST", "/webhooks/"+assinatura+"/entregas/"+entrega+"/reenviar", nil, http.StatusAccepted)
+	} else {
+		v.divergir("GET /webhooks/{id}/entregas: nenhuma entrega registrada após a revisão")
+	}
+	v.requisitar("POST", "/webhooks/"+assinatura+"/entregas/inexistente/reenviar", nil, http.StatusNotFound)
+
+	// Planilhas
+	csv := []byte("cnpj,numero,valor\n12.345.678/0001-90,1001,1000.00\n")
+	v.requisitar("POST", "/spreadsheet-preview", corpoFormulario(nil, arquivoFormulario{"file", "planilha.csv", csv}), http.StatusOK)
+	v.requisitar("POST", "/process-spreadsheet", corpoFormulario(nil, arquivoFormulario{"file", "planilha.csv", csv}), http.StatusOK)
+	v.requisitar("POST", "/process-spreadsheet", corpoFormulario(nil, arquivoFormulario{"file", "planilha.txt", csv}), http.StatusBadRequest)
+
+	// Retenção, titulares e remoções
+	v.requisitar("POST", "/retencao/expurgo?simular=true", nil, http.StatusOK)
+	v.requisitar("GET", "/titulares/dados?email=titular@exemplo.com", nil, http.StatusOK)
+	v.requisitar("GET", "/titulares/dados", nil, http.StatusBadRequest)
+	v.requisitar("DELETE", outra+"?motivo=teste", nil, http.StatusOK)
+	v.requisitar("DELETE", outra, nil, http.StatusNotFound)
+	v.requisitar("POST", "/titulares/anonimizar", corpoJSON(map[string]any{"email": "titular@exemplo.com"}), http.StatusOK)
+	v.requisitar("POST", "/titulares/anonimizar", corpoJSON(map[string]any{}), http.StatusBadRequest)
+	v.requisitar("DELETE", "/webhooks/"+assinatura, nil, http.StatusNoContent)
+	v.requisitar("DELETE", "/webhooks/"+assinatura, nil, http.StatusNotFound)
+
+	// Rotas sem prefixo, mantidas para clientes antigos
+	v.conferirLegada("GET", "/notas-fiscais/inexistente", nil, http.StatusNotFound)
+	v.conferirLegada("POST", "/save-nota-fiscal", corpoFormulario(map[string]string{"email": "titular@exemplo.com"}), http.StatusBadRequest)
+	v.conferirLegada("POST", "/upload", corpoFormulario(nil, pdf), http.StatusServiceUnavailable)
+}
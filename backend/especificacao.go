@@ -0,0 +1,287 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Leitura de handlers/openapi.json para TestContrato e gerar-cliente. Só é interpretado
+// o subconjunto de OpenAPI 3.1 usado na especificação.
+
+// parametroCaminho encontra os parâmetros de um caminho da especificação ({id})
+var parametroCaminho = regexp.MustCompile(`\{([^}]+)\}`)
+
+// especificacao é a especificação OpenAPI da API
+type especificacao struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]*operacaoAPI `json:"paths"`
+	Components struct {
+		Schemas    map[string]any          `json:"schemas"`
+		Parameters map[string]parametroAPI `json:"parameters"`
+		Responses  map[string]respostaAPI  `json:"responses"`
+	} `json:"components"`
+	// bruta é a especificação decodificada sem tipos, para conferir as referências
+	bruta map[string]any
+}
+
+// operacaoAPI é uma operação (método + caminho) da especificação
+type operacaoAPI struct {
+	OperationID string         `json:"operationId"`
+	Summary     string         `json:"summary"`
+	Handler     string         `json:"x-handler"` // nome da função em handlers
+	Parameters  []parametroAPI `json:"parameters"`
+	RequestBody *struct {
+		Required bool                `json:"required"`
+		Content  map[string]midiaAPI `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]respostaAPI `json:"responses"`
+}
+
+type parametroAPI struct {
+	Ref         string `json:"$ref"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Schema      any    `json:"schema"`
+}
+
+type respostaAPI struct {
+	Ref         string              `json:"$ref"`
+	Description string              `json:"description"`
+	Content     map[string]midiaAPI `json:"content"`
+}
+
+type midiaAPI struct {
+	Schema any `json:"schema"`
+}
+
+// metodosHTTP são os métodos aceitos como operação em paths, na ordem usada nos relatórios
+var metodosHTTP = []string{"get", "post", "put", "patch", "delete"}
+
+// carregarEspecificacao decodifica a especificação embutida em handlers
+func carregarEspecificacao() (*especificacao, error) {
+	var spec especificacao
+	if err := json.Unmarshal(handlers.EspecificacaoOpenAPI(), &spec); err != nil {
+		return nil, fmt.Errorf("openapi.json inválido: %v", err)
+	}
+	if err := json.Unmarshal(handlers.EspecificacaoOpenAPI(), &spec.bruta); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// base é o prefixo dos caminhos, do primeiro servidor da especificação (ex.: /api/v1)
+func (e *especificacao) base() string {
+	if len(e.Servers) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(e.Servers[0].URL, "/")
+}
+
+// operacoes percorre as operações em ordem de caminho e método
+func (e *especificacao) operacoes(f func(caminho, metodo string, op *operacaoAPI)) {
+	caminhos := make([]string, 0, len(e.Paths))
+	for caminho := range e.Paths {
+		caminhos = append(caminhos, caminho)
+	}
+	sort.Strings(caminhos)
+	for _, caminho := range caminhos {
+		for _, metodo := range metodosHTTP {
+			if op, ok := e.Paths[caminho][metodo]; ok {
+				f(caminho, metodo, op)
+			}
+		}
+	}
+}
+
+// parametro resolve a referência a um parâmetro de components
+func (e *especificacao) parametro(p parametroAPI) parametroAPI {
+	if nome, ok := strings.CutPrefix(p.Ref, "#/components/parameters/"); ok {
+		return e.Components.Parameters[nome]
+	}
+	return p
+}
+
+// resposta resolve a referência a uma resposta de components
+func (e *especificacao) resposta(r respostaAPI) respostaAPI {
+	if nome, ok := strings.CutPrefix(r.Ref, "#/components/responses/"); ok {
+		return e.Components.Responses[nome]
+	}
+	return r
+}
+
+// schema resolve a referência a um schema de components
+func (e *especificacao) schema(s any) map[string]any {
+	m, _ := s.(map[string]any)
+	if ref, ok := m["$ref"].(string); ok {
+		nome := strings.TrimPrefix(ref, "#/components/schemas/")
+		m, _ = e.Components.Schemas[nome].(map[string]any)
+	}
+	return m
+}
+
+// referenciasQuebradas lista os $ref que não apontam para nada na especificação
+func (e *especificacao) referenciasQuebradas() []string {
+	var quebradas []string
+	var percorrer func(v any)
+	percorrer = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && e.resolverPonteiro(ref) == nil {
+				quebradas = append(quebradas, ref)
+			}
+			for _, filho := range v {
+				percorrer(filho)
+			}
+		case []any:
+			for _, filho := range v {
+				percorrer(filho)
+			}
+		}
+	}
+	percorrer(e.bruta)
+	sort.Strings(quebradas)
+	return slices.Compact(quebradas)
+}
+
+// resolverPonteiro segue um ponteiro JSON local (#/a/b)
+func (e *especificacao) resolverPonteiro(ref string) any {
+	caminho, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil
+	}
+	var atual any = e.bruta
+	for _, parte := range strings.Split(caminho, "/") {
+		m, ok := atual.(map[string]any)
+		if !ok {
+			return nil
+		}
+		atual = m[strings.NewReplacer("~1", "/", "~0", "~").Replace(parte)]
+	}
+	return atual
+}
+
+// tiposSchema retorna os tipos aceitos pelo schema ("type" pode ser um nome ou uma lista)
+func tiposSchema(s map[string]any) []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		tipos := make([]string, 0, len(t))
+		for _, nome := range t {
+			if nome, ok := nome.(string); ok {
+				tipos = append(tipos, nome)
+			}
+		}
+		return tipos
+	}
+	return nil
+}
+
+// tipoJSON classifica um valor decodificado de JSON com os nomes de tipo do JSON Schema
+func tipoJSON(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// validar confere um valor decodificado de JSON com o schema e retorna as divergências,
+// indicando o caminho de cada uma no documento. Objetos com properties são fechados: campos
+// não documentados são divergências, a menos que additionalProperties os admita.
+func (e *especificacao) validar(schema any, valor any, caminho string) []string {
+	s := e.schema(schema)
+	if s == nil {
+		return nil
+	}
+
+	for _, chave := range []string{"oneOf", "anyOf"} {
+		alternativas, ok := s[chave].([]any)
+		if !ok {
+			continue
+		}
+		for _, alternativa := range alternativas {
+			if len(e.validar(alternativa, valor, caminho)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: não corresponde a nenhuma das alternativas de %s", caminho, chave)}
+	}
+
+	tipo := tipoJSON(valor)
+	if tipos := tiposSchema(s); len(tipos) > 0 {
+		aceito := slices.Contains(tipos, tipo) || (tipo == "integer" && slices.Contains(tipos, "number"))
+		if !aceito {
+			return []string{fmt.Sprintf("%s: tipo %s, esperado %s", caminho, tipo, strings.Join(tipos, " ou "))}
+		}
+	}
+	if opcoes, ok := s["enum"].([]any); ok && tipo != "object" && tipo != "array" && !slices.Contains(opcoes, valor) {
+		return []string{fmt.Sprintf("%s: valor %v fora de %v", caminho, valor, opcoes)}
+	}
+
+	var divergencias []string
+	switch valor := valor.(type) {
+	case map[string]any:
+		propriedades, fechado := s["properties"].(map[string]any)
+		if obrigatorios, ok := s["required"].([]any); ok {
+			for _, nome := range obrigatorios {
+				if _, ok := valor[nome.(string)]; !ok {
+					divergencias = append(divergencias, fmt.Sprintf("%s: campo obrigatório %q ausente", caminho, nome))
+				}
+			}
+		}
+		nomes := make([]string, 0, len(valor))
+		for nome := range valor {
+			nomes = append(nomes, nome)
+		}
+		sort.Strings(nomes)
+		for _, nome := range nomes {
+			if propriedade, ok := propriedades[nome]; ok {
+				divergencias = append(divergencias, e.validar(propriedade, valor[nome], caminho+"."+nome)...)
+				continue
+			}
+			switch adicionais := s["additionalProperties"].(type) {
+			case bool:
+				if !adicionais {
+					divergencias = append(divergencias, fmt.Sprintf("%s: campo %q não documentado", caminho, nome))
+				}
+			case map[string]any:
+				divergencias = append(divergencias, e.validar(adicionais, valor[nome], caminho+"."+nome)...)
+			default:
+				if fechado {
+					divergencias = append(divergencias, fmt.Sprintf("%s: campo %q não documentado", caminho, nome))
+				}
+			}
+		}
+	case []any:
+		if itens, ok := s["items"]; ok {
+			for i, item := range valor {
+				divergencias = append(divergencias, e.validar(itens, item, fmt.Sprintf("%s[%d]", caminho, i))...)
+			}
+		}
+	}
+	return divergencias
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// caminhoClientePadrao é o cliente do frontend, relativo ao diretório backend
+const caminhoClientePadrao = "../frontend/src/api.js"
+
+// executarGeracaoCliente gera o cliente JavaScript do frontend a partir de handlers/openapi.json:
+// uma função por operação (com o nome do operationId) e os tipos dos schemas em JSDoc.
+// Uso: go run . gerar-cliente [-saida ../frontend/src/api.js]
+func executarGeracaoCliente(args []string) int {
+	flags := flag.NewFlagSet("gerar-cliente", flag.ContinueOnError)
+	saida := flags.String("saida", caminhoClientePadrao, "arquivo gerado")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	spec, err := carregarEspecificacao()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao ler a especificação: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*saida, gerarCliente(spec), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao gravar o cliente: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Cliente gerado em %s\n", *saida)
+	return 0
+}
+
+// cabecalhoCliente é o início fixo do cliente: a função que executa as requisições e o erro
+// lançado para respostas de erro. {{prefixo}} é substituído pelo prefixo da especificação.
+const cabecalhoCliente = `// Cliente da API gerado por ` + "`go run . gerar-cliente`" + ` a partir de backend/handlers/openapi.json.
+// Não edite este arquivo: altere a especificação e gere o cliente novamente.
+
+const apiUrl = process.env.REACT_APP_API_URL || '';
+// prefixoApi é a versão da API usada pelo cliente (servers da especificação)
+const prefixoApi = '{{prefixo}}';
+
+// ErroApi é lançado quando a API responde com erro. codigo vem de error.code (ver CodigoErro)
+// e é o que o chamador deve comparar; detalhes e requestId completam o erro.
+export class ErroApi extends Error {
+  constructor(status, corpo) {
+    const erro = (corpo && corpo.error) || {};
+    super(erro.message || ` + "`Erro ${status}`" + `);
+    this.name = 'ErroApi';
+    this.status = status;
+    this.codigo = erro.code || 'internal_error';
+    this.detalhes = erro.details || {};
+    this.requestId = erro.request_id || '';
+  }
+}
+
+// requisitar executa a requisição e retorna o JSON da resposta. Respostas em outros formatos
+// (streaming, CSV, arquivos) são retornadas como Response, para o chamador ler o corpo.
+const requisitar = async (metodo, caminho, { query, json, form, headers, signal } = {}) => {
+  const params = new URLSearchParams();
+  Object.entries(query || {}).forEach(([nome, valor]) => {
+    if (valor !== undefined && valor !== null && valor !== '') {
+      params.append(nome, valor);
+    }
+  });
+  const base = ` + "`${apiUrl}${prefixoApi}${caminho}`" + `;
+  const url = params.toString() ? ` + "`${base}?${params}`" + ` : base;
+
+  const init = { method: metodo, headers: { ...headers }, signal };
+  if (json !== undefined) {
+    init.headers['Content-Type'] = 'application/json';
+    init.body = JSON.stringify(json);
+  } else if (form !== undefined) {
+    init.body = form;
+  }
+
+  const response = await fetch(url, init);
+  const tipo = response.headers.get('Content-Type') || '';
+  if (!response.ok) {
+    const corpo = tipo.includes('application/json') ? await response.json().catch(() => null) : null;
+    throw new ErroApi(response.status, corpo);
+  }
+  if (response.status === 204) {
+    return null;
+  }
+  if (tipo.includes('application/json')) {
+    return response.json();
+  }
+  return response;
+};
+`
+
+// gerarCliente gera o código do cliente; a saída é determinística, para TestContrato (contrato_test.go)
+// poder comparar o arquivo com a especificação
+func gerarCliente(spec *especificacao) []byte {
+	var b strings.Builder
+	b.WriteString(strings.Replace(cabecalhoCliente, "{{prefixo}}", spec.base(), 1))
+
+	nomes := make([]string, 0, len(spec.Components.Schemas))
+	for nome := range spec.Components.Schemas {
+		nomes = append(nomes, nome)
+	}
+	sort.Strings(nomes)
+	for _, nome := range nomes {
+		b.WriteString("\n")
+		escreverTipo(&b, spec, nome)
+	}
+
+	spec.operacoes(func(caminho, metodo string, op *operacaoAPI) {
+		b.WriteString("\n")
+		escreverOperacao(&b, spec, caminho, metodo, op)
+	})
+	return []byte(b.String())
+}
+
+// linhaJSDoc reduz descrições a uma linha, para não quebrar o comentário
+func linhaJSDoc(texto string) string {
+	texto = strings.Join(strings.Fields(texto), " ")
+	return strings.ReplaceAll(texto, "*/", "*\\/")
+}
+
+// escreverTipo escreve o @typedef de um schema de components
+func escreverTipo(b *strings.Builder, spec *especificacao, nome string) {
+	s, _ := spec.Components.Schemas[nome].(map[string]any)
+	propriedades, ok := s["properties"].(map[string]any)
+	if !ok {
+		if descricao, _ := s["description"].(string); descricao != "" {
+			fmt.Fprintf(b, "/**\n * %s\n * @typedef {%s} %s\n */\n", linhaJSDoc(descricao), tipoJS(spec, s), nome)
+			return
+		}
+		fmt.Fprintf(b, "/** @typedef {%s} %s */\n", tipoJS(spec, s), nome)
+		return
+	}
+
+	b.WriteString("/**\n")
+	if descricao, _ := s["description"].(string); descricao != "" {
+		fmt.Fprintf(b, " * %s\n", linhaJSDoc(descricao))
+	}
+	fmt.Fprintf(b, " * @typedef {Object} %s\n", nome)
+	obrigatorios := map[string]bool{}
+	if lista, ok := s["required"].([]any); ok {
+		for _, campo := range lista {
+			obrigatorios[campo.(string)] = true
+		}
+	}
+	campos := make([]string, 0, len(propriedades))
+	for campo := range propriedades {
+		campos = append(campos, campo)
+	}
+	sort.Strings(campos)
+	for _, campo := range campos {
+		propriedade, _ := propriedades[campo].(map[string]any)
+		rotulo := campo
+		if !obrigatorios[campo] {
+			rotulo = "[" + campo + "]"
+		}
+		linha := fmt.Sprintf(" * @property {%s} %s", tipoJS(spec, propriedade), rotulo)
+		if descricao, _ := propriedade["description"].(string); descricao != "" {
+			linha += " - " + linhaJSDoc(descricao)
+		}
+		b.WriteString(linha + "\n")
+	}
+	b.WriteString(" */\n")
+}
+
+// tipoJS converte um schema na expressão de tipo do JSDoc
+func tipoJS(spec *especificacao, schema any) string {
+	s, _ := schema.(map[string]any)
+	if ref, ok := s["$ref"].(string); ok {
+		return strings.TrimPrefix(ref, "#/components/schemas/")
+	}
+	for _, chave := range []string{"oneOf", "anyOf"} {
+		if alternativas, ok := s[chave].([]any); ok {
+			tipos := make([]string, 0, len(alternativas))
+			for _, alternativa := range alternativas {
+				tipos = append(tipos, tipoJS(spec, alternativa))
+			}
+			return strings.Join(tipos, "|")
+		}
+	}
+	if opcoes, ok := s["enum"].([]any); ok {
+		literais := make([]string, 0, len(opcoes))
+		for _, opcao := range opcoes {
+			if texto, ok := opcao.(string); ok {
+				literais = append(literais, "'"+texto+"'")
+			} else {
+				literais = append(literais, fmt.Sprint(opcao))
+			}
+		}
+		return strings.Join(literais, "|")
+	}
+
+	tipos := tiposSchema(s)
+	if len(tipos) == 0 {
+		return "*"
+	}
+	convertidos := make([]string, 0, len(tipos))
+	for _, tipo := range tipos {
+		switch tipo {
+		case "integer", "number":
+			tipo = "number"
+		case "array":
+			tipo = "Array<" + tipoJS(spec, s["items"]) + ">"
+		case "object":
+			if adicionais, ok := s["additionalProperties"].(map[string]any); ok {
+				tipo = "Object<string, " + tipoJS(spec, adicionais) + ">"
+			} else {
+				tipo = "Object"
+			}
+		case "string":
+			if s["format"] == "binary" {
+				tipo = "Blob"
+			}
+		}
+		convertidos = append(convertidos, tipo)
+	}
+	return strings.Join(convertidos, "|")
+}
+
+// escreverOperacao escreve a função de uma operação. Os argumentos são os parâmetros de
+// caminho, o corpo (objeto ou FormData), os parâmetros de consulta e as opções de requisitar
+// (headers, como Idempotency-Key e X-Usuario, e signal).
+func escreverOperacao(b *strings.Builder, spec *especificacao, caminho, metodo string, op *operacaoAPI) {
+	var argumentos, doc []string
+	modelo := caminho
+	for _, m := range parametroCaminho.FindAllStringSubmatch(caminho, -1) {
+		argumento := nomeArgumento(m[1])
+		argumentos = append(argumentos, argumento)
+		doc = append(doc, fmt.Sprintf(" * @param {string} %s", argumento))
+		modelo = strings.Replace(modelo, m[0], "${encodeURIComponent("+argumento+")}", 1)
+	}
+
+	corpo := ""
+	if op.RequestBody != nil {
+		if midia, ok := op.RequestBody.Content["application/json"]; ok {
+			argumentos = append(argumentos, "corpo")
+			doc = append(doc, fmt.Sprintf(" * @param {%s} corpo", tipoJS(spec, midia.Schema)))
+			corpo = ", json: corpo"
+		} else if _, ok := op.RequestBody.Content["multipart/form-data"]; ok {
+			argumentos = append(argumentos, "form")
+			doc = append(doc, " * @param {FormData} form")
+			corpo = ", form"
+		}
+	}
+
+	query := ""
+	var consultas []parametroAPI
+	for _, p := range op.Parameters {
+		if p = spec.parametro(p); p.In == "query" {
+			consultas = append(consultas, p)
+		}
+	}
+	if len(consultas) > 0 {
+		argumentos = append(argumentos, "query = {}")
+		doc = append(doc, " * @param {Object} [query]")
+		for _, p := range consultas {
+			linha := fmt.Sprintf(" * @param {%s} [query.%s]", tipoJS(spec, p.Schema), p.Name)
+			if p.Description != "" {
+				linha += " - " + linhaJSDoc(p.Description)
+			}
+			doc = append(doc, linha)
+		}
+		query = ", query"
+	}
+	argumentos = append(argumentos, "opcoes = {}")
+	doc = append(doc, " * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]")
+	doc = append(doc, fmt.Sprintf(" * @returns {Promise<%s>}", retornoJS(spec, op)))
+
+	b.WriteString("/**\n")
+	fmt.Fprintf(b, " * %s\n", linhaJSDoc(op.Summary))
+	fmt.Fprintf(b, " * %s %s\n", strings.ToUpper(metodo), caminho)
+	b.WriteString(strings.Join(doc, "\n") + "\n */\n")
+	fmt.Fprintf(b, "export const %s = (%s) =>\n", op.OperationID, strings.Join(argumentos, ", "))
+	fmt.Fprintf(b, "  requisitar('%s', `%s`, { ...opcoes%s%s });\n", strings.ToUpper(metodo), modelo, query, corpo)
+}
+
+// nomeArgumento evita que parâmetros de caminho colidam com os demais argumentos
+func nomeArgumento(parametro string) string {
+	switch parametro {
+	case "corpo", "form", "query", "opcoes":
+		return parametro + "Caminho"
+	}
+	return parametro
+}
+
+// retornoJS é o tipo resolvido pela função: o JSON da primeira resposta de sucesso, Response
+// para os demais formatos ou null para respostas sem corpo
+func retornoJS(spec *especificacao, op *operacaoAPI) string {
+	status := make([]string, 0, len(op.Responses))
+	for codigo := range op.Responses {
+		if strings.HasPrefix(codigo, "2") {
+			status = append(status, codigo)
+		}
+	}
+	sort.Strings(status)
+	if len(status) == 0 {
+		return "*"
+	}
+	resposta := spec.resposta(op.Responses[status[0]])
+	if len(resposta.Content) == 0 {
+		return "null"
+	}
+	midia, ok := resposta.Content["application/json"]
+	if !ok {
+		return "Response"
+	}
+	if len(resposta.Content) > 1 {
+		return tipoJS(spec, midia.Schema) + "|Response"
+	}
+	return tipoJS(spec, midia.Schema)
+}
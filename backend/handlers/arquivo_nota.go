@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abrirArquivoNota abre o PDF original de uma nota: pela chave no BlobStore ou, em notas
+// salvas antes dele, pelo nome do arquivo em uploads/
+func abrirArquivoNota(ctx context.Context, nota NotaFiscalData) (io.ReadCloser, InfoBlob, error) {
+	if chaveBlobValida(nota.Arquivo) {
+		return blobs.Abrir(ctx, nota.Arquivo)
+	}
+
+	caminho, ok := caminhoArquivoLegado(nota)
+	if !ok {
+		return nil, InfoBlob{}, ErrBlobNaoEncontrado
+	}
+	nome := filepath.Base(caminho)
+	f, err := os.Open(caminho)
+	if os.IsNotExist(err) {
+		return nil, InfoBlob{}, ErrBlobNaoEncontrado
+	}
+	if err != nil {
+		return nil, InfoBlob{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, InfoBlob{}, err
+	}
+	return f, InfoBlob{Tamanho: info.Size(), Tipo: tipoBlob(nome)}, nil
+}
+
+// caminhoArquivoLegado retorna o PDF de uma nota salva antes do BlobStore, em uploads/
+func caminhoArquivoLegado(nota NotaFiscalData) (string, bool) {
+	nome := nota.Arquivo
+	if nome == "" {
+		nome = nota.ID + ".pdf"
+	}
+	if strings.ContainsAny(nome, `/\`) || strings.Contains(nome, "..") {
+		return "", false
+	}
+	return filepath.Join(uploadDir, nome), true
+}
+
+// removerArquivoNota apaga o PDF de uma nota do BlobStore ou, em notas antigas, de uploads/,
+// e indica se havia arquivo. Quem chama deve garantir que nenhuma outra nota usa o mesmo arquivo.
+func removerArquivoNota(ctx context.Context, store BlobStore, nota NotaFiscalData) (bool, error) {
+	if chaveBlobValida(nota.Arquivo) {
+		existe, err := store.Existe(ctx, nota.Arquivo)
+		if err != nil || !existe {
+			return false, err
+		}
+		return true, store.Remover(ctx, nota.Arquivo)
+	}
+	caminho, ok := caminhoArquivoLegado(nota)
+	if !ok {
+		return false, nil
+	}
+	err := os.Remove(caminho)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// nomeDownload retorna o nome sugerido ao baixar o arquivo da nota
+func nomeDownload(nota NotaFiscalData) string {
+	if nota.NomeArquivo != "" {
+		return filepath.Base(nota.NomeArquivo)
+	}
+	if nota.NumeroNota != "" {
+		return "nota-fiscal-" + nota.NumeroNota + ".pdf"
+	}
+	return nota.ID + ".pdf"
+}
+
+// abrirArquivoNotaRequisicao carrega a nota da rota e abre seu arquivo, respondendo com erro se não for possível
+func abrirArquivoNotaRequisicao(c *gin.Context) (NotaFiscalData, io.ReadCloser, InfoBlob, bool) {
+	nota, ok := carregarNota(c)
+	if !ok {
+		return nota, nil, InfoBlob{}, false
+	}
+
+	conteudo, info, err := abrirArquivoNota(c.Request.Context(), nota)
+	if errors.Is(err, ErrBlobNaoEncontrado) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Arquivo da nota fiscal não encontrado")
+		return nota, nil, info, false
+	}
+	if err != nil {
+		log.Printf("Erro ao abrir arquivo da nota fiscal %s: %v", nota.ID, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao ler arquivo da nota fiscal")
+		return nota, nil, info, false
+	}
+	return nota, conteudo, info, true
+}
+
+// BaixarArquivoNota envia o PDF original de uma nota salva. Por padrão é exibido no navegador
+// (inline); com download=true é enviado como anexo.
+func BaixarArquivoNota(c *gin.Context) {
+	nota, conteudo, info, ok := abrirArquivoNotaRequisicao(c)
+	if !ok {
+		return
+	}
+	defer conteudo.Close()
+
+	disposicao := "inline"
+	if download, _ := strconv.ParseBool(c.Query("download")); download {
+		disposicao = "attachment"
+	}
+
+	c.DataFromReader(http.StatusOK, info.Tamanho, info.Tipo, conteudo, map[string]string{
+		"Content-Disposition": mime.FormatMediaType(disposicao, map[string]string{"filename": nomeDownload(nota)}),
+	})
+}
+
+// PreviewArquivoNota renderiza uma página do PDF da nota como PNG para a tela de revisão.
+// A página é informada em pagina (padrão 1) e a resolução em dpi (padrão 100, máximo 300).
+func PreviewArquivoNota(c *gin.Context) {
+	pagina := 1
+	if valor := c.Query("pagina"); valor != "" {
+		n, err := strconv.Atoi(valor)
+		if err != nil || n < 1 {
+			responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Página inválida")
+			return
+		}
+		pagina = n
+	}
+	dpi := 100
+	if valor := c.Query("dpi"); valor != "" {
+		n, err := strconv.Atoi(valor)
+		if err != nil || n < 10 || n > 300 {
+			responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Resolução inválida (use dpi entre 10 e 300)")
+			return
+		}
+		dpi = n
+	}
+
+	nota, conteudo, _, ok := abrirArquivoNotaRequisicao(c)
+	if !ok {
+		return
+	}
+	defer conteudo.Close()
+
+	if !strings.EqualFold(filepath.Ext(nota.Arquivo), ".pdf") && nota.Arquivo != "" {
+		responderErro(c, http.StatusUnprocessableEntity, CodigoArquivoInvalido, "Pré-visualização disponível apenas para PDF")
+		return
+	}
+
+	imagem, err := renderizarPagina(c.Request.Context(), conteudo, pagina, dpi)
+	if errors.Is(err, errPaginaInexistente) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, fmt.Sprintf("Página %d não existe no documento", pagina))
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao renderizar página %d da nota fiscal %s: %v", pagina, nota.ID, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao gerar pré-visualização")
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, "image/png", imagem)
+}
+
+// errPaginaInexistente indica que a página pedida é maior que o número de páginas do PDF
+var errPaginaInexistente = errors.New("página inexistente")
+
+// renderizarPagina converte uma página do PDF em PNG usando pdftoppm (poppler-utils)
+func renderizarPagina(ctx context.Context, pdf io.Reader, pagina, dpi int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "preview-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	caminhoPDF := filepath.Join(dir, "nota.pdf")
+	f, err := os.Create(caminhoPDF)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, pdf); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	p := strconv.Itoa(pagina)
+	saida := filepath.Join(dir, "pagina")
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-singlefile", "-r", strconv.Itoa(dpi), "-f", p, "-l", p, caminhoPDF, saida)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "Wrong page range") {
+			return nil, errPaginaInexistente
+		}
+		return nil, fmt.Errorf("pdftoppm: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.ReadFile(saida + ".png")
+}
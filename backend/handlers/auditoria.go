@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operações registradas na trilha de auditoria das notas
+const (
+	OperacaoUpload     = "upload"
+	OperacaoExtracao   = "extracao"
+	OperacaoImportacao = "importacao"
+	OperacaoCorrecao   = "correcao"
+	OperacaoAprovacao  = "aprovacao"
+	OperacaoRejeicao   = "rejeicao"
+	OperacaoRemocao    = "remocao"
+	OperacaoExpurgo    = "expurgo"
+	// Operações de atendimento ao titular dos dados (LGPD)
+	OperacaoExportacaoTitular = "exportacao_titular"
+	OperacaoAnonimizacao      = "anonimizacao"
+	// Remoção de dados pessoais da trilha e das cópias guardadas fora do repositório (ver redigirDadosPessoais)
+	OperacaoRedacao = "redacao"
+)
+
+// Alteracao guarda o valor de um campo antes e depois de uma operação (JSON; null se ausente)
+type Alteracao struct {
+	Antes  json.RawMessage `json:"antes"`
+	Depois json.RawMessage `json:"depois"`
+}
+
+// EventoAuditoria é uma entrada da trilha de auditoria de uma nota. Entradas nunca são
+// removidas nem alteradas depois de gravadas, exceto pela redação dos dados pessoais na
+// anonimização e no expurgo (ver RedigirAuditoria).
+type EventoAuditoria struct {
+	NotaID         string               `json:"notaId"`
+	Operacao       string               `json:"operacao"`
+	Ator           string               `json:"ator"`
+	Momento        time.Time            `json:"momento"`
+	VersaoExtrator string               `json:"versaoExtrator,omitempty"`
+	Alteracoes     map[string]Alteracao `json:"alteracoes,omitempty"`
+	Detalhes       string               `json:"detalhes,omitempty"`
+}
+
+// camposIgnoradosAuditoria não entram no diff: são consequência de outros campos
+var camposIgnoradosAuditoria = map[string]bool{
+	"valoresOriginais": true,
+}
+
+// camposPessoaisAuditoria são os campos com dados pessoais: no diff, seus valores são
+// substituídos por resumoDadoPessoal
+var camposPessoaisAuditoria = map[string]bool{
+	"email":       true,
+	"cnpj":        true,
+	"prestador":   true,
+	"nomeArquivo": true,
+}
+
+// marcaDadoPessoal substitui na trilha os dados pessoais sem resumo (criptografia desativada)
+// ou redigidos depois da gravação
+const (
+	marcaDadoPessoal = "[dado pessoal]"
+	marcaRedigido    = "[redigido]"
+)
+
+// resumoDadoPessoal é o que a trilha guarda de um campo pessoal: com a criptografia ativa, o
+// índice cego do valor, que permite conferir um valor conhecido sem revelá-lo; sem ela, apenas
+// a marca de que o campo foi preenchido. Valores vazios são mantidos.
+func resumoDadoPessoal(valor json.RawMessage) json.RawMessage {
+	var texto string
+	if json.Unmarshal(valor, &texto) != nil || strings.TrimSpace(texto) == "" {
+		return valor
+	}
+	resumo := marcaDadoPessoal
+	if cofre != nil {
+		resumo = cofre.IndiceCego(texto)
+	}
+	conteudo, _ := json.Marshal(resumo)
+	return conteudo
+}
+
+// diffNotas retorna os campos que mudaram entre duas versões da nota; antes nil indica criação.
+// Os campos pessoais guardam apenas resumoDadoPessoal.
+func diffNotas(antes *NotaFiscalData, depois NotaFiscalData) map[string]Alteracao {
+	paraMapa := func(nota *NotaFiscalData) map[string]json.RawMessage {
+		campos := map[string]json.RawMessage{}
+		if nota == nil {
+			return campos
+		}
+		if conteudo, err := json.Marshal(nota); err == nil {
+			json.Unmarshal(conteudo, &campos)
+		}
+		return campos
+	}
+	camposAntes, camposDepois := paraMapa(antes), paraMapa(&depois)
+
+	nulo := json.RawMessage("null")
+	alteracoes := map[string]Alteracao{}
+	for campo, valor := range camposDepois {
+		if anterior, ok := camposAntes[campo]; (!ok || !bytes.Equal(anterior, valor)) && !camposIgnoradosAuditoria[campo] {
+			if !ok {
+				anterior = nulo
+			}
+			alteracoes[campo] = Alteracao{Antes: anterior, Depois: valor}
+		}
+	}
+	for campo, anterior := range camposAntes {
+		if _, ok := camposDepois[campo]; !ok && !camposIgnoradosAuditoria[campo] {
+			alteracoes[campo] = Alteracao{Antes: anterior, Depois: nulo}
+		}
+	}
+	for campo, alteracao := range alteracoes {
+		if camposPessoaisAuditoria[campo] {
+			alteracoes[campo] = Alteracao{Antes: resumoDadoPessoal(alteracao.Antes), Depois: resumoDadoPessoal(alteracao.Depois)}
+		}
+	}
+	return alteracoes
+}
+
+// novoEvento cria a entrada de auditoria de uma operação sobre a nota
+func novoEvento(operacao, ator string, antes *NotaFiscalData, depois NotaFiscalData) EventoAuditoria {
+	return EventoAuditoria{
+		NotaID:         depois.ID,
+		Operacao:       operacao,
+		Ator:           ator,
+		Momento:        time.Now().UTC(),
+		VersaoExtrator: depois.VersaoExtrator,
+		Alteracoes:     diffNotas(antes, depois),
+	}
+}
+
+// marcaNaoAutenticado acompanha na trilha os atores informados pelo próprio cliente, que o
+// servidor não tem como verificar
+const marcaNaoAutenticado = " (não autenticado)"
+
+// atorRequisicao identifica quem faz a requisição: o cabeçalho X-Usuario ou, na falta dele,
+// o usuário informado na própria operação (revisor). Nenhum dos dois é autenticado, e o ator
+// é registrado com marcaNaoAutenticado.
+func atorRequisicao(c *gin.Context, informado string) string {
+	if usuario := strings.TrimSpace(c.GetHeader("X-Usuario")); usuario != "" {
+		return usuario + marcaNaoAutenticado
+	}
+	if informado = strings.TrimSpace(informado); informado != "" {
+		return informado + marcaNaoAutenticado
+	}
+	return "anonimo"
+}
+
+// HistoricoNotaFiscal retorna a trilha de auditoria de uma nota, inclusive de notas removidas
+func HistoricoNotaFiscal(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	if _, err := notasRepo.BuscarPorID(ctx, id); errors.Is(err, ErrNotaNaoEncontrada) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Nota fiscal não encontrada")
+		return
+	} else if err != nil {
+		log.Printf("Erro ao carregar nota fiscal %s: %v", id, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar nota fiscal")
+		return
+	}
+
+	eventos, err := notasRepo.Historico(ctx, id)
+	if err != nil {
+		log.Printf("Erro ao carregar histórico da nota fiscal %s: %v", id, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar histórico")
+		return
+	}
+	if eventos == nil {
+		eventos = []EventoAuditoria{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nota_id":   id,
+		"historico": eventos,
+		"total":     len(eventos),
+	})
+}
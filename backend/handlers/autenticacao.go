@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExigirTokenAdmin protege rotas administrativas (assinaturas de webhook) com o token de
+// ADMIN_TOKEN, enviado no cabeçalho "Authorization: Bearer <token>". Sem ADMIN_TOKEN
+// configurado, as rotas respondem 503.
+func ExigirTokenAdmin() gin.HandlerFunc {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		log.Println("ADMIN_TOKEN não configurado: rotas de administração (webhooks) desativadas")
+	}
+	// Os resumos têm o mesmo tamanho, para que a comparação não revele o tamanho do token
+	esperado := sha256.Sum256([]byte(token))
+
+	return func(c *gin.Context) {
+		if token == "" {
+			responderErro(c, http.StatusServiceUnavailable, CodigoServicoIndisponivel, "ADMIN_TOKEN não configurado no servidor")
+			return
+		}
+		tipo, recebido, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		resumo := sha256.Sum256([]byte(strings.TrimSpace(recebido)))
+		if !strings.EqualFold(tipo, "Bearer") || subtle.ConstantTimeCompare(resumo[:], esperado[:]) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			responderErro(c, http.StatusUnauthorized, CodigoNaoAutorizado, "Token de administração ausente ou inválido")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpcoesAvaliacao configura a avaliação da extração sobre um diretório de fixtures.
+// Cada documento (.pdf ou .xml) deve ter ao lado um <nome>.json com a resposta esperada
+// no mesmo formato de NFSeData (objeto ou array).
+type OpcoesAvaliacao struct {
+	Diretorio    string
+	Extrator     *ExtratorOpenAI
+	PrecoEntrada float64 // US$ por milhão de tokens de entrada
+	PrecoSaida   float64 // US$ por milhão de tokens de saída
+}
+
+// MetricaCampo resume o desempenho da extração de um campo
+type MetricaCampo struct {
+	Campo       string  `json:"campo"`
+	Esperados   int     `json:"esperados"`   // notas esperadas
+	Preenchidos int     `json:"preenchidos"` // notas extraídas com o campo preenchido
+	Corretos    int     `json:"corretos"`
+	Precisao    float64 `json:"precisao"`   // corretos / preenchidos
+	TaxaAcerto  float64 `json:"taxaAcerto"` // corretos / esperados
+}
+
+// ResultadoArquivoAvaliacao descreve a avaliação de um documento
+type ResultadoArquivoAvaliacao struct {
+	Arquivo     string        `json:"arquivo"`
+	Erro        string        `json:"erro,omitempty"`
+	Esperadas   int           `json:"esperadas"`
+	Extraidas   int           `json:"extraidas"`
+	Exato       bool          `json:"exato"`
+	Divergencia []string      `json:"divergencias,omitempty"`
+	Uso         UsoExtracao   `json:"uso"`
+	Custo       float64       `json:"custo"`
+	Duracao     time.Duration `json:"duracao"`
+}
+
+// RelatorioAvaliacao é o resultado consolidado da avaliação
+type RelatorioAvaliacao struct {
+	Versao         string                      `json:"versao"`
+	Documentos     int                         `json:"documentos"`
+	Falhas         int                         `json:"falhas"`
+	TaxaExata      float64                     `json:"taxaExata"` // documentos extraídos sem nenhuma divergência
+	Campos         []MetricaCampo              `json:"campos"`
+	Arquivos       []ResultadoArquivoAvaliacao `json:"arquivos"`
+	TokensEntrada  int                         `json:"tokensEntrada"`
+	TokensSaida    int                         `json:"tokensSaida"`
+	CustoTotal     float64                     `json:"custoTotal"`
+	LatenciaMedia  time.Duration               `json:"latenciaMedia"`
+	LatenciaP95    time.Duration               `json:"latenciaP95"`
+	LatenciaMaxima time.Duration               `json:"latenciaMaxima"`
+}
+
+// campoAvaliado define como ler e comparar um campo de NFSeData
+type campoAvaliado struct {
+	nome       string
+	valor      func(NFSeData) string
+	normalizar func(string) string
+}
+
+var camposAvaliados = []campoAvaliado{
+	{"Prestador de Serviços", func(n NFSeData) string { return n.PrestadorServicos }, normalizarTexto},
+	{"CNPJ (NF)", func(n NFSeData) string { return n.CNPJ }, normalizarCNPJ},
+	{"Número da Nota (NF)", func(n NFSeData) string { return n.NumeroNotaFiscal }, normalizarNumero},
+	{"Série da Nota (NF)", func(n NFSeData) string { return n.SerieNotaFiscal }, normalizarNumero},
+	{"Valor dos Serviços", func(n NFSeData) string { return formatarValor(n.ValorServicos) }, nil},
+	{"Data da Nota Fiscal", func(n NFSeData) string { return n.DataNotaFiscal }, normalizarData},
+	{"Competência da Nota Fiscal", func(n NFSeData) string { return n.CompetenciaNotaFiscal }, normalizarCompetencia},
+	{"ISS Retido", func(n NFSeData) string { return formatarValor(n.ISSRetido) }, nil},
+}
+
+// formatarValor representa valores em centavos; zero é tratado como campo não preenchido
+func formatarValor(v float64) string {
+	if math.Abs(v) < 0.005 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+func normalizarTexto(s string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(s)), " ")
+}
+
+func normalizarData(s string) string {
+	if d, err := ParseData(s); err == nil {
+		return d.String()
+	}
+	return strings.TrimSpace(s)
+}
+
+func normalizarCompetencia(s string) string {
+	if c, err := ParseCompetencia(s); err == nil {
+		return c.String()
+	}
+	return strings.TrimSpace(s)
+}
+
+// valorNormalizado retorna o valor do campo pronto para comparação
+func (c campoAvaliado) valorNormalizado(n NFSeData) string {
+	valor := c.valor(n)
+	if c.normalizar != nil {
+		valor = c.normalizar(valor)
+	}
+	return valor
+}
+
+// carregarEsperado lê a resposta esperada (objeto ou array de NFSeData)
+func carregarEsperado(caminho string) ([]NFSeData, error) {
+	conteudo, err := os.ReadFile(caminho)
+	if err != nil {
+		return nil, err
+	}
+
+	conteudo = []byte(strings.TrimSpace(string(conteudo)))
+	if strings.HasPrefix(string(conteudo), "[") {
+		var lista []NFSeData
+		err := json.Unmarshal(conteudo, &lista)
+		return lista, err
+	}
+
+	var nf NFSeData
+	if err := json.Unmarshal(conteudo, &nf); err != nil {
+		return nil, err
+	}
+	return []NFSeData{nf}, nil
+}
+
+// parearNotas associa cada nota esperada a uma extraída, primeiro pelo número da nota
+// e depois pela posição. Retorna, para cada esperada, o índice da extraída ou -1.
+func parearNotas(esperadas, extraidas []NFSeData) []int {
+	pares := make([]int, len(esperadas))
+	usadas := make([]bool, len(extraidas))
+
+	for i, esperada := range esperadas {
+		pares[i] = -1
+		numero := normalizarNumero(esperada.NumeroNotaFiscal)
+		if numero == "" {
+			continue
+		}
+		for j, extraida := range extraidas {
+			if !usadas[j] && normalizarNumero(extraida.NumeroNotaFiscal) == numero {
+				pares[i], usadas[j] = j, true
+				break
+			}
+		}
+	}
+
+	for i := range esperadas {
+		if pares[i] != -1 {
+			continue
+		}
+		for j := range extraidas {
+			if !usadas[j] {
+				pares[i], usadas[j] = j, true
+				break
+			}
+		}
+	}
+
+	return pares
+}
+
+// AvaliarExtracao executa o extrator sobre as fixtures do diretório e compara campo a campo
+// com as respostas esperadas
+func AvaliarExtracao(ctx context.Context, opcoes OpcoesAvaliacao) (RelatorioAvaliacao, error) {
+	relatorio := RelatorioAvaliacao{Versao: opcoes.Extrator.Versao()}
+
+	entradas, err := os.ReadDir(opcoes.Diretorio)
+	if err != nil {
+		return relatorio, err
+	}
+
+	metricas := make([]MetricaCampo, len(camposAvaliados))
+	for i, campo := range camposAvaliados {
+		metricas[i].Campo = campo.nome
+	}
+
+	var latencias []time.Duration
+	exatos := 0
+
+	for _, entrada := range entradas {
+		if err := ctx.Err(); err != nil {
+			return relatorio, err
+		}
+		ext := strings.ToLower(filepath.Ext(entrada.Name()))
+		if entrada.IsDir() || (ext != ".pdf" && ext != ".xml") {
+			continue
+		}
+
+		caminho := filepath.Join(opcoes.Diretorio, entrada.Name())
+		resultado := ResultadoArquivoAvaliacao{Arquivo: entrada.Name()}
+
+		esperadas, err := carregarEsperado(strings.TrimSuffix(caminho, filepath.Ext(caminho)) + ".json")
+		if err != nil {
+			resultado.Erro = fmt.Sprintf("resposta esperada indisponível: %v", err)
+			relatorio.Arquivos = append(relatorio.Arquivos, resultado)
+			relatorio.Falhas++
+			continue
+		}
+		for i := range esperadas {
+			normalizarDatasExtraidas(&esperadas[i])
+		}
+		resultado.Esperadas = len(esperadas)
+		relatorio.Documentos++
+
+		conteudo, err := os.ReadFile(caminho)
+		if err != nil {
+			return relatorio, err
+		}
+
+		extraidas, uso, err := opcoes.Extrator.Extrair(ctx, conteudo, entrada.Name())
+		resultado.Uso = uso
+		resultado.Duracao = uso.Duracao
+		resultado.Custo = (float64(uso.TokensEntrada)*opcoes.PrecoEntrada + float64(uso.TokensSaida)*opcoes.PrecoSaida) / 1e6
+		relatorio.TokensEntrada += uso.TokensEntrada
+		relatorio.TokensSaida += uso.TokensSaida
+		relatorio.CustoTotal += resultado.Custo
+		latencias = append(latencias, uso.Duracao)
+
+		if err != nil {
+			resultado.Erro = err.Error()
+			relatorio.Falhas++
+			extraidas = nil
+		}
+		resultado.Extraidas = len(extraidas)
+
+		pares := parearNotas(esperadas, extraidas)
+		pareadas := make([]bool, len(extraidas))
+		for i, esperada := range esperadas {
+			if pares[i] == -1 {
+				resultado.Divergencia = append(resultado.Divergencia, fmt.Sprintf("nota %q não extraída", esperada.NumeroNotaFiscal))
+			} else {
+				pareadas[pares[i]] = true
+			}
+			for k, campo := range camposAvaliados {
+				valorEsperado := campo.valorNormalizado(esperada)
+				metricas[k].Esperados++
+				if pares[i] == -1 {
+					continue
+				}
+				valorExtraido := campo.valorNormalizado(extraidas[pares[i]])
+				if valorExtraido != "" {
+					metricas[k].Preenchidos++
+				}
+				if valorExtraido == valorEsperado {
+					metricas[k].Corretos++
+				} else {
+					resultado.Divergencia = append(resultado.Divergencia, fmt.Sprintf("%s: esperado %q, extraído %q", campo.nome, valorEsperado, valorExtraido))
+				}
+			}
+		}
+
+		// Notas extraídas sem correspondência contam como campos preenchidos incorretamente
+		for j, extraida := range extraidas {
+			if pareadas[j] {
+				continue
+			}
+			resultado.Divergencia = append(resultado.Divergencia, fmt.Sprintf("nota %q extraída sem correspondência", extraida.NumeroNotaFiscal))
+			for k, campo := range camposAvaliados {
+				if campo.valorNormalizado(extraida) != "" {
+					metricas[k].Preenchidos++
+				}
+			}
+		}
+
+		resultado.Exato = resultado.Erro == "" && len(resultado.Divergencia) == 0
+		if resultado.Exato {
+			exatos++
+		}
+		relatorio.Arquivos = append(relatorio.Arquivos, resultado)
+	}
+
+	for i := range metricas {
+		if metricas[i].Preenchidos > 0 {
+			metricas[i].Precisao = float64(metricas[i].Corretos) / float64(metricas[i].Preenchidos)
+		}
+		if metricas[i].Esperados > 0 {
+			metricas[i].TaxaAcerto = float64(metricas[i].Corretos) / float64(metricas[i].Esperados)
+		}
+	}
+	relatorio.Campos = metricas
+
+	if relatorio.Documentos > 0 {
+		relatorio.TaxaExata = float64(exatos) / float64(relatorio.Documentos)
+	}
+
+	if len(latencias) > 0 {
+		sort.Slice(latencias, func(i, j int) bool { return latencias[i] < latencias[j] })
+		var soma time.Duration
+		for _, l := range latencias {
+			soma += l
+		}
+		relatorio.LatenciaMedia = soma / time.Duration(len(latencias))
+		relatorio.LatenciaP95 = latencias[int(math.Ceil(float64(len(latencias))*0.95))-1]
+		relatorio.LatenciaMaxima = latencias[len(latencias)-1]
+	}
+
+	return relatorio, nil
+}
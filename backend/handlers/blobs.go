@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrBlobNaoEncontrado é retornado quando não existe arquivo com a chave informada
+var ErrBlobNaoEncontrado = errors.New("arquivo não encontrado")
+
+// InfoBlob descreve um arquivo armazenado
+type InfoBlob struct {
+	Tamanho int64
+	Tipo    string
+}
+
+// BlobStore armazena os arquivos originais das notas. As chaves são derivadas do conteúdo
+// (ver chaveBlob), então gravar duas vezes o mesmo arquivo não o duplica.
+type BlobStore interface {
+	// Gravar armazena o conteúdo lido de r; tamanho pode ser -1 se desconhecido
+	Gravar(ctx context.Context, chave string, r io.Reader, tamanho int64, tipo string) error
+	// Abrir retorna o conteúdo para leitura em streaming; o chamador deve fechá-lo
+	Abrir(ctx context.Context, chave string) (io.ReadCloser, InfoBlob, error)
+	Existe(ctx context.Context, chave string) (bool, error)
+	Remover(ctx context.Context, chave string) error
+}
+
+// formatoChaveBlob aceita apenas chaves geradas por chaveBlob: <sha256 hex>[.ext]
+var formatoChaveBlob = regexp.MustCompile(`^[0-9a-f]{64}(\.[a-z0-9]{1,8})?$`)
+
+// chaveBlob gera a chave de um arquivo a partir do hash SHA-256 do conteúdo e da extensão do nome
+func chaveBlob(hash, nomeArquivo string) string {
+	ext := strings.ToLower(filepath.Ext(nomeArquivo))
+	if ext != "" && !formatoChaveBlob.MatchString(hash+ext) {
+		ext = ""
+	}
+	return hash + ext
+}
+
+// chaveBlobValida indica se a chave tem o formato gerado por chaveBlob
+func chaveBlobValida(chave string) bool {
+	return formatoChaveBlob.MatchString(chave)
+}
+
+// tipoBlob retorna o Content-Type correspondente à extensão da chave
+func tipoBlob(chave string) string {
+	if tipo := mime.TypeByExtension(filepath.Ext(chave)); tipo != "" {
+		return tipo
+	}
+	return "application/octet-stream"
+}
+
+// blobLocal guarda os arquivos em disco, em subdiretórios pelos dois primeiros caracteres da chave
+type blobLocal struct {
+	dir string
+}
+
+// NovoBlobLocal cria um BlobStore no diretório informado, convertido para caminho absoluto
+// para não depender do diretório de trabalho de quem chama
+func NovoBlobLocal(dir string) (BlobStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+	return &blobLocal{dir: abs}, nil
+}
+
+func (b *blobLocal) caminho(chave string) (string, error) {
+	if !chaveBlobValida(chave) {
+		return "", fmt.Errorf("chave de arquivo inválida: %q", chave)
+	}
+	return filepath.Join(b.dir, chave[:2], chave), nil
+}
+
+func (b *blobLocal) Gravar(_ context.Context, chave string, r io.Reader, _ int64, _ string) error {
+	caminho, err := b.caminho(chave)
+	if err != nil {
+		return err
+	}
+	return gravarAtomico(caminho, r)
+}
+
+func (b *blobLocal) Abrir(_ context.Context, chave string) (io.ReadCloser, InfoBlob, error) {
+	caminho, err := b.caminho(chave)
+	if err != nil {
+		return nil, InfoBlob{}, ErrBlobNaoEncontrado
+	}
+	f, err := os.Open(caminho)
+	if os.IsNotExist(err) {
+		return nil, InfoBlob{}, ErrBlobNaoEncontrado
+	}
+	if err != nil {
+		return nil, InfoBlob{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, InfoBlob{}, err
+	}
+	return f, InfoBlob{Tamanho: info.Size(), Tipo: tipoBlob(chave)}, nil
+}
+
+func (b *blobLocal) Existe(_ context.Context, chave string) (bool, error) {
+	caminho, err := b.caminho(chave)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(caminho)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *blobLocal) Remover(_ context.Context, chave string) error {
+	caminho, err := b.caminho(chave)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(caminho); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// blobs é o armazenamento de arquivos usado pelos handlers; configurado em main via ConfigurarBlobStore
+var blobs BlobStore = &blobLocal{dir: filepath.Join(uploadDir, "arquivos")}
+
+// ConfigurarBlobStore define o armazenamento de arquivos usado pelos handlers
+func ConfigurarBlobStore(store BlobStore) {
+	blobs = store
+}
+
+// AbrirBlobStore abre o armazenamento indicado por BLOB_STORAGE: "local" (padrão, em BLOB_DIR
+// ou uploads/arquivos) ou "s3" (qualquer serviço compatível com S3, como MinIO). Com um cofre
+// configurado (ConfigurarCofre), os arquivos são cifrados antes de gravados.
+func AbrirBlobStore() (BlobStore, error) {
+	store, err := abrirBlobStoreDriver()
+	if err != nil || cofre == nil {
+		return store, err
+	}
+	return NovoBlobCifrado(store, cofre), nil
+}
+
+// abrirBlobStoreDriver abre o armazenamento configurado em BLOB_STORAGE, sem criptografia
+func abrirBlobStoreDriver() (BlobStore, error) {
+	switch driver := os.Getenv("BLOB_STORAGE"); driver {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = filepath.Join(uploadDir, "arquivos")
+		}
+		return NovoBlobLocal(dir)
+	case "s3":
+		ssl, _ := strconv.ParseBool(os.Getenv("S3_SSL"))
+		return NovoBlobS3(ConfigS3{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Regiao:    os.Getenv("S3_REGIAO"),
+			SSL:       ssl,
+		})
+	default:
+		return nil, fmt.Errorf("BLOB_STORAGE desconhecido: %q", driver)
+	}
+}
+
+// gravarArquivoNota armazena o conteúdo de um arquivo enviado e retorna sua chave e se ele
+// foi gravado agora (false se o mesmo conteúdo já estava armazenado)
+func gravarArquivoNota(ctx context.Context, conteudo []byte, nomeArquivo string) (chave string, novo bool, err error) {
+	chave = chaveBlob(hashArquivo(conteudo), nomeArquivo)
+	existe, err := blobs.Existe(ctx, chave)
+	if err != nil || existe {
+		return chave, false, err
+	}
+	err = blobs.Gravar(ctx, chave, bytes.NewReader(conteudo), int64(len(conteudo)), tipoBlob(chave))
+	return chave, err == nil, err
+}
+
+// BaixarArquivo envia o arquivo armazenado com a chave informada
+func BaixarArquivo(c *gin.Context) {
+	chave := c.Param("chave")
+	if !chaveBlobValida(chave) {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Chave de arquivo inválida")
+		return
+	}
+
+	conteudo, info, err := blobs.Abrir(c.Request.Context(), chave)
+	if errors.Is(err, ErrBlobNaoEncontrado) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Arquivo não encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao abrir arquivo %s: %v", chave, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao ler arquivo")
+		return
+	}
+	defer conteudo.Close()
+
+	c.DataFromReader(http.StatusOK, info.Tamanho, info.Tipo, conteudo, map[string]string{
+		// Conteúdo endereçado por hash nunca muda
+		"Cache-Control": "private, max-age=31536000, immutable",
+		"ETag":          `"` + strings.TrimSuffix(chave, filepath.Ext(chave)) + `"`,
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ConfigS3 contém os dados de acesso a um serviço compatível com S3
+type ConfigS3 struct {
+	Endpoint  string // host[:porta], sem esquema
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Regiao    string
+	SSL       bool
+}
+
+// blobS3 guarda os arquivos como objetos em um bucket S3
+type blobS3 struct {
+	client *minio.Client
+	bucket string
+}
+
+// NovoBlobS3 conecta ao serviço S3 e cria o bucket se ainda não existir
+func NovoBlobS3(config ConfigS3) (BlobStore, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT e S3_BUCKET são obrigatórios")
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.SSL,
+		Region: config.Regiao,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao configurar cliente S3: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	existe, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao acessar bucket %s: %v", config.Bucket, err)
+	}
+	if !existe {
+		if err := client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Regiao}); err != nil {
+			return nil, fmt.Errorf("erro ao criar bucket %s: %v", config.Bucket, err)
+		}
+	}
+
+	return &blobS3{client: client, bucket: config.Bucket}, nil
+}
+
+// naoEncontrado indica se o erro do S3 é de objeto inexistente
+func naoEncontrado(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+func (b *blobS3) Gravar(ctx context.Context, chave string, r io.Reader, tamanho int64, tipo string) error {
+	if !chaveBlobValida(chave) {
+		return fmt.Errorf("chave de arquivo inválida: %q", chave)
+	}
+	// Com tamanho -1 o cliente envia em partes (multipart), sem carregar tudo em memória
+	_, err := b.client.PutObject(ctx, b.bucket, chave, r, tamanho, minio.PutObjectOptions{ContentType: tipo})
+	return err
+}
+
+func (b *blobS3) Abrir(ctx context.Context, chave string) (io.ReadCloser, InfoBlob, error) {
+	if !chaveBlobValida(chave) {
+		return nil, InfoBlob{}, ErrBlobNaoEncontrado
+	}
+	objeto, err := b.client.GetObject(ctx, b.bucket, chave, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, InfoBlob{}, err
+	}
+	// GetObject só contata o servidor na primeira leitura; Stat confirma que o objeto existe
+	stat, err := objeto.Stat()
+	if err != nil {
+		objeto.Close()
+		if naoEncontrado(err) {
+			return nil, InfoBlob{}, ErrBlobNaoEncontrado
+		}
+		return nil, InfoBlob{}, err
+	}
+	tipo := stat.ContentType
+	if tipo == "" {
+		tipo = tipoBlob(chave)
+	}
+	return objeto, InfoBlob{Tamanho: stat.Size, Tipo: tipo}, nil
+}
+
+func (b *blobS3) Existe(ctx context.Context, chave string) (bool, error) {
+	_, err := b.client.StatObject(ctx, b.bucket, chave, minio.StatObjectOptions{})
+	if naoEncontrado(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *blobS3) Remover(ctx context.Context, chave string) error {
+	return b.client.RemoveObject(ctx, b.bucket, chave, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// colecaoJSON armazena documentos JSON, um por ID, em um armazém de documentos: arquivos em um
+// subdiretório de uploads ou, nas coleções compartilhadas, o repositório configurado
+type colecaoJSON[T any] struct {
+	nome string
+	// cifrada indica que os documentos são cifrados com o cofre, quando configurado
+	cifrada bool
+	// compartilhada indica que a coleção usa o armazém do repositório (documentos), visível a
+	// todas as instâncias, em vez de arquivos locais
+	compartilhada bool
+}
+
+// novaColecaoJSON cria uma coleção no subdiretório informado do diretório de uploads
+func novaColecaoJSON[T any](nome string) *colecaoJSON[T] {
+	return &colecaoJSON[T]{nome: nome}
+}
+
+// novaColecaoJSONCifrada cria uma coleção cujos documentos, por conterem dados pessoais, são
+// cifrados em repouso como as notas (ver cifrarJSON)
+func novaColecaoJSONCifrada[T any](nome string) *colecaoJSON[T] {
+	return &colecaoJSON[T]{nome: nome, cifrada: true}
+}
+
+// novaColecaoCompartilhada cria uma coleção cifrada guardada no repositório configurado, para
+// documentos que precisam ser vistos por todas as instâncias (lotes, idempotência, webhooks)
+func novaColecaoCompartilhada[T any](nome string) *colecaoJSON[T] {
+	colecoesCompartilhadas = append(colecoesCompartilhadas, nome)
+	return &colecaoJSON[T]{nome: nome, cifrada: true, compartilhada: true}
+}
+
+// colecoesCompartilhadas lista os nomes das coleções criadas por novaColecaoCompartilhada, cujos
+// documentos RotacionarChaves regrava com a chave ativa
+var colecoesCompartilhadas []string
+
+// armazem retorna onde os documentos da coleção são guardados
+func (c *colecaoJSON[T]) armazem() ArmazemDocumentos {
+	if c.compartilhada {
+		return documentos
+	}
+	return armazemLocal
+}
+
+// codificar serializa um documento como gravado no armazém
+func (c *colecaoJSON[T]) codificar(doc T) ([]byte, error) {
+	conteudo, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil || !c.cifrada {
+		return conteudo, err
+	}
+	return cifrarJSON(conteudo)
+}
+
+// decodificar lê um documento gravado por salvar
+func (c *colecaoJSON[T]) decodificar(conteudo []byte, doc *T) error {
+	if c.cifrada {
+		var err error
+		if conteudo, err = decifrarJSON(conteudo); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(conteudo, doc)
+}
+
+// salvar grava (ou substitui) o documento com o ID informado
+func (c *colecaoJSON[T]) salvar(id string, doc T) error {
+	conteudo, err := c.codificar(doc)
+	if err != nil {
+		return err
+	}
+	return c.armazem().GravarDocumento(context.Background(), c.nome, id, conteudo)
+}
+
+// criar grava o documento apenas se o ID ainda não existir; false se já existir
+func (c *colecaoJSON[T]) criar(id string, doc T) (bool, error) {
+	conteudo, err := c.codificar(doc)
+	if err != nil {
+		return false, err
+	}
+	return c.armazem().CriarDocumento(context.Background(), c.nome, id, conteudo)
+}
+
+// carregar lê o documento com o ID informado; retorna os.ErrNotExist se não existir
+func (c *colecaoJSON[T]) carregar(id string) (T, error) {
+	var doc T
+	conteudo, err := c.armazem().LerDocumento(context.Background(), c.nome, id)
+	if err != nil {
+		return doc, err
+	}
+	err = c.decodificar(conteudo, &doc)
+	return doc, err
+}
+
+// listar lê todos os documentos da coleção, em ordem de ID, ignorando documentos inválidos
+func (c *colecaoJSON[T]) listar() ([]T, error) {
+	conteudos, err := c.armazem().ListarDocumentos(context.Background(), c.nome)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(conteudos))
+	for id := range conteudos {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var docs []T
+	for _, id := range ids {
+		var doc T
+		if err := c.decodificar(conteudos[id], &doc); err != nil {
+			log.Printf("Erro ao deserializar documento %s/%s: %v", c.nome, id, err)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// remover apaga o documento com o ID informado
+func (c *colecaoJSON[T]) remover(id string) error {
+	return c.armazem().RemoverDocumento(context.Background(), c.nome, id)
+}
+
+// Resultado das funções passadas a atualizar e atualizarTodos para cada documento
+const (
+	documentoMantido = iota
+	documentoAlterado
+	documentoRemovido
+)
+
+// tentativasAtualizacao limita as releituras quando outra gravação altera o documento
+const tentativasAtualizacao = 5
+
+// aplicar aplica f ao documento, cujo conteúdo atual é informado, e grava o resultado apenas se
+// o documento não tiver mudado desde a leitura; se mudou, relê e tenta de novo
+func (c *colecaoJSON[T]) aplicar(id string, atual []byte, f func(doc *T) int) (T, int, error) {
+	ctx := context.Background()
+	armazem := c.armazem()
+	for range tentativasAtualizacao {
+		var doc T
+		if err := c.decodificar(atual, &doc); err != nil {
+			return doc, documentoMantido, err
+		}
+		resultado := f(&doc)
+		switch resultado {
+		case documentoAlterado:
+			conteudo, err := c.codificar(doc)
+			if err != nil {
+				return doc, documentoMantido, err
+			}
+			if ok, err := armazem.SubstituirDocumento(ctx, c.nome, id, atual, conteudo); err != nil || ok {
+				return doc, resultado, err
+			}
+		case documentoRemovido:
+			// A remoção não compara o conteúdo: quem remove descarta o documento de qualquer forma
+			if err := armazem.RemoverDocumento(ctx, c.nome, id); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return doc, documentoMantido, err
+			}
+			return doc, resultado, nil
+		default:
+			return doc, resultado, nil
+		}
+
+		var err error
+		if atual, err = armazem.LerDocumento(ctx, c.nome, id); err != nil {
+			var vazio T
+			return vazio, documentoMantido, err
+		}
+	}
+	var vazio T
+	return vazio, documentoMantido, fmt.Errorf("documento %s/%s alterado concorrentemente", c.nome, id)
+}
+
+// atualizar lê o documento, aplica f e grava o resultado sem perder gravações concorrentes,
+// inclusive de outras instâncias nas coleções compartilhadas. Retorna os.ErrNotExist se o
+// documento não existir.
+func (c *colecaoJSON[T]) atualizar(id string, f func(doc *T) int) (T, int, error) {
+	atual, err := c.armazem().LerDocumento(context.Background(), c.nome, id)
+	if err != nil {
+		var vazio T
+		return vazio, documentoMantido, err
+	}
+	return c.aplicar(id, atual, f)
+}
+
+// atualizarTodos aplica f a cada documento da coleção, como atualizar. Retorna quantos foram
+// alterados ou removidos.
+func (c *colecaoJSON[T]) atualizarTodos(f func(id string, doc *T) int) (int, error) {
+	conteudos, err := c.armazem().ListarDocumentos(context.Background(), c.nome)
+	if err != nil {
+		return 0, err
+	}
+	atualizados := 0
+	for id, atual := range conteudos {
+		_, resultado, err := c.aplicar(id, atual, func(doc *T) int { return f(id, doc) })
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return atualizados, err
+		}
+		if resultado != documentoMantido {
+			atualizados++
+		}
+	}
+	return atualizados, nil
+}
+
+// importarLocais move para o repositório os documentos que uma coleção compartilhada gravou em
+// arquivos antes de o repositório guardar documentos. Documentos já presentes são mantidos.
+func (c *colecaoJSON[T]) importarLocais() (int, error) {
+	if !c.compartilhada || documentos == ArmazemDocumentos(armazemLocal) {
+		return 0, nil
+	}
+	ctx := context.Background()
+	locais, err := armazemLocal.ListarDocumentos(ctx, c.nome)
+	if err != nil {
+		return 0, err
+	}
+	importados := 0
+	for id, conteudo := range locais {
+		criado, err := documentos.CriarDocumento(ctx, c.nome, id, conteudo)
+		if err != nil {
+			return importados, err
+		}
+		if criado {
+			importados++
+		}
+		if err := armazemLocal.RemoverDocumento(ctx, c.nome, id); err != nil {
+			return importados, err
+		}
+	}
+	return importados, nil
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Arquivos compactados (.zip, .tar.gz/.tgz) enviados em /upload e /jobs são expandidos e cada
+// documento contido é processado como se tivesse sido enviado individualmente.
+
+// LimitesCompactado protege a expansão contra arquivos compactados maliciosos (zip bombs). Os
+// limites valem para a requisição inteira, somando todos os compactados enviados.
+type LimitesCompactado struct {
+	// MaxArquivos é o número máximo de documentos nos compactados
+	MaxArquivos int
+	// MaxBytes é o tamanho máximo, já descompactado, de todos os documentos somados
+	MaxBytes int64
+	// MaxBytesArquivo é o tamanho máximo, já descompactado, de cada documento
+	MaxBytesArquivo int64
+}
+
+// limitesCompactadoEnv lê os limites de COMPACTADO_MAX_ARQUIVOS (padrão 500),
+// COMPACTADO_MAX_MB (padrão 200) e COMPACTADO_MAX_MB_ARQUIVO (padrão 20)
+func limitesCompactadoEnv() LimitesCompactado {
+	limites := LimitesCompactado{MaxArquivos: 500, MaxBytes: 200 << 20, MaxBytesArquivo: 20 << 20}
+	if n, err := strconv.Atoi(os.Getenv("COMPACTADO_MAX_ARQUIVOS")); err == nil && n > 0 {
+		limites.MaxArquivos = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("COMPACTADO_MAX_MB"), 10, 64); err == nil && n > 0 {
+		limites.MaxBytes = n << 20
+	}
+	if n, err := strconv.ParseInt(os.Getenv("COMPACTADO_MAX_MB_ARQUIVO"), 10, 64); err == nil && n > 0 {
+		limites.MaxBytesArquivo = n << 20
+	}
+	return limites
+}
+
+// orcamentoCompactados controla o consumo dos limites pelos compactados de uma requisição.
+// Os documentos de ZIP são lidos sob demanda, inclusive em paralelo, e consomem o orçamento
+// de bytes na leitura.
+type orcamentoCompactados struct {
+	limites LimitesCompactado
+	mu      sync.Mutex
+	// arquivos e declarados são contados ao listar os compactados; lidos, ao ler os documentos
+	arquivos   int
+	declarados int64
+	lidos      int64
+}
+
+// incluir conta mais um documento, com o tamanho declarado no compactado (ou já lido)
+func (o *orcamentoCompactados) incluir(caminho string, declarado int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.arquivos >= o.limites.MaxArquivos {
+		return falhaExtracao(CodigoLimiteCompactado, "mais de %d arquivos nos compactados enviados", o.limites.MaxArquivos)
+	}
+	if declarado > o.limites.MaxBytesArquivo {
+		return falhaExtracao(CodigoLimiteCompactado, "%s excede o tamanho máximo descompactado", caminho)
+	}
+	if o.declarados+declarado > o.limites.MaxBytes {
+		return falhaExtracao(CodigoLimiteCompactado, "os compactados enviados excedem o tamanho máximo descompactado")
+	}
+	o.arquivos++
+	o.declarados += declarado
+	return nil
+}
+
+// ler lê um documento sem confiar no tamanho declarado, consumindo o orçamento de bytes
+func (o *orcamentoCompactados) ler(caminho string, r io.Reader, w io.Writer) (int64, error) {
+	o.mu.Lock()
+	limite := min(o.limites.MaxBytesArquivo, o.limites.MaxBytes-o.lidos)
+	o.mu.Unlock()
+
+	n, err := io.Copy(w, io.LimitReader(r, limite+1))
+	if err != nil {
+		return n, falhaExtracao(CodigoCompactadoInvalido, "erro ao descompactar %s: %v", caminho, err)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if n > limite || o.lidos+n > o.limites.MaxBytes {
+		return n, falhaExtracao(CodigoLimiteCompactado, "%s excede o tamanho máximo descompactado", caminho)
+	}
+	o.lidos += n
+	return n, nil
+}
+
+// documentoUpload é um documento a extrair: um arquivo enviado ou um arquivo de dentro de um
+// arquivo compactado enviado. Nos compactados, o nome é <compactado>/<caminho interno>.
+type documentoUpload struct {
+	nome string
+	ler  func() ([]byte, error)
+}
+
+// documentosEnviados lista os documentos dos arquivos enviados, expandindo os compactados.
+// Um compactado que não pode ser expandido vira um documento cuja leitura falha com o código
+// do problema, para ser relatado como os demais arquivos. Os documentos de ZIP são lidos do
+// arquivo enviado só quando processados; os de .tar.gz, que não permite acesso direto, são
+// copiados para arquivos temporários. limpar fecha e apaga esses arquivos e deve ser chamada
+// depois que os documentos forem processados.
+func documentosEnviados(files []*multipart.FileHeader) (documentos []documentoUpload, limpar func()) {
+	orcamento := &orcamentoCompactados{limites: limitesCompactadoEnv()}
+	var fechar []func()
+	limpar = func() {
+		for _, f := range fechar {
+			f()
+		}
+	}
+
+	for _, fileHeader := range files {
+		nome := fileHeader.Filename
+		if !arquivoCompactado(nome) {
+			documentos = append(documentos, documentoUpload{nome: nome, ler: func() ([]byte, error) {
+				return lerArquivoEnviado(fileHeader)
+			}})
+			continue
+		}
+
+		contidos, fecharCompactado, err := expandirCompactado(fileHeader, orcamento)
+		if fecharCompactado != nil {
+			fechar = append(fechar, fecharCompactado)
+		}
+		if err != nil {
+			documentos = append(documentos, documentoUpload{nome: nome, ler: func() ([]byte, error) { return nil, err }})
+			continue
+		}
+		documentos = append(documentos, contidos...)
+	}
+	return documentos, limpar
+}
+
+// arquivoCompactado indica, pela extensão, se o arquivo deve ser expandido
+func arquivoCompactado(nome string) bool {
+	nome = strings.ToLower(nome)
+	return strings.HasSuffix(nome, ".zip") || strings.HasSuffix(nome, ".tar.gz") || strings.HasSuffix(nome, ".tgz")
+}
+
+// caminhoInterno normaliza o caminho de um arquivo dentro do compactado. Caminhos absolutos
+// ou que saem da raiz (zip slip) são rejeitados; entradas ignoradas retornam "" sem erro.
+func caminhoInterno(nome string) (string, error) {
+	nome = strings.ReplaceAll(nome, `\`, "/")
+	if strings.HasPrefix(nome, "/") || (len(nome) > 1 && nome[1] == ':') {
+		return "", falhaExtracao(CodigoCompactadoInvalido, "caminho absoluto no arquivo compactado: %q", nome)
+	}
+	limpo := path.Clean(nome)
+	if limpo == ".." || strings.HasPrefix(limpo, "../") {
+		return "", falhaExtracao(CodigoCompactadoInvalido, "caminho fora do arquivo compactado: %q", nome)
+	}
+	// Metadados do macOS e arquivos ocultos não são documentos
+	if strings.HasPrefix(limpo, "__MACOSX/") || strings.HasPrefix(path.Base(limpo), ".") {
+		return "", nil
+	}
+	return sanitizarTexto(limpo, 255), nil
+}
+
+// expandirCompactado lista os documentos de um .zip ou .tar.gz enviado. Diretórios, links e
+// arquivos especiais são ignorados; compactados dentro do compactado não são expandidos. O
+// retorno fechar libera o arquivo enviado ou os temporários e pode ser não nil mesmo com erro.
+func expandirCompactado(fileHeader *multipart.FileHeader, orcamento *orcamentoCompactados) ([]documentoUpload, func(), error) {
+	compactado := sanitizarNomeArquivo(fileHeader.Filename)
+	arquivo, err := fileHeader.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var documentos []documentoUpload
+	if strings.HasSuffix(strings.ToLower(fileHeader.Filename), ".zip") {
+		fechar := func() { arquivo.Close() }
+		leitor, err := zip.NewReader(arquivo, fileHeader.Size)
+		if err != nil {
+			return nil, fechar, falhaExtracao(CodigoCompactadoInvalido, "arquivo ZIP inválido: %v", err)
+		}
+		for _, contido := range leitor.File {
+			if !contido.Mode().IsRegular() {
+				continue
+			}
+			caminho, err := caminhoInterno(contido.Name)
+			if err != nil {
+				return nil, fechar, err
+			}
+			if caminho == "" {
+				continue
+			}
+			if err := orcamento.incluir(caminho, int64(min(contido.UncompressedSize64, 1<<62))); err != nil {
+				return nil, fechar, err
+			}
+			documentos = append(documentos, documentoUpload{
+				nome: compactado + "/" + caminho,
+				ler: func() ([]byte, error) {
+					r, err := contido.Open()
+					if err != nil {
+						return nil, falhaExtracao(CodigoCompactadoInvalido, "erro ao abrir %s: %v", contido.Name, err)
+					}
+					defer r.Close()
+					var conteudo bytes.Buffer
+					_, err = orcamento.ler(caminho, r, &conteudo)
+					return conteudo.Bytes(), err
+				},
+			})
+		}
+		if len(documentos) == 0 {
+			return nil, fechar, falhaExtracao(CodigoResultadoVazio, "nenhum documento no arquivo compactado")
+		}
+		return documentos, fechar, nil
+	}
+
+	defer arquivo.Close()
+	temporarios, err := os.MkdirTemp("", "compactado-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	fechar := func() { os.RemoveAll(temporarios) }
+	gz, err := gzip.NewReader(arquivo)
+	if err != nil {
+		return nil, fechar, falhaExtracao(CodigoCompactadoInvalido, "arquivo .tar.gz inválido: %v", err)
+	}
+	defer gz.Close()
+	leitor := tar.NewReader(gz)
+	for {
+		cabecalho, err := leitor.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fechar, falhaExtracao(CodigoCompactadoInvalido, "arquivo .tar.gz inválido: %v", err)
+		}
+		if cabecalho.Typeflag != tar.TypeReg {
+			continue
+		}
+		caminho, err := caminhoInterno(cabecalho.Name)
+		if err != nil {
+			return nil, fechar, err
+		}
+		if caminho == "" {
+			continue
+		}
+		if err := orcamento.incluir(caminho, cabecalho.Size); err != nil {
+			return nil, fechar, err
+		}
+
+		temporario := filepath.Join(temporarios, strconv.Itoa(len(documentos)))
+		if err := copiarParaTemporario(temporario, caminho, leitor, orcamento); err != nil {
+			return nil, fechar, err
+		}
+		documentos = append(documentos, documentoUpload{
+			nome: compactado + "/" + caminho,
+			ler:  func() ([]byte, error) { return os.ReadFile(temporario) },
+		})
+	}
+	if len(documentos) == 0 {
+		return nil, fechar, falhaExtracao(CodigoResultadoVazio, "nenhum documento no arquivo compactado")
+	}
+	return documentos, fechar, nil
+}
+
+// copiarParaTemporario grava um documento do .tar.gz em disco, consumindo o orçamento
+func copiarParaTemporario(temporario, caminho string, r io.Reader, orcamento *orcamentoCompactados) error {
+	f, err := os.OpenFile(temporario, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := orcamento.ler(caminho, r, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// codigoErroLeitura classifica o erro ao ler um documento enviado
+func codigoErroLeitura(err error) string {
+	var erro *ErroExtracao
+	if errors.As(err, &erro) {
+		return erro.Codigo
+	}
+	return CodigoArquivoIlegivel
+}
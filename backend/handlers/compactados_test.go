@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"hash/crc32"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+// entradaTeste é um arquivo dentro de um compactado montado pelo teste
+type entradaTeste struct {
+	nome     string
+	conteudo []byte
+}
+
+// zipTeste monta um .zip com as entradas informadas, sem validar os nomes
+func zipTeste(t *testing.T, entradas ...entradaTeste) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entradas {
+		f, err := w.Create(e.nome)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(e.conteudo)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipTamanhoFalso monta um .zip com uma entrada comprimida cujo cabeçalho declara o tamanho
+// descompactado informado, diferente do real
+func zipTamanhoFalso(t *testing.T, nome string, conteudo []byte, declarado uint64) []byte {
+	t.Helper()
+	var comprimido bytes.Buffer
+	fw, _ := flate.NewWriter(&comprimido, flate.BestCompression)
+	fw.Write(conteudo)
+	fw.Close()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.CreateRaw(&zip.FileHeader{
+		Name:               nome,
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(conteudo),
+		CompressedSize64:   uint64(comprimido.Len()),
+		UncompressedSize64: declarado,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(comprimido.Bytes())
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarGzTeste monta um .tar.gz; cabeçalhos sem conteúdo (links, diretórios) são gravados como estão
+func tarGzTeste(t *testing.T, cabecalhos []*tar.Header, conteudos map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for _, h := range cabecalhos {
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(conteudos[h.Name]))
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(conteudos[h.Name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+// enviadosTeste monta os cabeçalhos de arquivos enviados em um formulário multipart
+func enviadosTeste(t *testing.T, arquivos ...entradaTeste) []*multipart.FileHeader {
+	t.Helper()
+	var corpo bytes.Buffer
+	w := multipart.NewWriter(&corpo)
+	for _, a := range arquivos {
+		parte, err := w.CreateFormFile("files", a.nome)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parte.Write(a.conteudo)
+	}
+	w.Close()
+	form, err := multipart.NewReader(&corpo, w.Boundary()).ReadForm(64 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["files"]
+}
+
+// expandirTeste expande os arquivos enviados e lê cada documento, retornando nome → conteúdo
+// ou nome → código do erro de leitura
+func expandirTeste(t *testing.T, arquivos ...entradaTeste) (conteudos map[string]string, erros map[string]string) {
+	t.Helper()
+	documentos, limpar := documentosEnviados(enviadosTeste(t, arquivos...))
+	t.Cleanup(limpar)
+	conteudos, erros = map[string]string{}, map[string]string{}
+	for _, doc := range documentos {
+		conteudo, err := doc.ler()
+		if err != nil {
+			erros[doc.nome] = codigoErroLeitura(err)
+			continue
+		}
+		conteudos[doc.nome] = string(conteudo)
+	}
+	return conteudos, erros
+}
+
+func TestCaminhoInterno(t *testing.T) {
+	casos := []struct {
+		nome     string
+		esperado string
+		invalido bool
+	}{
+		{nome: "nota.pdf", esperado: "nota.pdf"},
+		{nome: "pasta/nota.pdf", esperado: "pasta/nota.pdf"},
+		{nome: "./pasta/../nota.pdf", esperado: "nota.pdf"},
+		{nome: `pasta\nota.pdf`, esperado: "pasta/nota.pdf"},
+		{nome: "__MACOSX/._nota.pdf", esperado: ""},
+		{nome: "pasta/.DS_Store", esperado: ""},
+		{nome: "../nota.pdf", invalido: true},
+		{nome: "pasta/../../nota.pdf", invalido: true},
+		{nome: `..\nota.pdf`, invalido: true},
+		{nome: "..", invalido: true},
+		{nome: "/etc/passwd", invalido: true},
+		{nome: `\\servidor\nota.pdf`, invalido: true},
+		{nome: "C:/Windows/nota.pdf", invalido: true},
+		{nome: `C:\Windows\nota.pdf`, invalido: true},
+		{nome: "c:nota.pdf", invalido: true},
+	}
+	for _, caso := range casos {
+		caminho, err := caminhoInterno(caso.nome)
+		if caso.invalido {
+			if err == nil || codigoErroLeitura(err) != CodigoCompactadoInvalido {
+				t.Errorf("%q: caminho %q, erro %v; esperado %s", caso.nome, caminho, err, CodigoCompactadoInvalido)
+			}
+			continue
+		}
+		if err != nil || caminho != caso.esperado {
+			t.Errorf("%q: caminho %q, erro %v; esperado %q", caso.nome, caminho, err, caso.esperado)
+		}
+	}
+}
+
+func TestExpandirCompactadoCaminhosForaDaRaiz(t *testing.T) {
+	nota := []byte("%PDF-1.4 nota")
+	casos := map[string][]byte{
+		"zip com ../":          zipTeste(t, entradaTeste{"nota.pdf", nota}, entradaTeste{"../../fora.pdf", nota}),
+		"zip com caminho /":    zipTeste(t, entradaTeste{"/tmp/fora.pdf", nota}),
+		"zip com C:":           zipTeste(t, entradaTeste{`C:\Users\fora.pdf`, nota}),
+		"tar.gz com ../":       tarGzTeste(t, []*tar.Header{{Name: "a/../../fora.pdf", Typeflag: tar.TypeReg, Mode: 0644}}, map[string][]byte{"a/../../fora.pdf": nota}),
+		"tar.gz com caminho /": tarGzTeste(t, []*tar.Header{{Name: "/etc/cron.d/fora", Typeflag: tar.TypeReg, Mode: 0644}}, map[string][]byte{"/etc/cron.d/fora": nota}),
+	}
+	for nome, compactado := range casos {
+		extensao := ".zip"
+		if strings.HasPrefix(nome, "tar.gz") {
+			extensao = ".tar.gz"
+		}
+		conteudos, erros := expandirTeste(t, entradaTeste{"envio" + extensao, compactado})
+		if len(conteudos) != 0 || erros["envio"+extensao] != CodigoCompactadoInvalido {
+			t.Errorf("%s: documentos %v, erros %v; esperado o compactado recusado com %s", nome, conteudos, erros, CodigoCompactadoInvalido)
+		}
+	}
+}
+
+func TestExpandirZipTamanhoDeclaradoFalso(t *testing.T) {
+	t.Setenv("COMPACTADO_MAX_MB_ARQUIVO", "1")
+	grande := bytes.Repeat([]byte{0}, 3<<20)
+
+	// Declarado abaixo do limite, real acima: a leitura é interrompida (pelo leitor de ZIP, que não
+	// passa do tamanho declarado, ou pelo orçamento) e o documento é recusado
+	pequeno := zipTamanhoFalso(t, "nota.pdf", grande, 100)
+	conteudos, erros := expandirTeste(t, entradaTeste{"pequeno.zip", pequeno})
+	if len(conteudos) != 0 || erros["pequeno.zip/nota.pdf"] == "" {
+		t.Errorf("tamanho subdeclarado: documentos %d, erros %v", len(conteudos), erros)
+	}
+
+	// Declarado acima do limite: recusado antes de descompactar
+	enorme := zipTamanhoFalso(t, "nota.pdf", []byte("%PDF-1.4"), 1<<40)
+	conteudos, erros = expandirTeste(t, entradaTeste{"enorme.zip", enorme})
+	if len(conteudos) != 0 || erros["enorme.zip"] != CodigoLimiteCompactado {
+		t.Errorf("tamanho superdeclarado: documentos %d, erros %v; esperado %s", len(conteudos), erros, CodigoLimiteCompactado)
+	}
+}
+
+func TestOrcamentoCompactadosLeituraIgnoraDeclarado(t *testing.T) {
+	orcamento := &orcamentoCompactados{limites: LimitesCompactado{MaxArquivos: 10, MaxBytes: 100, MaxBytesArquivo: 60}}
+	if err := orcamento.incluir("a.pdf", 1); err != nil {
+		t.Fatal(err)
+	}
+	var destino bytes.Buffer
+	n, err := orcamento.ler("a.pdf", bytes.NewReader(make([]byte, 1000)), &destino)
+	if codigoErroLeitura(err) != CodigoLimiteCompactado || n > 61 || destino.Len() > 61 {
+		t.Fatalf("lidos %d bytes, erro %v; esperado no máximo o limite + 1 e %s", n, err, CodigoLimiteCompactado)
+	}
+
+	// O orçamento da requisição é compartilhado: 50 + 50 cabem, o terceiro não
+	for i, esperado := range []string{"", "", CodigoLimiteCompactado} {
+		_, err := orcamento.ler("b.pdf", bytes.NewReader(make([]byte, 50)), &bytes.Buffer{})
+		if (esperado == "" && err != nil) || (esperado != "" && codigoErroLeitura(err) != esperado) {
+			t.Fatalf("leitura %d: erro %v, esperado %q", i+1, err, esperado)
+		}
+	}
+}
+
+func TestExpandirCompactadosLimitesDaRequisicao(t *testing.T) {
+	nota := []byte("%PDF-1.4 nota")
+	tresNotas := zipTeste(t, entradaTeste{"1.pdf", nota}, entradaTeste{"2.pdf", nota}, entradaTeste{"3.pdf", nota})
+
+	t.Run("arquivos", func(t *testing.T) {
+		t.Setenv("COMPACTADO_MAX_ARQUIVOS", "5")
+		conteudos, erros := expandirTeste(t, entradaTeste{"a.zip", tresNotas}, entradaTeste{"b.zip", tresNotas})
+		if len(conteudos) != 3 || erros["b.zip"] != CodigoLimiteCompactado {
+			t.Errorf("documentos %d, erros %v; esperado o segundo compactado recusado", len(conteudos), erros)
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		t.Setenv("COMPACTADO_MAX_MB", "1")
+		meioMega := zipTeste(t, entradaTeste{"nota.pdf", bytes.Repeat([]byte{0}, 600<<10)})
+		conteudos, erros := expandirTeste(t, entradaTeste{"a.zip", meioMega}, entradaTeste{"b.zip", meioMega})
+		if len(conteudos) != 1 || erros["b.zip"] != CodigoLimiteCompactado {
+			t.Errorf("documentos %d, erros %v; esperado o segundo compactado recusado", len(conteudos), erros)
+		}
+	})
+
+	t.Run("bytes por arquivo", func(t *testing.T) {
+		t.Setenv("COMPACTADO_MAX_MB_ARQUIVO", "1")
+		grande := tarGzTeste(t, []*tar.Header{{Name: "grande.pdf", Typeflag: tar.TypeReg, Mode: 0644}},
+			map[string][]byte{"grande.pdf": bytes.Repeat([]byte{0}, 2<<20)})
+		conteudos, erros := expandirTeste(t, entradaTeste{"envio.tgz", grande})
+		if len(conteudos) != 0 || erros["envio.tgz"] != CodigoLimiteCompactado {
+			t.Errorf("documentos %d, erros %v; esperado %s", len(conteudos), erros, CodigoLimiteCompactado)
+		}
+	})
+}
+
+func TestExpandirTarGzIgnoraLinks(t *testing.T) {
+	nota := []byte("%PDF-1.4 nota")
+	compactado := tarGzTeste(t, []*tar.Header{
+		{Name: "pasta/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "pasta/link.pdf", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd", Mode: 0777},
+		{Name: "pasta/fixo.pdf", Typeflag: tar.TypeLink, Linkname: "../../etc/shadow", Mode: 0644},
+		{Name: "pasta/nota.pdf", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string][]byte{"pasta/nota.pdf": nota})
+
+	conteudos, erros := expandirTeste(t, entradaTeste{"envio.tar.gz", compactado})
+	if len(erros) != 0 || len(conteudos) != 1 || conteudos["envio.tar.gz/pasta/nota.pdf"] != string(nota) {
+		t.Fatalf("documentos %v, erros %v; esperado apenas pasta/nota.pdf", conteudos, erros)
+	}
+
+	// Só links: nada a processar
+	apenasLinks := tarGzTeste(t, []*tar.Header{{Name: "link.pdf", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}}, nil)
+	if _, erros := expandirTeste(t, entradaTeste{"links.tgz", apenasLinks}); erros["links.tgz"] != CodigoResultadoVazio {
+		t.Fatalf("compactado só com links: erros %v, esperado %s", erros, CodigoResultadoVazio)
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tipos de instrumento de compra aceitos
+const (
+	TipoPedidoCompra = "pedido"
+	TipoContrato     = "contrato"
+)
+
+// Situações da conciliação de uma nota fiscal com pedidos de compra e contratos
+const (
+	ConciliacaoConforme         = "conforme"
+	ConciliacaoSemContrato      = "sem_contrato"
+	ConciliacaoContratoExpirado = "contrato_expirado"
+	ConciliacaoValorExcedido    = "valor_excedido"
+)
+
+// Contrato representa um pedido de compra ou contrato aprovado com um fornecedor
+type Contrato struct {
+	ID             string        `json:"id"`
+	Tipo           string        `json:"tipo"`
+	Numero         string        `json:"numero"`
+	CNPJFornecedor string        `json:"cnpjFornecedor"`
+	Descricao      string        `json:"descricao"`
+	ValorTeto      float64       `json:"valorTeto"`
+	VigenciaInicio Data          `json:"vigenciaInicio"`
+	VigenciaFim    Data          `json:"vigenciaFim"`
+	Competencias   []Competencia `json:"competencias"` // vazio: todas as competências da vigência
+	Encerrado      bool          `json:"encerrado"`
+	CriadoEm       time.Time     `json:"criadoEm"`
+}
+
+// ResultadoConciliacao descreve a correspondência de uma nota fiscal com um contrato
+type ResultadoConciliacao struct {
+	NotaID         string      `json:"notaId"`
+	NumeroNota     string      `json:"numeroNota"`
+	CNPJ           string      `json:"cnpj"`
+	Competencia    Competencia `json:"competencia"`
+	Valor          float64     `json:"valor"`
+	Status         string      `json:"status"`
+	ContratoID     string      `json:"contratoId,omitempty"`
+	ContratoNumero string      `json:"contratoNumero,omitempty"`
+	ValorTeto      float64     `json:"valorTeto,omitempty"`
+	ValorFaturado  float64     `json:"valorFaturado,omitempty"` // acumulado no contrato, incluindo esta nota
+	Excedente      float64     `json:"excedente,omitempty"`
+	Mensagem       string      `json:"mensagem"`
+}
+
+// contratos guarda os contratos no repositório configurado, para que a conciliação seja a
+// mesma em todas as instâncias; cifrados em repouso, pois contêm os dados dos fornecedores
+var contratos = novaColecaoCompartilhada[Contrato]("contratos")
+
+// IniciarContratos importa para o repositório os contratos gravados em uploads/contratos/ antes
+// do armazenamento no repositório
+func IniciarContratos() error {
+	importados, err := contratos.importarLocais()
+	if importados > 0 {
+		log.Printf("%d contratos importados para o repositório", importados)
+	}
+	return err
+}
+
+// validar verifica os campos obrigatórios e normaliza o contrato
+func (ct *Contrato) validar() error {
+	ct.Tipo = strings.ToLower(strings.TrimSpace(ct.Tipo))
+	if ct.Tipo == "" {
+		ct.Tipo = TipoContrato
+	}
+	if ct.Tipo != TipoContrato && ct.Tipo != TipoPedidoCompra {
+		return fmt.Errorf("tipo deve ser %q ou %q", TipoContrato, TipoPedidoCompra)
+	}
+	if normalizarCNPJ(ct.CNPJFornecedor) == "" {
+		return errors.New("CNPJ do fornecedor é obrigatório")
+	}
+	if ct.ValorTeto <= 0 {
+		return errors.New("valor teto deve ser maior que zero")
+	}
+	if ct.VigenciaInicio.IsZero() || ct.VigenciaFim.IsZero() {
+		return errors.New("início e fim da vigência são obrigatórios")
+	}
+	if ct.VigenciaFim.Before(ct.VigenciaInicio.Time) {
+		return errors.New("fim da vigência anterior ao início")
+	}
+	if ct.Competencias == nil {
+		ct.Competencias = []Competencia{}
+	}
+	for _, competencia := range ct.Competencias {
+		if competencia.IsZero() {
+			return errors.New("competência vazia na lista de competências")
+		}
+	}
+	return nil
+}
+
+// cobre indica se o contrato cobre a data de referência e a competência da nota
+func (ct Contrato) cobre(referencia Data, competencia Competencia) bool {
+	if ct.Encerrado || referencia.Before(ct.VigenciaInicio.Time) || referencia.After(ct.VigenciaFim.Time) {
+		return false
+	}
+	if len(ct.Competencias) == 0 {
+		return true
+	}
+	for _, c := range ct.Competencias {
+		if c.Comparar(competencia) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dataReferencia retorna a data usada para verificar a vigência: a data da nota
+// ou, na falta dela, o primeiro dia da competência
+func dataReferencia(nota NotaFiscalData) Data {
+	if !nota.DataNota.IsZero() || nota.Competencia.IsZero() {
+		return nota.DataNota
+	}
+	return Data{time.Date(nota.Competencia.Ano, nota.Competencia.Mes, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// conciliarNotas associa cada nota fiscal a um contrato aberto do mesmo fornecedor,
+// acumulando o valor faturado por contrato em ordem cronológica
+func conciliarNotas(notas []NotaFiscalData, lista []Contrato) []ResultadoConciliacao {
+	ordenadas := append([]NotaFiscalData(nil), notas...)
+	sort.SliceStable(ordenadas, func(i, j int) bool {
+		di, dj := dataReferencia(ordenadas[i]), dataReferencia(ordenadas[j])
+		if !di.Equal(dj.Time) {
+			return di.Before(dj.Time)
+		}
+		return ordenadas[i].ID < ordenadas[j].ID
+	})
+
+	porFornecedor := make(map[string][]Contrato)
+	for _, ct := range lista {
+		cnpj := normalizarCNPJ(ct.CNPJFornecedor)
+		porFornecedor[cnpj] = append(porFornecedor[cnpj], ct)
+	}
+	for _, cts := range porFornecedor {
+		sort.Slice(cts, func(i, j int) bool { return cts[i].VigenciaInicio.Before(cts[j].VigenciaInicio.Time) })
+	}
+
+	faturado := make(map[string]float64)
+	resultados := make([]ResultadoConciliacao, 0, len(ordenadas))
+
+	for _, nota := range ordenadas {
+		resultado := ResultadoConciliacao{
+			NotaID:      nota.ID,
+			NumeroNota:  nota.NumeroNota,
+			CNPJ:        nota.CNPJ,
+			Competencia: nota.Competencia,
+			Valor:       nota.ValorServicos,
+		}
+
+		candidatos := porFornecedor[normalizarCNPJ(nota.CNPJ)]
+		if len(candidatos) == 0 {
+			resultado.Status = ConciliacaoSemContrato
+			resultado.Mensagem = "Nenhum pedido de compra ou contrato cadastrado para o fornecedor"
+			resultados = append(resultados, resultado)
+			continue
+		}
+
+		referencia := dataReferencia(nota)
+		var vigentes []Contrato
+		for _, ct := range candidatos {
+			if ct.cobre(referencia, nota.Competencia) {
+				vigentes = append(vigentes, ct)
+			}
+		}
+		if len(vigentes) == 0 {
+			resultado.Status = ConciliacaoContratoExpirado
+			resultado.Mensagem = "Nenhum contrato do fornecedor vigente na data e competência da nota"
+			resultados = append(resultados, resultado)
+			continue
+		}
+
+		// Preferir o primeiro contrato com saldo suficiente; se nenhum tiver, usar o primeiro vigente
+		escolhido := vigentes[0]
+		for _, ct := range vigentes {
+			if faturado[ct.ID]+nota.ValorServicos <= ct.ValorTeto+0.005 {
+				escolhido = ct
+				break
+			}
+		}
+
+		faturado[escolhido.ID] += nota.ValorServicos
+		resultado.ContratoID = escolhido.ID
+		resultado.ContratoNumero = escolhido.Numero
+		resultado.ValorTeto = escolhido.ValorTeto
+		resultado.ValorFaturado = math.Round(faturado[escolhido.ID]*100) / 100
+
+		if excedente := resultado.ValorFaturado - escolhido.ValorTeto; excedente > 0.005 {
+			resultado.Status = ConciliacaoValorExcedido
+			resultado.Excedente = math.Round(excedente*100) / 100
+			resultado.Mensagem = fmt.Sprintf("Valor faturado excede o teto do %s %s em R$ %.2f", escolhido.Tipo, escolhido.Numero, resultado.Excedente)
+		} else {
+			resultado.Status = ConciliacaoConforme
+			resultado.Mensagem = fmt.Sprintf("Nota coberta pelo %s %s", escolhido.Tipo, escolhido.Numero)
+		}
+		resultados = append(resultados, resultado)
+	}
+
+	return resultados
+}
+
+// conciliarNota calcula a conciliação de uma nota considerando as demais notas salvas
+func conciliarNota(ctx context.Context, nota NotaFiscalData) (ResultadoConciliacao, error) {
+	lista, err := contratos.listar()
+	if err != nil {
+		return ResultadoConciliacao{}, err
+	}
+
+	notasSalvas, err := notasRepo.Listar(ctx, FiltroNotas{CNPJ: nota.CNPJ})
+	if err != nil {
+		return ResultadoConciliacao{}, err
+	}
+
+	notas := []NotaFiscalData{nota}
+	for _, salva := range notasSalvas {
+		if salva.ID != nota.ID {
+			notas = append(notas, salva)
+		}
+	}
+
+	for _, resultado := range conciliarNotas(notas, lista) {
+		if resultado.NotaID == nota.ID {
+			return resultado, nil
+		}
+	}
+	return ResultadoConciliacao{}, fmt.Errorf("nota %s não conciliada", nota.ID)
+}
+
+// CriarContrato cadastra um pedido de compra ou contrato
+func CriarContrato(c *gin.Context) {
+	var contrato Contrato
+	if err := c.ShouldBindJSON(&contrato); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados do contrato inválidos: "+err.Error())
+		return
+	}
+
+	if err := contrato.validar(); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados do contrato inválidos: "+err.Error())
+		return
+	}
+
+	contrato.ID = novoIDNota()
+	contrato.CriadoEm = time.Now()
+
+	if err := contratos.salvar(contrato.ID, contrato); err != nil {
+		log.Printf("Erro ao salvar contrato: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar contrato")
+		return
+	}
+
+	c.JSON(http.StatusCreated, contrato)
+}
+
+// ListarContratos lista os pedidos de compra e contratos cadastrados, opcionalmente por CNPJ
+func ListarContratos(c *gin.Context) {
+	lista, err := contratos.listar()
+	if err != nil {
+		log.Printf("Erro ao listar contratos: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao listar contratos")
+		return
+	}
+
+	cnpj := normalizarCNPJ(c.Query("cnpj"))
+	filtrados := []Contrato{}
+	for _, ct := range lista {
+		if cnpj == "" || normalizarCNPJ(ct.CNPJFornecedor) == cnpj {
+			filtrados = append(filtrados, ct)
+		}
+	}
+	sort.Slice(filtrados, func(i, j int) bool { return filtrados[i].CriadoEm.Before(filtrados[j].CriadoEm) })
+
+	c.JSON(http.StatusOK, gin.H{
+		"contratos": filtrados,
+		"total":     len(filtrados),
+	})
+}
+
+// ObterContrato retorna um pedido de compra ou contrato pelo ID
+func ObterContrato(c *gin.Context) {
+	contrato, err := contratos.carregar(c.Param("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Contrato não encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao carregar contrato: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar contrato")
+		return
+	}
+
+	c.JSON(http.StatusOK, contrato)
+}
+
+// AtualizarContrato substitui os dados de um pedido de compra ou contrato (por exemplo, para encerrá-lo)
+func AtualizarContrato(c *gin.Context) {
+	var contrato Contrato
+	if err := c.ShouldBindJSON(&contrato); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados do contrato inválidos: "+err.Error())
+		return
+	}
+	if err := contrato.validar(); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados do contrato inválidos: "+err.Error())
+		return
+	}
+
+	contrato, _, err := contratos.atualizar(c.Param("id"), func(existente *Contrato) int {
+		contrato.ID = existente.ID
+		contrato.CriadoEm = existente.CriadoEm
+		*existente = contrato
+		return documentoAlterado
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Contrato não encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao salvar contrato: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar contrato")
+		return
+	}
+
+	c.JSON(http.StatusOK, contrato)
+}
+
+// ConciliarContratos relata, para cada nota salva, o contrato correspondente e as divergências:
+// notas sem contrato, com contrato expirado ou que excedem o valor teto. Aceita os mesmos
+// filtros de período de BuscarNotasFiscais e status=<situação>.
+func ConciliarContratos(c *gin.Context) {
+	filtro, err := parseFiltroPeriodo(c)
+	if err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
+		return
+	}
+
+	notas, err := notasRepo.Listar(c.Request.Context(), FiltroNotas{})
+	if err != nil {
+		log.Printf("Erro ao carregar notas fiscais: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+
+	lista, err := contratos.listar()
+	if err != nil {
+		log.Printf("Erro ao listar contratos: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao listar contratos")
+		return
+	}
+
+	// A conciliação considera todas as notas para acumular corretamente o valor faturado;
+	// o filtro de período só restringe o que é retornado
+	noPeriodo := make(map[string]bool)
+	for _, nota := range notas {
+		if filtro.Aceita(nota) {
+			noPeriodo[nota.ID] = true
+		}
+	}
+
+	status := c.Query("status")
+	resumo := map[string]int{
+		ConciliacaoConforme:         0,
+		ConciliacaoSemContrato:      0,
+		ConciliacaoContratoExpirado: 0,
+		ConciliacaoValorExcedido:    0,
+	}
+	resultados := []ResultadoConciliacao{}
+	for _, resultado := range conciliarNotas(notas, lista) {
+		if !noPeriodo[resultado.NotaID] {
+			continue
+		}
+		resumo[resultado.Status]++
+		if status == "" || resultado.Status == status {
+			resultados = append(resultados, resultado)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conciliacao": resultados,
+		"resumo":      resumo,
+		"total":       len(resultados),
+	})
+}
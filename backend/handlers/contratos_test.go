@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIniciarContratosImportaLocais(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// Contrato gravado em uploads/contratos/ por uma versão anterior, sem criptografia
+	if err := armazemLocal.GravarDocumento(context.Background(), "contratos", "c1", []byte(`{"id":"c1","numero":"PC-1","cnpjFornecedor":"11222333000181"}`)); err != nil {
+		t.Fatal(err)
+	}
+	anterior := documentos
+	documentos = &armazemArquivos{dir: "repositorio"}
+	t.Cleanup(func() { documentos = anterior })
+
+	if err := IniciarContratos(); err != nil {
+		t.Fatal(err)
+	}
+	contrato, err := contratos.carregar("c1")
+	if err != nil || contrato.Numero != "PC-1" {
+		t.Fatalf("contrato importado: %+v, erro %v", contrato, err)
+	}
+	if locais, _ := armazemLocal.ListarDocumentos(context.Background(), "contratos"); len(locais) != 0 {
+		t.Fatalf("%d contratos mantidos em uploads/contratos/", len(locais))
+	}
+}
@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Criptografia em repouso por envelope: cada conteúdo é cifrado com AES-256-GCM usando uma
+// chave de dados aleatória, que por sua vez é cifrada com a chave mestra ativa do chaveiro.
+// O envelope guarda o ID da chave mestra, de modo que chaves antigas continuam decifrando
+// o que foi gravado com elas até a rotação regravar os dados com a chave nova.
+//
+// Formato: "NFC1" | tamanho do ID (1 byte) | ID | nonce (12) | chave de dados cifrada (48) | nonce (12) | conteúdo cifrado
+
+const (
+	magicEnvelope = "NFC1"
+	// prefixoCifrado marca textos cifrados guardados em colunas e arquivos JSON
+	prefixoCifrado = "enc:"
+	tamanhoChave   = 32
+)
+
+// ErrSemChave é retornado ao ler dados cifrados sem a chave correspondente configurada
+var ErrSemChave = errors.New("dados cifrados com chave não configurada")
+
+// Cofre cifra e decifra conteúdos com as chaves mestras do chaveiro
+type Cofre struct {
+	chaves map[string][]byte
+	ativa  string
+	// indice é a chave HMAC dos índices cegos; não muda na rotação das chaves mestras
+	indice []byte
+}
+
+// NovoCofre cria um cofre com as chaves mestras (32 bytes cada), o ID da chave usada para
+// cifrar e a chave dos índices cegos
+func NovoCofre(chaves map[string][]byte, ativa string, indice []byte) (*Cofre, error) {
+	for id, chave := range chaves {
+		if len(chave) != tamanhoChave {
+			return nil, fmt.Errorf("chave %q deve ter %d bytes, tem %d", id, tamanhoChave, len(chave))
+		}
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("ID de chave inválido: %q", id)
+		}
+	}
+	if _, ok := chaves[ativa]; !ok {
+		return nil, fmt.Errorf("chave ativa %q não está no chaveiro", ativa)
+	}
+	if len(indice) != tamanhoChave {
+		return nil, fmt.Errorf("chave de índice deve ter %d bytes", tamanhoChave)
+	}
+	return &Cofre{chaves: chaves, ativa: ativa, indice: indice}, nil
+}
+
+// ChaveAtiva retorna o ID da chave mestra usada nas novas gravações
+func (c *Cofre) ChaveAtiva() string {
+	return c.ativa
+}
+
+func novoGCM(chave []byte) (cipher.AEAD, error) {
+	bloco, err := aes.NewCipher(chave)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloco)
+}
+
+// Cifrar cifra o conteúdo com uma chave de dados nova, protegida pela chave mestra ativa
+func (c *Cofre) Cifrar(conteudo []byte) ([]byte, error) {
+	dek := make([]byte, tamanhoChave)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+
+	kek, err := novoGCM(c.chaves[c.ativa])
+	if err != nil {
+		return nil, err
+	}
+	cabecalho := append([]byte(magicEnvelope), byte(len(c.ativa)))
+	cabecalho = append(cabecalho, c.ativa...)
+
+	nonceChave := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonceChave); err != nil {
+		return nil, err
+	}
+	envelope := append(append([]byte{}, cabecalho...), nonceChave...)
+	envelope = kek.Seal(envelope, nonceChave, dek, cabecalho)
+
+	aead, err := novoGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonceDados := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonceDados); err != nil {
+		return nil, err
+	}
+	envelope = append(envelope, nonceDados...)
+	return aead.Seal(envelope, nonceDados, conteudo, cabecalho), nil
+}
+
+// envelopeCifrado indica se o conteúdo está no formato de envelope
+func envelopeCifrado(conteudo []byte) bool {
+	return bytes.HasPrefix(conteudo, []byte(magicEnvelope))
+}
+
+// chaveDoEnvelope retorna o ID da chave mestra que protege o envelope
+func chaveDoEnvelope(envelope []byte) (string, error) {
+	if !envelopeCifrado(envelope) || len(envelope) < len(magicEnvelope)+1 {
+		return "", errors.New("conteúdo não está cifrado")
+	}
+	n := int(envelope[len(magicEnvelope)])
+	inicio := len(magicEnvelope) + 1
+	if len(envelope) < inicio+n {
+		return "", errors.New("envelope cifrado truncado")
+	}
+	return string(envelope[inicio : inicio+n]), nil
+}
+
+// Decifrar abre um envelope gerado por Cifrar com qualquer chave do chaveiro
+func (c *Cofre) Decifrar(envelope []byte) ([]byte, error) {
+	id, err := chaveDoEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	chave, ok := c.chaves[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrSemChave, id)
+	}
+	tamanhoCabecalho := len(magicEnvelope) + 1 + len(id)
+	cabecalho := envelope[:tamanhoCabecalho]
+	resto := envelope[tamanhoCabecalho:]
+
+	kek, err := novoGCM(chave)
+	if err != nil {
+		return nil, err
+	}
+	tamanhoDEK := tamanhoChave + kek.Overhead()
+	if len(resto) < kek.NonceSize()+tamanhoDEK {
+		return nil, errors.New("envelope cifrado truncado")
+	}
+	dek, err := kek.Open(nil, resto[:kek.NonceSize()], resto[kek.NonceSize():kek.NonceSize()+tamanhoDEK], cabecalho)
+	if err != nil {
+		return nil, fmt.Errorf("chave de dados inválida: %v", err)
+	}
+	resto = resto[kek.NonceSize()+tamanhoDEK:]
+
+	aead, err := novoGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	if len(resto) < aead.NonceSize() {
+		return nil, errors.New("envelope cifrado truncado")
+	}
+	conteudo, err := aead.Open(nil, resto[:aead.NonceSize()], resto[aead.NonceSize():], cabecalho)
+	if err != nil {
+		return nil, fmt.Errorf("conteúdo cifrado inválido: %v", err)
+	}
+	return conteudo, nil
+}
+
+// IndiceCego calcula um HMAC do valor, que permite buscas por igualdade sem guardar o valor
+func (c *Cofre) IndiceCego(valor string) string {
+	mac := hmac.New(sha256.New, c.indice)
+	mac.Write([]byte(valor))
+	return "h:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// cofre é o cofre usado pelos repositórios; nil desativa a criptografia
+var cofre *Cofre
+
+// ConfigurarCofre define o cofre usado para cifrar notas, auditoria e arquivos
+func ConfigurarCofre(c *Cofre) {
+	cofre = c
+}
+
+// chaveiroArquivo é o formato do arquivo de chaves (substituto local de um KMS)
+type chaveiroArquivo struct {
+	Ativa  string            `json:"ativa"`
+	Chaves map[string]string `json:"chaves"` // ID → chave em base64
+	Indice string            `json:"indice"` // chave dos índices cegos em base64
+}
+
+// AbrirCofre carrega o chaveiro do arquivo em CRIPTOGRAFIA_CHAVES_ARQUIVO ou das variáveis
+// CRIPTOGRAFIA_CHAVES (id:base64,id:base64), CRIPTOGRAFIA_CHAVE_ATIVA (padrão: a última da lista)
+// e CRIPTOGRAFIA_CHAVE_INDICE. Sem nenhuma delas, retorna nil (criptografia desativada).
+func AbrirCofre() (*Cofre, error) {
+	var chaveiro chaveiroArquivo
+
+	if caminho := os.Getenv("CRIPTOGRAFIA_CHAVES_ARQUIVO"); caminho != "" {
+		conteudo, err := os.ReadFile(caminho)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler chaveiro: %v", err)
+		}
+		if err := json.Unmarshal(conteudo, &chaveiro); err != nil {
+			return nil, fmt.Errorf("chaveiro inválido: %v", err)
+		}
+	} else if lista := os.Getenv("CRIPTOGRAFIA_CHAVES"); lista != "" {
+		chaveiro.Chaves = map[string]string{}
+		for _, item := range strings.Split(lista, ",") {
+			id, chave, ok := strings.Cut(strings.TrimSpace(item), ":")
+			if !ok {
+				return nil, fmt.Errorf("CRIPTOGRAFIA_CHAVES deve estar no formato id:base64,id:base64")
+			}
+			chaveiro.Chaves[id] = chave
+			chaveiro.Ativa = id
+		}
+		if ativa := os.Getenv("CRIPTOGRAFIA_CHAVE_ATIVA"); ativa != "" {
+			chaveiro.Ativa = ativa
+		}
+		chaveiro.Indice = os.Getenv("CRIPTOGRAFIA_CHAVE_INDICE")
+	} else {
+		return nil, nil
+	}
+
+	chaves := make(map[string][]byte, len(chaveiro.Chaves))
+	for id, valor := range chaveiro.Chaves {
+		chave, err := base64.StdEncoding.DecodeString(valor)
+		if err != nil {
+			return nil, fmt.Errorf("chave %q não está em base64: %v", id, err)
+		}
+		chaves[id] = chave
+	}
+	indice, err := base64.StdEncoding.DecodeString(chaveiro.Indice)
+	if err != nil {
+		return nil, fmt.Errorf("chave de índice não está em base64: %v", err)
+	}
+	return NovoCofre(chaves, chaveiro.Ativa, indice)
+}
+
+// cifrarJSON protege um conteúdo JSON. Com a criptografia ativa, o resultado é uma string JSON
+// "enc:<base64>", que continua sendo JSON válido (necessário para colunas JSONB).
+func cifrarJSON(conteudo []byte) ([]byte, error) {
+	if cofre == nil {
+		return conteudo, nil
+	}
+	envelope, err := cofre.Cifrar(conteudo)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(prefixoCifrado + base64.StdEncoding.EncodeToString(envelope))
+}
+
+// decifrarJSON desfaz cifrarJSON; conteúdos gravados sem criptografia são retornados como estão
+func decifrarJSON(conteudo []byte) ([]byte, error) {
+	conteudo = bytes.TrimSpace(conteudo)
+	if !bytes.HasPrefix(conteudo, []byte(`"`+prefixoCifrado)) {
+		return conteudo, nil
+	}
+	var texto string
+	if err := json.Unmarshal(conteudo, &texto); err != nil {
+		return nil, err
+	}
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(texto, prefixoCifrado))
+	if err != nil {
+		return nil, err
+	}
+	if cofre == nil {
+		return nil, ErrSemChave
+	}
+	return cofre.Decifrar(envelope)
+}
+
+// chaveDoJSON retorna o ID da chave mestra de um conteúdo gravado por cifrarJSON, ou "" se o
+// conteúdo estiver em claro ou não puder ser lido
+func chaveDoJSON(conteudo []byte) string {
+	var texto string
+	if json.Unmarshal(bytes.TrimSpace(conteudo), &texto) != nil || !strings.HasPrefix(texto, prefixoCifrado) {
+		return ""
+	}
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(texto, prefixoCifrado))
+	if err != nil {
+		return ""
+	}
+	id, _ := chaveDoEnvelope(envelope)
+	return id
+}
+
+// cifrarTexto protege um texto guardado em coluna TEXT (autor e detalhes da auditoria): com a
+// criptografia ativa, o resultado é "enc:<base64>"
+func cifrarTexto(texto string) (string, error) {
+	if cofre == nil || texto == "" {
+		return texto, nil
+	}
+	envelope, err := cofre.Cifrar([]byte(texto))
+	if err != nil {
+		return "", err
+	}
+	return prefixoCifrado + base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// decifrarTexto desfaz cifrarTexto; textos gravados sem criptografia são retornados como estão
+func decifrarTexto(texto string) (string, error) {
+	if !strings.HasPrefix(texto, prefixoCifrado) {
+		return texto, nil
+	}
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(texto, prefixoCifrado))
+	if err != nil {
+		return "", err
+	}
+	if cofre == nil {
+		return "", ErrSemChave
+	}
+	conteudo, err := cofre.Decifrar(envelope)
+	return string(conteudo), err
+}
+
+// indiceBusca retorna o valor guardado nas colunas de busca de dados pessoais (e-mail, CNPJ/CPF):
+// o próprio valor normalizado ou, com a criptografia ativa, seu índice cego
+func indiceBusca(valor string) string {
+	if cofre == nil || valor == "" {
+		return valor
+	}
+	return cofre.IndiceCego(valor)
+}
+
+// blobCifrado cifra os arquivos antes de entregá-los ao BlobStore e os decifra na leitura.
+// Arquivos gravados antes da criptografia são lidos como estão.
+type blobCifrado struct {
+	BlobStore
+	cofre *Cofre
+}
+
+// NovoBlobCifrado envolve um BlobStore com criptografia por envelope. O conteúdo é mantido
+// em memória para cifrar e decifrar, pois o GCM autentica o arquivo inteiro.
+func NovoBlobCifrado(store BlobStore, c *Cofre) BlobStore {
+	return &blobCifrado{BlobStore: store, cofre: c}
+}
+
+func (b *blobCifrado) Gravar(ctx context.Context, chave string, r io.Reader, _ int64, tipo string) error {
+	conteudo, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	envelope, err := b.cofre.Cifrar(conteudo)
+	if err != nil {
+		return err
+	}
+	return b.BlobStore.Gravar(ctx, chave, bytes.NewReader(envelope), int64(len(envelope)), tipo)
+}
+
+func (b *blobCifrado) Abrir(ctx context.Context, chave string) (io.ReadCloser, InfoBlob, error) {
+	r, info, err := b.BlobStore.Abrir(ctx, chave)
+	if err != nil {
+		return nil, info, err
+	}
+	defer r.Close()
+
+	conteudo, err := io.ReadAll(r)
+	if err != nil {
+		return nil, info, err
+	}
+	if envelopeCifrado(conteudo) {
+		if conteudo, err = b.cofre.Decifrar(conteudo); err != nil {
+			return nil, info, err
+		}
+	}
+	info.Tamanho = int64(len(conteudo))
+	return io.NopCloser(bytes.NewReader(conteudo)), info, nil
+}
+
+// Recifrar regrava o arquivo com a chave mestra ativa, se ainda estiver sem criptografia ou
+// com outra chave. Retorna se o arquivo foi regravado.
+func (b *blobCifrado) Recifrar(ctx context.Context, chave string) (bool, error) {
+	r, info, err := b.BlobStore.Abrir(ctx, chave)
+	if err != nil {
+		return false, err
+	}
+	conteudo, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if envelopeCifrado(conteudo) {
+		if id, err := chaveDoEnvelope(conteudo); err == nil && id == b.cofre.ChaveAtiva() {
+			return false, nil
+		}
+		if conteudo, err = b.cofre.Decifrar(conteudo); err != nil {
+			return false, err
+		}
+	}
+	return true, b.Gravar(ctx, chave, bytes.NewReader(conteudo), int64(len(conteudo)), info.Tipo)
+}
+
+// RelatorioRotacao resume a regravação dos dados com a chave mestra ativa
+type RelatorioRotacao struct {
+	ChaveAtiva         string `json:"chaveAtiva"`
+	Notas              int    `json:"notas"`
+	Arquivos           int    `json:"arquivos"`
+	ArquivosRecifrados int    `json:"arquivosRecifrados"`
+	// Documentos das coleções compartilhadas (lotes, contratos, webhooks, idempotência)
+	Documentos           int      `json:"documentos"`
+	DocumentosRecifrados int      `json:"documentosRecifrados"`
+	Falhas               []string `json:"falhas"`
+}
+
+// recifravel é implementado pelos repositórios que regravam uma nota com a chave ativa a partir
+// da versão atual, sem sobrescrever alterações feitas durante a rotação. Retorna a nota regravada.
+type recifravel interface {
+	Recifrar(ctx context.Context, id string) (NotaFiscalData, error)
+}
+
+// RotacionarChaves regrava todas as notas (inclusive removidas), seus arquivos e os documentos
+// das coleções compartilhadas com a chave mestra ativa, recalculando os índices cegos. Também cifra dados gravados antes da ativação
+// da criptografia. A trilha de auditoria não é regravada: por ser somente inclusão, as chaves
+// antigas devem permanecer no chaveiro para lê-la.
+func RotacionarChaves(ctx context.Context, repo NotaFiscalRepository, store BlobStore) (RelatorioRotacao, error) {
+	if cofre == nil {
+		return RelatorioRotacao{}, errors.New("criptografia não configurada")
+	}
+	relatorio := RelatorioRotacao{ChaveAtiva: cofre.ChaveAtiva()}
+	regravavel, ok := repo.(recifravel)
+	if !ok {
+		return relatorio, errors.New("o repositório não permite regravar as notas")
+	}
+
+	// A listagem só fornece os IDs; cada nota é lida de novo ao ser regravada
+	notas, err := repo.Listar(ctx, FiltroNotas{IncluirRemovidas: true})
+	if err != nil {
+		return relatorio, err
+	}
+
+	cifrado, _ := store.(*blobCifrado)
+	arquivos := map[string]bool{}
+	for _, listada := range notas {
+		nota, err := regravavel.Recifrar(ctx, listada.ID)
+		if errors.Is(err, ErrNotaNaoEncontrada) {
+			continue // expurgada durante a rotação
+		}
+		if err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("nota %s: %v", listada.ID, err))
+			continue
+		}
+		relatorio.Notas++
+		if chaveBlobValida(nota.Arquivo) {
+			arquivos[nota.Arquivo] = true
+		}
+	}
+	if sqlRepo, ok := repo.(*repositorioSQL); ok {
+		if err := sqlRepo.verificarEmClaro(ctx); err != nil {
+			return relatorio, err
+		}
+	}
+
+	chaves := make([]string, 0, len(arquivos))
+	for chave := range arquivos {
+		chaves = append(chaves, chave)
+	}
+	sort.Strings(chaves)
+	for _, chave := range chaves {
+		if cifrado == nil {
+			break
+		}
+		relatorio.Arquivos++
+		regravado, err := cifrado.Recifrar(ctx, chave)
+		if err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("arquivo %s: %v", chave, err))
+			continue
+		}
+		if regravado {
+			relatorio.ArquivosRecifrados++
+		}
+	}
+
+	// Os documentos ficam no repositório recebido, como em ConfigurarRepositorio
+	armazem, ok := repo.(ArmazemDocumentos)
+	if !ok {
+		armazem = armazemLocal
+	}
+	for _, colecao := range colecoesCompartilhadas {
+		conteudos, err := armazem.ListarDocumentos(ctx, colecao)
+		if err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("coleção %s: %v", colecao, err))
+			continue
+		}
+		ids := make([]string, 0, len(conteudos))
+		for id := range conteudos {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			relatorio.Documentos++
+			regravado, err := recifrarDocumento(ctx, armazem, colecao, id, conteudos[id])
+			if errors.Is(err, os.ErrNotExist) {
+				continue // removido durante a rotação
+			}
+			if err != nil {
+				relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("documento %s/%s: %v", colecao, id, err))
+				continue
+			}
+			if regravado {
+				relatorio.DocumentosRecifrados++
+			}
+		}
+	}
+
+	return relatorio, nil
+}
+
+// recifrarDocumento regrava com a chave ativa um documento de coleção compartilhada, se ainda
+// estiver em claro ou com outra chave. Como em colecaoJSON.atualizar, a gravação só ocorre se o
+// documento não mudou desde a leitura; se mudou, ele é lido de novo. Retorna se foi regravado.
+func recifrarDocumento(ctx context.Context, armazem ArmazemDocumentos, colecao, id string, atual []byte) (bool, error) {
+	for range tentativasAtualizacao {
+		if chaveDoJSON(atual) == cofre.ChaveAtiva() {
+			return false, nil
+		}
+		conteudo, err := decifrarJSON(atual)
+		if err != nil {
+			return false, err
+		}
+		if conteudo, err = cifrarJSON(conteudo); err != nil {
+			return false, err
+		}
+		if ok, err := armazem.SubstituirDocumento(ctx, colecao, id, atual, conteudo); err != nil || ok {
+			return ok, err
+		}
+		if atual, err = armazem.LerDocumento(ctx, colecao, id); err != nil {
+			return false, err
+		}
+	}
+	return false, fmt.Errorf("documento alterado repetidamente durante a regravação; execute novamente")
+}
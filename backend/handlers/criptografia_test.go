@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cofreTeste configura um cofre com chaves fixas (uma por ID) e a chave ativa informada,
+// restaurando a configuração anterior ao fim do teste
+func cofreTeste(t *testing.T, ativa string, ids ...string) *Cofre {
+	t.Helper()
+	chaves := map[string][]byte{}
+	for i, id := range ids {
+		chaves[id] = bytes.Repeat([]byte{byte(i + 1)}, tamanhoChave)
+	}
+	c, err := NovoCofre(chaves, ativa, bytes.Repeat([]byte{0xAA}, tamanhoChave))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anterior := cofre
+	ConfigurarCofre(c)
+	t.Cleanup(func() { ConfigurarCofre(anterior) })
+	return c
+}
+
+func TestCofreEnvelope(t *testing.T) {
+	antigo := cofreTeste(t, "2026-01", "2026-01")
+	conteudo := []byte(`{"email":"titular@exemplo.com"}`)
+
+	envelope, err := antigo.Cifrar(conteudo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envelopeCifrado(envelope) || bytes.Contains(envelope, conteudo) {
+		t.Fatal("envelope sem o cabeçalho ou com o conteúdo em claro")
+	}
+	if id, err := chaveDoEnvelope(envelope); err != nil || id != "2026-01" {
+		t.Fatalf("chave do envelope: %q, erro %v", id, err)
+	}
+	if outro, _ := antigo.Cifrar(conteudo); bytes.Equal(outro, envelope) {
+		t.Fatal("dois envelopes iguais para o mesmo conteúdo")
+	}
+	if decifrado, err := antigo.Decifrar(envelope); err != nil || !bytes.Equal(decifrado, conteudo) {
+		t.Fatalf("decifrado %q, erro %v", decifrado, err)
+	}
+
+	// Após a rotação, a chave antiga continua no chaveiro e abre o envelope gravado com ela
+	novo := cofreTeste(t, "2026-10", "2026-01", "2026-10")
+	if decifrado, err := novo.Decifrar(envelope); err != nil || !bytes.Equal(decifrado, conteudo) {
+		t.Fatalf("decifrado com o chaveiro novo %q, erro %v", decifrado, err)
+	}
+	// Cabeçalho: magic, tamanho do ID e ID da chave ativa
+	if recifrado, _ := novo.Cifrar(conteudo); !bytes.HasPrefix(recifrado, []byte(magicEnvelope+"\x072026-10")) {
+		t.Fatal("novo envelope não usa a chave ativa")
+	}
+
+	semChave := cofreTeste(t, "2026-10", "x", "2026-10")
+	if _, err := semChave.Decifrar(envelope); !errors.Is(err, ErrSemChave) {
+		t.Fatalf("chave ausente: %v, esperado ErrSemChave", err)
+	}
+
+	alterado := append([]byte{}, envelope...)
+	alterado[len(alterado)-1] ^= 1
+	if _, err := novo.Decifrar(alterado); err == nil {
+		t.Fatal("envelope alterado foi aceito")
+	}
+	if _, err := novo.Decifrar(envelope[:len(magicEnvelope)+1+len("2026-01")+10]); err == nil {
+		t.Fatal("envelope truncado foi aceito")
+	}
+	if _, err := novo.Decifrar(conteudo); err == nil {
+		t.Fatal("conteúdo sem envelope foi aceito")
+	}
+}
+
+func TestNovoCofreValidaChaves(t *testing.T) {
+	chave := bytes.Repeat([]byte{1}, tamanhoChave)
+	casos := map[string]struct {
+		chaves map[string][]byte
+		ativa  string
+		indice []byte
+	}{
+		"chave curta":            {map[string][]byte{"a": chave[:16]}, "a", chave},
+		"ativa fora do chaveiro": {map[string][]byte{"a": chave}, "b", chave},
+		"sem chave de índice":    {map[string][]byte{"a": chave}, "a", nil},
+	}
+	for nome, caso := range casos {
+		if _, err := NovoCofre(caso.chaves, caso.ativa, caso.indice); err == nil {
+			t.Errorf("%s: cofre criado", nome)
+		}
+	}
+}
+
+func TestIndiceCego(t *testing.T) {
+	if indiceBusca("12345678000190") != "12345678000190" {
+		t.Fatal("sem criptografia, o índice deve ser o próprio valor")
+	}
+
+	c := cofreTeste(t, "k1", "k1")
+	indice := indiceBusca("12345678000190")
+	if !strings.HasPrefix(indice, "h:") || strings.Contains(indice, "12345678000190") {
+		t.Fatalf("índice cego %q", indice)
+	}
+	if indiceBusca("12345678000190") != indice || c.IndiceCego("12345678000190") != indice {
+		t.Fatal("índice cego não é determinístico")
+	}
+	if indiceBusca("12345678000191") == indice {
+		t.Fatal("valores diferentes com o mesmo índice")
+	}
+	if indiceBusca("") != "" {
+		t.Fatal("valor vazio deve continuar vazio")
+	}
+
+	// A chave de índice não muda na rotação das chaves mestras: o índice é o mesmo
+	cofreTeste(t, "k2", "k1", "k2")
+	if indiceBusca("12345678000190") != indice {
+		t.Fatal("índice cego mudou com a chave mestra ativa")
+	}
+}
+
+func TestCifrarJSON(t *testing.T) {
+	conteudo := []byte(`{"prestador":"Prestador Exemplo LTDA"}`)
+	if claro, _ := cifrarJSON(conteudo); !bytes.Equal(claro, conteudo) {
+		t.Fatal("sem criptografia, o conteúdo deve ser mantido")
+	}
+
+	cofreTeste(t, "k1", "k1")
+	cifrado, err := cifrarJSON(conteudo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texto string
+	if err := json.Unmarshal(cifrado, &texto); err != nil || !strings.HasPrefix(texto, prefixoCifrado) {
+		t.Fatalf("conteúdo cifrado não é uma string JSON enc:...: %s", cifrado)
+	}
+	if chaveDoJSON(cifrado) != "k1" || chaveDoJSON(conteudo) != "" {
+		t.Fatal("chave do conteúdo cifrado")
+	}
+	if decifrado, err := decifrarJSON(cifrado); err != nil || !bytes.Equal(decifrado, conteudo) {
+		t.Fatalf("decifrado %s, erro %v", decifrado, err)
+	}
+	if claro, err := decifrarJSON(conteudo); err != nil || !bytes.Equal(claro, conteudo) {
+		t.Fatal("conteúdo gravado sem criptografia deve ser lido como está")
+	}
+
+	ConfigurarCofre(nil)
+	if _, err := decifrarJSON(cifrado); !errors.Is(err, ErrSemChave) {
+		t.Fatalf("sem cofre: %v, esperado ErrSemChave", err)
+	}
+}
+
+// colunasGravadas lê as colunas de busca da nota como estão no banco
+func colunasGravadas(t *testing.T, repo *repositorioSQL, id string) (email, cnpj, prestador string, valor float64, dados string) {
+	t.Helper()
+	err := repo.db.QueryRow(repo.rebind(`SELECT email, cnpj, prestador, valor_servicos, dados FROM notas_fiscais WHERE id = ?`), id).
+		Scan(&email, &cnpj, &prestador, &valor, &dados)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSQLiteColunasCifradas(t *testing.T) {
+	cofreTeste(t, "k1", "k1")
+	repo := abrirSQLiteTeste(t)
+	ctx := context.Background()
+
+	for i, valor := range []float64{150, 900, 450} {
+		n := strconv.Itoa(i + 1)
+		nota := notaTeste("n"+n, "10"+n, "1", "hash-"+n)
+		nota.ValorServicos = valor
+		if i == 1 {
+			nota.Prestador = "Outro Fornecedor SA"
+		}
+		if err := repo.Salvar(ctx, nota); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	email, cnpj, prestador, valor, dados := colunasGravadas(t, repo, "n1")
+	if email != indiceBusca("titular@exemplo.com") || cnpj != indiceBusca("12345678000190") {
+		t.Errorf("colunas de busca sem índice cego: %q %q", email, cnpj)
+	}
+	if prestador != "" || valor != 0 {
+		t.Errorf("prestador e valor em claro: %q %v", prestador, valor)
+	}
+	if strings.Contains(dados, "Prestador") || strings.Contains(dados, "titular") {
+		t.Error("JSON da nota em claro")
+	}
+
+	// Igualdade pelo índice cego; trecho do prestador e faixa de valor em memória. Sem data, a
+	// ordenação padrão desempata pelo ID.
+	minimo, maximo := 200.0, 1000.0
+	casos := map[string]struct {
+		filtro FiltroNotas
+		ids    []string
+	}{
+		"e-mail":           {FiltroNotas{Email: "TITULAR@exemplo.com"}, []string{"n1", "n2", "n3"}},
+		"CNPJ":             {FiltroNotas{CNPJ: "12.345.678/0001-90", Ordenacao: OrdenarCriadoEm}, []string{"n1", "n2", "n3"}},
+		"prestador":        {FiltroNotas{Prestador: "exemplo"}, []string{"n1", "n3"}},
+		"valor":            {FiltroNotas{ValorMinimo: &minimo, ValorMaximo: &maximo}, []string{"n2", "n3"}},
+		"ordem por valor":  {FiltroNotas{Ordenacao: OrdenarValorServicos, Decrescente: true, Limite: 2}, []string{"n2", "n3"}},
+		"cursor por valor": {FiltroNotas{Ordenacao: OrdenarValorServicos, Apos: &PosicaoNota{Valor: 150.0, ID: "n1"}}, []string{"n3", "n2"}},
+	}
+	for nome, caso := range casos {
+		notas, err := repo.Listar(ctx, caso.filtro)
+		if err != nil {
+			t.Fatalf("%s: %v", nome, err)
+		}
+		var ids []string
+		for _, nota := range notas {
+			ids = append(ids, nota.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(caso.ids, ",") {
+			t.Errorf("%s: %v, esperado %v", nome, ids, caso.ids)
+		}
+	}
+}
+
+func TestRotacionarChavesRegravaDocumentosEColunas(t *testing.T) {
+	t.Chdir(t.TempDir())
+	ctx := context.Background()
+	anteriorRepo, anteriorDocumentos := notasRepo, documentos
+	t.Cleanup(func() { notasRepo, documentos = anteriorRepo, anteriorDocumentos })
+
+	// Nota e documento gravados antes da criptografia, com prestador e valor em claro
+	repo := abrirSQLiteTeste(t)
+	ConfigurarRepositorio(repo)
+	nota := notaTeste("n1", "100", "1", "hash-1")
+	nota.ValorServicos = 150
+	if err := repo.Salvar(ctx, nota); err != nil {
+		t.Fatal(err)
+	}
+	if err := contratos.salvar("c1", Contrato{ID: "c1", Numero: "PC-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cofreTeste(t, "k1", "k1")
+	if err := lotes.salvar("l1", Lote{ID: "l1", Status: StatusLoteConcluido}); err != nil {
+		t.Fatal(err)
+	}
+
+	cofreTeste(t, "k2", "k1", "k2")
+	relatorio, err := RotacionarChaves(ctx, repo, nil)
+	if err != nil || len(relatorio.Falhas) > 0 {
+		t.Fatalf("rotação: %+v, erro %v", relatorio, err)
+	}
+	if relatorio.Notas != 1 || relatorio.Documentos != 2 || relatorio.DocumentosRecifrados != 2 {
+		t.Fatalf("relatório %+v", relatorio)
+	}
+
+	for colecao, id := range map[string]string{"contratos": "c1", "lotes": "l1"} {
+		conteudo, err := repo.LerDocumento(ctx, colecao, id)
+		if err != nil || chaveDoJSON(conteudo) != "k2" {
+			t.Errorf("documento %s/%s não regravado com a chave ativa: erro %v", colecao, id, err)
+		}
+	}
+	if contrato, err := contratos.carregar("c1"); err != nil || contrato.Numero != "PC-1" {
+		t.Errorf("contrato regravado: %+v, erro %v", contrato, err)
+	}
+	email, _, prestador, valor, _ := colunasGravadas(t, repo, "n1")
+	if !strings.HasPrefix(email, "h:") || prestador != "" || valor != 0 {
+		t.Errorf("colunas da nota após a rotação: %q %q %v", email, prestador, valor)
+	}
+
+	// Tudo já com a chave ativa: nada a regravar
+	relatorio, err = RotacionarChaves(ctx, repo, nil)
+	if err != nil || relatorio.DocumentosRecifrados != 0 {
+		t.Fatalf("segunda rotação: %+v, erro %v", relatorio, err)
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatos canônicos usados para armazenar e exibir datas e competências
+const (
+	formatoData        = "02/01/2006"
+	formatoCompetencia = "01/2006"
+)
+
+// Data representa a data de uma nota fiscal (sem horário), serializada como DD/MM/AAAA
+type Data struct {
+	time.Time
+}
+
+// Competencia representa o mês de referência de uma nota fiscal, serializado como MM/AAAA
+type Competencia struct {
+	Ano int
+	Mes time.Month
+}
+
+// ParseData interpreta uma data nos formatos DD/MM/AAAA (dia e mês com um ou dois dígitos)
+// ou AAAA-MM-DD, rejeitando datas inexistentes como 31/02/2025
+func ParseData(valor string) (Data, error) {
+	valor = strings.TrimSpace(valor)
+	for _, layout := range []string{"02/01/2006", "2/1/2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, valor); err == nil {
+			if t.Year() < 1900 || t.Year() > 2100 {
+				return Data{}, fmt.Errorf("ano fora do intervalo permitido: %q", valor)
+			}
+			return Data{t}, nil
+		}
+	}
+	return Data{}, fmt.Errorf("data inválida %q: use o formato DD/MM/AAAA", valor)
+}
+
+// ParseCompetencia interpreta uma competência nos formatos MM/AAAA (mês com um ou dois dígitos)
+// ou AAAA-MM
+func ParseCompetencia(valor string) (Competencia, error) {
+	valor = strings.TrimSpace(valor)
+
+	var mesTexto, anoTexto string
+	if partes := strings.Split(valor, "/"); len(partes) == 2 {
+		mesTexto, anoTexto = partes[0], partes[1]
+	} else if partes := strings.Split(valor, "-"); len(partes) == 2 {
+		anoTexto, mesTexto = partes[0], partes[1]
+	} else {
+		return Competencia{}, fmt.Errorf("competência inválida %q: use o formato MM/AAAA", valor)
+	}
+
+	mes, errMes := strconv.Atoi(mesTexto)
+	ano, errAno := strconv.Atoi(anoTexto)
+	if errMes != nil || errAno != nil || len(mesTexto) > 2 || len(anoTexto) != 4 {
+		return Competencia{}, fmt.Errorf("competência inválida %q: use o formato MM/AAAA", valor)
+	}
+	if mes < 1 || mes > 12 {
+		return Competencia{}, fmt.Errorf("mês inválido na competência %q", valor)
+	}
+	if ano < 1900 || ano > 2100 {
+		return Competencia{}, fmt.Errorf("ano fora do intervalo permitido na competência %q", valor)
+	}
+
+	return Competencia{Ano: ano, Mes: time.Month(mes)}, nil
+}
+
+// CompetenciaDaData retorna a competência (mês/ano) de uma data
+func CompetenciaDaData(d Data) Competencia {
+	return Competencia{Ano: d.Year(), Mes: d.Month()}
+}
+
+// String retorna a data no formato DD/MM/AAAA, ou vazio se a data não foi informada
+func (d Data) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(formatoData)
+}
+
+// MarshalJSON serializa a data no formato DD/MM/AAAA
+func (d Data) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON aceita os formatos de ParseData; string vazia resulta em data não informada
+func (d *Data) UnmarshalJSON(b []byte) error {
+	var valor string
+	if err := json.Unmarshal(b, &valor); err != nil {
+		return err
+	}
+	if strings.TrimSpace(valor) == "" {
+		*d = Data{}
+		return nil
+	}
+	parsed, err := ParseData(valor)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// IsZero indica se a competência não foi informada
+func (c Competencia) IsZero() bool {
+	return c.Ano == 0 && c.Mes == 0
+}
+
+// Comparar retorna -1, 0 ou 1 conforme a competência seja anterior, igual ou posterior a outra
+func (c Competencia) Comparar(outra Competencia) int {
+	switch {
+	case c.Ano != outra.Ano:
+		if c.Ano < outra.Ano {
+			return -1
+		}
+		return 1
+	case c.Mes != outra.Mes:
+		if c.Mes < outra.Mes {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// String retorna a competência no formato MM/AAAA, ou vazio se não foi informada
+func (c Competencia) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%02d/%04d", int(c.Mes), c.Ano)
+}
+
+// MarshalJSON serializa a competência no formato MM/AAAA
+func (c Competencia) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON aceita os formatos de ParseCompetencia; string vazia resulta em competência não informada
+func (c *Competencia) UnmarshalJSON(b []byte) error {
+	var valor string
+	if err := json.Unmarshal(b, &valor); err != nil {
+		return err
+	}
+	if strings.TrimSpace(valor) == "" {
+		*c = Competencia{}
+		return nil
+	}
+	parsed, err := ParseCompetencia(valor)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// FiltroPeriodo restringe notas fiscais por intervalo de competência e de data da nota.
+// Limites não informados (valor zero) não restringem a busca; os limites são inclusivos.
+type FiltroPeriodo struct {
+	CompetenciaInicio Competencia
+	CompetenciaFim    Competencia
+	DataInicio        Data
+	DataFim           Data
+}
+
+// Vazio indica se nenhum limite foi informado
+func (f FiltroPeriodo) Vazio() bool {
+	return f.CompetenciaInicio.IsZero() && f.CompetenciaFim.IsZero() && f.DataInicio.IsZero() && f.DataFim.IsZero()
+}
+
+// Aceita indica se a nota fiscal está dentro do período do filtro
+func (f FiltroPeriodo) Aceita(nota NotaFiscalData) bool {
+	if !f.CompetenciaInicio.IsZero() && (nota.Competencia.IsZero() || nota.Competencia.Comparar(f.CompetenciaInicio) < 0) {
+		return false
+	}
+	if !f.CompetenciaFim.IsZero() && (nota.Competencia.IsZero() || nota.Competencia.Comparar(f.CompetenciaFim) > 0) {
+		return false
+	}
+	if !f.DataInicio.IsZero() && (nota.DataNota.IsZero() || nota.DataNota.Before(f.DataInicio.Time)) {
+		return false
+	}
+	if !f.DataFim.IsZero() && (nota.DataNota.IsZero() || nota.DataNota.After(f.DataFim.Time)) {
+		return false
+	}
+	return true
+}
+
+// layoutsDataLegados são formatos encontrados em notas salvas antes da data tipada, quando o
+// texto extraído pelo modelo era gravado sem validação
+var layoutsDataLegados = []string{"02-01-2006", "02.01.2006", "02/01/06", "2006/01/02", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// parseDataLegada interpreta as datas de registros antigos: os formatos de ParseData e os
+// formatos legados
+func parseDataLegada(valor string) (Data, bool) {
+	if d, err := ParseData(valor); err == nil {
+		return d, true
+	}
+	for _, layout := range layoutsDataLegados {
+		if t, err := time.Parse(layout, strings.TrimSpace(valor)); err == nil && t.Year() >= 1900 && t.Year() <= 2100 {
+			return Data{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}, true
+		}
+	}
+	return Data{}, false
+}
+
+// parseCompetenciaLegada interpreta as competências de registros antigos: os formatos de
+// ParseCompetencia, MM-AAAA, MM.AAAA e datas completas (a competência é o mês da data)
+func parseCompetenciaLegada(valor string) (Competencia, bool) {
+	if c, err := ParseCompetencia(valor); err == nil {
+		return c, true
+	}
+	if c, err := ParseCompetencia(strings.NewReplacer("-", "/", ".", "/").Replace(strings.TrimSpace(valor))); err == nil {
+		return c, true
+	}
+	if d, ok := parseDataLegada(valor); ok {
+		return CompetenciaDaData(d), true
+	}
+	return Competencia{}, false
+}
+
+// decodificarNotaArmazenada decodifica uma nota gravada em JSON. Registros antigos podem ter
+// data ou competência fora do formato canônico: são convertidas pelos formatos legados e, se
+// nem assim forem reconhecidas, ficam vazias, com o texto original em ValoresOriginais para
+// a revisão. Os avisos descrevem cada campo que não pôde ser convertido.
+func decodificarNotaArmazenada(conteudo []byte) (NotaFiscalData, []string, error) {
+	var nota NotaFiscalData
+	if err := json.Unmarshal(conteudo, &nota); err == nil {
+		return nota, nil, nil
+	}
+
+	var campos map[string]json.RawMessage
+	if err := json.Unmarshal(conteudo, &campos); err != nil {
+		return nota, nil, err
+	}
+	var avisos []string
+	originais := map[string]json.RawMessage{}
+	converter := func(campo string, parse func(string) (string, bool)) {
+		var texto string
+		if bruto, ok := campos[campo]; !ok || json.Unmarshal(bruto, &texto) != nil {
+			return
+		}
+		canonico, ok := parse(texto)
+		if !ok {
+			avisos = append(avisos, fmt.Sprintf("%s %q não reconhecida; mantida em valoresOriginais", campo, texto))
+			originais[campo] = campos[campo]
+		}
+		campos[campo], _ = json.Marshal(canonico)
+	}
+	converter("dataNota", func(v string) (string, bool) {
+		d, ok := parseDataLegada(v)
+		return d.String(), ok || strings.TrimSpace(v) == ""
+	})
+	converter("competencia", func(v string) (string, bool) {
+		c, ok := parseCompetenciaLegada(v)
+		return c.String(), ok || strings.TrimSpace(v) == ""
+	})
+
+	corrigido, err := json.Marshal(campos)
+	if err != nil {
+		return nota, nil, err
+	}
+	if err := json.Unmarshal(corrigido, &nota); err != nil {
+		return nota, nil, err
+	}
+	for campo, valor := range originais {
+		if _, ok := nota.ValoresOriginais[campo]; ok {
+			continue
+		}
+		if nota.ValoresOriginais == nil {
+			nota.ValoresOriginais = map[string]json.RawMessage{}
+		}
+		nota.ValoresOriginais[campo] = valor
+	}
+	return nota, avisos, nil
+}
+
+// normalizarDatasExtraidas reescreve data e competência extraídas no formato canônico,
+// mantendo o texto original quando não for possível interpretá-lo
+func normalizarDatasExtraidas(nf *NFSeData) {
+	if d, err := ParseData(nf.DataNotaFiscal); err == nil {
+		nf.DataNotaFiscal = d.String()
+	}
+	if c, err := ParseCompetencia(nf.CompetenciaNotaFiscal); err == nil {
+		nf.CompetenciaNotaFiscal = c.String()
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseData(t *testing.T) {
+	validas := map[string]string{
+		"05/03/2025":   "05/03/2025",
+		"5/3/2025":     "05/03/2025",
+		"2025-03-05":   "05/03/2025",
+		" 29/02/2024 ": "29/02/2024",
+	}
+	for valor, esperado := range validas {
+		d, err := ParseData(valor)
+		if err != nil || d.String() != esperado {
+			t.Errorf("ParseData(%q) = %q, erro %v; esperado %q", valor, d.String(), err, esperado)
+		}
+	}
+
+	// Formatos legados e datas inexistentes não são aceitos na entrada
+	invalidas := []string{"", "31/02/2025", "29/02/2025", "13/13/2025", "05-03-2025", "05.03.2025", "05/03/25",
+		"2025/03/05", "2025-03-05T10:00:00Z", "05/03/1899", "05/03/2101", "março de 2025"}
+	for _, valor := range invalidas {
+		if d, err := ParseData(valor); err == nil {
+			t.Errorf("ParseData(%q) aceitou %q", valor, d.String())
+		}
+	}
+}
+
+func TestParseCompetencia(t *testing.T) {
+	validas := map[string]string{
+		"03/2025":   "03/2025",
+		"3/2025":    "03/2025",
+		"2025-03":   "03/2025",
+		" 12/2024 ": "12/2024",
+	}
+	for valor, esperado := range validas {
+		c, err := ParseCompetencia(valor)
+		if err != nil || c.String() != esperado {
+			t.Errorf("ParseCompetencia(%q) = %q, erro %v; esperado %q", valor, c.String(), err, esperado)
+		}
+	}
+
+	invalidas := []string{"", "13/2025", "00/2025", "03/25", "003/2025", "03-2025", "03.2025", "05/03/2025",
+		"2025/03", "03/1899", "marco/2025"}
+	for _, valor := range invalidas {
+		if c, err := ParseCompetencia(valor); err == nil {
+			t.Errorf("ParseCompetencia(%q) aceitou %q", valor, c.String())
+		}
+	}
+}
+
+func TestParseDataLegada(t *testing.T) {
+	validas := map[string]string{
+		"05/03/2025":           "05/03/2025",
+		"05-03-2025":           "05/03/2025",
+		"05.03.2025":           "05/03/2025",
+		"05/03/25":             "05/03/2025",
+		"2025/03/05":           "05/03/2025",
+		"2025-03-05T23:30:00Z": "05/03/2025",
+		"2025-03-05T10:00:00":  "05/03/2025",
+		"2025-03-05 10:00:00":  "05/03/2025",
+	}
+	for valor, esperado := range validas {
+		d, ok := parseDataLegada(valor)
+		if !ok || d.String() != esperado {
+			t.Errorf("parseDataLegada(%q) = %q, %v; esperado %q", valor, d.String(), ok, esperado)
+		}
+	}
+	for _, valor := range []string{"", "31/02/2025", "ontem", "05/03/1800"} {
+		if d, ok := parseDataLegada(valor); ok {
+			t.Errorf("parseDataLegada(%q) aceitou %q", valor, d.String())
+		}
+	}
+}
+
+func TestParseCompetenciaLegada(t *testing.T) {
+	validas := map[string]string{
+		"03/2025":    "03/2025",
+		"03-2025":    "03/2025",
+		"03.2025":    "03/2025",
+		"2025-03":    "03/2025",
+		"05/03/2025": "03/2025",
+		"2025-03-05": "03/2025",
+	}
+	for valor, esperado := range validas {
+		c, ok := parseCompetenciaLegada(valor)
+		if !ok || c.String() != esperado {
+			t.Errorf("parseCompetenciaLegada(%q) = %q, %v; esperado %q", valor, c.String(), ok, esperado)
+		}
+	}
+	for _, valor := range []string{"", "13/2025", "março"} {
+		if c, ok := parseCompetenciaLegada(valor); ok {
+			t.Errorf("parseCompetenciaLegada(%q) aceitou %q", valor, c.String())
+		}
+	}
+}
+
+func TestDataJSON(t *testing.T) {
+	var nota struct {
+		Data        Data        `json:"data"`
+		Competencia Competencia `json:"competencia"`
+	}
+	if err := json.Unmarshal([]byte(`{"data":"2025-03-05","competencia":"3/2025"}`), &nota); err != nil {
+		t.Fatal(err)
+	}
+	conteudo, _ := json.Marshal(nota)
+	if string(conteudo) != `{"data":"05/03/2025","competencia":"03/2025"}` {
+		t.Errorf("serializado como %s", conteudo)
+	}
+
+	if err := json.Unmarshal([]byte(`{"data":"","competencia":""}`), &nota); err != nil || !nota.Data.IsZero() || !nota.Competencia.IsZero() {
+		t.Errorf("vazios: %+v, erro %v", nota, err)
+	}
+	if err := json.Unmarshal([]byte(`{"data":"05-03-2025"}`), &nota); err == nil {
+		t.Error("formato legado aceito na entrada JSON")
+	}
+}
+
+func TestDecodificarNotaArmazenada(t *testing.T) {
+	// Registro canônico: sem avisos
+	nota, avisos, err := decodificarNotaArmazenada([]byte(`{"id":"n1","dataNota":"05/03/2025","competencia":"03/2025"}`))
+	if err != nil || len(avisos) != 0 || nota.DataNota.String() != "05/03/2025" {
+		t.Fatalf("canônico: %+v, avisos %v, erro %v", nota, avisos, err)
+	}
+
+	// Registro antigo em formato legado: convertido
+	nota, avisos, err = decodificarNotaArmazenada([]byte(`{"id":"n2","dataNota":"05.03.2025","competencia":"2025-03-05"}`))
+	if err != nil || len(avisos) != 0 || nota.DataNota.String() != "05/03/2025" || nota.Competencia.String() != "03/2025" {
+		t.Fatalf("legado: %+v, avisos %v, erro %v", nota, avisos, err)
+	}
+
+	// Irreconhecível: fica vazio, com o texto original em valoresOriginais e um aviso
+	nota, avisos, err = decodificarNotaArmazenada([]byte(`{"id":"n3","dataNota":"ontem","competencia":"03/2025"}`))
+	if err != nil || !nota.DataNota.IsZero() || nota.Competencia.String() != "03/2025" {
+		t.Fatalf("irreconhecível: %+v, erro %v", nota, err)
+	}
+	if len(avisos) != 1 || !strings.Contains(avisos[0], "dataNota") || string(nota.ValoresOriginais["dataNota"]) != `"ontem"` {
+		t.Fatalf("avisos %v, originais %v", avisos, nota.ValoresOriginais)
+	}
+}
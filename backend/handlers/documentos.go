@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ArmazemDocumentos guarda os documentos das coleções compartilhadas entre instâncias (lotes
+// de /jobs e respostas idempotentes). Os repositórios SQL o implementam com a tabela documentos;
+// no modo arquivos, os documentos ficam em uploads/<colecao>/<id>.json e valem só para uma instância.
+type ArmazemDocumentos interface {
+	// LerDocumento retorna os.ErrNotExist se o documento não existir
+	LerDocumento(ctx context.Context, colecao, id string) ([]byte, error)
+	// ListarDocumentos retorna o conteúdo de cada documento da coleção pelo ID
+	ListarDocumentos(ctx context.Context, colecao string) (map[string][]byte, error)
+	// GravarDocumento cria ou substitui o documento
+	GravarDocumento(ctx context.Context, colecao, id string, conteudo []byte) error
+	// CriarDocumento grava o documento apenas se o ID não existir; false se já existir
+	CriarDocumento(ctx context.Context, colecao, id string, conteudo []byte) (bool, error)
+	// SubstituirDocumento grava o documento apenas se o conteúdo atual for anterior; false se
+	// foi alterado ou removido nesse intervalo
+	SubstituirDocumento(ctx context.Context, colecao, id string, anterior, conteudo []byte) (bool, error)
+	// RemoverDocumento retorna os.ErrNotExist se o documento não existir
+	RemoverDocumento(ctx context.Context, colecao, id string) error
+}
+
+// documentos é o armazém das coleções compartilhadas; ConfigurarRepositorio o troca pelo
+// repositório quando este implementa ArmazemDocumentos
+var documentos ArmazemDocumentos = armazemLocal
+
+// armazemLocal guarda as coleções em arquivos no diretório de uploads: é o armazém do modo
+// arquivos e a origem dos documentos importados por importarLocais
+var armazemLocal = &armazemArquivos{dir: uploadDir}
+
+// armazemArquivos guarda cada documento em <dir>/<colecao>/<id>.json
+type armazemArquivos struct {
+	dir string
+	// mu torna CriarDocumento e SubstituirDocumento atômicos nesta instância
+	mu sync.Mutex
+}
+
+// caminho retorna o arquivo de um documento, rejeitando IDs que escapariam do diretório
+func (a *armazemArquivos) caminho(colecao, id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return "", fmt.Errorf("id inválido: %q", id)
+	}
+	return filepath.Join(a.dir, colecao, id+".json"), nil
+}
+
+func (a *armazemArquivos) LerDocumento(_ context.Context, colecao, id string) ([]byte, error) {
+	caminho, err := a.caminho(colecao, id)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return os.ReadFile(caminho)
+}
+
+func (a *armazemArquivos) ListarDocumentos(_ context.Context, colecao string) (map[string][]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	arquivos, err := os.ReadDir(filepath.Join(a.dir, colecao))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string][]byte, len(arquivos))
+	for _, arquivo := range arquivos {
+		if arquivo.IsDir() || !strings.HasSuffix(arquivo.Name(), ".json") {
+			continue
+		}
+		conteudo, err := os.ReadFile(filepath.Join(a.dir, colecao, arquivo.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs[strings.TrimSuffix(arquivo.Name(), ".json")] = conteudo
+	}
+	return docs, nil
+}
+
+func (a *armazemArquivos) GravarDocumento(_ context.Context, colecao, id string, conteudo []byte) error {
+	caminho, err := a.caminho(colecao, id)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return gravarAtomico(caminho, bytes.NewReader(conteudo))
+}
+
+func (a *armazemArquivos) CriarDocumento(_ context.Context, colecao, id string, conteudo []byte) (bool, error) {
+	caminho, err := a.caminho(colecao, id)
+	if err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := os.Stat(caminho); err == nil {
+		return false, nil
+	}
+	return true, gravarAtomico(caminho, bytes.NewReader(conteudo))
+}
+
+func (a *armazemArquivos) SubstituirDocumento(_ context.Context, colecao, id string, anterior, conteudo []byte) (bool, error) {
+	caminho, err := a.caminho(colecao, id)
+	if err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	atual, err := os.ReadFile(caminho)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(atual, anterior) {
+		return false, err
+	}
+	return true, gravarAtomico(caminho, bytes.NewReader(conteudo))
+}
+
+func (a *armazemArquivos) RemoverDocumento(_ context.Context, colecao, id string) error {
+	caminho, err := a.caminho(colecao, id)
+	if err != nil {
+		return os.ErrNotExist
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return os.Remove(caminho)
+}
+
+func (r *repositorioSQL) LerDocumento(ctx context.Context, colecao, id string) ([]byte, error) {
+	var conteudo string
+	err := r.db.QueryRowContext(ctx, r.rebind(`SELECT conteudo FROM documentos WHERE colecao = ? AND id = ?`), colecao, id).Scan(&conteudo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return []byte(conteudo), err
+}
+
+func (r *repositorioSQL) ListarDocumentos(ctx context.Context, colecao string) (map[string][]byte, error) {
+	rows, err := r.db.QueryContext(ctx, r.rebind(`SELECT id, conteudo FROM documentos WHERE colecao = ?`), colecao)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := map[string][]byte{}
+	for rows.Next() {
+		var id, conteudo string
+		if err := rows.Scan(&id, &conteudo); err != nil {
+			return nil, err
+		}
+		docs[id] = []byte(conteudo)
+	}
+	return docs, rows.Err()
+}
+
+func (r *repositorioSQL) GravarDocumento(ctx context.Context, colecao, id string, conteudo []byte) error {
+	_, err := r.db.ExecContext(ctx, r.rebind(`INSERT INTO documentos (colecao, id, conteudo) VALUES (?, ?, ?)
+		ON CONFLICT (colecao, id) DO UPDATE SET conteudo = excluded.conteudo`), colecao, id, string(conteudo))
+	return err
+}
+
+func (r *repositorioSQL) CriarDocumento(ctx context.Context, colecao, id string, conteudo []byte) (bool, error) {
+	res, err := r.db.ExecContext(ctx, r.rebind(`INSERT INTO documentos (colecao, id, conteudo) VALUES (?, ?, ?)
+		ON CONFLICT (colecao, id) DO NOTHING`), colecao, id, string(conteudo))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *repositorioSQL) SubstituirDocumento(ctx context.Context, colecao, id string, anterior, conteudo []byte) (bool, error) {
+	res, err := r.db.ExecContext(ctx, r.rebind(`UPDATE documentos SET conteudo = ? WHERE colecao = ? AND id = ? AND conteudo = ?`),
+		string(conteudo), colecao, id, string(anterior))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *repositorioSQL) RemoverDocumento(ctx context.Context, colecao, id string) error {
+	res, err := r.db.ExecContext(ctx, r.rebind(`DELETE FROM documentos WHERE colecao = ? AND id = ?`), colecao, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return os.ErrNotExist
+	}
+	return err
+}
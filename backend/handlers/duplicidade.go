@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tipos de suspeita de duplicidade retornados por ListarDuplicadas
+const (
+	DuplicidadeExata      = "exata"      // mesmo CNPJ, número e série
+	DuplicidadeArquivo    = "arquivo"    // mesmo conteúdo de arquivo (hash)
+	DuplicidadeAproximada = "aproximada" // mesmo prestador, valor e data, número diferente
+)
+
+// GrupoDuplicidade agrupa notas salvas suspeitas de serem a mesma nota fiscal
+type GrupoDuplicidade struct {
+	Tipo  string           `json:"tipo"`
+	Chave string           `json:"chave"`
+	Notas []NotaFiscalData `json:"notas"`
+}
+
+// normalizarCNPJ mantém apenas os dígitos do CNPJ/CPF
+func normalizarCNPJ(cnpj string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, cnpj)
+}
+
+// normalizarNumero remove separadores e zeros à esquerda de número e série da nota
+func normalizarNumero(numero string) string {
+	normalizado := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, numero)
+	return strings.TrimLeft(normalizado, "0")
+}
+
+// chaveNota monta a chave de identificação (CNPJ + número) usada na detecção de duplicadas
+func chaveNota(cnpj, numero string) string {
+	return normalizarCNPJ(cnpj) + "|" + normalizarNumero(numero)
+}
+
+// mesmaSerie considera séries equivalentes quando iguais ou quando uma delas não foi informada,
+// já que muitas prefeituras não emitem série e a extração pode não encontrá-la
+func mesmaSerie(a, b string) bool {
+	a, b = normalizarNumero(a), normalizarNumero(b)
+	return a == "" || b == "" || a == b
+}
+
+// hashArquivo calcula o SHA-256 do conteúdo do arquivo
+func hashArquivo(conteudo []byte) string {
+	soma := sha256.Sum256(conteudo)
+	return hex.EncodeToString(soma[:])
+}
+
+// encontrarDuplicada procura entre as notas salvas não removidas uma nota com o mesmo CNPJ,
+// número e série ou com o mesmo hash de arquivo. Retorna nil se não houver duplicada.
+func encontrarDuplicada(notas []NotaFiscalData, cnpj, numero, serie, hash string) *NotaFiscalData {
+	chave := chaveNota(cnpj, numero)
+	for i := range notas {
+		if notas[i].RemovidoEm != nil {
+			continue
+		}
+		if hash != "" && notas[i].HashArquivo == hash {
+			return &notas[i]
+		}
+		if normalizarNumero(numero) != "" && chaveNota(notas[i].CNPJ, notas[i].NumeroNota) == chave && mesmaSerie(notas[i].Serie, serie) {
+			return &notas[i]
+		}
+	}
+	return nil
+}
+
+// agruparDuplicadas identifica grupos de notas exatamente duplicadas, com o mesmo arquivo
+// ou aproximadamente duplicadas (mesmo prestador, valor e data com número diferente)
+func agruparDuplicadas(notas []NotaFiscalData) []GrupoDuplicidade {
+	var grupos []GrupoDuplicidade
+
+	agrupar := func(tipo string, chaveDe func(NotaFiscalData) string, valido func([]NotaFiscalData) bool) {
+		indice := make(map[string][]NotaFiscalData)
+		var chaves []string
+		for _, nota := range notas {
+			chave := chaveDe(nota)
+			if chave == "" {
+				continue
+			}
+			if _, ok := indice[chave]; !ok {
+				chaves = append(chaves, chave)
+			}
+			indice[chave] = append(indice[chave], nota)
+		}
+		sort.Strings(chaves)
+		for _, chave := range chaves {
+			if len(indice[chave]) > 1 && valido(indice[chave]) {
+				grupos = append(grupos, GrupoDuplicidade{Tipo: tipo, Chave: chave, Notas: indice[chave]})
+			}
+		}
+	}
+
+	agrupar(DuplicidadeExata, func(n NotaFiscalData) string {
+		if normalizarCNPJ(n.CNPJ) == "" || normalizarNumero(n.NumeroNota) == "" {
+			return ""
+		}
+		return chaveNota(n.CNPJ, n.NumeroNota)
+	}, func(grupo []NotaFiscalData) bool {
+		for i := 1; i < len(grupo); i++ {
+			if !mesmaSerie(grupo[0].Serie, grupo[i].Serie) {
+				return false
+			}
+		}
+		return true
+	})
+
+	agrupar(DuplicidadeArquivo, func(n NotaFiscalData) string {
+		return n.HashArquivo
+	}, func([]NotaFiscalData) bool { return true })
+
+	agrupar(DuplicidadeAproximada, func(n NotaFiscalData) string {
+		if normalizarCNPJ(n.CNPJ) == "" || n.DataNota.IsZero() {
+			return ""
+		}
+		// Centavos evitam diferenças de arredondamento em ponto flutuante
+		centavos := int64(math.Round(n.ValorServicos * 100))
+		return normalizarCNPJ(n.CNPJ) + "|" + n.DataNota.String() + "|" + strconv.FormatInt(centavos, 10)
+	}, func(grupo []NotaFiscalData) bool {
+		// Só é aproximada se houver números diferentes; números iguais já são duplicidade exata
+		for i := 1; i < len(grupo); i++ {
+			if normalizarNumero(grupo[i].NumeroNota) != normalizarNumero(grupo[0].NumeroNota) {
+				return true
+			}
+		}
+		return false
+	})
+
+	return grupos
+}
+
+// ListarDuplicadas lista grupos de notas fiscais salvas suspeitas de duplicidade
+func ListarDuplicadas(c *gin.Context) {
+	notas, err := notasRepo.Listar(c.Request.Context(), FiltroNotas{})
+	if err != nil {
+		log.Printf("Erro ao carregar notas fiscais: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+
+	grupos := agruparDuplicadas(notas)
+	if grupos == nil {
+		grupos = []GrupoDuplicidade{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"duplicadas": grupos,
+		"total":      len(grupos),
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizarNumero(t *testing.T) {
+	casos := map[string]string{
+		"123":       "123",
+		"000123":    "123",
+		"2025/0042": "20250042",
+		"a-1":       "A1",
+		" 0 ":       "",
+		"":          "",
+	}
+	for valor, esperado := range casos {
+		if obtido := normalizarNumero(valor); obtido != esperado {
+			t.Errorf("normalizarNumero(%q) = %q, esperado %q", valor, obtido, esperado)
+		}
+	}
+	if normalizarCNPJ("12.345.678/0001-90") != "12345678000190" {
+		t.Error("CNPJ formatado não normalizado")
+	}
+}
+
+func TestMesmaSerie(t *testing.T) {
+	casos := []struct {
+		a, b  string
+		mesma bool
+	}{
+		{"1", "1", true},
+		{"01", "1", true},
+		{"A", "a", true},
+		{"", "1", true},
+		{"1", "", true},
+		{"", "", true},
+		{"0", "2", true}, // "0" normaliza para vazio
+		{"1", "2", false},
+		{"A", "B", false},
+	}
+	for _, caso := range casos {
+		if obtido := mesmaSerie(caso.a, caso.b); obtido != caso.mesma {
+			t.Errorf("mesmaSerie(%q, %q) = %v, esperado %v", caso.a, caso.b, obtido, caso.mesma)
+		}
+	}
+}
+
+func TestEncontrarDuplicada(t *testing.T) {
+	removida := time.Now()
+	salvas := []NotaFiscalData{
+		{ID: "serie1", CNPJ: "12.345.678/0001-90", NumeroNota: "100", Serie: "1", HashArquivo: "hash-serie1"},
+		{ID: "semSerie", CNPJ: "12345678000190", NumeroNota: "200", HashArquivo: "hash-semSerie"},
+		{ID: "removida", CNPJ: "12345678000190", NumeroNota: "300", Serie: "1", HashArquivo: "hash-removida", RemovidoEm: &removida},
+		{ID: "semNumero", CNPJ: "12345678000190", HashArquivo: ""},
+	}
+	casos := []struct {
+		nome                      string
+		cnpj, numero, serie, hash string
+		esperado                  string
+	}{
+		{"mesma chave", "12345678000190", "100", "1", "", "serie1"},
+		{"chave formatada de outro jeito", "12.345.678/0001-90", "0100", "01", "", "serie1"},
+		{"série vazia no envio", "12345678000190", "100", "", "", "serie1"},
+		{"série vazia na salva", "12345678000190", "200", "5", "", "semSerie"},
+		{"outra série", "12345678000190", "100", "2", "", ""},
+		{"outro CNPJ", "98765432000110", "100", "1", "", ""},
+		{"outro número", "12345678000190", "101", "1", "", ""},
+		{"mesmo arquivo com outros dados", "98765432000110", "999", "9", "hash-serie1", "serie1"},
+		{"removida não conta", "12345678000190", "300", "1", "hash-removida", ""},
+		{"sem número só compara o arquivo", "12345678000190", "", "", "", ""},
+	}
+	for _, caso := range casos {
+		duplicada := encontrarDuplicada(salvas, caso.cnpj, caso.numero, caso.serie, caso.hash)
+		obtido := ""
+		if duplicada != nil {
+			obtido = duplicada.ID
+		}
+		if obtido != caso.esperado {
+			t.Errorf("%s: duplicada %q, esperada %q", caso.nome, obtido, caso.esperado)
+		}
+	}
+}
+
+func TestAgruparDuplicadas(t *testing.T) {
+	data, _ := ParseData("10/03/2025")
+	notas := []NotaFiscalData{
+		{ID: "a", CNPJ: "12345678000190", NumeroNota: "100", Serie: "1", HashArquivo: "h1"},
+		{ID: "b", CNPJ: "12.345.678/0001-90", NumeroNota: "0100", HashArquivo: "h2"},
+		{ID: "c", CNPJ: "12345678000190", NumeroNota: "200", Serie: "1", HashArquivo: "h3"},
+		{ID: "d", CNPJ: "12345678000190", NumeroNota: "200", Serie: "2", HashArquivo: "h3"},
+		{ID: "e", CNPJ: "98765432000110", NumeroNota: "1", DataNota: data, ValorServicos: 150.10},
+		{ID: "f", CNPJ: "98765432000110", NumeroNota: "2", DataNota: data, ValorServicos: 150.1000001},
+	}
+	grupos := map[string][]string{}
+	for _, grupo := range agruparDuplicadas(notas) {
+		for _, nota := range grupo.Notas {
+			grupos[grupo.Tipo] = append(grupos[grupo.Tipo], nota.ID)
+		}
+	}
+	esperados := map[string]string{
+		// a e b: mesma chave, série vazia em b; c e d têm séries diferentes
+		DuplicidadeExata:      "a,b",
+		DuplicidadeArquivo:    "c,d",
+		DuplicidadeAproximada: "e,f",
+	}
+	for tipo, ids := range esperados {
+		if obtido := strings.Join(grupos[tipo], ","); obtido != ids {
+			t.Errorf("%s: %s, esperado %s", tipo, obtido, ids)
+		}
+	}
+}
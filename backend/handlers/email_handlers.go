@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,47 +16,80 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// uploadDir é o diretório onde os PDFs e os dados das notas fiscais são salvos
+const uploadDir = "uploads"
+
 // NotaFiscalData representa os dados da nota fiscal a ser salva
 type NotaFiscalData struct {
-	Email         string  `json:"email"`
-	NumeroNota    string  `json:"numeroNota"`
-	Competencia   string  `json:"competencia"`
-	Prestador     string  `json:"prestador"`
-	CNPJ          string  `json:"cnpj"`
-	ValorServicos float64 `json:"valorServicos"`
-	DataNota      string  `json:"dataNota"`
-	ISSRetido     float64 `json:"issRetido"`
+	ID            string      `json:"id"`
+	Email         string      `json:"email"`
+	NumeroNota    string      `json:"numeroNota"`
+	Serie         string      `json:"serie"`
+	Competencia   Competencia `json:"competencia"`
+	Prestador     string      `json:"prestador"`
+	CNPJ          string      `json:"cnpj"`
+	ValorServicos float64     `json:"valorServicos"`
+	DataNota      Data        `json:"dataNota"`
+	ISSRetido     float64     `json:"issRetido"`
+	// Arquivo é a chave do PDF no BlobStore; em notas antigas, o nome do arquivo em uploads/
+	Arquivo     string `json:"arquivo"`
+	HashArquivo string `json:"hashArquivo"`
+	NomeArquivo string `json:"nomeArquivo,omitempty"` // nome do arquivo enviado
+	// VersaoExtrator identifica modelo e prompt usados na extração (ver ExtratorOpenAI.Versao)
+	VersaoExtrator string `json:"versaoExtrator,omitempty"`
+
+	// Revisão manual: situação, valores extraídos originalmente para os campos corrigidos e revisor
+	Status           string                     `json:"status"`
+	ValoresOriginais map[string]json.RawMessage `json:"valoresOriginais,omitempty"`
+	Revisor          string                     `json:"revisor,omitempty"`
+	RevisadoEm       *time.Time                 `json:"revisadoEm,omitempty"`
+	MotivoRejeicao   string                     `json:"motivoRejeicao,omitempty"`
+
+	CriadoEm time.Time `json:"criadoEm,omitzero"`
+	// RemovidoEm marca a exclusão lógica; notas removidas não são listadas nem contam como duplicadas
+	RemovidoEm *time.Time `json:"removidoEm,omitempty"`
 }
 
 // SaveNotaFiscal salva a nota fiscal no sistema
 func SaveNotaFiscal(c *gin.Context) {
 	// Parse multipart form
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Erro ao processar formulário"})
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao processar formulário")
 		return
 	}
 
 	// Extrair dados do formulário
-	email := c.PostForm("email")
-	numeroNota := c.PostForm("numeroNota")
-	competencia := c.PostForm("competencia")
+	email := sanitizarTexto(c.PostForm("email"), maxEmailNota)
+	numeroNota := sanitizarTexto(c.PostForm("numeroNota"), maxNumeroNota)
+	competenciaForm := c.PostForm("competencia")
+
+	if email == "" || numeroNota == "" || competenciaForm == "" {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados obrigatórios não fornecidos")
+		return
+	}
+	if endereco, err := mail.ParseAddress(email); err != nil || endereco.Address != email {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "E-mail inválido")
+		return
+	}
 
-	if email == "" || numeroNota == "" || competencia == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Dados obrigatórios não fornecidos"})
+	competencia, err := ParseCompetencia(competenciaForm)
+	if err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Competência inválida: "+err.Error())
 		return
 	}
 
 	// Processar arquivo PDF
 	file, header, err := c.Request.FormFile("notaFiscal")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Arquivo PDF não fornecido"})
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Arquivo PDF não fornecido")
 		return
 	}
 	defer file.Close()
 
-	// Verificar se é um PDF
-	if filepath.Ext(header.Filename) != ".pdf" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Apenas arquivos PDF são aceitos"})
+	// Verificar se é um PDF: pela extensão e pelo conteúdo
+	nomeArquivo := sanitizarNomeArquivo(header.Filename)
+	if !strings.EqualFold(filepath.Ext(nomeArquivo), ".pdf") {
+		responderErro(c, http.StatusBadRequest, CodigoArquivoInvalido, "Apenas arquivos PDF são aceitos")
 		return
 	}
 
@@ -62,23 +97,29 @@ func SaveNotaFiscal(c *gin.Context) {
 	pdfBytes, err := io.ReadAll(file)
 	if err != nil {
 		log.Printf("Erro ao ler arquivo PDF: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao ler arquivo PDF"})
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao ler arquivo PDF")
+		return
+	}
+	if !pdfValido(pdfBytes) {
+		responderErro(c, http.StatusBadRequest, CodigoArquivoInvalido, "O arquivo enviado não é um PDF válido")
 		return
 	}
 
 	// Obter a chave da API OpenAI
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "A variável de ambiente OPENAI_API_KEY não está configurada."})
+		responderErro(c, http.StatusServiceUnavailable, CodigoExtracaoIndisponivel, "A variável de ambiente OPENAI_API_KEY não está configurada.")
 		return
 	}
 
 	// Extrair dados da nota fiscal usando OpenAI
 	log.Printf("Iniciando extração de dados da nota fiscal para email: %s", email)
-	nfseDataList, err := callOpenAI(pdfBytes, apiKey)
+	extrator := NovoExtratorOpenAI(apiKey)
+	nfseDataList, _, err := extrator.Extrair(c.Request.Context(), pdfBytes, nomeArquivo)
 	if err != nil {
 		log.Printf("Erro ao extrair dados da nota fiscal: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao processar nota fiscal: " + err.Error()})
+		codigo := codigoErroExtracao(err)
+		responderErro(c, statusErroExtracao(codigo), codigo, "Erro ao processar nota fiscal: "+err.Error())
 		return
 	}
 
@@ -86,7 +127,7 @@ func SaveNotaFiscal(c *gin.Context) {
 
 	if len(nfseDataList) == 0 {
 		log.Printf("Nenhum dado foi extraído da nota fiscal")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Não foi possível extrair dados da nota fiscal"})
+		responderErro(c, http.StatusUnprocessableEntity, CodigoResultadoVazio, "Não foi possível extrair dados da nota fiscal")
 		return
 	}
 
@@ -107,147 +148,214 @@ func SaveNotaFiscal(c *gin.Context) {
 		notaFiscalExtraida = nfseDataList[0]
 	}
 
-	// Criar diretório para salvar os arquivos se não existir
-	uploadDir := "uploads"
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Printf("Erro ao criar diretório: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro interno do servidor"})
-		return
+	// A data extraída precisa ser uma data válida; ausência de data é aceita
+	var dataNota Data
+	if strings.TrimSpace(notaFiscalExtraida.DataNotaFiscal) != "" {
+		dataNota, err = ParseData(notaFiscalExtraida.DataNotaFiscal)
+		if err != nil {
+			log.Printf("Data extraída inválida: %v", err)
+			responderErroDetalhes(c, http.StatusUnprocessableEntity, CodigoDadosExtraidosInvalidos, "Data da nota fiscal extraída é inválida: "+err.Error(), gin.H{
+				"extracted_data": notaFiscalExtraida,
+			})
+			return
+		}
 	}
 
-	// Gerar nome único para o arquivo
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s_%s.pdf", email, numeroNota, timestamp)
-	filePath := filepath.Join(uploadDir, filename)
+	// Rejeitar notas já salvas (mesmo CNPJ, número e série ou mesmo arquivo)
+	notasMu.Lock()
+	defer notasMu.Unlock()
 
-	// Salvar arquivo PDF
-	dst, err := os.Create(filePath)
+	ctx := c.Request.Context()
+	hash := hashArquivo(pdfBytes)
+	original, err := notasRepo.BuscarDuplicada(ctx, notaFiscalExtraida.CNPJ, notaFiscalExtraida.NumeroNotaFiscal, notaFiscalExtraida.SerieNotaFiscal, hash)
 	if err != nil {
-		log.Printf("Erro ao criar arquivo: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao salvar arquivo"})
+		log.Printf("Erro ao verificar duplicidade: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro interno do servidor")
 		return
 	}
-	defer dst.Close()
-
-	// Copiar conteúdo do arquivo
-	if _, err := dst.Write(pdfBytes); err != nil {
-		log.Printf("Erro ao copiar arquivo: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao salvar arquivo"})
+	if original != nil {
+		log.Printf("Nota fiscal duplicada: %s já salva em %s", notaFiscalExtraida.NumeroNotaFiscal, original.ID)
+		responderNotaDuplicada(c, original, notaFiscalExtraida)
 		return
 	}
 
-	// Criar registro completo da nota fiscal com dados extraídos
-	notaFiscal := NotaFiscalData{
-		Email:         email,
-		NumeroNota:    notaFiscalExtraida.NumeroNotaFiscal,
-		Competencia:   competencia,
-		Prestador:     notaFiscalExtraida.PrestadorServicos,
-		CNPJ:          notaFiscalExtraida.CNPJ,
-		ValorServicos: notaFiscalExtraida.ValorServicos,
-		DataNota:      notaFiscalExtraida.DataNotaFiscal,
-		ISSRetido:     notaFiscalExtraida.ISSRetido,
-	}
-
-	// Salvar dados em JSON (em produção, use um banco de dados)
-	notaFiscalJSON, err := json.Marshal(notaFiscal)
+	// O ID é gerado pelo servidor, sem dados do formulário
+	id := novoIDNota()
+
+	// Salvar arquivo PDF
+	chave, arquivoNovo, err := gravarArquivoNota(ctx, pdfBytes, nomeArquivo)
 	if err != nil {
-		log.Printf("Erro ao serializar dados: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro interno do servidor"})
+		log.Printf("Erro ao gravar arquivo: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar arquivo")
 		return
 	}
 
-	// Salvar dados em arquivo JSON
-	jsonFilename := fmt.Sprintf("%s_%s_%s.json", email, numeroNota, timestamp)
-	jsonFilepath := filepath.Join(uploadDir, jsonFilename)
-
-	if err := os.WriteFile(jsonFilepath, notaFiscalJSON, 0644); err != nil {
+	// Criar registro completo da nota fiscal com dados extraídos
+	notaFiscal := NotaFiscalData{
+		ID:             id,
+		Email:          email,
+		NumeroNota:     notaFiscalExtraida.NumeroNotaFiscal,
+		Serie:          notaFiscalExtraida.SerieNotaFiscal,
+		Competencia:    competencia,
+		Prestador:      notaFiscalExtraida.PrestadorServicos,
+		CNPJ:           notaFiscalExtraida.CNPJ,
+		ValorServicos:  notaFiscalExtraida.ValorServicos,
+		DataNota:       dataNota,
+		ISSRetido:      notaFiscalExtraida.ISSRetido,
+		Arquivo:        chave,
+		HashArquivo:    hash,
+		NomeArquivo:    nomeArquivo,
+		VersaoExtrator: extrator.Versao(),
+		Status:         StatusPendente,
+		CriadoEm:       time.Now(),
+	}
+	sanitizarCamposNota(&notaFiscal)
+
+	// Upload e extração ficam registrados separadamente na trilha de auditoria. O e-mail e o
+	// nome do arquivo são dados pessoais e não entram no autor nem nos detalhes.
+	ator := atorRequisicao(c, "")
+	extracao := novoEvento(OperacaoExtracao, ator, nil, notaFiscal)
+	upload := EventoAuditoria{
+		NotaID:   id,
+		Operacao: OperacaoUpload,
+		Ator:     ator,
+		Momento:  extracao.Momento,
+		Detalhes: fmt.Sprintf("arquivo %s (sha256 %s)", chave, hash),
+	}
+	if err := notasRepo.Salvar(ctx, notaFiscal, upload, extracao); err != nil {
 		log.Printf("Erro ao salvar dados JSON: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao salvar dados"})
+		// Sem o registro da nota, o PDF ficaria órfão
+		if arquivoNovo {
+			blobs.Remover(ctx, chave)
+		}
+		// Outra instância salvou a mesma nota depois da verificação acima
+		if errors.Is(err, ErrNotaDuplicada) {
+			original, _ := notasRepo.BuscarDuplicada(ctx, notaFiscal.CNPJ, notaFiscal.NumeroNota, notaFiscal.Serie, hash)
+			responderNotaDuplicada(c, original, notaFiscalExtraida)
+			return
+		}
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar dados")
 		return
 	}
 
-	log.Printf("Nota fiscal salva: %s", filename)
+	log.Printf("Nota fiscal salva: %s (arquivo %s)", id, chave)
 	log.Printf("Dados extraídos: %+v", notaFiscal)
 
+	// Conciliar com pedidos de compra e contratos; falhas aqui não impedem o salvamento
+	conciliacao, err := conciliarNota(ctx, notaFiscal)
+	if err != nil {
+		log.Printf("Erro ao conciliar nota fiscal com contratos: %v", err)
+	}
+
+	publicarEvento(EventoNotaSalva, gin.H{"nota": notaFiscal})
+	if err == nil {
+		publicarConciliacao(notaFiscal, conciliacao)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Nota fiscal salva com sucesso",
-		"filename":       filename,
+		"filename":       chave,
 		"data":           notaFiscal,
 		"extracted_data": notaFiscalExtraida,
+		"conciliacao":    conciliacao,
 	})
 }
 
-// BuscarNotasFiscais busca notas fiscais por competência
+// responderNotaDuplicada rejeita o salvamento de uma nota já salva, com a nota original
+// (nil se ela não puder ser identificada) e os dados extraídos do envio
+func responderNotaDuplicada(c *gin.Context, original *NotaFiscalData, extraida NFSeData) {
+	detalhes := gin.H{"extracted_data": extraida}
+	if original != nil {
+		detalhes["duplicada_de"] = original.ID
+		detalhes["original"] = original
+	}
+	responderErroDetalhes(c, http.StatusConflict, CodigoNotaDuplicada, "Nota fiscal já enviada anteriormente", detalhes)
+}
+
+// BuscarNotasFiscais busca notas fiscais por competência ou por período.
+// Aceita competencia=MM/AAAA (competência exata), competencia_inicio/competencia_fim
+// e data_inicio/data_fim (DD/MM/AAAA), combináveis entre si.
 func BuscarNotasFiscais(c *gin.Context) {
 	competencia := c.Query("competencia")
 
 	log.Printf("Busca de notas fiscais solicitada para competência: %s", competencia)
 
-	if competencia == "" {
-		log.Printf("Erro: Competência não fornecida")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Competência é obrigatória"})
+	filtro, err := parseFiltroPeriodo(c)
+	if err != nil {
+		log.Printf("Erro: filtro de período inválido: %v", err)
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
 		return
 	}
 
-	// Criar diretório de uploads se não existir
-	uploadDir := "uploads"
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Printf("Erro ao criar diretório: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro interno do servidor"})
+	if filtro.Vazio() {
+		log.Printf("Erro: Competência não fornecida")
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Competência é obrigatória")
 		return
 	}
 
-	// Listar todos os arquivos JSON no diretório
-	files, err := os.ReadDir(uploadDir)
+	notasFiscais, err := notasRepo.Listar(c.Request.Context(), FiltroNotas{Periodo: filtro})
 	if err != nil {
-		log.Printf("Erro ao ler diretório: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao buscar notas fiscais"})
+		log.Printf("Erro ao buscar notas fiscais: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
 		return
 	}
 
-	log.Printf("Encontrados %d arquivos no diretório uploads", len(files))
+	log.Printf("Encontradas %d notas fiscais para competência %s", len(notasFiscais), competencia)
 
-	var notasFiscais []NotaFiscalData
+	response := gin.H{
+		"notas_fiscais": notasFiscais,
+		"total":         len(notasFiscais),
+		"competencia":   competencia,
+	}
 
-	// Filtrar arquivos JSON e ler conteúdo
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			log.Printf("Processando arquivo JSON: %s", file.Name())
-			filePath := filepath.Join(uploadDir, file.Name())
+	log.Printf("Resposta JSON: %+v", response)
 
-			// Ler conteúdo do arquivo JSON
-			content, err := os.ReadFile(filePath)
-			if err != nil {
-				log.Printf("Erro ao ler arquivo %s: %v", file.Name(), err)
-				continue
-			}
+	c.JSON(http.StatusOK, response)
+}
 
-			log.Printf("Conteúdo do arquivo %s: %s", file.Name(), string(content))
+// parseFiltroPeriodo lê os parâmetros de período da query string
+func parseFiltroPeriodo(c *gin.Context) (FiltroPeriodo, error) {
+	var filtro FiltroPeriodo
 
-			// Deserializar dados da nota fiscal
-			var notaFiscal NotaFiscalData
-			if err := json.Unmarshal(content, &notaFiscal); err != nil {
-				log.Printf("Erro ao deserializar arquivo %s: %v", file.Name(), err)
-				continue
-			}
+	if valor := c.Query("competencia"); valor != "" {
+		competencia, err := ParseCompetencia(valor)
+		if err != nil {
+			return filtro, err
+		}
+		filtro.CompetenciaInicio, filtro.CompetenciaFim = competencia, competencia
+	}
 
-			// Filtrar por competência
-			if strings.Contains(notaFiscal.Competencia, competencia) {
-				log.Printf("Nota fiscal %s corresponde à competência %s", file.Name(), competencia)
-				notasFiscais = append(notasFiscais, notaFiscal)
+	for _, param := range []struct {
+		nome    string
+		destino *Competencia
+	}{
+		{"competencia_inicio", &filtro.CompetenciaInicio},
+		{"competencia_fim", &filtro.CompetenciaFim},
+	} {
+		if valor := c.Query(param.nome); valor != "" {
+			competencia, err := ParseCompetencia(valor)
+			if err != nil {
+				return filtro, fmt.Errorf("%s: %v", param.nome, err)
 			}
+			*param.destino = competencia
 		}
 	}
 
-	log.Printf("Encontradas %d notas fiscais para competência %s", len(notasFiscais), competencia)
-
-	response := gin.H{
-		"notas_fiscais": notasFiscais,
-		"total":         len(notasFiscais),
-		"competencia":   competencia,
+	for _, param := range []struct {
+		nome    string
+		destino *Data
+	}{
+		{"data_inicio", &filtro.DataInicio},
+		{"data_fim", &filtro.DataFim},
+	} {
+		if valor := c.Query(param.nome); valor != "" {
+			data, err := ParseData(valor)
+			if err != nil {
+				return filtro, fmt.Errorf("%s: %v", param.nome, err)
+			}
+			*param.destino = data
+		}
 	}
 
-	log.Printf("Resposta JSON: %+v", response)
-
-	c.JSON(http.StatusOK, response)
+	return filtro, nil
 }
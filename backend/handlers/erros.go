@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PrefixoAPI é o prefixo das rotas da versão atual da API. As mesmas rotas continuam
+// registradas sem prefixo para clientes antigos, com o formato de erro anterior.
+const PrefixoAPI = "/api/v1"
+
+// Códigos de erro da API, em ErroAPI.Codigo. Os clientes decidem pelo código; a mensagem
+// é para exibição e pode mudar. Falhas de extração usam os códigos de arquivo (ErroExtracao).
+const (
+	CodigoRequisicaoInvalida      = "invalid_request"
+	CodigoNaoEncontrado           = "not_found"
+	CodigoNaoAutorizado           = "unauthorized"
+	CodigoSituacaoInvalida        = "invalid_state"
+	CodigoNotaDuplicada           = "duplicate_note"
+	CodigoIdempotenciaEmAndamento = "idempotency_in_progress"
+	CodigoIdempotenciaReutilizada = "idempotency_key_reused"
+	CodigoDadosExtraidosInvalidos = "invalid_extracted_data"
+	CodigoExtracaoIndisponivel    = "extraction_unavailable"
+	CodigoServicoIndisponivel     = "service_unavailable"
+	CodigoFalhaParcial            = "partial_failure"
+	CodigoErroInterno             = "internal_error"
+)
+
+// CabecalhoRequestID identifica a requisição nos logs e nos erros. Um valor enviado pelo
+// cliente (ou pelo proxy) é mantido; sem ele, o servidor gera um.
+const CabecalhoRequestID = "X-Request-ID"
+
+// ErroAPI é o corpo das respostas de erro: {"error": ErroAPI}
+type ErroAPI struct {
+	Codigo    string `json:"code"`
+	Mensagem  string `json:"message"`
+	Detalhes  gin.H  `json:"details,omitempty"`
+	RequestID string `json:"request_id"`
+}
+
+// Chaves no contexto do Gin
+const (
+	chaveRequestID  = "request_id"
+	chaveErroLegado = "erro_legado"
+)
+
+// requestIDValido aceita IDs enviados pelo cliente que possam ir para logs sem escape
+var requestIDValido = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// IdentificarRequisicao atribui um ID a cada requisição e o devolve no cabeçalho X-Request-ID
+func IdentificarRequisicao() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(CabecalhoRequestID)
+		if !requestIDValido.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set(chaveRequestID, id)
+		c.Header(CabecalhoRequestID, id)
+		c.Next()
+	}
+}
+
+// RotasLegadas marca as rotas sem prefixo de versão: os erros mantêm o formato anterior,
+// {"error": "mensagem", ...detalhes}, e a resposta indica a rota substituta
+func RotasLegadas() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(chaveErroLegado, true)
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+PrefixoAPI+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// responderErro interrompe a requisição com o erro no formato da rota
+func responderErro(c *gin.Context, status int, codigo, mensagem string) {
+	responderErroDetalhes(c, status, codigo, mensagem, nil)
+}
+
+// responderErroDetalhes interrompe a requisição com o erro e dados complementares
+// (por exemplo, a nota original de uma duplicada)
+func responderErroDetalhes(c *gin.Context, status int, codigo, mensagem string, detalhes gin.H) {
+	id := c.GetString(chaveRequestID)
+	if status >= http.StatusInternalServerError {
+		log.Printf("Requisição %s %s (%s) respondida com %d: %s", c.Request.Method, c.Request.URL.Path, id, status, codigo)
+	}
+
+	if c.GetBool(chaveErroLegado) {
+		corpo := gin.H{"error": mensagem}
+		for chave, valor := range detalhes {
+			corpo[chave] = valor
+		}
+		c.AbortWithStatusJSON(status, corpo)
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": ErroAPI{
+		Codigo:    codigo,
+		Mensagem:  mensagem,
+		Detalhes:  detalhes,
+		RequestID: id,
+	}})
+}
+
+// statusErroExtracao é o status HTTP de uma falha de extração de um único arquivo: o provedor
+// falhou (502), o servidor falhou (500) ou o arquivo não tem dados extraíveis (422)
+func statusErroExtracao(codigo string) int {
+	switch codigo {
+	case CodigoProvedor, CodigoJSONInvalido:
+		return http.StatusBadGateway
+	case CodigoConversao, CodigoFalhaExtracao:
+		return http.StatusInternalServerError
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// RotaInexistente responde às rotas não registradas
+func RotaInexistente(c *gin.Context) {
+	responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Rota não encontrada")
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// novoIDNota gera o ID de uma nota (e de um contrato): 128 bits aleatórios em hexadecimal. Não contém dados do
+// envio e pode ser usado como nome de arquivo.
+func novoIDNota() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand não falha em sistemas suportados
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// gravarAtomico grava o conteúdo em um arquivo temporário no mesmo diretório e o renomeia para
+// o destino, de modo que leitores nunca vejam um arquivo incompleto
+func gravarAtomico(caminho string, r io.Reader) error {
+	dir := filepath.Dir(caminho)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(caminho)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	falhar := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		return falhar(err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return falhar(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return falhar(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), caminho); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// pdfValido confere a assinatura %PDF- do arquivo; a especificação admite bytes antes dela
+// dentro do primeiro 1 KB
+func pdfValido(conteudo []byte) bool {
+	inicio := conteudo[:min(len(conteudo), 1024)]
+	return bytes.Contains(inicio, []byte("%PDF-"))
+}
+
+// Tamanhos máximos (em caracteres) dos campos de texto da nota, extraídos ou informados pelo usuário
+const (
+	maxEmailNota     = 254
+	maxNumeroNota    = 60
+	maxSerieNota     = 20
+	maxPrestadorNota = 200
+	maxCNPJNota      = 30
+	maxRevisor       = 200
+	maxMotivo        = 1000
+)
+
+// sanitizarCamposNota aplica sanitizarTexto aos campos de texto extraídos ou corrigidos da nota
+func sanitizarCamposNota(nota *NotaFiscalData) {
+	nota.NumeroNota = sanitizarTexto(nota.NumeroNota, maxNumeroNota)
+	nota.Serie = sanitizarTexto(nota.Serie, maxSerieNota)
+	nota.Prestador = sanitizarTexto(nota.Prestador, maxPrestadorNota)
+	nota.CNPJ = sanitizarTexto(nota.CNPJ, maxCNPJNota)
+}
+
+// sanitizarTexto remove caracteres de controle e espaços nas pontas e limita o tamanho
+// (em caracteres) de um valor informado pelo usuário
+func sanitizarTexto(valor string, maximo int) string {
+	valor = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, valor)
+	valor = strings.TrimSpace(valor)
+	if utf8.RuneCountInString(valor) > maximo {
+		valor = string([]rune(valor)[:maximo])
+	}
+	return valor
+}
+
+// sanitizarNomeArquivo reduz o nome enviado ao nome base, sem diretórios nem caracteres de controle
+func sanitizarNomeArquivo(nome string) string {
+	nome = strings.ReplaceAll(nome, `\`, "/")
+	nome = sanitizarTexto(filepath.Base(nome), 200)
+	if nome == "" || nome == "." || nome == "/" || nome == ".." {
+		return "nota-fiscal.pdf"
+	}
+	return nome
+}
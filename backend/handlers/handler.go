@@ -2,15 +2,24 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,12 +28,14 @@ import (
 type NFSeData struct {
 	CNPJ                   string  `json:"CNPJ (NF)"`
 	NumeroNotaFiscal       string  `json:"Número da Nota (NF)"`
+	SerieNotaFiscal        string  `json:"Série da Nota (NF)"`
 	ValorServicos          float64 `json:"Valor dos Serviços"`
 	ValorLiquidoNotaFiscal float64 `json:"Valor Líquido da Nota Fiscal"`
 	DataNotaFiscal         string  `json:"Data da Nota Fiscal"`
 	CompetenciaNotaFiscal  string  `json:"Competência da Nota Fiscal"`
 	PrestadorServicos      string  `json:"Prestador de Serviços"`
 	ISSRetido              float64 `json:"ISS Retido"`
+	DuplicadaDe            string  `json:"Duplicada De,omitempty"`
 }
 
 // Structs for OpenAI API
@@ -56,54 +67,23 @@ type OpenAIResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
-// callOpenAI sends the invoice image to OpenAI API for processing.
-func callOpenAI(pdfBytes []byte, apiKey string) ([]NFSeData, error) {
-	var nfseDataList []NFSeData
-
-	// Create a temporary file for the PDF
-	tmpPdfFile, err := os.CreateTemp("", "invoice-*.pdf")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp pdf file: %v", err)
-	}
-	defer os.Remove(tmpPdfFile.Name())
-
-	if _, err := tmpPdfFile.Write(pdfBytes); err != nil {
-		return nil, fmt.Errorf("failed to write to temp pdf file: %v", err)
-	}
-	tmpPdfFile.Close()
-
-	// Convert PDF to image using pdftoppm (from poppler-utils)
-	// We'll just process the first page.
-	outputImagePath := strings.TrimSuffix(tmpPdfFile.Name(), ".pdf")
-	cmd := exec.Command("pdftoppm", "-png", "-f", "1", "-l", "1", tmpPdfFile.Name(), outputImagePath)
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to convert pdf to image: %v. Make sure poppler-utils is installed", err)
-	}
-
-	imageFilePath := outputImagePath + "-1.png"
-	defer os.Remove(imageFilePath)
-
-	// Read the image file
-	imageBytes, err := os.ReadFile(imageFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image file: %v", err)
-	}
-
-	// Encode the image to base64
-	base64Image := base64.StdEncoding.EncodeToString(imageBytes)
-	imageURL := fmt.Sprintf("data:image/png;base64,%s", base64Image)
-
-	systemPrompt := `Você é um especialista em extração de dados de Notas Fiscais de Serviço Eletrônicas (NFS-e) de diferentes prefeituras do Brasil. Sua tarefa é analisar a imagem de uma nota fiscal e retornar **APENAS** um JSON válido com a seguinte estrutura:
+// defaultSystemPrompt is the extraction prompt sent to the model unless overridden.
+const defaultSystemPrompt = `Você é um especialista em extração de dados de Notas Fiscais de Serviço Eletrônicas (NFS-e) de diferentes prefeituras do Brasil. Sua tarefa é analisar a imagem de uma nota fiscal e retornar **APENAS** um JSON válido com a seguinte estrutura:
 
 {
   "Prestador de Serviços": "Razão Social ou nome do prestador",
   "CNPJ (NF)": "CNPJ do prestador de serviços",
   "Número da Nota (NF)": "número da nota fiscal",
+  "Série da Nota (NF)": "série da nota fiscal",
   "Valor dos Serviços": 0.0,
   "Data da Nota Fiscal": "DD/MM/AAAA",
   "Competência da Nota Fiscal": "MM/AAAA",
@@ -128,33 +108,196 @@ func callOpenAI(pdfBytes []byte, apiKey string) ([]NFSeData, error) {
 5. **Número da Nota (NF)**:
    - Busque por "Número da NFS-e" ou "Número da Nota Fiscal". Priorize o número da NFS-e.
 
-6. **Valor dos Serviços**:
+6. **Série da Nota (NF)**:
+   - Busque por "Série" ou "Série da NFS-e". Se não houver, use string vazia "".
+
+7. **Valor dos Serviços**:
    - Use o campo **"Valor do Serviço"** ou **"Valor Total"**.
    - O número deve ser puro (sem aspas e sem R$), ex: 2380.89.
 
-7. **Data da Nota Fiscal**:
+8. **Data da Nota Fiscal**:
    - Extraia do campo "Data de Emissão" ou similar. Use o formato DD/MM/AAAA.
 
-8. **Competência da Nota Fiscal**:
+9. **Competência da Nota Fiscal**:
    - Busque pelo campo "Competência". Se não existir, use o mês/ano da data de emissão.
 
-9. **ISS Retido**:
+10. **ISS Retido**:
    - Busque por "ISS Retido" ou "(-) ISS Retido". Se não houver, o valor é 0.
 
-10. **Se algum campo não for encontrado**:
+11. **Se algum campo não for encontrado**:
     - Use string vazia "" (exceto para campos de valor, que devem ser 0).
 
-11. **Se houver mais de uma nota fiscal no mesmo texto**, retorne um array com um objeto JSON para cada uma.`
+12. **Se houver mais de uma nota fiscal no mesmo texto**, retorne um array com um objeto JSON para cada uma.`
+
+// UsoExtracao records the cost and latency of one extraction call.
+type UsoExtracao struct {
+	Modelo        string        `json:"modelo"`
+	TokensEntrada int           `json:"tokensEntrada"`
+	TokensSaida   int           `json:"tokensSaida"`
+	Duracao       time.Duration `json:"duracao"`
+}
+
+// ExtratorOpenAI extracts invoice data from PDFs using the OpenAI chat completions API.
+type ExtratorOpenAI struct {
+	APIKey        string
+	Modelo        string
+	BaseURL       string
+	PromptSistema string
+	Client        *http.Client
+
+	// limitador spaces out calls to the provider (see limitadorProvedor)
+	limitador *limitadorTaxa
+}
+
+// Error codes reported for files that could not be processed (see ErroExtracao)
+const (
+	CodigoArquivoIlegivel = "file_unreadable"
+	CodigoArquivoInvalido = "unsupported_file"
+	CodigoConversao       = "conversion_failed"
+	CodigoProvedor        = "provider_error"
+	CodigoJSONInvalido    = "invalid_json"
+	CodigoXMLInvalido     = "invalid_xml"
+	CodigoResultadoVazio  = "empty_result"
+	CodigoFalhaExtracao   = "extraction_failed"
+	// Archives (.zip, .tar.gz) that could not be expanded
+	CodigoCompactadoInvalido = "invalid_archive"
+	CodigoLimiteCompactado   = "archive_limit_exceeded"
+)
+
+// ErroExtracao is an extraction error classified by the step that failed.
+type ErroExtracao struct {
+	Codigo string
+	Err    error
+}
+
+func (e *ErroExtracao) Error() string { return e.Err.Error() }
+func (e *ErroExtracao) Unwrap() error { return e.Err }
+
+// falhaExtracao classifies an extraction error with one of the Codigo* codes.
+func falhaExtracao(codigo string, format string, args ...any) error {
+	return &ErroExtracao{Codigo: codigo, Err: fmt.Errorf(format, args...)}
+}
+
+// codigoErroExtracao returns the code of a classified error, or CodigoFalhaExtracao.
+func codigoErroExtracao(err error) string {
+	var erro *ErroExtracao
+	if errors.As(err, &erro) {
+		return erro.Codigo
+	}
+	return CodigoFalhaExtracao
+}
+
+// registroPreenchido reports whether the model returned any invoice data in the record.
+func registroPreenchido(nf NFSeData) bool {
+	return nf.NumeroNotaFiscal != "" || nf.CNPJ != "" || nf.ValorServicos != 0
+}
+
+// timeoutOpenAIEnv reads the limit for each call to the provider from OPENAI_TIMEOUT
+// (default 2m), so a provider that stops responding does not hold a file forever.
+func timeoutOpenAIEnv() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("OPENAI_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return 2 * time.Minute
+}
+
+// NovoExtratorOpenAI creates an extractor configured from the environment
+// (OPENAI_MODEL and OPENAI_BASE_URL, defaulting to gpt-4o on api.openai.com, and
+// OPENAI_TIMEOUT). Extractors for the same BaseURL share the OPENAI_RPM rate limit.
+func NovoExtratorOpenAI(apiKey string) *ExtratorOpenAI {
+	modelo := os.Getenv("OPENAI_MODEL")
+	if modelo == "" {
+		modelo = "gpt-4o"
+	}
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &ExtratorOpenAI{
+		APIKey:        apiKey,
+		Modelo:        modelo,
+		BaseURL:       baseURL,
+		PromptSistema: defaultSystemPrompt,
+		Client:        &http.Client{Timeout: timeoutOpenAIEnv()},
+		limitador:     limitadorProvedor(baseURL),
+	}
+}
+
+// Versao identifies the model and prompt used, so results from different prompts can be told apart.
+func (e *ExtratorOpenAI) Versao() string {
+	soma := sha256.Sum256([]byte(e.PromptSistema))
+	return fmt.Sprintf("%s/prompt-%s", e.Modelo, hex.EncodeToString(soma[:])[:8])
+}
+
+// Extrair extracts the invoices in a document. XML files (NFS-e ABRASF layout) are parsed
+// locally; any other file is treated as a PDF and sent to the model.
+// The context bounds the whole extraction: canceling it stops pdftoppm and the API call.
+func (e *ExtratorOpenAI) Extrair(ctx context.Context, conteudo []byte, nomeArquivo string) ([]NFSeData, UsoExtracao, error) {
+	if strings.EqualFold(filepath.Ext(nomeArquivo), ".xml") {
+		inicio := time.Now()
+		nfseDataList, err := extrairXMLNFSe(conteudo)
+		if err != nil {
+			err = &ErroExtracao{Codigo: CodigoXMLInvalido, Err: err}
+		}
+		return nfseDataList, UsoExtracao{Modelo: "xml", Duracao: time.Since(inicio)}, err
+	}
+	return e.extrairPDF(ctx, conteudo)
+}
+
+// extrairPDF converts the first page of the PDF to an image and asks the model for its data.
+func (e *ExtratorOpenAI) extrairPDF(ctx context.Context, pdfBytes []byte) (nfseDataList []NFSeData, uso UsoExtracao, err error) {
+	uso.Modelo = e.Modelo
+	inicio := time.Now()
+	defer func() { uso.Duracao = time.Since(inicio) }()
+
+	// Create a temporary file for the PDF
+	tmpPdfFile, err := os.CreateTemp("", "invoice-*.pdf")
+	if err != nil {
+		return nil, uso, falhaExtracao(CodigoConversao, "failed to create temp pdf file: %v", err)
+	}
+	defer os.Remove(tmpPdfFile.Name())
+
+	if _, err := tmpPdfFile.Write(pdfBytes); err != nil {
+		tmpPdfFile.Close()
+		return nil, uso, falhaExtracao(CodigoConversao, "failed to write to temp pdf file: %v", err)
+	}
+	tmpPdfFile.Close()
+
+	// Convert PDF to image using pdftoppm (from poppler-utils)
+	// We'll just process the first page.
+	outputImagePath := strings.TrimSuffix(tmpPdfFile.Name(), ".pdf")
+	imageFilePath := outputImagePath + "-1.png"
+	// Also removes a partial image left by a pdftoppm killed on cancellation
+	defer os.Remove(imageFilePath)
+
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-f", "1", "-l", "1", tmpPdfFile.Name(), outputImagePath)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, uso, ctx.Err()
+		}
+		return nil, uso, falhaExtracao(CodigoConversao, "failed to convert pdf to image: %v. Make sure poppler-utils is installed", err)
+	}
+
+	// Read the image file
+	imageBytes, err := os.ReadFile(imageFilePath)
+	if err != nil {
+		return nil, uso, falhaExtracao(CodigoConversao, "failed to read image file: %v", err)
+	}
+
+	// Encode the image to base64
+	base64Image := base64.StdEncoding.EncodeToString(imageBytes)
+	imageURL := fmt.Sprintf("data:image/png;base64,%s", base64Image)
 
 	userPrompt := "Extraia os dados da imagem desta nota fiscal e retorne apenas o JSON."
 
 	reqBody := OpenAIRequest{
-		Model: "gpt-4o",
+		Model: e.Modelo,
 		Messages: []OpenAIMessage{
 			{
 				Role: "system",
 				Content: []interface{}{
-					MessageContent{Type: "text", Text: systemPrompt},
+					MessageContent{Type: "text", Text: e.PromptSistema},
 				},
 			},
 			{
@@ -170,46 +313,54 @@ func callOpenAI(pdfBytes []byte, apiKey string) ([]NFSeData, error) {
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return nfseDataList, fmt.Errorf("erro ao criar JSON para OpenAI: %v", err)
+		return nfseDataList, uso, fmt.Errorf("erro ao criar JSON para OpenAI: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", e.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nfseDataList, fmt.Errorf("erro ao criar requisição para OpenAI: %v", err)
+		return nfseDataList, uso, fmt.Errorf("erro ao criar requisição para OpenAI: %v", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Authorization", "Bearer "+e.APIKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if err := e.limitador.aguardar(ctx); err != nil {
+		return nfseDataList, uso, err
+	}
+	resp, err := e.Client.Do(req)
 	if err != nil {
-		return nfseDataList, fmt.Errorf("erro ao chamar a API OpenAI: %v", err)
+		if ctx.Err() != nil {
+			return nfseDataList, uso, ctx.Err()
+		}
+		return nfseDataList, uso, falhaExtracao(CodigoProvedor, "erro ao chamar a API OpenAI: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nfseDataList, fmt.Errorf("erro ao ler resposta da OpenAI: %v", err)
+		return nfseDataList, uso, falhaExtracao(CodigoProvedor, "erro ao ler resposta da OpenAI: %v", err)
 	}
 
 	// Check if response is successful
 	if resp.StatusCode != http.StatusOK {
-		return nfseDataList, fmt.Errorf("erro da API OpenAI (status %d): %s", resp.StatusCode, string(respBody))
+		return nfseDataList, uso, falhaExtracao(CodigoProvedor, "erro da API OpenAI (status %d): %s", resp.StatusCode, string(respBody))
 	}
 
 	var openAIResp OpenAIResponse
 	if err := json.Unmarshal(respBody, &openAIResp); err != nil {
-		return nfseDataList, fmt.Errorf("erro ao decodificar resposta da OpenAI: %v. Resposta: %s", err, string(respBody))
+		return nfseDataList, uso, falhaExtracao(CodigoProvedor, "erro ao decodificar resposta da OpenAI: %v. Resposta: %s", err, string(respBody))
 	}
 
+	uso.TokensEntrada = openAIResp.Usage.PromptTokens
+	uso.TokensSaida = openAIResp.Usage.CompletionTokens
+
 	if openAIResp.Error != nil {
-		return nfseDataList, fmt.Errorf("erro da API OpenAI: %s", openAIResp.Error.Message)
+		return nfseDataList, uso, falhaExtracao(CodigoProvedor, "erro da API OpenAI: %s", openAIResp.Error.Message)
 	}
 
 	if len(openAIResp.Choices) == 0 {
-		return nfseDataList, fmt.Errorf("resposta da OpenAI vazia")
+		return nfseDataList, uso, falhaExtracao(CodigoResultadoVazio, "resposta da OpenAI vazia")
 	}
 
 	// Limpar o conteúdo para garantir que seja um JSON válido
@@ -231,7 +382,7 @@ func callOpenAI(pdfBytes []byte, apiKey string) ([]NFSeData, error) {
 	if strings.HasPrefix(jsonContent, "[") {
 		// Response is a JSON array
 		if err := json.Unmarshal([]byte(jsonContent), &nfseDataList); err != nil {
-			return nil, fmt.Errorf("erro ao fazer unmarshal do array JSON da OpenAI: %v. Resposta: %s", err, jsonContent)
+			return nil, uso, falhaExtracao(CodigoJSONInvalido, "erro ao fazer unmarshal do array JSON da OpenAI: %v. Resposta: %s", err, jsonContent)
 		}
 	} else if strings.HasPrefix(jsonContent, "{") {
 		// Response is a single JSON object
@@ -243,20 +394,21 @@ func callOpenAI(pdfBytes []byte, apiKey string) ([]NFSeData, error) {
 			if startIdx != -1 && endIdx != -1 && endIdx > startIdx {
 				jsonSubstring := jsonContent[startIdx : endIdx+1]
 				if err := json.Unmarshal([]byte(jsonSubstring), &singleNfseData); err != nil {
-					return nil, fmt.Errorf("erro ao fazer unmarshal do JSON da OpenAI (substring): %v. Resposta: %s", err, jsonContent)
+					return nil, uso, falhaExtracao(CodigoJSONInvalido, "erro ao fazer unmarshal do JSON da OpenAI (substring): %v. Resposta: %s", err, jsonContent)
 				}
 			} else {
-				return nil, fmt.Errorf("erro ao fazer unmarshal do JSON da OpenAI: %v. Resposta: %s", err, jsonContent)
+				return nil, uso, falhaExtracao(CodigoJSONInvalido, "erro ao fazer unmarshal do JSON da OpenAI: %v. Resposta: %s", err, jsonContent)
 			}
 		}
 		nfseDataList = append(nfseDataList, singleNfseData)
 	} else {
-		return nil, fmt.Errorf("formato de resposta inesperado da OpenAI: não é JSON nem array. Resposta: %s", jsonContent)
+		return nil, uso, falhaExtracao(CodigoJSONInvalido, "formato de resposta inesperado da OpenAI: não é JSON nem array. Resposta: %s", jsonContent)
 	}
 
-	// Calculate the net value
+	// Calculate the net value and normalize dates to DD/MM/AAAA and MM/AAAA
 	for i := range nfseDataList {
 		nfseDataList[i].ValorLiquidoNotaFiscal = nfseDataList[i].ValorServicos - nfseDataList[i].ISSRetido
+		normalizarDatasExtraidas(&nfseDataList[i])
 	}
 
 	// Validate that we have at least some data
@@ -266,15 +418,18 @@ func callOpenAI(pdfBytes []byte, apiKey string) ([]NFSeData, error) {
 		log.Printf("Warning: OpenAI response for an image seems empty or invalid: %+v", nfseDataList)
 	}
 
-	return nfseDataList, nil
+	return nfseDataList, uso, nil
 }
 
 // DecodeNotaFiscal handles multi-file upload and processing using a streaming response.
+// The stream format is negotiated by formatoStreamUpload: typed SSE or NDJSON events
+// (see EventoUpload), or the legacy records separated by "\n---\n". Up to
+// UPLOAD_CONCORRENCIA files are extracted in parallel, so events of different files may
+// interleave and arrive out of order; each event carries the original file index.
 func DecodeNotaFiscal(c *gin.Context) {
 	c.Header("Access-Control-Allow-Origin", "*")
 	c.Header("Access-Control-Allow-Methods", "POST, OPTIONS")
-	c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Idempotency-Key")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 
@@ -283,59 +438,198 @@ func DecodeNotaFiscal(c *gin.Context) {
 		return
 	}
 
+	formato, err := formatoStreamUpload(c)
+	if err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
+		return
+	}
+
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "A variável de ambiente OPENAI_API_KEY não está configurada."})
+		responderErro(c, http.StatusServiceUnavailable, CodigoExtracaoIndisponivel, "A variável de ambiente OPENAI_API_KEY não está configurada.")
 		return
 	}
 
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB max
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Erro ao processar formulário."})
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao processar formulário.")
 		return
 	}
 
 	files := c.Request.MultipartForm.File["files"]
 	if len(files) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Nenhum arquivo enviado."})
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Nenhum arquivo enviado.")
 		return
 	}
 
 	flusher, ok := c.Writer.(http.Flusher)
 	if !ok {
 		log.Println("Streaming unsupported!")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Streaming not supported"})
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Streaming not supported")
 		return
 	}
 
-	for _, fileHeader := range files {
-		file, err := fileHeader.Open()
-		if err != nil {
-			log.Printf("Error opening file %s: %v", fileHeader.Filename, err)
-			continue
+	extrator := NovoExtratorOpenAI(apiKey)
+	stream := novoStreamUpload(c, flusher, formato)
+	documentos, limpar := documentosEnviados(files)
+	defer limpar()
+	total := len(documentos)
+	resumo := ResumoUpload{Failures: []FalhaUpload{}}
+
+	// Workers stop picking up files once the stream is abandoned
+	ctx, cancelar := context.WithCancel(c.Request.Context())
+	defer cancelar()
+
+	// Files are extracted by a bounded pool of workers; results are streamed by this
+	// goroutine, the only writer, in the order they complete
+	indices := make(chan int)
+	resultados := make(chan resultadoArquivo)
+	var workers sync.WaitGroup
+	for range min(concorrenciaUpload(), total) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indices {
+				select {
+				case resultados <- resultadoArquivo{index: index, iniciado: true}:
+				case <-ctx.Done():
+					return
+				}
+				resultado := processarArquivoUpload(ctx, extrator, documentos[index])
+				resultado.index = index
+				select {
+				case resultados <- resultado:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(indices)
+		for index := range documentos {
+			select {
+			case indices <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(resultados)
+	}()
+
+	// enviar cancels the remaining work when the client has gone away
+	enviar := func(evento EventoUpload) bool {
+		evento.Total = total
+		if err := stream.enviar(evento); err != nil {
+			log.Printf("Error writing upload stream: %v", err)
+			cancelar()
+			return false
 		}
+		return true
+	}
 
-		content, err := io.ReadAll(file)
-		file.Close() // Close file immediately after reading
-		if err != nil {
-			log.Printf("Error reading file %s: %v", fileHeader.Filename, err)
+	processados := 0
+	for resultado := range resultados {
+		if ctx.Err() != nil {
+			// Drain until the workers notice the cancellation
 			continue
 		}
-
-		nfseDataList, err := callOpenAI(content, apiKey)
-		if err != nil {
-			log.Printf("Error processing %s with OpenAI: %v", fileHeader.Filename, err)
+		index := resultado.index
+		nome := documentos[index].nome
+		if resultado.iniciado {
+			enviar(EventoUpload{Type: EventoArquivoIniciado, File: nome, Index: index})
 			continue
 		}
 
-		for _, nfseData := range nfseDataList {
-			jsonData, err := json.Marshal(nfseData)
-			if err != nil {
-				log.Printf("Error marshalling NFSe data: %v", err)
+		if resultado.codigo != "" {
+			resumo.Failed++
+			resumo.Failures = append(resumo.Failures, FalhaUpload{File: nome, Index: index, Code: resultado.codigo})
+			if !enviar(EventoUpload{Type: EventoArquivoFalhou, File: nome, Index: index, Code: resultado.codigo, Error: mensagensErroUpload[resultado.codigo]}) {
 				continue
 			}
-			// Use a separator to distinguish between JSON objects
-			fmt.Fprintf(c.Writer, "%s\n---\n", jsonData)
-			flusher.Flush()
+		} else {
+			resumo.Succeeded++
+			for _, nfseData := range resultado.registros {
+				record := nfseData
+				resumo.Records++
+				if !enviar(EventoUpload{Type: EventoRegistro, File: nome, Index: index, Record: &record}) {
+					break
+				}
+			}
+		}
+		processados++
+		enviar(EventoUpload{Type: EventoProgresso, File: nome, Index: index, Processed: processados})
+	}
+	if ctx.Err() != nil {
+		log.Printf("Upload canceled: %d of %d files processed", processados, total)
+		return
+	}
+
+	log.Printf("Upload processed: %d files succeeded, %d failed, %d records", resumo.Succeeded, resumo.Failed, resumo.Records)
+	enviar(EventoUpload{Type: EventoConcluido, Index: total, Processed: total, Summary: &resumo})
+}
+
+// resultadoArquivo is sent by an upload worker when it starts a file and when it finishes it
+type resultadoArquivo struct {
+	index     int
+	iniciado  bool
+	registros []NFSeData
+	codigo    string // error code when the file failed
+}
+
+// processarArquivoUpload reads and extracts one uploaded document (see extrairArquivoUpload)
+func processarArquivoUpload(ctx context.Context, extrator *ExtratorOpenAI, documento documentoUpload) resultadoArquivo {
+	content, err := documento.ler()
+	if err != nil {
+		log.Printf("Error reading file %s: %v", documento.nome, err)
+		return resultadoArquivo{codigo: codigoErroLeitura(err)}
+	}
+	return extrairArquivoUpload(ctx, extrator, documento.nome, content)
+}
+
+// lerArquivoEnviado reads the whole content of a file from a multipart form
+func lerArquivoEnviado(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// extrairArquivoUpload extracts the invoices of one uploaded document, flagging records that
+// were already saved so the user knows before sending them again
+func extrairArquivoUpload(ctx context.Context, extrator *ExtratorOpenAI, nome string, content []byte) resultadoArquivo {
+	// PDFs are checked by content, not only by extension
+	if !strings.EqualFold(filepath.Ext(nome), ".xml") && !pdfValido(content) {
+		log.Printf("Skipping %s: not a PDF or NFS-e XML", nome)
+		return resultadoArquivo{codigo: CodigoArquivoInvalido}
+	}
+
+	nfseDataList, _, err := extrator.Extrair(ctx, content, nome)
+	if ctx.Err() != nil {
+		// Canceled (client gone or shutdown); the caller discards the result
+		return resultadoArquivo{codigo: CodigoFalhaExtracao}
+	}
+	if err != nil {
+		log.Printf("Error processing %s with OpenAI: %v", nome, err)
+		return resultadoArquivo{codigo: codigoErroExtracao(err)}
+	}
+	if len(nfseDataList) == 0 || slices.IndexFunc(nfseDataList, registroPreenchido) < 0 {
+		log.Printf("No invoice data extracted from %s", nome)
+		return resultadoArquivo{codigo: CodigoResultadoVazio}
+	}
+
+	hash := hashArquivo(content)
+	for i, nfseData := range nfseDataList {
+		original, err := notasRepo.BuscarDuplicada(ctx, nfseData.CNPJ, nfseData.NumeroNotaFiscal, nfseData.SerieNotaFiscal, hash)
+		if err != nil {
+			log.Printf("Error checking duplicates for %s: %v", nome, err)
+		} else if original != nil {
+			nfseDataList[i].DuplicadaDe = original.ID
 		}
 	}
+	return resultadoArquivo{registros: nfseDataList}
 }
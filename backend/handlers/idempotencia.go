@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Chaves de idempotência: uma requisição de escrita repetida com o mesmo cabeçalho
+// Idempotency-Key e o mesmo corpo recebe a resposta guardada da primeira, sem executar de
+// novo (e sem nova chamada à OpenAI). Evita notas duplicadas quando o cliente reenvia após
+// uma falha de rede.
+
+// cabecalhoIdempotencia é o cabeçalho com a chave informada pelo cliente
+const cabecalhoIdempotencia = "Idempotency-Key"
+
+// cabecalhosGuardados são os cabeçalhos da resposta reproduzidos junto com o corpo
+var cabecalhosGuardados = []string{"Content-Type", "Content-Disposition", "Location"}
+
+// respostaIdempotente é a resposta guardada para uma chave
+type respostaIdempotente struct {
+	HashCorpo  string            `json:"hashCorpo"`
+	Status     int               `json:"status"`
+	Cabecalhos map[string]string `json:"cabecalhos"`
+	Corpo      []byte            `json:"corpo"`
+	ExpiraEm   time.Time         `json:"expiraEm"`
+}
+
+// respostasIdempotentes guarda as respostas no repositório configurado, para que o reenvio
+// tenha a mesma resposta em qualquer instância; cifradas em repouso, pois contêm dados das notas
+var respostasIdempotentes = novaColecaoCompartilhada[respostaIdempotente]("idempotencia")
+
+// reservaIdempotencia marca uma chave com requisição em execução. A reserva vence em
+// ExpiraEm, para que a chave não fique presa se a instância parar no meio da requisição.
+type reservaIdempotencia struct {
+	Dono     string    `json:"dono"`
+	ExpiraEm time.Time `json:"expiraEm"`
+}
+
+// reservasIdempotencia são as chaves em execução, compartilhadas entre as instâncias
+var reservasIdempotencia = novaColecaoCompartilhada[reservaIdempotencia]("idempotencia-andamento")
+
+// duracaoReservaIdempotencia é o tempo máximo que uma requisição mantém a chave reservada
+const duracaoReservaIdempotencia = 10 * time.Minute
+
+// rotasSemIdempotencia recusam Idempotency-Key com 400: /upload transmite a resposta à medida
+// que extrai, e guardá-la exigiria manter o streaming inteiro em memória. Ignorar o cabeçalho
+// faria o cliente acreditar que o reenvio é seguro; para envios idempotentes, use POST /jobs.
+var rotasSemIdempotencia = map[string]bool{"/upload": true}
+
+// janelaIdempotenciaEnv lê por quanto tempo as respostas são guardadas de
+// IDEMPOTENCIA_JANELA (padrão 24h)
+func janelaIdempotenciaEnv() time.Duration {
+	if janela, err := time.ParseDuration(os.Getenv("IDEMPOTENCIA_JANELA")); err == nil && janela > 0 {
+		return janela
+	}
+	return 24 * time.Hour
+}
+
+// maxCorpoIdempotenciaEnv lê o tamanho máximo do corpo das requisições com Idempotency-Key de
+// IDEMPOTENCIA_MAX_MB (padrão 200)
+func maxCorpoIdempotenciaEnv() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("IDEMPOTENCIA_MAX_MB"), 10, 64); err == nil && n > 0 {
+		return n << 20
+	}
+	return 200 << 20
+}
+
+// idChaveIdempotencia identifica a chave no armazenamento. A chave vale para o mesmo método,
+// caminho e usuário (X-Usuario), para que clientes diferentes não vejam respostas uns dos outros.
+func idChaveIdempotencia(c *gin.Context, chave string) string {
+	soma := sha256.Sum256([]byte(strings.Join([]string{
+		c.Request.Method, c.Request.URL.Path, c.GetHeader("X-Usuario"), chave,
+	}, "\x00")))
+	return hex.EncodeToString(soma[:])
+}
+
+// hashCorpoRequisicao resume o corpo da requisição. Em formulários multipart, o resumo cobre
+// os campos e arquivos, e não o separador, que o navegador sorteia a cada envio.
+func hashCorpoRequisicao(tipo string, corpo io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	tipoMidia, parametros, err := mime.ParseMediaType(tipo)
+	if err == nil && tipoMidia == "multipart/form-data" && parametros["boundary"] != "" {
+		leitor := multipart.NewReader(corpo, parametros["boundary"])
+		for {
+			parte, err := leitor.NextPart()
+			if errors.Is(err, io.EOF) {
+				return hex.EncodeToString(h.Sum(nil)), nil
+			}
+			if err != nil {
+				break
+			}
+			conteudo := sha256.New()
+			io.Copy(conteudo, parte)
+			io.WriteString(h, parte.FormName()+"\x00"+parte.FileName()+"\x00")
+			h.Write(conteudo.Sum(nil))
+		}
+		// Multipart inválido: o handler rejeita; o resumo usa o corpo bruto
+		h.Reset()
+		if _, err := corpo.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	if _, err := io.Copy(h, corpo); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// guardarCorpo copia o corpo da requisição para um arquivo temporário, que substitui o corpo
+// para o handler, e retorna seu resumo. O arquivo deve ser fechado e apagado pelo chamador.
+func guardarCorpo(c *gin.Context, limite int64) (*os.File, string, error) {
+	arquivo, err := os.CreateTemp("", "idempotencia-*")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(arquivo, http.MaxBytesReader(c.Writer, c.Request.Body, limite)); err != nil {
+		return arquivo, "", err
+	}
+	if _, err := arquivo.Seek(0, io.SeekStart); err != nil {
+		return arquivo, "", err
+	}
+	hash, err := hashCorpoRequisicao(c.GetHeader("Content-Type"), arquivo)
+	if err != nil {
+		return arquivo, "", err
+	}
+	if _, err := arquivo.Seek(0, io.SeekStart); err != nil {
+		return arquivo, "", err
+	}
+	c.Request.Body = io.NopCloser(arquivo)
+	return arquivo, hash, nil
+}
+
+// reservarChave reserva a chave para esta requisição; false se outra requisição, nesta ou em
+// outra instância, estiver com ela
+func reservarChave(id, dono string) (bool, error) {
+	agora := time.Now()
+	reserva := reservaIdempotencia{Dono: dono, ExpiraEm: agora.Add(duracaoReservaIdempotencia)}
+	for range tentativasAtualizacao {
+		criada, err := reservasIdempotencia.criar(id, reserva)
+		if err != nil || criada {
+			return criada, err
+		}
+		// Reserva vencida: a requisição que a fez não terminou
+		_, resultado, err := reservasIdempotencia.atualizar(id, func(atual *reservaIdempotencia) int {
+			if agora.Before(atual.ExpiraEm) {
+				return documentoMantido
+			}
+			*atual = reserva
+			return documentoAlterado
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return resultado == documentoAlterado, err
+	}
+	return false, nil
+}
+
+// liberarChave remove a reserva, se ainda for desta requisição
+func liberarChave(id, dono string) {
+	_, _, err := reservasIdempotencia.atualizar(id, func(atual *reservaIdempotencia) int {
+		if atual.Dono != dono {
+			return documentoMantido
+		}
+		return documentoRemovido
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Erro ao liberar Idempotency-Key: %v", err)
+	}
+}
+
+// gravadorResposta copia a resposta enviada ao cliente para guardá-la. Uma resposta
+// transmitida aos poucos (Flush) deixa de ser copiada e não é guardada.
+type gravadorResposta struct {
+	gin.ResponseWriter
+	corpo       bytes.Buffer
+	transmitida bool
+}
+
+func (g *gravadorResposta) Write(dados []byte) (int, error) {
+	if !g.transmitida {
+		g.corpo.Write(dados)
+	}
+	return g.ResponseWriter.Write(dados)
+}
+
+func (g *gravadorResposta) WriteString(dados string) (int, error) {
+	if !g.transmitida {
+		g.corpo.WriteString(dados)
+	}
+	return g.ResponseWriter.WriteString(dados)
+}
+
+func (g *gravadorResposta) Flush() {
+	g.transmitida = true
+	g.corpo = bytes.Buffer{}
+	g.ResponseWriter.Flush()
+}
+
+// Idempotencia é o middleware das chaves de idempotência, aplicado a POST, PUT, PATCH e
+// DELETE que tragam o cabeçalho Idempotency-Key. A mesma chave com outro corpo é rejeitada
+// com 422; enquanto a primeira requisição executa, as repetições recebem 409. Respostas 5xx,
+// respostas interrompidas (cliente desconectado) e transmitidas aos poucos não são guardadas,
+// para que o reenvio execute. O corpo é lido até IDEMPOTENCIA_MAX_MB; acima disso, 413.
+func Idempotencia(ctx context.Context) gin.HandlerFunc {
+	janela := janelaIdempotenciaEnv()
+	limite := maxCorpoIdempotenciaEnv()
+	// Respostas guardadas em uploads/idempotencia/ por versões anteriores
+	if _, err := respostasIdempotentes.importarLocais(); err != nil {
+		log.Printf("Erro ao importar respostas idempotentes locais: %v", err)
+	}
+	go expurgarRespostasIdempotentes(ctx, janela)
+
+	return func(c *gin.Context) {
+		if rotasSemIdempotencia[strings.TrimPrefix(c.FullPath(), PrefixoAPI)] {
+			if c.GetHeader(cabecalhoIdempotencia) != "" {
+				responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Idempotency-Key não é aceita nesta rota; use POST /jobs para envios idempotentes")
+				return
+			}
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+		chave := strings.TrimSpace(c.GetHeader(cabecalhoIdempotencia))
+		if chave == "" {
+			c.Next()
+			return
+		}
+		if len(chave) > 255 {
+			responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Idempotency-Key deve ter no máximo 255 caracteres")
+			return
+		}
+
+		corpo, hash, err := guardarCorpo(c, limite)
+		if corpo != nil {
+			defer os.Remove(corpo.Name())
+			defer corpo.Close()
+		}
+		var grande *http.MaxBytesError
+		if errors.As(err, &grande) {
+			responderErroDetalhes(c, http.StatusRequestEntityTooLarge, CodigoRequisicaoInvalida,
+				"Requisição maior que o limite para Idempotency-Key", gin.H{"limite_bytes": limite})
+			return
+		}
+		if err != nil {
+			responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao ler a requisição")
+			return
+		}
+		id := idChaveIdempotencia(c, chave)
+
+		dono := novoIDNota()
+		reservada, err := reservarChave(id, dono)
+		if err != nil {
+			log.Printf("Erro ao reservar Idempotency-Key: %v", err)
+			responderErro(c, http.StatusServiceUnavailable, CodigoServicoIndisponivel, "Não foi possível verificar a Idempotency-Key")
+			return
+		}
+		if !reservada {
+			responderErro(c, http.StatusConflict, CodigoIdempotenciaEmAndamento, "Uma requisição com esta Idempotency-Key ainda está em andamento")
+			return
+		}
+		defer liberarChave(id, dono)
+
+		guardada, err := respostasIdempotentes.carregar(id)
+		if err == nil && time.Now().Before(guardada.ExpiraEm) {
+			if guardada.HashCorpo != hash {
+				responderErro(c, http.StatusUnprocessableEntity, CodigoIdempotenciaReutilizada, "Idempotency-Key já usada com outro conteúdo")
+				return
+			}
+			for nome, valor := range guardada.Cabecalhos {
+				c.Header(nome, valor)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(guardada.Status, guardada.Cabecalhos["Content-Type"], guardada.Corpo)
+			c.Abort()
+			return
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Erro ao ler resposta idempotente: %v", err)
+		}
+
+		gravador := &gravadorResposta{ResponseWriter: c.Writer}
+		c.Writer = gravador
+		c.Next()
+
+		status := gravador.Status()
+		if status >= http.StatusInternalServerError || c.Request.Context().Err() != nil || gravador.transmitida {
+			return
+		}
+		resposta := respostaIdempotente{
+			HashCorpo:  hash,
+			Status:     status,
+			Cabecalhos: map[string]string{},
+			Corpo:      gravador.corpo.Bytes(),
+			ExpiraEm:   time.Now().Add(janela),
+		}
+		for _, nome := range cabecalhosGuardados {
+			if valor := gravador.Header().Get(nome); valor != "" {
+				resposta.Cabecalhos[nome] = valor
+			}
+		}
+		if err := respostasIdempotentes.salvar(id, resposta); err != nil {
+			log.Printf("Erro ao guardar resposta idempotente: %v", err)
+		}
+	}
+}
+
+// expurgarRespostasIdempotentes apaga periodicamente as respostas e as reservas vencidas
+func expurgarRespostasIdempotentes(ctx context.Context, janela time.Duration) {
+	ticker := time.NewTicker(min(janela, time.Hour))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		agora := time.Now()
+		if _, err := respostasIdempotentes.atualizarTodos(func(_ string, resposta *respostaIdempotente) int {
+			if agora.After(resposta.ExpiraEm) {
+				return documentoRemovido
+			}
+			return documentoMantido
+		}); err != nil {
+			log.Printf("Erro ao apagar respostas idempotentes vencidas: %v", err)
+		}
+		if _, err := reservasIdempotencia.atualizarTodos(func(_ string, reserva *reservaIdempotencia) int {
+			if agora.After(reserva.ExpiraEm) {
+				return documentoRemovido
+			}
+			return documentoMantido
+		}); err != nil {
+			log.Printf("Erro ao apagar reservas de Idempotency-Key vencidas: %v", err)
+		}
+	}
+}
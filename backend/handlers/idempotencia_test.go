@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roteadorIdempotenciaTeste monta o middleware de idempotência em um diretório temporário,
+// com uma rota de escrita que conta as execuções e, se liberar não for nil, aguarda nele
+func roteadorIdempotenciaTeste(t *testing.T, execucoes *atomic.Int32, iniciada chan<- struct{}, liberar <-chan struct{}) *gin.Engine {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	gin.SetMode(gin.TestMode)
+	ctx, cancelar := context.WithCancel(context.Background())
+	t.Cleanup(cancelar)
+
+	router := gin.New()
+	grupo := router.Group("", Idempotencia(ctx))
+	grupo.POST("/notas", func(c *gin.Context) {
+		n := execucoes.Add(1)
+		if iniciada != nil {
+			iniciada <- struct{}{}
+		}
+		if liberar != nil {
+			<-liberar
+		}
+		c.Header("Location", "/notas/1")
+		c.JSON(http.StatusCreated, gin.H{"execucao": n})
+	})
+	grupo.POST("/upload", func(c *gin.Context) {
+		execucoes.Add(1)
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+// requisicaoIdempotente envia um POST JSON com a chave informada (vazia: sem o cabeçalho)
+func requisicaoIdempotente(router http.Handler, caminho, chave, corpo string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, caminho, strings.NewReader(corpo))
+	req.Header.Set("Content-Type", "application/json")
+	if chave != "" {
+		req.Header.Set(cabecalhoIdempotencia, chave)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotenciaReproduzResposta(t *testing.T) {
+	var execucoes atomic.Int32
+	router := roteadorIdempotenciaTeste(t, &execucoes, nil, nil)
+
+	primeira := requisicaoIdempotente(router, "/notas", "chave-1", `{"numero":"1"}`)
+	if primeira.Code != http.StatusCreated {
+		t.Fatalf("primeiro envio: status %d: %s", primeira.Code, primeira.Body.String())
+	}
+	reenvio := requisicaoIdempotente(router, "/notas", "chave-1", `{"numero":"1"}`)
+	if reenvio.Code != http.StatusCreated || reenvio.Body.String() != primeira.Body.String() {
+		t.Fatalf("reenvio: status %d, corpo %q; esperado %d, %q", reenvio.Code, reenvio.Body.String(), primeira.Code, primeira.Body.String())
+	}
+	if reenvio.Header().Get("Idempotent-Replayed") != "true" || reenvio.Header().Get("Location") != "/notas/1" {
+		t.Fatalf("cabeçalhos do reenvio: %v", reenvio.Header())
+	}
+	if n := execucoes.Load(); n != 1 {
+		t.Fatalf("handler executado %d vezes, esperado 1", n)
+	}
+
+	// Outra chave e requisições sem chave executam de novo
+	requisicaoIdempotente(router, "/notas", "chave-2", `{"numero":"1"}`)
+	requisicaoIdempotente(router, "/notas", "", `{"numero":"1"}`)
+	if n := execucoes.Load(); n != 3 {
+		t.Fatalf("handler executado %d vezes, esperado 3", n)
+	}
+}
+
+func TestIdempotenciaMultipartIgnoraSeparador(t *testing.T) {
+	var execucoes atomic.Int32
+	router := roteadorIdempotenciaTeste(t, &execucoes, nil, nil)
+
+	enviar := func(separador string) int {
+		var corpo bytes.Buffer
+		escritor := multipart.NewWriter(&corpo)
+		escritor.SetBoundary(separador)
+		escritor.WriteField("numero", "1")
+		arquivo, _ := escritor.CreateFormFile("file", "nota.pdf")
+		arquivo.Write([]byte("%PDF-1.4 conteúdo"))
+		escritor.Close()
+		req := httptest.NewRequest(http.MethodPost, "/notas", &corpo)
+		req.Header.Set("Content-Type", escritor.FormDataContentType())
+		req.Header.Set(cabecalhoIdempotencia, "chave-multipart")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if status := enviar("separador-a"); status != http.StatusCreated {
+		t.Fatalf("primeiro envio: status %d", status)
+	}
+	if status := enviar("separador-b"); status != http.StatusCreated {
+		t.Fatalf("reenvio com outro separador: status %d", status)
+	}
+	if n := execucoes.Load(); n != 1 {
+		t.Fatalf("handler executado %d vezes, esperado 1", n)
+	}
+}
+
+func TestIdempotenciaRecusaCorpoDiferente(t *testing.T) {
+	var execucoes atomic.Int32
+	router := roteadorIdempotenciaTeste(t, &execucoes, nil, nil)
+
+	requisicaoIdempotente(router, "/notas", "chave-1", `{"numero":"1"}`)
+	w := requisicaoIdempotente(router, "/notas", "chave-1", `{"numero":"2"}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), CodigoIdempotenciaReutilizada) {
+		t.Fatalf("status %d, esperado 422 com %s: %s", w.Code, CodigoIdempotenciaReutilizada, w.Body.String())
+	}
+	if n := execucoes.Load(); n != 1 {
+		t.Fatalf("handler executado %d vezes, esperado 1", n)
+	}
+}
+
+func TestIdempotenciaRequisicaoEmAndamento(t *testing.T) {
+	var execucoes atomic.Int32
+	iniciada := make(chan struct{}, 1)
+	liberar := make(chan struct{})
+	router := roteadorIdempotenciaTeste(t, &execucoes, iniciada, liberar)
+
+	primeira := make(chan *httptest.ResponseRecorder)
+	go func() { primeira <- requisicaoIdempotente(router, "/notas", "chave-1", `{"numero":"1"}`) }()
+	<-iniciada
+
+	// A primeira ainda executa: o reenvio, com o mesmo ou outro corpo, recebe 409
+	for _, corpo := range []string{`{"numero":"1"}`, `{"numero":"2"}`} {
+		w := requisicaoIdempotente(router, "/notas", "chave-1", corpo)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodigoIdempotenciaEmAndamento) {
+			t.Fatalf("reenvio durante a execução: status %d, esperado 409: %s", w.Code, w.Body.String())
+		}
+	}
+
+	close(liberar)
+	if w := <-primeira; w.Code != http.StatusCreated {
+		t.Fatalf("primeiro envio: status %d", w.Code)
+	}
+	// Concluída, a chave é liberada e o reenvio recebe a resposta guardada
+	if w := requisicaoIdempotente(router, "/notas", "chave-1", `{"numero":"1"}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("reenvio após a conclusão: status %d, cabeçalhos %v", w.Code, w.Header())
+	}
+	if n := execucoes.Load(); n != 1 {
+		t.Fatalf("handler executado %d vezes, esperado 1", n)
+	}
+}
+
+func TestIdempotenciaRecusadaNoUpload(t *testing.T) {
+	var execucoes atomic.Int32
+	router := roteadorIdempotenciaTeste(t, &execucoes, nil, nil)
+
+	w := requisicaoIdempotente(router, "/upload", "chave-1", `{}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), CodigoRequisicaoInvalida) {
+		t.Fatalf("status %d, esperado 400: %s", w.Code, w.Body.String())
+	}
+	if w := requisicaoIdempotente(router, "/upload", "", `{}`); w.Code != http.StatusOK {
+		t.Fatalf("sem a chave: status %d, esperado 200", w.Code)
+	}
+	if n := execucoes.Load(); n != 1 {
+		t.Fatalf("handler executado %d vezes, esperado 1", n)
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OpcoesImportacao configura a importação das notas salvas no formato antigo
+// (pares <id>.json + <id>.pdf em um diretório) para o repositório atual
+type OpcoesImportacao struct {
+	Diretorio   string
+	Repositorio NotaFiscalRepository
+	// Arquivos recebe os PDFs encontrados, referenciados nas notas pela chave do BlobStore
+	Arquivos BlobStore
+	// Simular apenas relata o que seria importado, sem gravar nada
+	Simular bool
+}
+
+// FalhaImportacao descreve um arquivo que não pôde ser importado ou foi importado com ressalvas
+type FalhaImportacao struct {
+	Arquivo string `json:"arquivo"`
+	Motivo  string `json:"motivo"`
+}
+
+// RelatorioImportacao resume o resultado da importação
+type RelatorioImportacao struct {
+	Encontradas    int               `json:"encontradas"`
+	Importadas     int               `json:"importadas"`
+	JaExistentes   int               `json:"jaExistentes"` // mesmo ID já presente no repositório
+	Duplicadas     []FalhaImportacao `json:"duplicadas"`   // mesma nota já importada ou salva com outro ID
+	Falhas         []FalhaImportacao `json:"falhas"`
+	Avisos         []FalhaImportacao `json:"avisos"`         // importadas sem o PDF correspondente ou com datas não reconhecidas
+	ArquivosSemPar []string          `json:"arquivosSemPar"` // PDFs sem nota correspondente
+}
+
+// ImportarLegado lê as notas <id>.json do diretório, associa cada uma ao PDF de mesmo nome,
+// descarta duplicadas e grava as demais no repositório, com o PDF no BlobStore. Notas são processadas em ordem de
+// criação, de modo que, entre duplicadas, a mais antiga é a importada.
+func ImportarLegado(ctx context.Context, opcoes OpcoesImportacao) (RelatorioImportacao, error) {
+	var relatorio RelatorioImportacao
+
+	entradas, err := os.ReadDir(opcoes.Diretorio)
+	if err != nil {
+		return relatorio, err
+	}
+
+	legado := &repositorioArquivos{dir: opcoes.Diretorio}
+	pdfs := make(map[string]bool)
+	var notas []NotaFiscalData
+
+	for _, entrada := range entradas {
+		if entrada.IsDir() {
+			continue
+		}
+		nome := entrada.Name()
+		switch strings.ToLower(filepath.Ext(nome)) {
+		case ".pdf":
+			pdfs[nome] = true
+		case ".json":
+			relatorio.Encontradas++
+			nota, avisos, err := legado.lerComAvisos(filepath.Join(opcoes.Diretorio, nome))
+			if err != nil {
+				relatorio.Falhas = append(relatorio.Falhas, FalhaImportacao{nome, fmt.Sprintf("JSON inválido: %v", err)})
+				continue
+			}
+			for _, aviso := range avisos {
+				relatorio.Avisos = append(relatorio.Avisos, FalhaImportacao{nome, aviso})
+			}
+			notas = append(notas, nota)
+		}
+	}
+
+	sort.SliceStable(notas, func(i, j int) bool {
+		if !notas[i].CriadoEm.Equal(notas[j].CriadoEm) {
+			return notas[i].CriadoEm.Before(notas[j].CriadoEm)
+		}
+		return notas[i].ID < notas[j].ID
+	})
+
+	// Notas aceitas nesta execução; no modo simulado não estão no repositório
+	var importadas []NotaFiscalData
+	usados := make(map[string]bool)
+
+	for _, nota := range notas {
+		arquivoJSON := nota.ID + ".json"
+
+		if nota.NumeroNota == "" && nota.CNPJ == "" && nota.Email == "" {
+			relatorio.Falhas = append(relatorio.Falhas, FalhaImportacao{arquivoJSON, "não contém dados de nota fiscal"})
+			continue
+		}
+
+		if _, err := opcoes.Repositorio.BuscarPorID(ctx, nota.ID); err == nil {
+			relatorio.JaExistentes++
+			usados[nota.Arquivo] = true
+			usados[nota.ID+".pdf"] = true
+			continue
+		} else if !errors.Is(err, ErrNotaNaoEncontrada) {
+			return relatorio, err
+		}
+
+		// PDF pelo nome registrado na nota ou, em registros antigos, pelo mesmo nome do JSON
+		pdf := nota.Arquivo
+		if !pdfs[pdf] {
+			pdf = nota.ID + ".pdf"
+		}
+		var conteudo []byte
+		semPDF := !pdfs[pdf]
+		if !semPDF {
+			usados[pdf] = true
+			conteudo, err = os.ReadFile(filepath.Join(opcoes.Diretorio, pdf))
+			if err != nil {
+				relatorio.Falhas = append(relatorio.Falhas, FalhaImportacao{arquivoJSON, fmt.Sprintf("erro ao ler %s: %v", pdf, err)})
+				continue
+			}
+			// O hash registrado pode ser de outra versão do arquivo; a chave usa o conteúdo atual
+			hash := hashArquivo(conteudo)
+			if nota.HashArquivo == "" {
+				nota.HashArquivo = hash
+			}
+			nota.Arquivo = chaveBlob(hash, pdf)
+			if nota.NomeArquivo == "" {
+				nota.NomeArquivo = pdf
+			}
+		} else {
+			nota.Arquivo = ""
+		}
+
+		if original := encontrarDuplicada(importadas, nota.CNPJ, nota.NumeroNota, nota.Serie, nota.HashArquivo); original != nil {
+			relatorio.Duplicadas = append(relatorio.Duplicadas, FalhaImportacao{arquivoJSON, "duplicada de " + original.ID})
+			continue
+		}
+		original, err := opcoes.Repositorio.BuscarDuplicada(ctx, nota.CNPJ, nota.NumeroNota, nota.Serie, nota.HashArquivo)
+		if err != nil {
+			return relatorio, err
+		}
+		if original != nil {
+			relatorio.Duplicadas = append(relatorio.Duplicadas, FalhaImportacao{arquivoJSON, "duplicada de " + original.ID})
+			continue
+		}
+
+		if !opcoes.Simular {
+			if conteudo != nil {
+				err := opcoes.Arquivos.Gravar(ctx, nota.Arquivo, bytes.NewReader(conteudo), int64(len(conteudo)), tipoBlob(nota.Arquivo))
+				if err != nil {
+					relatorio.Falhas = append(relatorio.Falhas, FalhaImportacao{arquivoJSON, fmt.Sprintf("erro ao gravar PDF: %v", err)})
+					continue
+				}
+			}
+			evento := novoEvento(OperacaoImportacao, "importar-legado", nil, nota)
+			evento.Detalhes = "importada de " + filepath.Join(opcoes.Diretorio, arquivoJSON)
+			if err := opcoes.Repositorio.Salvar(ctx, nota, evento); errors.Is(err, ErrNotaDuplicada) {
+				relatorio.Duplicadas = append(relatorio.Duplicadas, FalhaImportacao{arquivoJSON, "duplicada de nota já salva"})
+				continue
+			} else if err != nil {
+				relatorio.Falhas = append(relatorio.Falhas, FalhaImportacao{arquivoJSON, fmt.Sprintf("erro ao salvar: %v", err)})
+				continue
+			}
+		}
+
+		if semPDF {
+			relatorio.Avisos = append(relatorio.Avisos, FalhaImportacao{arquivoJSON, "PDF correspondente não encontrado"})
+		}
+		importadas = append(importadas, nota)
+		relatorio.Importadas++
+	}
+
+	for pdf := range pdfs {
+		if !usados[pdf] {
+			relatorio.ArquivosSemPar = append(relatorio.ArquivosSemPar, pdf)
+		}
+	}
+	sort.Strings(relatorio.ArquivosSemPar)
+
+	return relatorio, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limitadorTaxa espaça as chamadas a um provedor para no máximo porMinuto chamadas por
+// minuto, distribuídas uniformemente. Um limitador nil não limita.
+type limitadorTaxa struct {
+	mu        sync.Mutex
+	intervalo time.Duration
+	proxima   time.Time
+}
+
+// novoLimitadorTaxa cria um limitador; porMinuto <= 0 retorna nil (sem limite)
+func novoLimitadorTaxa(porMinuto int) *limitadorTaxa {
+	if porMinuto <= 0 {
+		return nil
+	}
+	return &limitadorTaxa{intervalo: time.Minute / time.Duration(porMinuto)}
+}
+
+// aguardar reserva a próxima vaga e espera por ela, ou até o contexto ser cancelado
+func (l *limitadorTaxa) aguardar(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	vaga := time.Now()
+	if l.proxima.After(vaga) {
+		vaga = l.proxima
+	}
+	l.proxima = vaga.Add(l.intervalo)
+	l.mu.Unlock()
+
+	espera := time.Until(vaga)
+	if espera <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(espera)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var (
+	limitadoresMu sync.Mutex
+	limitadores   = map[string]*limitadorTaxa{}
+)
+
+// limitadorProvedor retorna o limitador compartilhado do provedor (identificado pela URL da
+// API), para que todas as requisições do processo respeitem o mesmo limite.
+// O limite vem de OPENAI_RPM (chamadas por minuto; vazio ou 0 não limita).
+func limitadorProvedor(provedor string) *limitadorTaxa {
+	limitadoresMu.Lock()
+	defer limitadoresMu.Unlock()
+
+	if limitador, ok := limitadores[provedor]; ok {
+		return limitador
+	}
+	porMinuto, _ := strconv.Atoi(os.Getenv("OPENAI_RPM"))
+	limitador := novoLimitadorTaxa(porMinuto)
+	limitadores[provedor] = limitador
+	return limitador
+}
+
+// concorrenciaUpload é o número de arquivos extraídos em paralelo por requisição de
+// /upload (UPLOAD_CONCORRENCIA, padrão 4)
+func concorrenciaUpload() int {
+	if n, err := strconv.Atoi(os.Getenv("UPLOAD_CONCORRENCIA")); err == nil && n > 0 {
+		return n
+	}
+	return 4
+}
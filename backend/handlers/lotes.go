@@ -0,0 +1,578 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lotes de extração assíncronos (/jobs): os arquivos enviados são guardados no repositório e
+// processados em segundo plano, e o resultado fica salvo para consulta posterior. Com o
+// repositório SQL, lotes e arquivos são vistos por todas as instâncias: cada lote é reservado
+// pela instância que o processa, que só grava o lote enquanto a reserva for sua, e lotes
+// pendentes ou abandonados (reserva vencida) são retomados por qualquer instância. Os
+// resultados expiram com a política de retenção.
+
+// Situações de um lote
+const (
+	StatusLoteNaFila      = "queued"
+	StatusLoteProcessando = "processing"
+	StatusLoteConcluido   = "completed"
+	StatusLoteFalhou      = "failed"
+)
+
+// Situações de cada arquivo de um lote
+const (
+	StatusArquivoPendente    = "pending"
+	StatusArquivoProcessando = "processing"
+	StatusArquivoConcluido   = "succeeded"
+	StatusArquivoFalhou      = "failed"
+)
+
+// ArquivoLote é um arquivo enviado em um lote e o resultado da sua extração
+type ArquivoLote struct {
+	Index   int        `json:"index"`
+	File    string     `json:"file"`
+	Status  string     `json:"status"`
+	Records []NFSeData `json:"records,omitempty"`
+	Code    string     `json:"code,omitempty"`  // failed: um dos códigos Codigo*
+	Error   string     `json:"error,omitempty"` // failed: mensagem para o usuário
+	// Chave do conteúdo em arquivosEnviadosLote, até o arquivo ser processado; não é
+	// enviada nas respostas (ver publico)
+	Chave string `json:"chave,omitempty"`
+}
+
+// finalizado indica se o arquivo já foi processado
+func (a ArquivoLote) finalizado() bool {
+	return a.Status == StatusArquivoConcluido || a.Status == StatusArquivoFalhou
+}
+
+// Lote é um envio de arquivos processado em segundo plano
+type Lote struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Error      string        `json:"error,omitempty"` // failed: motivo da falha do lote
+	Ator       string        `json:"actor"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	UpdatedAt  time.Time     `json:"updatedAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+	Total      int           `json:"total"`
+	Processed  int           `json:"processed"`
+	Files      []ArquivoLote `json:"files,omitempty"`
+	Summary    *ResumoUpload `json:"summary,omitempty"` // completed
+	// ReservadoAte é o fim da reserva da instância que processa o lote, renovada enquanto ela
+	// o processa, e ReservadoPor identifica a reserva; não são enviados nas respostas
+	ReservadoAte *time.Time `json:"reservadoAte,omitempty"`
+	ReservadoPor string     `json:"reservadoPor,omitempty"`
+}
+
+// publico retorna o lote como enviado nas respostas da API, sem os dados internos
+func (l Lote) publico() Lote {
+	l.ReservadoAte = nil
+	l.ReservadoPor = ""
+	l.Files = slices.Clone(l.Files)
+	for i := range l.Files {
+		l.Files[i].Chave = ""
+	}
+	return l
+}
+
+// lotes guarda os lotes no repositório, cifrados em repouso: os resultados contêm dados das notas
+var lotes = novaColecaoCompartilhada[Lote]("lotes")
+
+// arquivoEnviadoLote é o conteúdo de um arquivo do lote guardado até ser processado
+type arquivoEnviadoLote struct {
+	Conteudo []byte `json:"conteudo"`
+}
+
+// arquivosEnviadosLote guarda os arquivos enviados nos lotes, com ID <lote>-<índice>; cada
+// arquivo é apagado quando processado
+var arquivosEnviadosLote = novaColecaoCompartilhada[arquivoEnviadoLote]("lotes-arquivos")
+
+// chaveArquivoLote identifica o arquivo do lote em arquivosEnviadosLote
+func chaveArquivoLote(loteID string, index int) string {
+	return loteID + "-" + strconv.Itoa(index)
+}
+
+// lerArquivoLote lê o conteúdo guardado de um arquivo do lote. Lotes criados antes do
+// armazenamento no repositório guardavam os arquivos em uploads/lotes/<id>/, pela chave do BlobStore.
+func lerArquivoLote(ctx context.Context, loteID string, arquivo ArquivoLote) ([]byte, error) {
+	if !chaveBlobValida(arquivo.Chave) {
+		guardado, err := arquivosEnviadosLote.carregar(arquivo.Chave)
+		return guardado.Conteudo, err
+	}
+	var store BlobStore
+	store, err := NovoBlobLocal(filepath.Join(uploadDir, "lotes", loteID))
+	if err != nil {
+		return nil, err
+	}
+	if cofre != nil {
+		store = NovoBlobCifrado(store, cofre)
+	}
+	r, _, err := store.Abrir(ctx, arquivo.Chave)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// removerArquivosLote apaga os arquivos guardados do lote
+func removerArquivosLote(lote Lote) {
+	for _, arquivo := range lote.Files {
+		if arquivo.Chave != "" && !chaveBlobValida(arquivo.Chave) {
+			if err := arquivosEnviadosLote.remover(arquivo.Chave); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Erro ao apagar arquivo %s do lote %s: %v", arquivo.Chave, lote.ID, err)
+			}
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(uploadDir, "lotes", lote.ID)); err != nil {
+		log.Printf("Erro ao apagar arquivos do lote %s: %v", lote.ID, err)
+	}
+}
+
+// duracaoReservaLote é por quanto tempo um lote fica reservado à instância que o processa sem
+// uma nova gravação; vencida a reserva, outra instância o retoma
+const duracaoReservaLote = 10 * time.Minute
+
+// intervaloRenovacaoLote é o intervalo entre as renovações da reserva durante o processamento,
+// para que uma extração demorada não deixe a reserva vencer
+const intervaloRenovacaoLote = duracaoReservaLote / 4
+
+// reservarLote reserva o lote para esta instância, com um novo dono; false se já estiver
+// concluído ou reservado por outra instância
+func reservarLote(id string) (Lote, bool, error) {
+	agora := time.Now().UTC()
+	dono := novoIDNota()
+	lote, resultado, err := lotes.atualizar(id, func(lote *Lote) int {
+		if lote.Status == StatusLoteConcluido || lote.Status == StatusLoteFalhou {
+			return documentoMantido
+		}
+		if lote.ReservadoAte != nil && agora.Before(*lote.ReservadoAte) {
+			return documentoMantido
+		}
+		reserva := agora.Add(duracaoReservaLote)
+		lote.ReservadoAte = &reserva
+		lote.ReservadoPor = dono
+		return documentoAlterado
+	})
+	return lote, resultado == documentoAlterado, err
+}
+
+// gravarLoteReservado grava o lote se a reserva ainda for do dono informado; false se outra
+// instância assumiu o lote (a reserva venceu) ou ele foi apagado
+func gravarLoteReservado(lote Lote, dono string) (bool, error) {
+	_, resultado, err := lotes.atualizar(lote.ID, func(atual *Lote) int {
+		if atual.ReservadoPor != dono {
+			return documentoMantido
+		}
+		*atual = lote
+		return documentoAlterado
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return resultado == documentoAlterado, err
+}
+
+// filaLotes é a fila de lotes a processar. A fila em memória é reconstruída a partir dos
+// lotes salvos na inicialização, então nenhum lote se perde ao reiniciar.
+type filaLotes struct {
+	mu  sync.Mutex
+	ids []string
+	// naFila evita enfileirar de novo um lote já na fila
+	naFila map[string]bool
+	aviso  chan struct{}
+}
+
+var fila = &filaLotes{naFila: map[string]bool{}, aviso: make(chan struct{}, 1)}
+
+func (f *filaLotes) enfileirar(id string) {
+	f.mu.Lock()
+	if f.naFila[id] {
+		f.mu.Unlock()
+		return
+	}
+	f.naFila[id] = true
+	f.ids = append(f.ids, id)
+	f.mu.Unlock()
+	select {
+	case f.aviso <- struct{}{}:
+	default:
+	}
+}
+
+// proximo aguarda o próximo lote da fila; false quando o contexto é cancelado
+func (f *filaLotes) proximo(ctx context.Context) (string, bool) {
+	for {
+		f.mu.Lock()
+		if len(f.ids) > 0 {
+			id := f.ids[0]
+			f.ids = f.ids[1:]
+			delete(f.naFila, id)
+			f.mu.Unlock()
+			return id, true
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-f.aviso:
+		}
+	}
+}
+
+// intervaloVerificacaoLotes é o intervalo entre as buscas por lotes pendentes ou abandonados
+const intervaloVerificacaoLotes = time.Minute
+
+// enfileirarPendentes enfileira os lotes na fila ou em processamento sem reserva válida:
+// criados em outra instância, interrompidos por uma reinicialização ou abandonados por uma
+// instância que parou. Retorna quantos foram enfileirados.
+func enfileirarPendentes() (int, error) {
+	salvos, err := lotes.listar()
+	if err != nil {
+		return 0, err
+	}
+	slices.SortFunc(salvos, func(a, b Lote) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	agora := time.Now()
+	pendentes := 0
+	for _, lote := range salvos {
+		if lote.Status != StatusLoteNaFila && lote.Status != StatusLoteProcessando {
+			continue
+		}
+		if lote.ReservadoAte != nil && agora.Before(*lote.ReservadoAte) {
+			continue
+		}
+		fila.enfileirar(lote.ID)
+		pendentes++
+	}
+	return pendentes, nil
+}
+
+// IniciarLotes retoma os lotes não concluídos (inclusive os interrompidos no meio) e processa
+// a fila em segundo plano, um lote por vez, até o contexto ser cancelado. Lotes gravados em
+// arquivos antes do armazenamento no repositório são importados para ele.
+func IniciarLotes(ctx context.Context) error {
+	for _, importar := range []func() (int, error){lotes.importarLocais, arquivosEnviadosLote.importarLocais} {
+		if importados, err := importar(); err != nil {
+			return err
+		} else if importados > 0 {
+			log.Printf("%d documentos de lotes importados para o repositório", importados)
+		}
+	}
+	retomados, err := enfileirarPendentes()
+	if err != nil {
+		return err
+	}
+	if retomados > 0 {
+		log.Printf("Retomando %d lotes de extração pendentes", retomados)
+	}
+
+	go func() {
+		ticker := time.NewTicker(intervaloVerificacaoLotes)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := enfileirarPendentes(); err != nil {
+					log.Printf("Erro ao buscar lotes pendentes: %v", err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			id, ok := fila.proximo(ctx)
+			if !ok {
+				return
+			}
+			if err := processarLote(ctx, id); err != nil {
+				log.Printf("Erro ao processar lote %s: %v", id, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// processarLote reserva o lote e extrai os arquivos ainda não processados, com o mesmo
+// paralelismo do /upload, salvando o lote (e renovando a reserva) a cada arquivo concluído.
+// Se a reserva for perdida para outra instância, o processamento é interrompido sem gravar.
+func processarLote(ctx context.Context, id string) error {
+	lote, reservado, err := reservarLote(id)
+	if err != nil || !reservado {
+		return err
+	}
+	dono := lote.ReservadoPor
+	ctx, interromper := context.WithCancel(ctx)
+	defer interromper()
+
+	// mu protege o lote, atualizado pelos workers, e perdida
+	var mu sync.Mutex
+	perdida := false
+	salvar := func() {
+		// Concluído (reserva liberada) ou assumido por outra instância: nada mais é gravado
+		if perdida || lote.ReservadoPor == "" {
+			return
+		}
+		lote.UpdatedAt = time.Now().UTC()
+		reserva := lote.UpdatedAt.Add(duracaoReservaLote)
+		lote.ReservadoAte = &reserva
+		if lote.Status == StatusLoteConcluido || lote.Status == StatusLoteFalhou {
+			lote.ReservadoAte = nil
+			lote.ReservadoPor = ""
+		}
+		gravado, err := gravarLoteReservado(lote, dono)
+		if err != nil {
+			log.Printf("Erro ao salvar lote %s: %v", lote.ID, err)
+			return
+		}
+		if !gravado {
+			log.Printf("Reserva do lote %s perdida para outra instância; processamento interrompido", lote.ID)
+			perdida = true
+			interromper()
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(intervaloRenovacaoLote)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				salvar()
+				mu.Unlock()
+			}
+		}
+	}()
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		mu.Lock()
+		lote.Status = StatusLoteFalhou
+		lote.Error = "A variável de ambiente OPENAI_API_KEY não está configurada."
+		salvar()
+		mu.Unlock()
+		return errors.New(lote.Error)
+	}
+	extrator := NovoExtratorOpenAI(apiKey)
+
+	mu.Lock()
+	lote.Status = StatusLoteProcessando
+	var pendentes []int
+	for i, arquivo := range lote.Files {
+		if !arquivo.finalizado() {
+			lote.Files[i].Status = StatusArquivoPendente
+			pendentes = append(pendentes, i)
+		}
+	}
+	salvar()
+	mu.Unlock()
+
+	indices := make(chan int)
+	var workers sync.WaitGroup
+	for range min(concorrenciaUpload(), len(pendentes)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indices {
+				mu.Lock()
+				lote.Files[i].Status = StatusArquivoProcessando
+				arquivo := lote.Files[i]
+				salvar()
+				mu.Unlock()
+
+				resultado := extrairArquivoLote(ctx, extrator, lote.ID, arquivo)
+				if ctx.Err() != nil {
+					// Interrompido (parada ou reserva perdida): o arquivo é processado de novo
+					// quando o lote for retomado
+					return
+				}
+
+				mu.Lock()
+				arquivo = lote.Files[i]
+				if resultado.codigo != "" {
+					arquivo.Status = StatusArquivoFalhou
+					arquivo.Code = resultado.codigo
+					arquivo.Error = mensagensErroUpload[resultado.codigo]
+				} else {
+					arquivo.Status = StatusArquivoConcluido
+					arquivo.Records = resultado.registros
+				}
+				lote.Files[i] = arquivo
+				lote.Processed++
+				salvar()
+				// O conteúdo só é apagado depois de gravado o resultado: sem a reserva, o lote
+				// será retomado por outra instância, que ainda precisa dele
+				if !perdida && (chaveBlobValida(arquivo.Chave) || arquivosEnviadosLote.remover(arquivo.Chave) == nil) {
+					lote.Files[i].Chave = ""
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+enviar:
+	for _, i := range pendentes {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break enviar
+		}
+	}
+	close(indices)
+	workers.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	resumo := ResumoUpload{Failures: []FalhaUpload{}}
+	for _, arquivo := range lote.Files {
+		if arquivo.Status == StatusArquivoFalhou {
+			resumo.Failed++
+			resumo.Failures = append(resumo.Failures, FalhaUpload{File: arquivo.File, Index: arquivo.Index, Code: arquivo.Code})
+			continue
+		}
+		resumo.Succeeded++
+		resumo.Records += len(arquivo.Records)
+	}
+	agora := time.Now().UTC()
+	lote.Status = StatusLoteConcluido
+	lote.Processed = lote.Total
+	lote.FinishedAt = &agora
+	lote.Summary = &resumo
+	salvar()
+	if perdida {
+		return nil
+	}
+
+	removerArquivosLote(lote)
+	log.Printf("Lote %s concluído: %d arquivos extraídos, %d falhas, %d registros", id, resumo.Succeeded, resumo.Failed, resumo.Records)
+	return nil
+}
+
+// extrairArquivoLote lê um arquivo guardado do lote e extrai suas notas
+func extrairArquivoLote(ctx context.Context, extrator *ExtratorOpenAI, loteID string, arquivo ArquivoLote) resultadoArquivo {
+	conteudo, err := lerArquivoLote(ctx, loteID, arquivo)
+	if err != nil {
+		log.Printf("Erro ao ler arquivo %s do lote: %v", arquivo.File, err)
+		return resultadoArquivo{codigo: CodigoArquivoIlegivel}
+	}
+	return extrairArquivoUpload(ctx, extrator, arquivo.File, conteudo)
+}
+
+// CriarLote recebe os arquivos (campo files), expande os compactados, guarda os documentos e enfileira o lote para processamento
+// em segundo plano. Responde 202 com o lote; o andamento é consultado em GET /jobs/:id.
+func CriarLote(c *gin.Context) {
+	if os.Getenv("OPENAI_API_KEY") == "" {
+		responderErro(c, http.StatusServiceUnavailable, CodigoExtracaoIndisponivel, "A variável de ambiente OPENAI_API_KEY não está configurada.")
+		return
+	}
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao processar formulário.")
+		return
+	}
+	files := c.Request.MultipartForm.File["files"]
+	if len(files) == 0 {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Nenhum arquivo enviado.")
+		return
+	}
+
+	documentos, limpar := documentosEnviados(files)
+	defer limpar()
+	agora := time.Now().UTC()
+	lote := Lote{
+		ID:        novoIDNota(),
+		Status:    StatusLoteNaFila,
+		Ator:      atorRequisicao(c, ""),
+		CreatedAt: agora,
+		UpdatedAt: agora,
+		Total:     len(documentos),
+		Files:     make([]ArquivoLote, 0, len(documentos)),
+	}
+	for index, documento := range documentos {
+		arquivo := ArquivoLote{Index: index, File: sanitizarTexto(documento.nome, 255), Status: StatusArquivoPendente}
+		conteudo, err := documento.ler()
+		if err != nil {
+			log.Printf("Erro ao ler arquivo %s: %v", arquivo.File, err)
+			arquivo.Status = StatusArquivoFalhou
+			arquivo.Code = codigoErroLeitura(err)
+			arquivo.Error = mensagensErroUpload[arquivo.Code]
+			lote.Processed++
+			lote.Files = append(lote.Files, arquivo)
+			continue
+		}
+
+		arquivo.Chave = chaveArquivoLote(lote.ID, index)
+		if err := arquivosEnviadosLote.salvar(arquivo.Chave, arquivoEnviadoLote{Conteudo: conteudo}); err != nil {
+			log.Printf("Erro ao guardar arquivo %s do lote: %v", arquivo.File, err)
+			removerArquivosLote(lote)
+			responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao guardar arquivos do lote")
+			return
+		}
+		lote.Files = append(lote.Files, arquivo)
+	}
+
+	if err := lotes.salvar(lote.ID, lote); err != nil {
+		log.Printf("Erro ao salvar lote: %v", err)
+		removerArquivosLote(lote)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao criar lote")
+		return
+	}
+	fila.enfileirar(lote.ID)
+
+	log.Printf("Lote %s criado com %d arquivos", lote.ID, lote.Total)
+	// Relativo à rota usada, com ou sem o prefixo de versão
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+lote.ID)
+	c.JSON(http.StatusAccepted, lote.publico())
+}
+
+// ObterLote retorna o andamento do lote e o resultado de cada arquivo
+func ObterLote(c *gin.Context) {
+	lote, err := lotes.carregar(c.Param("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Lote não encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao carregar lote: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar lote")
+		return
+	}
+	c.JSON(http.StatusOK, lote.publico())
+}
+
+// ListarLotes lista os lotes, do mais recente ao mais antigo, sem os resultados por arquivo
+func ListarLotes(c *gin.Context) {
+	todos, err := lotes.listar()
+	if err != nil {
+		log.Printf("Erro ao listar lotes: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao listar lotes")
+		return
+	}
+	slices.SortFunc(todos, func(a, b Lote) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	for i := range todos {
+		todos[i].Files = nil
+	}
+	if todos == nil {
+		todos = []Lote{}
+	}
+	c.JSON(http.StatusOK, todos)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// notaXMLTeste é uma NFS-e ABRASF mínima, extraída localmente, sem chamada ao provedor
+const notaXMLTeste = `<?xml version="1.0" encoding="UTF-8"?>
+<CompNfse><Nfse><InfNfse>
+<Numero>123</Numero>
+<Servico><Valores><ValorServicos>100.00</ValorServicos></Valores></Servico>
+<PrestadorServico><IdentificacaoPrestador><Cnpj>11222333000181</Cnpj></IdentificacaoPrestador></PrestadorServico>
+</InfNfse></Nfse></CompNfse>`
+
+// loteTeste grava um lote na fila com os arquivos informados (nome: conteúdo)
+func loteTeste(t *testing.T, id string, arquivos map[string]string) Lote {
+	t.Helper()
+	lote := Lote{ID: id, Status: StatusLoteNaFila, CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Total: len(arquivos)}
+	for nome, conteudo := range arquivos {
+		chave := chaveArquivoLote(id, len(lote.Files))
+		if err := arquivosEnviadosLote.salvar(chave, arquivoEnviadoLote{Conteudo: []byte(conteudo)}); err != nil {
+			t.Fatal(err)
+		}
+		lote.Files = append(lote.Files, ArquivoLote{Index: len(lote.Files), File: nome, Status: StatusArquivoPendente, Chave: chave})
+	}
+	if err := lotes.salvar(id, lote); err != nil {
+		t.Fatal(err)
+	}
+	return lote
+}
+
+func TestReservaLoteTemDono(t *testing.T) {
+	t.Chdir(t.TempDir())
+	loteTeste(t, "l1", nil)
+
+	reservado, ok, err := reservarLote("l1")
+	if err != nil || !ok || reservado.ReservadoPor == "" {
+		t.Fatalf("primeira reserva: ok %v, dono %q, erro %v", ok, reservado.ReservadoPor, err)
+	}
+	if _, ok, _ := reservarLote("l1"); ok {
+		t.Fatal("lote reservado foi reservado de novo")
+	}
+	if gravado, err := gravarLoteReservado(reservado, reservado.ReservadoPor); err != nil || !gravado {
+		t.Fatalf("gravação pelo dono: gravado %v, erro %v", gravado, err)
+	}
+
+	// A reserva vence e outra instância assume o lote: o dono anterior não grava mais
+	vencida := time.Now().UTC().Add(-time.Second)
+	atual, _ := lotes.carregar("l1")
+	atual.ReservadoAte = &vencida
+	lotes.salvar("l1", atual)
+	outra, ok, err := reservarLote("l1")
+	if err != nil || !ok || outra.ReservadoPor == reservado.ReservadoPor {
+		t.Fatalf("reserva após o vencimento: ok %v, dono %q, erro %v", ok, outra.ReservadoPor, err)
+	}
+	antigo := reservado
+	antigo.Status = StatusLoteConcluido
+	if gravado, err := gravarLoteReservado(antigo, reservado.ReservadoPor); err != nil || gravado {
+		t.Fatalf("gravação pelo dono anterior: gravado %v, erro %v", gravado, err)
+	}
+	salvo, _ := lotes.carregar("l1")
+	if salvo.Status == StatusLoteConcluido || salvo.ReservadoPor != outra.ReservadoPor {
+		t.Fatalf("lote sobrescrito pelo dono anterior: status %s, dono %q", salvo.Status, salvo.ReservadoPor)
+	}
+	if salvo.publico().ReservadoPor != "" {
+		t.Fatal("dono da reserva enviado na resposta")
+	}
+}
+
+func TestProcessarLoteLiberaReserva(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "chave-de-teste")
+	loteTeste(t, "l1", map[string]string{"nota.xml": notaXMLTeste})
+
+	if err := processarLote(context.Background(), "l1"); err != nil {
+		t.Fatal(err)
+	}
+	lote, err := lotes.carregar("l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lote.Status != StatusLoteConcluido || lote.Files[0].Status != StatusArquivoConcluido || len(lote.Files[0].Records) != 1 {
+		t.Fatalf("lote %s, arquivo %s com %d registros", lote.Status, lote.Files[0].Status, len(lote.Files[0].Records))
+	}
+	if lote.ReservadoAte != nil || lote.ReservadoPor != "" {
+		t.Fatalf("reserva mantida após a conclusão: %v %q", lote.ReservadoAte, lote.ReservadoPor)
+	}
+	if _, err := arquivosEnviadosLote.carregar(chaveArquivoLote("l1", 0)); err == nil {
+		t.Fatal("conteúdo do arquivo mantido após o processamento")
+	}
+
+	// Concluído, o lote não é reservado nem processado de novo
+	if _, ok, _ := reservarLote("l1"); ok {
+		t.Fatal("lote concluído foi reservado")
+	}
+}
+
+func TestProcessarLoteReservadoPorOutraInstancia(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "chave-de-teste")
+	lote := loteTeste(t, "l1", map[string]string{"nota.xml": notaXMLTeste})
+	reserva := time.Now().UTC().Add(time.Minute)
+	lote.ReservadoAte = &reserva
+	lote.ReservadoPor = "outra-instancia"
+	lotes.salvar("l1", lote)
+
+	if err := processarLote(context.Background(), "l1"); err != nil {
+		t.Fatal(err)
+	}
+	salvo, _ := lotes.carregar("l1")
+	if salvo.Status != StatusLoteNaFila || salvo.ReservadoPor != "outra-instancia" {
+		t.Fatalf("lote de outra instância alterado: status %s, dono %q", salvo.Status, salvo.ReservadoPor)
+	}
+	if _, err := arquivosEnviadosLote.carregar(chaveArquivoLote("l1", 0)); err != nil {
+		t.Fatalf("conteúdo do arquivo apagado: %v", err)
+	}
+}
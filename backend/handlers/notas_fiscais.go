@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tamanho de página da listagem de notas
+const (
+	limitePadraoNotas = 50
+	limiteMaximoNotas = 200
+)
+
+// cursorNotas é o conteúdo (em base64) do cursor de paginação. Guarda a ordenação para que
+// o cursor não seja usado com outra ordem.
+type cursorNotas struct {
+	Ordenacao   string      `json:"o"`
+	Decrescente bool        `json:"d"`
+	Posicao     PosicaoNota `json:"p"`
+}
+
+func codificarCursor(cursor cursorNotas) string {
+	conteudo, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(conteudo)
+}
+
+func decodificarCursor(valor string) (cursorNotas, error) {
+	var cursor cursorNotas
+	conteudo, err := base64.RawURLEncoding.DecodeString(valor)
+	if err == nil {
+		err = json.Unmarshal(conteudo, &cursor)
+	}
+	return cursor, err
+}
+
+// parseValor interpreta valores monetários com ponto ou vírgula decimal
+func parseValor(valor string) (float64, error) {
+	valor = strings.TrimSpace(valor)
+	if !strings.Contains(valor, ".") {
+		valor = strings.Replace(valor, ",", ".", 1)
+	}
+	return strconv.ParseFloat(valor, 64)
+}
+
+// parseFiltroNotas monta o filtro da listagem a partir dos parâmetros da requisição:
+// cnpj, prestador, email, status, os filtros de período de parseFiltroPeriodo, valor_min,
+// valor_max, ordenar (campo, com "-" para decrescente), limite e cursor
+func parseFiltroNotas(c *gin.Context) (FiltroNotas, error) {
+	periodo, err := parseFiltroPeriodo(c)
+	if err != nil {
+		return FiltroNotas{}, err
+	}
+
+	filtro := FiltroNotas{
+		Periodo:   periodo,
+		CNPJ:      c.Query("cnpj"),
+		Prestador: c.Query("prestador"),
+		Email:     c.Query("email"),
+		Status:    c.Query("status"),
+		Limite:    limitePadraoNotas,
+	}
+
+	for _, param := range []struct {
+		nome    string
+		destino **float64
+	}{
+		{"valor_min", &filtro.ValorMinimo},
+		{"valor_max", &filtro.ValorMaximo},
+	} {
+		if valor := c.Query(param.nome); valor != "" {
+			v, err := parseValor(valor)
+			if err != nil {
+				return filtro, fmt.Errorf("%s inválido: %q", param.nome, valor)
+			}
+			*param.destino = &v
+		}
+	}
+
+	if ordenar := c.Query("ordenar"); ordenar != "" {
+		filtro.Decrescente = strings.HasPrefix(ordenar, "-")
+		filtro.Ordenacao = strings.TrimPrefix(ordenar, "-")
+		if _, ok := colunasOrdenacao[filtro.Ordenacao]; !ok {
+			return filtro, fmt.Errorf("ordenação inválida: %q (use dataNota, competencia, valorServicos, criadoEm ou prestador)", filtro.Ordenacao)
+		}
+	}
+
+	if valor := c.Query("limite"); valor != "" {
+		n, err := strconv.Atoi(valor)
+		if err != nil || n < 1 || n > limiteMaximoNotas {
+			return filtro, fmt.Errorf("limite inválido: use um número entre 1 e %d", limiteMaximoNotas)
+		}
+		filtro.Limite = n
+	}
+
+	if valor := c.Query("cursor"); valor != "" {
+		cursor, err := decodificarCursor(valor)
+		if err != nil {
+			return filtro, errors.New("cursor inválido")
+		}
+		ordenacao := filtro.Ordenacao
+		if ordenacao == "" {
+			ordenacao = OrdenarDataNota
+		}
+		if cursor.Ordenacao != ordenacao || cursor.Decrescente != filtro.Decrescente {
+			return filtro, errors.New("cursor gerado com outra ordenação")
+		}
+		filtro.Apos = &cursor.Posicao
+	}
+
+	return filtro, nil
+}
+
+// ListarNotasFiscais lista as notas salvas com filtros, ordenação e paginação por cursor.
+// Quando há mais resultados, proximo_cursor deve ser enviado em cursor para obter a próxima página.
+func ListarNotasFiscais(c *gin.Context) {
+	filtro, err := parseFiltroNotas(c)
+	if err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
+		return
+	}
+
+	// Um item a mais indica se existe próxima página
+	limite := filtro.Limite
+	filtro.Limite++
+	notas, err := notasRepo.Listar(c.Request.Context(), filtro)
+	if err != nil {
+		log.Printf("Erro ao listar notas fiscais: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+
+	var proximoCursor any
+	if len(notas) > limite {
+		notas = notas[:limite]
+		ordenacao := filtro.Ordenacao
+		if ordenacao == "" {
+			ordenacao = OrdenarDataNota
+		}
+		proximoCursor = codificarCursor(cursorNotas{
+			Ordenacao:   ordenacao,
+			Decrescente: filtro.Decrescente,
+			Posicao:     filtro.Posicao(notas[len(notas)-1]),
+		})
+	}
+	if notas == nil {
+		notas = []NotaFiscalData{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notas_fiscais":  notas,
+		"quantidade":     len(notas),
+		"proximo_cursor": proximoCursor,
+	})
+}
+
+// ObterNotaFiscal retorna uma nota salva pelo ID
+func ObterNotaFiscal(c *gin.Context) {
+	nota, ok := carregarNota(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": nota})
+}
+
+// RemoverNotaFiscal faz a exclusão lógica de uma nota: ela deixa de ser listada e de contar
+// como duplicada, mas o registro e o PDF são mantidos. O motivo pode ser informado em motivo.
+func RemoverNotaFiscal(c *gin.Context) {
+	notasMu.Lock()
+	defer notasMu.Unlock()
+
+	nota, ok := carregarNota(c)
+	if !ok {
+		return
+	}
+
+	antes := nota
+	agora := time.Now()
+	nota.RemovidoEm = &agora
+	evento := novoEvento(OperacaoRemocao, atorRequisicao(c, ""), &antes, nota)
+	evento.Detalhes = c.Query("motivo")
+	if err := notasRepo.Atualizar(c.Request.Context(), nota, evento); err != nil {
+		log.Printf("Erro ao remover nota fiscal %s: %v", nota.ID, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao remover nota fiscal")
+		return
+	}
+
+	log.Printf("Nota fiscal %s removida", nota.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Nota fiscal removida com sucesso"})
+}
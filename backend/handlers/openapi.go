@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A especificação OpenAPI da API é mantida à mão em openapi.json. TestContrato (go test, em
+// contrato_test.go) a confere com as rotas registradas e com as respostas dos handlers, e
+// `go run . gerar-cliente` gera a partir dela o cliente usado pelo frontend.
+
+//go:embed openapi.json
+var especificacaoOpenAPI []byte
+
+// EspecificacaoOpenAPI retorna o conteúdo de openapi.json
+func EspecificacaoOpenAPI() []byte {
+	return especificacaoOpenAPI
+}
+
+// ServirOpenAPI envia a especificação OpenAPI
+func ServirOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", especificacaoOpenAPI)
+}
+
+// paginaDocumentacao carrega o Swagger UI de um CDN, apontando para a especificação servida
+// ao lado (caminho relativo, para funcionar também atrás de um proxy com prefixo)
+const paginaDocumentacao = `<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+  <meta charset="utf-8">
+  <title>NF Decoder AI - API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: 'openapi.json', dom_id: '#swagger-ui' });
+  </script>
+</body>
+</html>
+`
+
+// DocumentacaoAPI exibe a documentação interativa da API
+func DocumentacaoAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(paginaDocumentacao))
+}
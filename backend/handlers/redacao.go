@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Redação de dados pessoais: na anonimização e no expurgo, os dados apagados da nota também
+// são apagados da trilha de auditoria e das cópias guardadas fora do repositório de notas
+// (resultados dos lotes de /jobs, payloads das entregas de webhook e respostas idempotentes).
+
+// redacaoNota é uma nota cujos dados pessoais serão redigidos
+type redacaoNota struct {
+	// nota é a nota antes da anonimização ou do expurgo
+	nota NotaFiscalData
+	// campos são os campos pessoais redigidos (ver camposPessoaisAuditoria) e valores, seus
+	// valores na nota
+	campos  map[string]bool
+	valores []string
+}
+
+// dadosPessoais retorna os valores dos campos pessoais da nota
+func dadosPessoais(nota NotaFiscalData) map[string]string {
+	return map[string]string{
+		"email":       nota.Email,
+		"cnpj":        nota.CNPJ,
+		"prestador":   nota.Prestador,
+		"nomeArquivo": nota.NomeArquivo,
+	}
+}
+
+// novaRedacao monta a redação dos dados pessoais que a nota tinha e deixou de ter em depois;
+// depois nil (expurgo) redige todos
+func novaRedacao(antes NotaFiscalData, depois *NotaFiscalData) redacaoNota {
+	redacao := redacaoNota{nota: antes, campos: map[string]bool{}}
+	var restantes map[string]string
+	if depois != nil {
+		restantes = dadosPessoais(*depois)
+	}
+	for campo, valor := range dadosPessoais(antes) {
+		if strings.TrimSpace(valor) != "" && (restantes == nil || restantes[campo] != valor) {
+			redacao.campos[campo] = true
+			redacao.valores = append(redacao.valores, valor)
+		}
+	}
+	return redacao
+}
+
+// formatoDocumento reconhece CNPJ/CPF com ou sem formatação
+var formatoDocumento = regexp.MustCompile(`^[0-9./\- ]+$`)
+
+// corresponde indica se o texto é um dos valores a redigir. Documentos são comparados
+// apenas pelos dígitos, com ou sem formatação.
+func (r redacaoNota) corresponde(texto string) bool {
+	texto = strings.TrimSpace(texto)
+	if texto == "" {
+		return false
+	}
+	documento := formatoDocumento.MatchString(texto)
+	for _, valor := range r.valores {
+		if strings.EqualFold(texto, strings.TrimSpace(valor)) {
+			return true
+		}
+		if digitos := normalizarCNPJ(valor); documento && len(digitos) >= 11 && digitos == normalizarCNPJ(texto) {
+			return true
+		}
+	}
+	return false
+}
+
+// redigirTexto substitui os valores a redigir contidos no texto por marcaRedigido
+func (r redacaoNota) redigirTexto(texto string) (string, bool) {
+	redigido := texto
+	for _, valor := range r.valores {
+		if valor = strings.TrimSpace(valor); valor == "" {
+			continue
+		}
+		redigido = regexp.MustCompile(`(?i)`+regexp.QuoteMeta(valor)).ReplaceAllLiteralString(redigido, marcaRedigido)
+	}
+	return redigido, redigido != texto
+}
+
+// redigirValor percorre um valor JSON decodificado e substitui as strings a redigir
+func (r redacaoNota) redigirValor(valor any) (any, bool) {
+	switch v := valor.(type) {
+	case string:
+		if r.corresponde(v) {
+			return marcaRedigido, true
+		}
+	case map[string]any:
+		alterado := false
+		for chave, item := range v {
+			if novo, ok := r.redigirValor(item); ok {
+				v[chave] = novo
+				alterado = true
+			}
+		}
+		return v, alterado
+	case []any:
+		alterado := false
+		for i, item := range v {
+			if novo, ok := r.redigirValor(item); ok {
+				v[i] = novo
+				alterado = true
+			}
+		}
+		return v, alterado
+	}
+	return valor, false
+}
+
+// redigirEvento apaga do evento de auditoria os dados pessoais: os valores (ou resumos) dos
+// campos redigidos e os valores a redigir no autor, nos detalhes e nas demais alterações
+func (r redacaoNota) redigirEvento(evento *EventoAuditoria) bool {
+	alterado := false
+	if _, ok := r.redigirTexto(evento.Ator); ok {
+		evento.Ator = marcaRedigido
+		alterado = true
+	}
+	if detalhes, ok := r.redigirTexto(evento.Detalhes); ok {
+		evento.Detalhes = detalhes
+		alterado = true
+	}
+
+	marca, _ := json.Marshal(marcaRedigido)
+	for campo, alteracao := range evento.Alteracoes {
+		for _, valor := range []*json.RawMessage{&alteracao.Antes, &alteracao.Depois} {
+			var decodificado any
+			if json.Unmarshal(*valor, &decodificado) != nil {
+				continue
+			}
+			if r.campos[campo] {
+				// O resumo (índice cego) também liga a trilha ao titular
+				if texto, ok := decodificado.(string); ok && strings.TrimSpace(texto) != "" && texto != prestadorAnonimizado && texto != marcaRedigido {
+					*valor = marca
+					alterado = true
+				}
+				continue
+			}
+			if novo, ok := r.redigirValor(decodificado); ok {
+				if conteudo, err := json.Marshal(novo); err == nil {
+					*valor = conteudo
+				} else {
+					*valor = marca
+				}
+				alterado = true
+			}
+		}
+		evento.Alteracoes[campo] = alteracao
+	}
+	return alterado
+}
+
+// correspondeRegistro indica se o registro extraído em um lote é desta nota (mesmo CNPJ e
+// número) ou do titular (CPF entre os valores a redigir)
+func (r redacaoNota) correspondeRegistro(registro NFSeData) bool {
+	cnpj := normalizarCNPJ(registro.CNPJ)
+	if cnpj == "" {
+		return false
+	}
+	if cnpj == normalizarCNPJ(r.nota.CNPJ) && normalizarNumero(registro.NumeroNotaFiscal) == normalizarNumero(r.nota.NumeroNota) {
+		return true
+	}
+	return len(cnpj) == 11 && r.corresponde(registro.CNPJ)
+}
+
+// redigirLote apaga os dados pessoais dos registros do lote que correspondem às notas
+func redigirLote(lote *Lote, redacoes []redacaoNota) bool {
+	alterado := false
+	for i := range lote.Files {
+		arquivo := &lote.Files[i]
+		for j := range arquivo.Records {
+			registro := &arquivo.Records[j]
+			for _, redacao := range redacoes {
+				if !redacao.correspondeRegistro(*registro) {
+					continue
+				}
+				for _, campo := range []*string{&registro.CNPJ, &registro.PrestadorServicos, &arquivo.File} {
+					if redacao.corresponde(*campo) {
+						*campo = marcaRedigido
+						alterado = true
+					}
+				}
+			}
+		}
+	}
+	return alterado
+}
+
+// redigirEntrega apaga os dados pessoais do payload de uma entrega de webhook sobre as notas
+func redigirEntrega(entrega *EntregaWebhook, redacoes map[string]redacaoNota) bool {
+	var payload map[string]any
+	if json.Unmarshal(entrega.Payload, &payload) != nil {
+		return false
+	}
+	dados, _ := payload["dados"].(map[string]any)
+	nota, _ := dados["nota"].(map[string]any)
+	id, _ := nota["id"].(string)
+	redacao, ok := redacoes[id]
+	if !ok {
+		return false
+	}
+	if _, alterado := redacao.redigirValor(payload); !alterado {
+		return false
+	}
+	conteudo, err := json.Marshal(payload)
+	if err != nil {
+		return false
+	}
+	entrega.Payload = conteudo
+	return true
+}
+
+// ContagemRedacao resume o que foi redigido por redigirCopias
+type ContagemRedacao struct {
+	Lotes     int `json:"lotes"`
+	Entregas  int `json:"entregasWebhook"`
+	Respostas int `json:"respostasIdempotentes"`
+}
+
+// Total retorna o número de documentos alterados ou apagados
+func (c ContagemRedacao) Total() int {
+	return c.Lotes + c.Entregas + c.Respostas
+}
+
+// redigirCopias apaga os dados pessoais das notas das cópias guardadas fora do repositório:
+// registros dos lotes, payloads das entregas de webhook e respostas idempotentes (apagadas
+// inteiras, por serem apenas cache da resposta). Lotes em processamento e entregas em
+// andamento são regravados pelo próprio processamento, que pode desfazer a redação.
+func redigirCopias(redacoes []redacaoNota) (ContagemRedacao, error) {
+	var contagem ContagemRedacao
+	if len(redacoes) == 0 {
+		return contagem, nil
+	}
+	porID := make(map[string]redacaoNota, len(redacoes))
+	for _, redacao := range redacoes {
+		porID[redacao.nota.ID] = redacao
+	}
+
+	var err error
+	contagem.Lotes, err = lotes.atualizarTodos(func(_ string, lote *Lote) int {
+		if redigirLote(lote, redacoes) {
+			return documentoAlterado
+		}
+		return documentoMantido
+	})
+	if err != nil {
+		return contagem, fmt.Errorf("lotes: %v", err)
+	}
+
+	contagem.Entregas, err = entregasWebhook.atualizarTodos(func(_ string, entrega *EntregaWebhook) int {
+		if redigirEntrega(entrega, porID) {
+			return documentoAlterado
+		}
+		return documentoMantido
+	})
+	if err != nil {
+		return contagem, fmt.Errorf("entregas de webhook: %v", err)
+	}
+
+	contagem.Respostas, err = respostasIdempotentes.atualizarTodos(func(_ string, resposta *respostaIdempotente) int {
+		for id := range porID {
+			if bytes.Contains(resposta.Corpo, []byte(id)) {
+				return documentoRemovido
+			}
+		}
+		return documentoMantido
+	})
+	if err != nil {
+		return contagem, fmt.Errorf("respostas idempotentes: %v", err)
+	}
+	return contagem, nil
+}
+
+// redigirDadosPessoais redige as cópias e a trilha de auditoria das notas, registrando em cada
+// trilha um evento OperacaoRedacao com as quantidades de cópias, sem os valores. Retorna as falhas.
+func redigirDadosPessoais(ctx context.Context, repo NotaFiscalRepository, redacoes []redacaoNota, ator string) (ContagemRedacao, []string) {
+	var falhas []string
+	contagem, err := redigirCopias(redacoes)
+	if err != nil {
+		falhas = append(falhas, fmt.Sprintf("cópias dos dados: %v", err))
+	}
+
+	detalhes := fmt.Sprintf("dados pessoais redigidos da trilha e das cópias: %d lotes, %d entregas de webhook, %d respostas idempotentes",
+		contagem.Lotes, contagem.Entregas, contagem.Respostas)
+	for _, redacao := range redacoes {
+		evento := EventoAuditoria{NotaID: redacao.nota.ID, Operacao: OperacaoRedacao, Ator: ator, Momento: time.Now().UTC(), Detalhes: detalhes}
+		if err := repo.RedigirAuditoria(ctx, redacao.nota.ID, redacao.redigirEvento, evento); err != nil {
+			falhas = append(falhas, fmt.Sprintf("auditoria da nota %s: %v", redacao.nota.ID, err))
+		}
+	}
+	return contagem, falhas
+}
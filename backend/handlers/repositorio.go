@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNotaNaoEncontrada é retornado quando a nota fiscal não existe no repositório
+var ErrNotaNaoEncontrada = errors.New("nota fiscal não encontrada")
+
+// ErrNotaDuplicada é retornado pelos repositórios SQL quando a gravação violaria a regra de
+// duplicidade (mesmo CNPJ e número com série equivalente, ver mesmaSerie, ou mesmo arquivo de
+// uma nota não removida), por exemplo quando outra instância salvou a mesma nota entre
+// BuscarDuplicada e Salvar
+var ErrNotaDuplicada = errors.New("nota fiscal já salva")
+
+// Campos aceitos na ordenação da listagem de notas (nomes JSON de NotaFiscalData)
+const (
+	OrdenarDataNota      = "dataNota"
+	OrdenarCompetencia   = "competencia"
+	OrdenarValorServicos = "valorServicos"
+	OrdenarCriadoEm      = "criadoEm"
+	OrdenarPrestador     = "prestador"
+)
+
+// colunasOrdenacao associa cada campo de ordenação à coluna do repositório SQL
+var colunasOrdenacao = map[string]string{
+	OrdenarDataNota:      "data_nota",
+	OrdenarCompetencia:   "competencia",
+	OrdenarValorServicos: "valor_servicos",
+	OrdenarCriadoEm:      "criado_em",
+	OrdenarPrestador:     "prestador",
+}
+
+// formatoCriadoEm tem largura fixa para que a data de criação ordene corretamente como texto
+const formatoCriadoEm = "2006-01-02T15:04:05.000000000Z07:00"
+
+// PosicaoNota identifica uma nota na ordenação da listagem, para continuar a partir dela
+type PosicaoNota struct {
+	Valor any    `json:"v"`
+	ID    string `json:"id"`
+}
+
+// FiltroNotas restringe a listagem de notas fiscais; campos vazios não filtram
+type FiltroNotas struct {
+	Periodo     FiltroPeriodo
+	Status      string
+	Email       string
+	CNPJ        string
+	Prestador   string   // trecho do nome, sem diferenciar maiúsculas
+	ValorMinimo *float64 // valor dos serviços
+	ValorMaximo *float64
+	// Notas removidas (exclusão lógica) só são listadas se pedido
+	IncluirRemovidas bool
+
+	// Ordenacao é um dos campos Ordenar*; vazio ordena por data da nota. O ID desempata.
+	Ordenacao   string
+	Decrescente bool
+	// Apos continua a listagem depois desta posição (paginação por cursor)
+	Apos *PosicaoNota
+	// Limite é o número máximo de notas retornadas; 0 não limita
+	Limite int
+}
+
+// valorOrdenacao retorna o valor da nota no campo de ordenação, na mesma representação
+// usada nas colunas do repositório SQL
+func valorOrdenacao(nota NotaFiscalData, campo string) any {
+	switch campo {
+	case OrdenarCompetencia:
+		return competenciaISO(nota.Competencia)
+	case OrdenarValorServicos:
+		return nota.ValorServicos
+	case OrdenarCriadoEm:
+		return nota.CriadoEm.UTC().Format(formatoCriadoEm)
+	case OrdenarPrestador:
+		return normalizarTexto(nota.Prestador)
+	default:
+		return dataISO(nota.DataNota)
+	}
+}
+
+// compararPosicao compara a nota com a posição na ordenação do filtro (-1, 0 ou 1)
+func (f FiltroNotas) compararPosicao(nota NotaFiscalData, posicao PosicaoNota) int {
+	var r int
+	switch v := valorOrdenacao(nota, f.Ordenacao).(type) {
+	case float64:
+		outro, _ := posicao.Valor.(float64)
+		r = cmp.Compare(v, outro)
+	case string:
+		outro, _ := posicao.Valor.(string)
+		r = strings.Compare(v, outro)
+	}
+	if r == 0 {
+		r = strings.Compare(nota.ID, posicao.ID)
+	}
+	if f.Decrescente {
+		r = -r
+	}
+	return r
+}
+
+// Posicao retorna a posição da nota na ordenação do filtro, usada como cursor da próxima página
+func (f FiltroNotas) Posicao(nota NotaFiscalData) PosicaoNota {
+	return PosicaoNota{Valor: valorOrdenacao(nota, f.Ordenacao), ID: nota.ID}
+}
+
+// Aceita indica se a nota fiscal atende ao filtro (sem considerar ordenação e paginação)
+func (f FiltroNotas) Aceita(nota NotaFiscalData) bool {
+	if nota.RemovidoEm != nil && !f.IncluirRemovidas {
+		return false
+	}
+	if f.Status != "" && nota.Status != f.Status {
+		return false
+	}
+	if f.Email != "" && !strings.EqualFold(nota.Email, f.Email) {
+		return false
+	}
+	if f.CNPJ != "" && normalizarCNPJ(nota.CNPJ) != normalizarCNPJ(f.CNPJ) {
+		return false
+	}
+	if f.Prestador != "" && !strings.Contains(normalizarTexto(nota.Prestador), normalizarTexto(f.Prestador)) {
+		return false
+	}
+	if f.ValorMinimo != nil && nota.ValorServicos < *f.ValorMinimo {
+		return false
+	}
+	if f.ValorMaximo != nil && nota.ValorServicos > *f.ValorMaximo {
+		return false
+	}
+	return f.Periodo.Aceita(nota)
+}
+
+// NotaFiscalRepository abstrai o armazenamento das notas fiscais salvas
+type NotaFiscalRepository interface {
+	// Salvar grava uma nova nota fiscal e os eventos de auditoria da operação. Pode retornar
+	// ErrNotaDuplicada, assim como Atualizar.
+	Salvar(ctx context.Context, nota NotaFiscalData, eventos ...EventoAuditoria) error
+	// Atualizar substitui os dados de uma nota existente e registra os eventos de auditoria
+	Atualizar(ctx context.Context, nota NotaFiscalData, eventos ...EventoAuditoria) error
+	// Excluir apaga definitivamente a nota (expurgo); a trilha de auditoria é mantida e recebe os eventos
+	Excluir(ctx context.Context, id string, eventos ...EventoAuditoria) error
+	// BuscarPorID retorna ErrNotaNaoEncontrada se a nota não existir; notas removidas são retornadas
+	BuscarPorID(ctx context.Context, id string) (NotaFiscalData, error)
+	// Listar retorna as notas que atendem ao filtro, na ordem e com o limite do filtro
+	Listar(ctx context.Context, filtro FiltroNotas) ([]NotaFiscalData, error)
+	// BuscarDuplicada retorna a nota salva (não removida) com o mesmo CNPJ, número e série ou com
+	// o mesmo hash de arquivo, ou nil se não houver
+	BuscarDuplicada(ctx context.Context, cnpj, numero, serie, hash string) (*NotaFiscalData, error)
+	// Historico retorna a trilha de auditoria da nota, em ordem cronológica
+	Historico(ctx context.Context, notaID string) ([]EventoAuditoria, error)
+	// RedigirAuditoria aplica redigir a cada evento já gravado da nota, regravando os que ela
+	// alterar, e registra os eventos da operação. É a única alteração aceita pela trilha, usada
+	// para apagar dados pessoais na anonimização e no expurgo.
+	RedigirAuditoria(ctx context.Context, notaID string, redigir func(*EventoAuditoria) bool, eventos ...EventoAuditoria) error
+	Close() error
+}
+
+// notasMu serializa as operações dos handlers que consultam e depois gravam notas
+// (verificação de duplicidade no salvamento e revisão) nesta instância; entre instâncias,
+// o repositório SQL rejeita a duplicada com ErrNotaDuplicada
+var notasMu sync.Mutex
+
+// notasRepo é o repositório usado pelos handlers; configurado em main via ConfigurarRepositorio
+var notasRepo NotaFiscalRepository = NovoRepositorioArquivos(uploadDir)
+
+// ConfigurarRepositorio define o repositório de notas fiscais usado pelos handlers e, se ele
+// guardar documentos (repositórios SQL), o armazém das coleções compartilhadas
+func ConfigurarRepositorio(repo NotaFiscalRepository) {
+	notasRepo = repo
+	if armazem, ok := repo.(ArmazemDocumentos); ok {
+		documentos = armazem
+	} else {
+		documentos = armazemLocal
+	}
+}
+
+// AbrirRepositorio abre o repositório indicado pela variável NOTAS_STORAGE:
+// "sqlite" (padrão, arquivo em SQLITE_PATH ou uploads/notas.db), "postgres"
+// (conexão em POSTGRES_URL, para várias instâncias do backend) ou "arquivos"
+// (JSON em uploads/, mantido para compatibilidade com instalações antigas)
+func AbrirRepositorio() (NotaFiscalRepository, error) {
+	switch driver := os.Getenv("NOTAS_STORAGE"); driver {
+	case "", "sqlite":
+		caminho := os.Getenv("SQLITE_PATH")
+		if caminho == "" {
+			caminho = filepath.Join(uploadDir, "notas.db")
+		}
+		return NovoRepositorioSQLite(caminho)
+	case "postgres":
+		url := os.Getenv("POSTGRES_URL")
+		if url == "" {
+			return nil, fmt.Errorf("POSTGRES_URL não configurada")
+		}
+		return NovoRepositorioPostgres(url)
+	case "arquivos":
+		return NovoRepositorioArquivos(uploadDir), nil
+	default:
+		return nil, fmt.Errorf("NOTAS_STORAGE desconhecido: %q", driver)
+	}
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// repositorioArquivos guarda cada nota em um arquivo <id>.json no diretório de uploads e sua
+// trilha de auditoria em auditoria/<id>.jsonl, aberto apenas para inclusão (exceto na redação).
+// Mantido para compatibilidade: cada consulta relê todos os arquivos do diretório.
+type repositorioArquivos struct {
+	dir string
+	mu  sync.Mutex
+	// avisados são os arquivos com datas não reconhecidas já informadas no log
+	avisados sync.Map
+}
+
+// NovoRepositorioArquivos cria um repositório de notas em arquivos JSON no diretório informado
+func NovoRepositorioArquivos(dir string) NotaFiscalRepository {
+	return &repositorioArquivos{dir: dir}
+}
+
+// caminho retorna o arquivo JSON de uma nota, rejeitando IDs que escapariam do diretório
+func (r *repositorioArquivos) caminho(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return "", fmt.Errorf("id de nota inválido: %q", id)
+	}
+	return filepath.Join(r.dir, id+".json"), nil
+}
+
+// ler lê e completa os dados de uma nota a partir do seu arquivo. Datas fora do formato
+// canônico em registros antigos são avisadas uma vez por arquivo (ver decodificarNotaArmazenada).
+func (r *repositorioArquivos) ler(caminho string) (NotaFiscalData, error) {
+	nota, avisos, err := r.lerComAvisos(caminho)
+	if len(avisos) > 0 {
+		if _, avisado := r.avisados.LoadOrStore(caminho, true); !avisado {
+			log.Printf("Nota %s: %s", filepath.Base(caminho), strings.Join(avisos, "; "))
+		}
+	}
+	return nota, err
+}
+
+// lerComAvisos lê a nota e retorna os avisos de conversão de datas de registros antigos
+func (r *repositorioArquivos) lerComAvisos(caminho string) (NotaFiscalData, []string, error) {
+	content, err := os.ReadFile(caminho)
+	if err != nil {
+		return NotaFiscalData{}, nil, err
+	}
+	if content, err = decifrarJSON(content); err != nil {
+		return NotaFiscalData{}, nil, err
+	}
+	nota, avisos, err := decodificarNotaArmazenada(content)
+	if err != nil {
+		return nota, nil, err
+	}
+
+	// Registros antigos não possuem ID, status nem data de criação
+	if nota.ID == "" {
+		nota.ID = strings.TrimSuffix(filepath.Base(caminho), ".json")
+	}
+	if nota.Status == "" {
+		nota.Status = StatusPendente
+	}
+	if nota.CriadoEm.IsZero() {
+		if info, err := os.Stat(caminho); err == nil {
+			nota.CriadoEm = info.ModTime()
+		}
+	}
+	return nota, avisos, nil
+}
+
+// gravar grava os dados da nota em <dir>/<id>.json, cifrados se a criptografia estiver ativa
+func (r *repositorioArquivos) gravar(nota NotaFiscalData) error {
+	caminho, err := r.caminho(nota.ID)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(nota)
+	if err != nil {
+		return err
+	}
+	if content, err = cifrarJSON(content); err != nil {
+		return err
+	}
+	return gravarAtomico(caminho, bytes.NewReader(content))
+}
+
+// todas lê todas as notas fiscais salvas em JSON no diretório
+func (r *repositorioArquivos) todas() ([]NotaFiscalData, error) {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var notas []NotaFiscalData
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		nota, err := r.ler(filepath.Join(r.dir, file.Name()))
+		if err != nil {
+			log.Printf("Erro ao ler arquivo %s: %v", file.Name(), err)
+			continue
+		}
+		notas = append(notas, nota)
+	}
+
+	return notas, nil
+}
+
+// caminhoAuditoria retorna o arquivo da trilha de auditoria de uma nota
+func (r *repositorioArquivos) caminhoAuditoria(id string) (string, error) {
+	caminho, err := r.caminho(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(r.dir, "auditoria", strings.TrimSuffix(filepath.Base(caminho), ".json")+".jsonl"), nil
+}
+
+// registrar acrescenta os eventos ao final da trilha de auditoria da nota
+func (r *repositorioArquivos) registrar(id string, eventos []EventoAuditoria) error {
+	if len(eventos) == 0 {
+		return nil
+	}
+	caminho, err := r.caminhoAuditoria(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(caminho), 0755); err != nil {
+		return err
+	}
+
+	var linhas []byte
+	for _, evento := range eventos {
+		linha, err := json.Marshal(evento)
+		if err != nil {
+			return err
+		}
+		// Cada linha é cifrada separadamente para a trilha continuar sendo só de inclusão
+		if linha, err = cifrarJSON(linha); err != nil {
+			return err
+		}
+		linhas = append(append(linhas, linha...), '\n')
+	}
+
+	f, err := os.OpenFile(caminho, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// Sync garante a trilha em disco antes da alteração da nota
+	if _, err := f.Write(linhas); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *repositorioArquivos) Salvar(_ context.Context, nota NotaFiscalData, eventos ...EventoAuditoria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caminho, err := r.caminho(nota.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(caminho); err == nil {
+		return fmt.Errorf("nota fiscal %s já existe", nota.ID)
+	}
+	// A auditoria é gravada antes da nota: uma falha entre as duas deixa, no máximo, um evento
+	// de alteração não aplicada, nunca uma alteração sem registro
+	if err := r.registrar(nota.ID, eventos); err != nil {
+		return err
+	}
+	return r.gravar(nota)
+}
+
+func (r *repositorioArquivos) Atualizar(_ context.Context, nota NotaFiscalData, eventos ...EventoAuditoria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caminho, err := r.caminho(nota.ID)
+	if err != nil {
+		return ErrNotaNaoEncontrada
+	}
+	if _, err := os.Stat(caminho); os.IsNotExist(err) {
+		return ErrNotaNaoEncontrada
+	}
+	// Auditoria antes da alteração, como em Salvar
+	if err := r.registrar(nota.ID, eventos); err != nil {
+		return err
+	}
+	return r.gravar(nota)
+}
+
+// Recifrar regrava a nota com a chave ativa a partir do arquivo atual, sob o mesmo lock das
+// demais gravações deste processo
+func (r *repositorioArquivos) Recifrar(_ context.Context, id string) (NotaFiscalData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caminho, err := r.caminho(id)
+	if err != nil {
+		return NotaFiscalData{}, ErrNotaNaoEncontrada
+	}
+	nota, err := r.ler(caminho)
+	if os.IsNotExist(err) {
+		return nota, ErrNotaNaoEncontrada
+	}
+	if err != nil {
+		return nota, err
+	}
+	return nota, r.gravar(nota)
+}
+
+func (r *repositorioArquivos) Excluir(_ context.Context, id string, eventos ...EventoAuditoria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caminho, err := r.caminho(id)
+	if err != nil {
+		return ErrNotaNaoEncontrada
+	}
+	if _, err := os.Stat(caminho); os.IsNotExist(err) {
+		return ErrNotaNaoEncontrada
+	}
+	// Auditoria antes da exclusão, como em Salvar
+	if err := r.registrar(id, eventos); err != nil {
+		return err
+	}
+	return os.Remove(caminho)
+}
+
+func (r *repositorioArquivos) BuscarPorID(_ context.Context, id string) (NotaFiscalData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caminho, err := r.caminho(id)
+	if err != nil {
+		return NotaFiscalData{}, ErrNotaNaoEncontrada
+	}
+	nota, err := r.ler(caminho)
+	if os.IsNotExist(err) {
+		return nota, ErrNotaNaoEncontrada
+	}
+	return nota, err
+}
+
+func (r *repositorioArquivos) Listar(_ context.Context, filtro FiltroNotas) ([]NotaFiscalData, error) {
+	r.mu.Lock()
+	notas, err := r.todas()
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var filtradas []NotaFiscalData
+	for _, nota := range notas {
+		if filtro.Aceita(nota) && (filtro.Apos == nil || filtro.compararPosicao(nota, *filtro.Apos) > 0) {
+			filtradas = append(filtradas, nota)
+		}
+	}
+	ordenarNotas(filtradas, filtro)
+	if filtro.Limite > 0 && len(filtradas) > filtro.Limite {
+		filtradas = filtradas[:filtro.Limite]
+	}
+	return filtradas, nil
+}
+
+func (r *repositorioArquivos) BuscarDuplicada(_ context.Context, cnpj, numero, serie, hash string) (*NotaFiscalData, error) {
+	r.mu.Lock()
+	notas, err := r.todas()
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return encontrarDuplicada(notas, cnpj, numero, serie, hash), nil
+}
+
+func (r *repositorioArquivos) Historico(_ context.Context, notaID string) ([]EventoAuditoria, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lerAuditoria(notaID)
+}
+
+// lerAuditoria lê a trilha de auditoria da nota; o chamador segura r.mu
+func (r *repositorioArquivos) lerAuditoria(notaID string) ([]EventoAuditoria, error) {
+	caminho, err := r.caminhoAuditoria(notaID)
+	if err != nil {
+		return nil, ErrNotaNaoEncontrada
+	}
+	conteudo, err := os.ReadFile(caminho)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var eventos []EventoAuditoria
+	for _, linha := range bytes.Split(conteudo, []byte("\n")) {
+		if len(bytes.TrimSpace(linha)) == 0 {
+			continue
+		}
+		linha, err := decifrarJSON(linha)
+		if err != nil {
+			return nil, fmt.Errorf("trilha de auditoria de %s: %v", notaID, err)
+		}
+		var evento EventoAuditoria
+		if err := json.Unmarshal(linha, &evento); err != nil {
+			return nil, fmt.Errorf("trilha de auditoria de %s corrompida: %v", notaID, err)
+		}
+		eventos = append(eventos, evento)
+	}
+	return eventos, nil
+}
+
+// RedigirAuditoria reescreve a trilha da nota com os eventos redigidos e os novos eventos ao
+// final, substituindo o arquivo de uma vez
+func (r *repositorioArquivos) RedigirAuditoria(_ context.Context, notaID string, redigir func(*EventoAuditoria) bool, eventos ...EventoAuditoria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caminho, err := r.caminhoAuditoria(notaID)
+	if err != nil {
+		return ErrNotaNaoEncontrada
+	}
+	gravados, err := r.lerAuditoria(notaID)
+	if err != nil {
+		return err
+	}
+	for i := range gravados {
+		redigir(&gravados[i])
+	}
+
+	var linhas []byte
+	for _, evento := range append(gravados, eventos...) {
+		linha, err := json.Marshal(evento)
+		if err != nil {
+			return err
+		}
+		if linha, err = cifrarJSON(linha); err != nil {
+			return err
+		}
+		linhas = append(append(linhas, linha...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(caminho), 0755); err != nil {
+		return err
+	}
+	return gravarAtomico(caminho, bytes.NewReader(linhas))
+}
+
+func (r *repositorioArquivos) Close() error {
+	return nil
+}
+
+// ordenarNotas ordena pelo campo de ordenação do filtro e ID, a mesma ordem usada pelo repositório SQL
+func ordenarNotas(notas []NotaFiscalData, filtro FiltroNotas) {
+	sort.SliceStable(notas, func(i, j int) bool {
+		return filtro.compararPosicao(notas[i], filtro.Posicao(notas[j])) < 0
+	})
+}
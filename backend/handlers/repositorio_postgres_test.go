@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// urlPostgresTeste cria um esquema vazio no banco de POSTGRES_URL e retorna a URL que o usa
+// como search_path, para cada teste migrar do zero. Sem POSTGRES_URL, o teste é ignorado.
+func urlPostgresTeste(t *testing.T) string {
+	t.Helper()
+	base := os.Getenv("POSTGRES_URL")
+	if base == "" {
+		t.Skip("POSTGRES_URL não configurada")
+	}
+
+	db, err := sql.Open("pgx", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esquema := "teste_" + novoIDNota()[:12]
+	if _, err := db.Exec(`CREATE SCHEMA ` + esquema); err != nil {
+		db.Close()
+		t.Fatalf("erro ao criar esquema: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DROP SCHEMA ` + esquema + ` CASCADE`)
+		db.Close()
+	})
+
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("POSTGRES_URL deve estar no formato postgres://...: %v", err)
+	}
+	consulta := u.Query()
+	consulta.Set("search_path", esquema)
+	u.RawQuery = consulta.Encode()
+	return u.String()
+}
+
+// abrirPostgresTeste abre o repositório em um esquema novo
+func abrirPostgresTeste(t *testing.T) *repositorioSQL {
+	t.Helper()
+	repo, err := NovoRepositorioPostgres(urlPostgresTeste(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo.(*repositorioSQL)
+}
+
+// notaTeste monta uma nota ativa com os campos usados na detecção de duplicadas
+func notaTeste(id, numero, serie, hash string) NotaFiscalData {
+	return NotaFiscalData{
+		ID:          id,
+		Email:       "titular@exemplo.com",
+		CNPJ:        "12.345.678/0001-90",
+		NumeroNota:  numero,
+		Serie:       serie,
+		Prestador:   "Prestador Exemplo LTDA",
+		HashArquivo: hash,
+		Arquivo:     hash + ".pdf",
+		Status:      StatusPendente,
+		CriadoEm:    time.Now(),
+	}
+}
+
+func TestPostgresMigracoesConcorrentes(t *testing.T) {
+	url := urlPostgresTeste(t)
+
+	// Instâncias iniciando juntas: o advisory lock serializa as migrações
+	var wg sync.WaitGroup
+	erros := make([]error, 4)
+	for i := range erros {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo, err := NovoRepositorioPostgres(url)
+			if err == nil {
+				repo.Close()
+			}
+			erros[i] = err
+		}()
+	}
+	wg.Wait()
+	for i, err := range erros {
+		if err != nil {
+			t.Errorf("instância %d: %v", i, err)
+		}
+	}
+
+	repo, err := NovoRepositorioPostgres(url)
+	if err != nil {
+		t.Fatalf("reabrir o banco migrado: %v", err)
+	}
+	defer repo.Close()
+	var aplicadas int
+	if err := repo.(*repositorioSQL).db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&aplicadas); err != nil {
+		t.Fatal(err)
+	}
+	if aplicadas != len(migracoesPostgres) {
+		t.Errorf("migrações aplicadas = %d, esperado %d", aplicadas, len(migracoesPostgres))
+	}
+}
+
+func TestPostgresSalvarTransacional(t *testing.T) {
+	repo := abrirPostgresTeste(t)
+	ctx := context.Background()
+
+	nota := notaTeste("a1", "100", "1", "hash-a1")
+	eventos := []EventoAuditoria{
+		{NotaID: nota.ID, Operacao: OperacaoUpload, Ator: "teste", Momento: time.Now()},
+		novoEvento(OperacaoExtracao, "teste", nil, nota),
+	}
+	if err := repo.Salvar(ctx, nota, eventos...); err != nil {
+		t.Fatal(err)
+	}
+	salva, err := repo.BuscarPorID(ctx, nota.ID)
+	if err != nil || salva.NumeroNota != nota.NumeroNota || salva.Arquivo != nota.Arquivo {
+		t.Fatalf("BuscarPorID = %+v, %v", salva, err)
+	}
+	if historico, err := repo.Historico(ctx, nota.ID); err != nil || len(historico) != 2 {
+		t.Fatalf("Historico = %d eventos, %v; esperado 2", len(historico), err)
+	}
+
+	// Falha no registro da auditoria (o PostgreSQL rejeita NUL em TEXT): nada é gravado
+	outra := notaTeste("b2", "200", "1", "hash-b2")
+	invalido := EventoAuditoria{NotaID: outra.ID, Operacao: OperacaoUpload, Ator: "teste", Momento: time.Now(), Detalhes: "\x00"}
+	if err := repo.Salvar(ctx, outra, invalido); err == nil {
+		t.Fatal("Salvar com evento inválido deveria falhar")
+	}
+	if _, err := repo.BuscarPorID(ctx, outra.ID); !errors.Is(err, ErrNotaNaoEncontrada) {
+		t.Errorf("nota gravada apesar da falha na auditoria: %v", err)
+	}
+	var arquivos int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM arquivos_notas WHERE nota_id = $1`, outra.ID).Scan(&arquivos); err != nil {
+		t.Fatal(err)
+	}
+	if arquivos != 0 {
+		t.Errorf("arquivos_notas gravada apesar da falha na auditoria")
+	}
+}
+
+func TestPostgresBuscarDuplicada(t *testing.T) {
+	repo := abrirPostgresTeste(t)
+	ctx := context.Background()
+
+	original := notaTeste("a1", "00123", "1", "hash-a1")
+	if err := repo.Salvar(ctx, original); err != nil {
+		t.Fatal(err)
+	}
+
+	casos := []struct {
+		nome                      string
+		cnpj, numero, serie, hash string
+		esperado                  string
+	}{
+		{"mesmo número normalizado, sem série", "12345678000190", "123", "", "", "a1"},
+		{"mesmo número e série", "12.345.678/0001-90", "123", "01", "", "a1"},
+		{"série diferente", "12345678000190", "123", "2", "", ""},
+		{"outro CNPJ", "98765432000110", "123", "1", "", ""},
+		{"mesmo arquivo", "", "", "", "hash-a1", "a1"},
+		{"nada informado", "", "", "", "", ""},
+	}
+	for _, caso := range casos {
+		nota, err := repo.BuscarDuplicada(ctx, caso.cnpj, caso.numero, caso.serie, caso.hash)
+		if err != nil {
+			t.Fatalf("%s: %v", caso.nome, err)
+		}
+		encontrada := ""
+		if nota != nil {
+			encontrada = nota.ID
+		}
+		if encontrada != caso.esperado {
+			t.Errorf("%s: duplicada = %q, esperado %q", caso.nome, encontrada, caso.esperado)
+		}
+	}
+
+	// Os índices únicos rejeitam a duplicada mesmo sem a verificação prévia
+	if err := repo.Salvar(ctx, notaTeste("b2", "123", "1", "hash-b2")); !errors.Is(err, ErrNotaDuplicada) {
+		t.Errorf("mesmo CNPJ, número e série: %v, esperado ErrNotaDuplicada", err)
+	}
+	if err := repo.Salvar(ctx, notaTeste("c3", "456", "1", "hash-a1")); !errors.Is(err, ErrNotaDuplicada) {
+		t.Errorf("mesmo arquivo: %v, esperado ErrNotaDuplicada", err)
+	}
+
+	// Notas removidas não contam como duplicadas
+	agora := time.Now()
+	original.RemovidoEm = &agora
+	if err := repo.Atualizar(ctx, original); err != nil {
+		t.Fatal(err)
+	}
+	if nota, err := repo.BuscarDuplicada(ctx, original.CNPJ, "123", "1", "hash-a1"); err != nil || nota != nil {
+		t.Errorf("nota removida retornada como duplicada: %v, %v", nota, err)
+	}
+	if err := repo.Salvar(ctx, notaTeste("b2", "123", "1", "hash-a1")); err != nil {
+		t.Errorf("reenvio após remoção: %v", err)
+	}
+}
+
+func TestPostgresSalvarConcorrente(t *testing.T) {
+	url := urlPostgresTeste(t)
+	ctx := context.Background()
+
+	// Duas instâncias salvam a mesma nota ao mesmo tempo: apenas uma gravação é aceita
+	var repos []NotaFiscalRepository
+	for range 2 {
+		repo, err := NovoRepositorioPostgres(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer repo.Close()
+		repos = append(repos, repo)
+	}
+
+	var wg sync.WaitGroup
+	erros := make([]error, len(repos))
+	for i, repo := range repos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			erros[i] = repo.Salvar(ctx, notaTeste(novoIDNota(), "777", "1", "hash-777"))
+		}()
+	}
+	wg.Wait()
+
+	aceitas, duplicadas := 0, 0
+	for _, err := range erros {
+		switch {
+		case err == nil:
+			aceitas++
+		case errors.Is(err, ErrNotaDuplicada):
+			duplicadas++
+		default:
+			t.Errorf("erro inesperado: %v", err)
+		}
+	}
+	if aceitas != 1 || duplicadas != 1 {
+		t.Errorf("aceitas = %d, duplicadas = %d; esperado 1 e 1", aceitas, duplicadas)
+	}
+}
+
+func TestPostgresSerieVazia(t *testing.T) {
+	conferirSerieVazia(t, abrirPostgresTeste(t))
+}
+
+func TestPostgresSalvarConcorrenteSerieVazia(t *testing.T) {
+	url := urlPostgresTeste(t)
+
+	// Duas instâncias salvam a mesma nota, uma sem série e outra com série
+	var repos []NotaFiscalRepository
+	for range 2 {
+		repo, err := NovoRepositorioPostgres(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer repo.Close()
+		repos = append(repos, repo)
+	}
+	conferirSerieVaziaConcorrente(t, repos)
+}
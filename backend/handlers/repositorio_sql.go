@@ -0,0 +1,665 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+)
+
+// migracao é uma alteração de esquema aplicada uma única vez, em ordem de versão
+type migracao struct {
+	versao   int
+	comandos []string
+}
+
+// repositorioSQL implementa NotaFiscalRepository sobre database/sql. Os campos usados em
+// filtros ficam em colunas indexadas; a nota completa fica em JSON na coluna dados e a
+// referência ao arquivo da nota na tabela arquivos_notas.
+type repositorioSQL struct {
+	db *sql.DB
+	// numerado indica placeholders $1, $2... (PostgreSQL) em vez de ?
+	numerado bool
+	// emClaro indica que, com a criptografia ativa, ainda há notas com e-mail ou CNPJ sem índice
+	// cego (gravadas antes da ativação): as buscas procuram as duas formas até rotacionar-chaves
+	emClaro atomic.Bool
+}
+
+// verificarEmClaro atualiza emClaro; sem criptografia, as colunas guardam sempre o valor em claro
+func (r *repositorioSQL) verificarEmClaro(ctx context.Context) error {
+	if cofre == nil {
+		r.emClaro.Store(false)
+		return nil
+	}
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (SELECT 1 FROM notas_fiscais
+		WHERE (email <> '' AND email NOT LIKE 'h:%') OR (cnpj <> '' AND cnpj NOT LIKE 'h:%') LIMIT 1) AS t`).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("erro ao verificar notas sem índice cego: %v", err)
+	}
+	r.emClaro.Store(n > 0)
+	return nil
+}
+
+// condicaoBusca monta a condição de igualdade de uma coluna de dados pessoais: o índice cego
+// e, enquanto houver notas gravadas antes da criptografia, também o valor em claro
+func (r *repositorioSQL) condicaoBusca(coluna, valor string) (string, []any) {
+	indice := indiceBusca(valor)
+	if !r.emClaro.Load() || indice == valor {
+		return coluna + " = ?", []any{indice}
+	}
+	return coluna + " IN (?, ?)", []any{indice, valor}
+}
+
+// rebind adapta os placeholders ? da consulta ao driver em uso
+func (r *repositorioSQL) rebind(consulta string) string {
+	if !r.numerado {
+		return consulta
+	}
+	var b strings.Builder
+	n := 0
+	for _, ch := range consulta {
+		if ch == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// migrar cria a tabela de controle e aplica as migrações ainda não aplicadas
+func (r *repositorioSQL) migrar(migracoes []migracao) error {
+	if _, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		versao INTEGER PRIMARY KEY,
+		aplicada_em TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("erro ao criar tabela de migrações: %v", err)
+	}
+
+	for _, m := range migracoes {
+		var existe int
+		if err := r.db.QueryRow(r.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE versao = ?`), m.versao).Scan(&existe); err != nil {
+			return err
+		}
+		if existe > 0 {
+			continue
+		}
+
+		tx, err := r.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, comando := range m.comandos {
+			if _, err := tx.Exec(comando); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("erro na migração %d: %v", m.versao, err)
+			}
+		}
+		if _, err := tx.Exec(r.rebind(`INSERT INTO schema_migrations (versao, aplicada_em) VALUES (?, ?)`), m.versao, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// competenciaISO formata a competência como AAAA-MM, que ordena corretamente como texto
+func competenciaISO(c Competencia) string {
+	if c.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%04d-%02d", c.Ano, int(c.Mes))
+}
+
+// dataISO formata a data como AAAA-MM-DD, que ordena corretamente como texto
+func dataISO(d Data) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format("2006-01-02")
+}
+
+// colunasNota retorna os valores das colunas indexadas e o JSON completo da nota. Com a
+// criptografia ativa, o JSON é cifrado e e-mail e CNPJ são guardados como índices cegos;
+// prestador e valor, buscados por trecho e por faixa, o que um índice cego não atende, ficam
+// vazios nas colunas e Listar os filtra e ordena em memória (ver filtroEmMemoria).
+func colunasNota(nota NotaFiscalData) ([]any, error) {
+	dados, err := json.Marshal(nota)
+	if err != nil {
+		return nil, err
+	}
+	if dados, err = cifrarJSON(dados); err != nil {
+		return nil, err
+	}
+	prestador, valor := normalizarTexto(nota.Prestador), nota.ValorServicos
+	if cofre != nil {
+		prestador, valor = "", 0
+	}
+	removidoEm := ""
+	if nota.RemovidoEm != nil {
+		removidoEm = nota.RemovidoEm.UTC().Format(formatoCriadoEm)
+	}
+	return []any{
+		indiceBusca(strings.ToLower(strings.TrimSpace(nota.Email))),
+		indiceBusca(normalizarCNPJ(nota.CNPJ)),
+		normalizarNumero(nota.NumeroNota),
+		normalizarNumero(nota.Serie),
+		competenciaISO(nota.Competencia),
+		dataISO(nota.DataNota),
+		valor,
+		nota.Status,
+		nota.HashArquivo,
+		prestador,
+		removidoEm,
+		string(dados),
+	}, nil
+}
+
+// escaparLike protege os curingas de LIKE em um trecho de texto procurado
+func escaparLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// lerNota decifra (se necessário) e converte o JSON da coluna dados
+func lerNota(dados string) (NotaFiscalData, error) {
+	var nota NotaFiscalData
+	conteudo, err := decifrarJSON([]byte(dados))
+	if err != nil {
+		return nota, err
+	}
+	err = json.Unmarshal(conteudo, &nota)
+	return nota, err
+}
+
+// lerNotas converte as linhas (coluna dados) em notas fiscais
+func lerNotas(rows *sql.Rows) ([]NotaFiscalData, error) {
+	defer rows.Close()
+
+	var notas []NotaFiscalData
+	for rows.Next() {
+		var dados string
+		if err := rows.Scan(&dados); err != nil {
+			return nil, err
+		}
+		nota, err := lerNota(dados)
+		if err != nil {
+			return nil, err
+		}
+		notas = append(notas, nota)
+	}
+	return notas, rows.Err()
+}
+
+// erroGravacao converte a violação dos índices únicos de duplicidade (migração 5) em
+// ErrNotaDuplicada; os demais erros são retornados sem alteração
+func erroGravacao(err error) error {
+	var erroPostgres *pgconn.PgError
+	if errors.As(err, &erroPostgres) && erroPostgres.Code == "23505" && strings.HasPrefix(erroPostgres.ConstraintName, "idx_notas_unica") {
+		return ErrNotaDuplicada
+	}
+	// SQLITE_CONSTRAINT_UNIQUE; chaves primárias violadas usam outro código
+	var erroSQLite *sqlite.Error
+	if errors.As(err, &erroSQLite) && erroSQLite.Code() == 2067 && strings.Contains(err.Error(), "notas_fiscais.") {
+		return ErrNotaDuplicada
+	}
+	return err
+}
+
+// conferirNumeroUnico retorna ErrNotaDuplicada se outra nota não removida tiver o mesmo CNPJ e
+// número com série equivalente (mesmaSerie: iguais ou uma delas vazia). O índice único da
+// migração 5 compara a série literalmente e não cobre a série vazia, então a regra é conferida
+// na transação que grava a nota, depois da gravação: no SQLite, a escrita serializa as
+// transações e a consulta vê todas as notas confirmadas; no PostgreSQL, um bloqueio da
+// transação por CNPJ e número faz gravações concorrentes da mesma nota esperarem uma pela outra.
+func (r *repositorioSQL) conferirNumeroUnico(ctx context.Context, tx *sql.Tx, nota NotaFiscalData) error {
+	// Sem CNPJ (por exemplo, anonimizada), a nota não é identificada pelo número
+	numero, cnpj := normalizarNumero(nota.NumeroNota), normalizarCNPJ(nota.CNPJ)
+	if numero == "" || cnpj == "" || nota.RemovidoEm != nil {
+		return nil
+	}
+	serie := normalizarNumero(nota.Serie)
+	condicaoCNPJ, valoresCNPJ := r.condicaoBusca("cnpj", cnpj)
+	args := append(valoresCNPJ, numero, nota.ID, serie, serie)
+	var existentes int
+	err := tx.QueryRowContext(ctx, r.rebind(`SELECT COUNT(*) FROM notas_fiscais
+		WHERE removido_em = '' AND `+condicaoCNPJ+` AND numero = ? AND id <> ?
+		  AND (serie = ? OR serie = '' OR ? = '')`), args...).Scan(&existentes)
+	if err != nil {
+		return err
+	}
+	if existentes > 0 {
+		return ErrNotaDuplicada
+	}
+	return nil
+}
+
+// bloquearNumero serializa, no PostgreSQL, as transações que gravam notas com o mesmo CNPJ e
+// número até o fim da transação (ver conferirNumeroUnico); no SQLite, a escrita já as serializa.
+// A chave usa o índice cego do CNPJ, para não enviar o CNPJ em claro.
+func (r *repositorioSQL) bloquearNumero(ctx context.Context, tx *sql.Tx, nota NotaFiscalData) error {
+	numero, cnpj := normalizarNumero(nota.NumeroNota), normalizarCNPJ(nota.CNPJ)
+	if !r.numerado || numero == "" || cnpj == "" || nota.RemovidoEm != nil {
+		return nil
+	}
+	chave := indiceBusca(cnpj) + "|" + numero
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, chave)
+	return err
+}
+
+// registrar grava os eventos de auditoria na transação da operação que os gerou
+func (r *repositorioSQL) registrar(ctx context.Context, tx *sql.Tx, eventos []EventoAuditoria) error {
+	for _, evento := range eventos {
+		ator, alteracoes, detalhes, err := colunasEvento(evento)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, r.rebind(`INSERT INTO auditoria_notas
+			(nota_id, operacao, ator, momento, versao_extrator, alteracoes, detalhes)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			evento.NotaID, evento.Operacao, ator, evento.Momento.UTC().Format(formatoCriadoEm),
+			evento.VersaoExtrator, alteracoes, detalhes); err != nil {
+			return fmt.Errorf("erro ao registrar auditoria: %v", err)
+		}
+	}
+	return nil
+}
+
+// colunasEvento retorna o autor, as alterações e os detalhes do evento como gravados na
+// auditoria, cifrados se a criptografia estiver ativa
+func colunasEvento(evento EventoAuditoria) (ator, alteracoes, detalhes string, err error) {
+	conteudo, err := json.Marshal(evento.Alteracoes)
+	if err != nil {
+		return "", "", "", err
+	}
+	if conteudo, err = cifrarJSON(conteudo); err != nil {
+		return "", "", "", err
+	}
+	if ator, err = cifrarTexto(evento.Ator); err != nil {
+		return "", "", "", err
+	}
+	if detalhes, err = cifrarTexto(evento.Detalhes); err != nil {
+		return "", "", "", err
+	}
+	return ator, string(conteudo), detalhes, nil
+}
+
+func (r *repositorioSQL) Salvar(ctx context.Context, nota NotaFiscalData, eventos ...EventoAuditoria) error {
+	colunas, err := colunasNota(nota)
+	if err != nil {
+		return err
+	}
+	criadoEm := nota.CriadoEm.UTC().Format(formatoCriadoEm)
+	args := append([]any{nota.ID}, colunas...)
+	args = append(args, criadoEm)
+
+	// A nota e a referência ao seu arquivo são gravadas juntas ou nenhuma delas
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.bloquearNumero(ctx, tx, nota); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, r.rebind(`INSERT INTO notas_fiscais
+		(id, email, cnpj, numero, serie, competencia, data_nota, valor_servicos, status, hash_arquivo, prestador, removido_em, dados, criado_em)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), args...); err != nil {
+		return erroGravacao(err)
+	}
+	if err := r.conferirNumeroUnico(ctx, tx, nota); err != nil {
+		return err
+	}
+	if nota.Arquivo != "" {
+		if _, err := tx.ExecContext(ctx, r.rebind(`INSERT INTO arquivos_notas (nota_id, nome, hash, criado_em)
+			VALUES (?, ?, ?, ?)`), nota.ID, nota.Arquivo, nota.HashArquivo, criadoEm); err != nil {
+			return err
+		}
+	}
+	if err := r.registrar(ctx, tx, eventos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// atualizacaoNota regrava as colunas de colunasNota, na mesma ordem
+const atualizacaoNota = `UPDATE notas_fiscais SET
+	email = ?, cnpj = ?, numero = ?, serie = ?, competencia = ?, data_nota = ?,
+	valor_servicos = ?, status = ?, hash_arquivo = ?, prestador = ?, removido_em = ?, dados = ?`
+
+func (r *repositorioSQL) Atualizar(ctx context.Context, nota NotaFiscalData, eventos ...EventoAuditoria) error {
+	colunas, err := colunasNota(nota)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.bloquearNumero(ctx, tx, nota); err != nil {
+		return err
+	}
+	// Só a mudança de CNPJ, número ou série (ou a restauração da nota) é conferida: pares
+	// gravados antes da regra continuam podendo ser revisados
+	var mesmaChave int
+	if err := tx.QueryRowContext(ctx, r.rebind(`SELECT COUNT(*) FROM notas_fiscais
+		WHERE id = ? AND removido_em = '' AND cnpj = ? AND numero = ? AND serie = ?`),
+		nota.ID, colunas[1], colunas[2], colunas[3]).Scan(&mesmaChave); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, r.rebind(atualizacaoNota+` WHERE id = ?`), append(colunas, nota.ID)...)
+	if err != nil {
+		return erroGravacao(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotaNaoEncontrada
+	}
+	if mesmaChave == 0 {
+		if err := r.conferirNumeroUnico(ctx, tx, nota); err != nil {
+			return err
+		}
+	}
+	if err := r.registrar(ctx, tx, eventos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Recifrar regrava a nota com a chave ativa e os índices cegos atuais. A gravação só ocorre se
+// a coluna dados ainda for a lida; se a nota mudou no intervalo, ela é lida de novo, para não
+// desfazer alterações concorrentes.
+func (r *repositorioSQL) Recifrar(ctx context.Context, id string) (NotaFiscalData, error) {
+	for tentativa := 0; tentativa < 5; tentativa++ {
+		var dados string
+		err := r.db.QueryRowContext(ctx, r.rebind(`SELECT dados FROM notas_fiscais WHERE id = ?`), id).Scan(&dados)
+		if errors.Is(err, sql.ErrNoRows) {
+			return NotaFiscalData{}, ErrNotaNaoEncontrada
+		}
+		if err != nil {
+			return NotaFiscalData{}, err
+		}
+		nota, err := lerNota(dados)
+		if err != nil {
+			return nota, err
+		}
+		colunas, err := colunasNota(nota)
+		if err != nil {
+			return nota, err
+		}
+
+		res, err := r.db.ExecContext(ctx, r.rebind(atualizacaoNota+` WHERE id = ? AND dados = ?`), append(colunas, id, dados)...)
+		if err != nil {
+			return nota, erroGravacao(err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return nota, err
+		}
+	}
+	return NotaFiscalData{}, errors.New("nota alterada repetidamente durante a regravação; execute novamente")
+}
+
+func (r *repositorioSQL) Excluir(ctx context.Context, id string, eventos ...EventoAuditoria) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// arquivos_notas é apagada em cascata
+	res, err := tx.ExecContext(ctx, r.rebind(`DELETE FROM notas_fiscais WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotaNaoEncontrada
+	}
+	if err := r.registrar(ctx, tx, eventos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *repositorioSQL) BuscarPorID(ctx context.Context, id string) (NotaFiscalData, error) {
+	var dados string
+
+	err := r.db.QueryRowContext(ctx, r.rebind(`SELECT dados FROM notas_fiscais WHERE id = ?`), id).Scan(&dados)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotaFiscalData{}, ErrNotaNaoEncontrada
+	}
+	if err != nil {
+		return NotaFiscalData{}, err
+	}
+	return lerNota(dados)
+}
+
+// filtroEmMemoria indica que o filtro usa prestador ou valor, que com a criptografia ativa só
+// existem no JSON cifrado: a consulta traz as notas dos demais filtros e Listar conclui a
+// filtragem, a ordenação e a paginação depois de decifrá-las
+func filtroEmMemoria(filtro FiltroNotas) bool {
+	return cofre != nil && (filtro.Prestador != "" || filtro.ValorMinimo != nil || filtro.ValorMaximo != nil ||
+		filtro.Ordenacao == OrdenarPrestador || filtro.Ordenacao == OrdenarValorServicos)
+}
+
+func (r *repositorioSQL) Listar(ctx context.Context, filtro FiltroNotas) ([]NotaFiscalData, error) {
+	var condicoes []string
+	var args []any
+	emMemoria := filtroEmMemoria(filtro)
+
+	if filtro.Status != "" {
+		condicoes = append(condicoes, "status = ?")
+		args = append(args, filtro.Status)
+	}
+	if filtro.Email != "" {
+		condicao, valores := r.condicaoBusca("email", strings.ToLower(strings.TrimSpace(filtro.Email)))
+		condicoes = append(condicoes, condicao)
+		args = append(args, valores...)
+	}
+	if filtro.CNPJ != "" {
+		condicao, valores := r.condicaoBusca("cnpj", normalizarCNPJ(filtro.CNPJ))
+		condicoes = append(condicoes, condicao)
+		args = append(args, valores...)
+	}
+	if filtro.Prestador != "" && !emMemoria {
+		condicoes = append(condicoes, `prestador LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaparLike(normalizarTexto(filtro.Prestador))+"%")
+	}
+	if filtro.ValorMinimo != nil && !emMemoria {
+		condicoes = append(condicoes, "valor_servicos >= ?")
+		args = append(args, *filtro.ValorMinimo)
+	}
+	if filtro.ValorMaximo != nil && !emMemoria {
+		condicoes = append(condicoes, "valor_servicos <= ?")
+		args = append(args, *filtro.ValorMaximo)
+	}
+	if !filtro.IncluirRemovidas {
+		condicoes = append(condicoes, "removido_em = ''")
+	}
+	if p := filtro.Periodo; !p.CompetenciaInicio.IsZero() {
+		condicoes = append(condicoes, "competencia <> '' AND competencia >= ?")
+		args = append(args, competenciaISO(p.CompetenciaInicio))
+	}
+	if p := filtro.Periodo; !p.CompetenciaFim.IsZero() {
+		condicoes = append(condicoes, "competencia <> '' AND competencia <= ?")
+		args = append(args, competenciaISO(p.CompetenciaFim))
+	}
+	if p := filtro.Periodo; !p.DataInicio.IsZero() {
+		condicoes = append(condicoes, "data_nota <> '' AND data_nota >= ?")
+		args = append(args, dataISO(p.DataInicio))
+	}
+	if p := filtro.Periodo; !p.DataFim.IsZero() {
+		condicoes = append(condicoes, "data_nota <> '' AND data_nota <= ?")
+		args = append(args, dataISO(p.DataFim))
+	}
+
+	coluna, ok := colunasOrdenacao[filtro.Ordenacao]
+	if !ok {
+		coluna = colunasOrdenacao[OrdenarDataNota]
+	}
+	direcao, comparacao := "ASC", ">"
+	if filtro.Decrescente {
+		direcao, comparacao = "DESC", "<"
+	}
+	if filtro.Apos != nil && !emMemoria {
+		condicoes = append(condicoes, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", coluna, comparacao))
+		args = append(args, filtro.Apos.Valor, filtro.Apos.Valor, filtro.Apos.ID)
+	}
+
+	consulta := `SELECT dados FROM notas_fiscais`
+	if len(condicoes) > 0 {
+		consulta += " WHERE " + strings.Join(condicoes, " AND ")
+	}
+	if !emMemoria {
+		consulta += fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s", coluna, direcao)
+		if filtro.Limite > 0 {
+			consulta += fmt.Sprintf(" LIMIT %d", filtro.Limite)
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx, r.rebind(consulta), args...)
+	if err != nil {
+		return nil, err
+	}
+	notas, err := lerNotas(rows)
+	if err != nil || !emMemoria {
+		return notas, err
+	}
+
+	// Como em repositorioArquivos.Listar
+	var filtradas []NotaFiscalData
+	for _, nota := range notas {
+		if filtro.Aceita(nota) && (filtro.Apos == nil || filtro.compararPosicao(nota, *filtro.Apos) > 0) {
+			filtradas = append(filtradas, nota)
+		}
+	}
+	ordenarNotas(filtradas, filtro)
+	if filtro.Limite > 0 && len(filtradas) > filtro.Limite {
+		filtradas = filtradas[:filtro.Limite]
+	}
+	return filtradas, nil
+}
+
+func (r *repositorioSQL) BuscarDuplicada(ctx context.Context, cnpj, numero, serie, hash string) (*NotaFiscalData, error) {
+	// Os índices restringem os candidatos; a regra de série fica em encontrarDuplicada
+	condicaoCNPJ, valoresCNPJ := r.condicaoBusca("cnpj", normalizarCNPJ(cnpj))
+	args := append([]any{hash}, valoresCNPJ...)
+	rows, err := r.db.QueryContext(ctx, r.rebind(`SELECT dados FROM notas_fiscais
+		WHERE removido_em = '' AND ((hash_arquivo <> '' AND hash_arquivo = ?)
+		   OR (numero <> '' AND `+condicaoCNPJ+` AND numero = ?))`),
+		append(args, normalizarNumero(numero))...)
+	if err != nil {
+		return nil, err
+	}
+
+	candidatos, err := lerNotas(rows)
+	if err != nil {
+		return nil, err
+	}
+	return encontrarDuplicada(candidatos, cnpj, numero, serie, hash), nil
+}
+
+func (r *repositorioSQL) Historico(ctx context.Context, notaID string) ([]EventoAuditoria, error) {
+	_, eventos, err := r.lerEventos(ctx, r.db, notaID)
+	return eventos, err
+}
+
+// consultor é o que lerEventos usa de *sql.DB e *sql.Tx
+type consultor interface {
+	QueryContext(ctx context.Context, consulta string, args ...any) (*sql.Rows, error)
+}
+
+// lerEventos lê a trilha de auditoria da nota em ordem cronológica, com o ID de cada linha
+func (r *repositorioSQL) lerEventos(ctx context.Context, db consultor, notaID string) ([]int64, []EventoAuditoria, error) {
+	rows, err := db.QueryContext(ctx, r.rebind(`SELECT id, nota_id, operacao, ator, momento, versao_extrator, alteracoes, detalhes
+		FROM auditoria_notas WHERE nota_id = ? ORDER BY id`), notaID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var eventos []EventoAuditoria
+	for rows.Next() {
+		var id int64
+		var evento EventoAuditoria
+		var momento, alteracoes string
+		if err := rows.Scan(&id, &evento.NotaID, &evento.Operacao, &evento.Ator, &momento, &evento.VersaoExtrator, &alteracoes, &evento.Detalhes); err != nil {
+			return nil, nil, err
+		}
+		if evento.Momento, err = time.Parse(time.RFC3339Nano, momento); err != nil {
+			return nil, nil, err
+		}
+		conteudo, err := decifrarJSON([]byte(alteracoes))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(conteudo, &evento.Alteracoes); err != nil {
+			return nil, nil, err
+		}
+		if evento.Ator, err = decifrarTexto(evento.Ator); err != nil {
+			return nil, nil, err
+		}
+		if evento.Detalhes, err = decifrarTexto(evento.Detalhes); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		eventos = append(eventos, evento)
+	}
+	return ids, eventos, rows.Err()
+}
+
+// RedigirAuditoria regrava os eventos alterados por redigir em uma transação. Os triggers da
+// trilha só aceitam a alteração enquanto a nota está em redacoes_auditoria (migração 6).
+func (r *repositorioSQL) RedigirAuditoria(ctx context.Context, notaID string, redigir func(*EventoAuditoria) bool, eventos ...EventoAuditoria) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, gravados, err := r.lerEventos(ctx, tx, notaID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, r.rebind(`INSERT INTO redacoes_auditoria (nota_id) VALUES (?)`), notaID); err != nil {
+		return err
+	}
+	for i := range gravados {
+		if !redigir(&gravados[i]) {
+			continue
+		}
+		ator, alteracoes, detalhes, err := colunasEvento(gravados[i])
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, r.rebind(`UPDATE auditoria_notas SET ator = ?, alteracoes = ?, detalhes = ? WHERE id = ?`),
+			ator, alteracoes, detalhes, ids[i]); err != nil {
+			return fmt.Errorf("erro ao redigir auditoria: %v", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, r.rebind(`DELETE FROM redacoes_auditoria WHERE nota_id = ?`), notaID); err != nil {
+		return err
+	}
+	if err := r.registrar(ctx, tx, eventos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *repositorioSQL) Close() error {
+	return r.db.Close()
+}
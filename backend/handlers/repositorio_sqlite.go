@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// migracoesSQLite define o esquema do banco SQLite embutido
+var migracoesSQLite = []migracao{
+	{versao: 1, comandos: []string{
+		`CREATE TABLE notas_fiscais (
+			id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			cnpj TEXT NOT NULL,
+			numero TEXT NOT NULL,
+			serie TEXT NOT NULL,
+			competencia TEXT NOT NULL,
+			data_nota TEXT NOT NULL,
+			valor_servicos REAL NOT NULL,
+			status TEXT NOT NULL,
+			hash_arquivo TEXT NOT NULL,
+			dados TEXT NOT NULL,
+			criado_em TEXT NOT NULL
+		)`,
+		`CREATE INDEX idx_notas_cnpj ON notas_fiscais (cnpj)`,
+		`CREATE INDEX idx_notas_competencia ON notas_fiscais (competencia)`,
+		`CREATE INDEX idx_notas_numero ON notas_fiscais (numero)`,
+		`CREATE INDEX idx_notas_email ON notas_fiscais (email)`,
+		`CREATE INDEX idx_notas_data ON notas_fiscais (data_nota)`,
+		`CREATE INDEX idx_notas_hash ON notas_fiscais (hash_arquivo)`,
+	}},
+	{versao: 2, comandos: []string{
+		`CREATE TABLE arquivos_notas (
+			nota_id TEXT PRIMARY KEY REFERENCES notas_fiscais (id) ON DELETE CASCADE,
+			nome TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			criado_em TEXT NOT NULL
+		)`,
+		`INSERT INTO arquivos_notas (nota_id, nome, hash, criado_em)
+			SELECT id, json_extract(dados, '$.arquivo'), hash_arquivo, criado_em
+			FROM notas_fiscais WHERE COALESCE(json_extract(dados, '$.arquivo'), '') <> ''`,
+	}},
+	{versao: 3, comandos: []string{
+		`ALTER TABLE notas_fiscais ADD COLUMN prestador TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE notas_fiscais ADD COLUMN removido_em TEXT NOT NULL DEFAULT ''`,
+		`UPDATE notas_fiscais SET prestador = UPPER(TRIM(COALESCE(json_extract(dados, '$.prestador'), '')))`,
+		`CREATE INDEX idx_notas_prestador ON notas_fiscais (prestador)`,
+		`CREATE INDEX idx_notas_valor ON notas_fiscais (valor_servicos)`,
+		// Notas gravadas antes desta versão têm criado_em em RFC3339Nano (UTC), de largura variável;
+		// o backfill completa a fração com zeros para o formato de formatoCriadoEm, que ordena como texto
+		`UPDATE notas_fiscais SET criado_em = ` + criadoEmNormalizado + ` WHERE length(criado_em) <> 30 AND criado_em LIKE '%Z'`,
+		`UPDATE arquivos_notas SET criado_em = ` + criadoEmNormalizado + ` WHERE length(criado_em) <> 30 AND criado_em LIKE '%Z'`,
+		`CREATE INDEX idx_notas_criado_em ON notas_fiscais (criado_em)`,
+	}},
+	// Trilha de auditoria: triggers impedem alteração e exclusão de entradas
+	{versao: 4, comandos: []string{
+		`CREATE TABLE auditoria_notas (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			nota_id TEXT NOT NULL,
+			operacao TEXT NOT NULL,
+			ator TEXT NOT NULL,
+			momento TEXT NOT NULL,
+			versao_extrator TEXT NOT NULL,
+			alteracoes TEXT NOT NULL,
+			detalhes TEXT NOT NULL
+		)`,
+		`CREATE INDEX idx_auditoria_nota ON auditoria_notas (nota_id)`,
+		`CREATE TRIGGER auditoria_notas_sem_update BEFORE UPDATE ON auditoria_notas
+		BEGIN SELECT RAISE(ABORT, 'auditoria_notas aceita apenas inclusões'); END`,
+		`CREATE TRIGGER auditoria_notas_sem_delete BEFORE DELETE ON auditoria_notas
+		BEGIN SELECT RAISE(ABORT, 'auditoria_notas aceita apenas inclusões'); END`,
+	}},
+	// Índices únicos de duplicidade, como no PostgreSQL (ver migracoesPostgres)
+	{versao: 5, comandos: []string{
+		`CREATE UNIQUE INDEX idx_notas_unica_numero ON notas_fiscais (cnpj, numero, serie)
+			WHERE removido_em = '' AND numero <> ''`,
+		`CREATE UNIQUE INDEX idx_notas_unica_hash ON notas_fiscais (hash_arquivo)
+			WHERE removido_em = '' AND hash_arquivo <> ''`,
+	}},
+	// Redação de dados pessoais na trilha: uma linha em redacoes_auditoria, incluída e apagada
+	// na mesma transação por RedigirAuditoria, libera a alteração de ator, alterações e detalhes
+	// dos eventos daquela nota
+	{versao: 6, comandos: []string{
+		`CREATE TABLE redacoes_auditoria (nota_id TEXT PRIMARY KEY)`,
+		`DROP TRIGGER auditoria_notas_sem_update`,
+		`CREATE TRIGGER auditoria_notas_sem_update BEFORE UPDATE ON auditoria_notas
+		WHEN NOT EXISTS (SELECT 1 FROM redacoes_auditoria WHERE nota_id = OLD.nota_id)
+			OR NEW.id <> OLD.id OR NEW.nota_id <> OLD.nota_id OR NEW.operacao <> OLD.operacao
+			OR NEW.momento <> OLD.momento OR NEW.versao_extrator <> OLD.versao_extrator
+		BEGIN SELECT RAISE(ABORT, 'auditoria_notas aceita apenas inclusões e redações'); END`,
+	}},
+	// Documentos das coleções compartilhadas entre instâncias (ver ArmazemDocumentos)
+	{versao: 7, comandos: []string{
+		`CREATE TABLE documentos (
+			colecao TEXT NOT NULL,
+			id TEXT NOT NULL,
+			conteudo TEXT NOT NULL,
+			PRIMARY KEY (colecao, id)
+		)`,
+	}},
+}
+
+// criadoEmNormalizado converte "2006-01-02T15:04:05[.fração]Z" para o formato de formatoCriadoEm
+// em UTC: os 19 primeiros caracteres e a fração completada com zeros até 9 dígitos
+const criadoEmNormalizado = `substr(criado_em, 1, 19) || '.' ||
+	substr(CASE WHEN instr(criado_em, '.') > 0 THEN substr(criado_em, 21, length(criado_em) - 21) ELSE '' END || '000000000', 1, 9) || 'Z'`
+
+// NovoRepositorioSQLite abre (criando se necessário) o banco SQLite no caminho informado
+// e aplica as migrações pendentes
+func NovoRepositorioSQLite(caminho string) (NotaFiscalRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(caminho), 0755); err != nil {
+		return nil, err
+	}
+
+	// WAL permite leituras concorrentes; busy_timeout espera em vez de falhar em escritas concorrentes
+	db, err := sql.Open("sqlite", "file:"+caminho+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir SQLite: %v", err)
+	}
+
+	repo := &repositorioSQL{db: db}
+	if err := repo.migrar(migracoesSQLite); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := repo.verificarEmClaro(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
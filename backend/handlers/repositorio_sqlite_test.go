@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// abrirSQLiteTeste abre o repositório em um banco novo no diretório temporário do teste
+func abrirSQLiteTeste(t *testing.T) *repositorioSQL {
+	t.Helper()
+	repo, err := NovoRepositorioSQLite(filepath.Join(t.TempDir(), "notas.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo.(*repositorioSQL)
+}
+
+// conferirSerieVazia verifica que a série vazia equivale a qualquer série na gravação, como
+// em BuscarDuplicada, e que pares gravados antes da regra continuam podendo ser atualizados
+func conferirSerieVazia(t *testing.T, repo *repositorioSQL) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := repo.Salvar(ctx, notaTeste("a1", "500", "", "hash-a1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Salvar(ctx, notaTeste("b2", "500", "1", "hash-b2")); !errors.Is(err, ErrNotaDuplicada) {
+		t.Errorf("série 1 com a mesma nota sem série salva: %v, esperado ErrNotaDuplicada", err)
+	}
+	if err := repo.Salvar(ctx, notaTeste("c3", "600", "1", "hash-c3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Salvar(ctx, notaTeste("d4", "600", "", "hash-d4")); !errors.Is(err, ErrNotaDuplicada) {
+		t.Errorf("sem série com a mesma nota de série 1 salva: %v, esperado ErrNotaDuplicada", err)
+	}
+	if err := repo.Salvar(ctx, notaTeste("e5", "600", "2", "hash-e5")); err != nil {
+		t.Errorf("mesma nota com outra série: %v", err)
+	}
+
+	// A correção que muda a série para uma equivalente à de outra nota é rejeitada
+	corrigida := notaTeste("e5", "600", "", "hash-e5")
+	if err := repo.Atualizar(ctx, corrigida); !errors.Is(err, ErrNotaDuplicada) {
+		t.Errorf("correção para série vazia: %v, esperado ErrNotaDuplicada", err)
+	}
+
+	// Par gravado antes da regra (série alterada diretamente no banco): a revisão continua
+	if _, err := repo.db.Exec(repo.rebind(`UPDATE notas_fiscais SET serie = '' WHERE id = ?`), "e5"); err != nil {
+		t.Fatal(err)
+	}
+	aprovada := notaTeste("e5", "600", "", "hash-e5")
+	aprovada.Status = StatusAprovada
+	if err := repo.Atualizar(ctx, aprovada); err != nil {
+		t.Errorf("atualização de nota com par anterior à regra: %v", err)
+	}
+}
+
+// conferirSerieVaziaConcorrente salva ao mesmo tempo a mesma nota sem série e com série, em
+// arquivos diferentes: apenas uma gravação é aceita
+func conferirSerieVaziaConcorrente(t *testing.T, repos []NotaFiscalRepository) {
+	t.Helper()
+	ctx := context.Background()
+	for rodada := range 10 {
+		numero := "9" + string(rune('0'+rodada))
+		var wg sync.WaitGroup
+		erros := make([]error, 2)
+		for i, serie := range []string{"", "1"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				erros[i] = repos[i%len(repos)].Salvar(ctx, notaTeste(novoIDNota(), numero, serie, novoIDNota()))
+			}()
+		}
+		wg.Wait()
+
+		aceitas := 0
+		for _, err := range erros {
+			switch {
+			case err == nil:
+				aceitas++
+			case !errors.Is(err, ErrNotaDuplicada):
+				t.Fatalf("número %s: erro inesperado: %v", numero, err)
+			}
+		}
+		if aceitas != 1 {
+			t.Fatalf("número %s: %d gravações aceitas, esperada 1", numero, aceitas)
+		}
+	}
+}
+
+func TestSQLiteSerieVazia(t *testing.T) {
+	conferirSerieVazia(t, abrirSQLiteTeste(t))
+}
+
+func TestSQLiteSalvarConcorrenteSerieVazia(t *testing.T) {
+	conferirSerieVaziaConcorrente(t, []NotaFiscalRepository{abrirSQLiteTeste(t)})
+}
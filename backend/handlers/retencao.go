@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// atorRetencao identifica o expurgo automático na trilha de auditoria
+const atorRetencao = "retencao"
+
+// PoliticaRetencao define por quanto tempo as notas são guardadas antes do expurgo. O prazo
+// é contado a partir de 1º de janeiro do ano seguinte ao da emissão, como o prazo de guarda de
+// documentos fiscais (art. 173 do CTN).
+type PoliticaRetencao struct {
+	// Anos é o prazo padrão; 0 desativa o expurgo
+	Anos int `json:"anos"`
+	// AnosPorStatus substitui o prazo padrão para notas na situação indicada (ex.: rejeitada)
+	AnosPorStatus map[string]int `json:"anosPorStatus,omitempty"`
+	// DiasLotes é por quantos dias o resultado de um lote de /jobs é guardado após sua
+	// conclusão; 0 guarda indefinidamente
+	DiasLotes int `json:"diasLotes"`
+	// DiasEntregasWebhook é por quantos dias o registro de uma entrega de webhook concluída
+	// (entregue ou com falha) é guardado; 0 guarda indefinidamente
+	DiasEntregasWebhook int `json:"diasEntregasWebhook"`
+	// Intervalo entre as execuções do expurgo agendado
+	Intervalo time.Duration `json:"-"`
+}
+
+// PoliticaRetencaoEnv lê a política das variáveis RETENCAO_ANOS (padrão 5; 0 desativa),
+// RETENCAO_ANOS_POR_STATUS (ex.: rejeitada:1,pendente:5), RETENCAO_LOTES_DIAS (padrão 30;
+// 0 desativa), RETENCAO_WEBHOOKS_DIAS (padrão 30; 0 desativa) e RETENCAO_INTERVALO (padrão 24h)
+func PoliticaRetencaoEnv() (PoliticaRetencao, error) {
+	politica := PoliticaRetencao{Anos: 5, DiasLotes: 30, DiasEntregasWebhook: 30, Intervalo: 24 * time.Hour}
+
+	if valor := os.Getenv("RETENCAO_ANOS"); valor != "" {
+		anos, err := strconv.Atoi(valor)
+		if err != nil || anos < 0 {
+			return politica, fmt.Errorf("RETENCAO_ANOS inválido: %q", valor)
+		}
+		politica.Anos = anos
+	}
+	if valor := os.Getenv("RETENCAO_ANOS_POR_STATUS"); valor != "" {
+		politica.AnosPorStatus = map[string]int{}
+		for _, item := range strings.Split(valor, ",") {
+			status, prazo, ok := strings.Cut(strings.TrimSpace(item), ":")
+			anos, err := strconv.Atoi(prazo)
+			if !ok || err != nil || anos < 1 {
+				return politica, fmt.Errorf("RETENCAO_ANOS_POR_STATUS deve estar no formato status:anos,status:anos")
+			}
+			politica.AnosPorStatus[status] = anos
+		}
+	}
+	if valor := os.Getenv("RETENCAO_LOTES_DIAS"); valor != "" {
+		dias, err := strconv.Atoi(valor)
+		if err != nil || dias < 0 {
+			return politica, fmt.Errorf("RETENCAO_LOTES_DIAS inválido: %q", valor)
+		}
+		politica.DiasLotes = dias
+	}
+	if valor := os.Getenv("RETENCAO_WEBHOOKS_DIAS"); valor != "" {
+		dias, err := strconv.Atoi(valor)
+		if err != nil || dias < 0 {
+			return politica, fmt.Errorf("RETENCAO_WEBHOOKS_DIAS inválido: %q", valor)
+		}
+		politica.DiasEntregasWebhook = dias
+	}
+	if valor := os.Getenv("RETENCAO_INTERVALO"); valor != "" {
+		intervalo, err := time.ParseDuration(valor)
+		if err != nil || intervalo < time.Minute {
+			return politica, fmt.Errorf("RETENCAO_INTERVALO inválido: %q (mínimo 1m)", valor)
+		}
+		politica.Intervalo = intervalo
+	}
+	return politica, nil
+}
+
+// Ativa indica se a política expurga alguma nota ou lote
+func (p PoliticaRetencao) Ativa() bool {
+	return p.expurgaNotas() || p.DiasLotes > 0 || p.DiasEntregasWebhook > 0
+}
+
+// expurgaNotas indica se a política expurga alguma nota
+func (p PoliticaRetencao) expurgaNotas() bool {
+	return p.Anos > 0 || len(p.AnosPorStatus) > 0
+}
+
+// dataReferenciaRetencao é a data da nota usada no prazo de guarda: data de emissão,
+// competência ou, na falta delas, a data em que a nota foi salva
+func dataReferenciaRetencao(nota NotaFiscalData) time.Time {
+	switch {
+	case !nota.DataNota.IsZero():
+		return nota.DataNota.Time
+	case !nota.Competencia.IsZero():
+		return time.Date(nota.Competencia.Ano, nota.Competencia.Mes, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return nota.CriadoEm
+	}
+}
+
+// Vencimento retorna a data a partir da qual a nota pode ser expurgada; false se não expira
+func (p PoliticaRetencao) Vencimento(nota NotaFiscalData) (time.Time, bool) {
+	anos, ok := p.AnosPorStatus[nota.Status]
+	if !ok {
+		anos = p.Anos
+	}
+	referencia := dataReferenciaRetencao(nota)
+	if anos <= 0 || referencia.IsZero() {
+		return time.Time{}, false
+	}
+	return time.Date(referencia.Year()+1+anos, time.January, 1, 0, 0, 0, 0, time.UTC), true
+}
+
+// NotaExpurgada descreve uma nota apagada (ou que seria apagada, em simulação) pelo expurgo
+type NotaExpurgada struct {
+	ID         string    `json:"id"`
+	NumeroNota string    `json:"numeroNota"`
+	Status     string    `json:"status"`
+	VenceuEm   time.Time `json:"venceuEm"`
+}
+
+// RelatorioExpurgo resume uma execução do expurgo
+type RelatorioExpurgo struct {
+	Simulacao                 bool            `json:"simulacao"`
+	Verificadas               int             `json:"verificadas"`
+	Expurgadas                []NotaExpurgada `json:"expurgadas"`
+	ArquivosRemovidos         int             `json:"arquivosRemovidos"`
+	LotesExpurgados           int             `json:"lotesExpurgados"`
+	EntregasWebhookExpurgadas int             `json:"entregasWebhookExpurgadas"`
+	CopiasRedigidas           ContagemRedacao `json:"copiasRedigidas"`
+	Falhas                    []string        `json:"falhas"`
+}
+
+// referenciasArquivos conta quantas notas usam cada arquivo, para só apagar arquivos sem uso
+func referenciasArquivos(notas []NotaFiscalData) map[string]int {
+	referencias := map[string]int{}
+	for _, nota := range notas {
+		if nota.Arquivo != "" {
+			referencias[nota.Arquivo]++
+		}
+	}
+	return referencias
+}
+
+// expurgarLotes apaga os lotes concluídos ou com falha antes do limite, com os arquivos que
+// ainda restarem; com simular=true apenas os conta
+func expurgarLotes(limite time.Time, simular bool) (int, error) {
+	vencido := func(lote Lote) bool {
+		if lote.Status != StatusLoteConcluido && lote.Status != StatusLoteFalhou {
+			return false
+		}
+		fim := lote.UpdatedAt
+		if lote.FinishedAt != nil {
+			fim = *lote.FinishedAt
+		}
+		return fim.Before(limite)
+	}
+
+	if simular {
+		todos, err := lotes.listar()
+		return len(slices.DeleteFunc(todos, func(lote Lote) bool { return !vencido(lote) })), err
+	}
+	var vencidos []Lote
+	expurgados, err := lotes.atualizarTodos(func(_ string, lote *Lote) int {
+		if !vencido(*lote) {
+			return documentoMantido
+		}
+		vencidos = append(vencidos, *lote)
+		return documentoRemovido
+	})
+	for _, lote := range vencidos {
+		removerArquivosLote(lote)
+	}
+	return expurgados, err
+}
+
+// expurgarEntregasWebhook apaga o registro das entregas de webhook entregues ou com falha
+// antes do limite; com simular=true apenas as conta
+func expurgarEntregasWebhook(limite time.Time, simular bool) (int, error) {
+	vencida := func(entrega EntregaWebhook) bool {
+		if entrega.Status == EntregaPendente {
+			return false
+		}
+		fim := entrega.CriadoEm
+		if n := len(entrega.Tentativas); n > 0 {
+			fim = entrega.Tentativas[n-1].Momento
+		}
+		return fim.Before(limite)
+	}
+
+	if simular {
+		todas, err := entregasWebhook.listar()
+		return len(slices.DeleteFunc(todas, func(entrega EntregaWebhook) bool { return !vencida(entrega) })), err
+	}
+	return entregasWebhook.atualizarTodos(func(_ string, entrega *EntregaWebhook) int {
+		if !vencida(*entrega) {
+			return documentoMantido
+		}
+		return documentoRemovido
+	})
+}
+
+// ExpurgarNotas apaga os lotes de /jobs concluídos há mais de DiasLotes, as entregas de
+// webhook concluídas há mais de DiasEntregasWebhook e as notas
+// (inclusive removidas) cujo prazo de guarda venceu até agora,
+// junto com seus PDFs. Cada expurgo é registrado na trilha de auditoria, que é mantida com os
+// dados pessoais redigidos, assim como as cópias em lotes, webhooks e respostas idempotentes.
+func ExpurgarNotas(ctx context.Context, repo NotaFiscalRepository, store BlobStore, politica PoliticaRetencao, agora time.Time, simular bool) (RelatorioExpurgo, error) {
+	relatorio := RelatorioExpurgo{Simulacao: simular, Expurgadas: []NotaExpurgada{}, Falhas: []string{}}
+	if politica.DiasLotes > 0 {
+		expurgados, err := expurgarLotes(agora.AddDate(0, 0, -politica.DiasLotes), simular)
+		if err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("lotes: %v", err))
+		}
+		relatorio.LotesExpurgados = expurgados
+	}
+	if politica.DiasEntregasWebhook > 0 {
+		expurgadas, err := expurgarEntregasWebhook(agora.AddDate(0, 0, -politica.DiasEntregasWebhook), simular)
+		if err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("entregas de webhook: %v", err))
+		}
+		relatorio.EntregasWebhookExpurgadas = expurgadas
+	}
+	if !politica.expurgaNotas() {
+		return relatorio, nil
+	}
+
+	notas, err := repo.Listar(ctx, FiltroNotas{IncluirRemovidas: true})
+	if err != nil {
+		return relatorio, err
+	}
+	relatorio.Verificadas = len(notas)
+	referencias := referenciasArquivos(notas)
+
+	var vencidas []NotaFiscalData
+	var redacoes []redacaoNota
+	for _, nota := range notas {
+		vencimento, ok := politica.Vencimento(nota)
+		if !ok || agora.Before(vencimento) {
+			continue
+		}
+		if simular {
+			relatorio.Expurgadas = append(relatorio.Expurgadas, NotaExpurgada{ID: nota.ID, NumeroNota: nota.NumeroNota, Status: nota.Status, VenceuEm: vencimento})
+			continue
+		}
+		vencidas = append(vencidas, nota)
+		redacoes = append(redacoes, novaRedacao(nota, nil))
+	}
+	if len(vencidas) == 0 {
+		return relatorio, nil
+	}
+
+	// A redação vem antes da exclusão: a trilha continua acessível pelo ID da nota
+	copias, falhas := redigirDadosPessoais(ctx, repo, redacoes, atorRetencao)
+	relatorio.CopiasRedigidas = copias
+	relatorio.Falhas = append(relatorio.Falhas, falhas...)
+
+	for _, nota := range vencidas {
+		vencimento, _ := politica.Vencimento(nota)
+		expurgada := NotaExpurgada{ID: nota.ID, NumeroNota: nota.NumeroNota, Status: nota.Status, VenceuEm: vencimento}
+		evento := EventoAuditoria{
+			NotaID:   nota.ID,
+			Operacao: OperacaoExpurgo,
+			Ator:     atorRetencao,
+			Momento:  time.Now().UTC(),
+			Detalhes: fmt.Sprintf("prazo de retenção vencido em %s", vencimento.Format("2006-01-02")),
+		}
+		if err := repo.Excluir(ctx, nota.ID, evento); err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("nota %s: %v", nota.ID, err))
+			continue
+		}
+		relatorio.Expurgadas = append(relatorio.Expurgadas, expurgada)
+
+		// Notas antigas sem o campo arquivo usam <id>.pdf, exclusivo da nota
+		if nota.Arquivo != "" {
+			if referencias[nota.Arquivo]--; referencias[nota.Arquivo] > 0 {
+				continue
+			}
+		}
+		removido, err := removerArquivoNota(ctx, store, nota)
+		if err != nil {
+			relatorio.Falhas = append(relatorio.Falhas, fmt.Sprintf("arquivo da nota %s: %v", nota.ID, err))
+			continue
+		}
+		if removido {
+			relatorio.ArquivosRemovidos++
+		}
+	}
+	return relatorio, nil
+}
+
+// politicaRetencao é a política usada pelo expurgo agendado e pelo endpoint de expurgo
+var politicaRetencao = PoliticaRetencao{Anos: 5, DiasLotes: 30, DiasEntregasWebhook: 30, Intervalo: 24 * time.Hour}
+
+// IniciarRetencao configura a política e, se ativa, executa o expurgo na inicialização e a
+// cada intervalo, até o contexto ser cancelado
+func IniciarRetencao(ctx context.Context, politica PoliticaRetencao) {
+	politicaRetencao = politica
+	if !politica.Ativa() {
+		log.Println("Expurgo de notas, lotes e entregas de webhook desativado (RETENCAO_ANOS=0, RETENCAO_LOTES_DIAS=0 e RETENCAO_WEBHOOKS_DIAS=0)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(politica.Intervalo)
+		defer ticker.Stop()
+		for {
+			notasMu.Lock()
+			relatorio, err := ExpurgarNotas(ctx, notasRepo, blobs, politica, time.Now(), false)
+			notasMu.Unlock()
+			if err != nil {
+				log.Printf("Erro no expurgo de notas: %v", err)
+			} else if len(relatorio.Expurgadas) > 0 || relatorio.LotesExpurgados > 0 || relatorio.EntregasWebhookExpurgadas > 0 || len(relatorio.Falhas) > 0 {
+				log.Printf("Expurgo de notas: %d expurgadas, %d arquivos removidos, %d lotes expurgados, %d entregas de webhook expurgadas, %d falhas",
+					len(relatorio.Expurgadas), relatorio.ArquivosRemovidos, relatorio.LotesExpurgados, relatorio.EntregasWebhookExpurgadas, len(relatorio.Falhas))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ExpurgarNotasVencidas executa o expurgo imediatamente; com simular=true apenas lista as
+// notas que seriam apagadas
+func ExpurgarNotasVencidas(c *gin.Context) {
+	simular, _ := strconv.ParseBool(c.Query("simular"))
+
+	notasMu.Lock()
+	relatorio, err := ExpurgarNotas(c.Request.Context(), notasRepo, blobs, politicaRetencao, time.Now(), simular)
+	notasMu.Unlock()
+	if err != nil {
+		log.Printf("Erro no expurgo de notas: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao expurgar notas fiscais")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"politica":  politicaRetencao,
+		"relatorio": relatorio,
+	})
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Situações de revisão de uma nota fiscal salva
+const (
+	StatusPendente  = "pendente"
+	StatusAprovada  = "aprovada"
+	StatusRejeitada = "rejeitada"
+	StatusCorrigida = "corrigida"
+)
+
+// camposEditaveis lista os campos (nomes JSON de NotaFiscalData) que podem ser corrigidos na revisão
+var camposEditaveis = map[string]bool{
+	"numeroNota":    true,
+	"serie":         true,
+	"competencia":   true,
+	"prestador":     true,
+	"cnpj":          true,
+	"valorServicos": true,
+	"dataNota":      true,
+	"issRetido":     true,
+}
+
+// CorrecaoRequest representa a correção de campos de uma nota fiscal
+type CorrecaoRequest struct {
+	Revisor string                     `json:"revisor"`
+	Campos  map[string]json.RawMessage `json:"campos"`
+}
+
+// DecisaoRequest representa a aprovação ou rejeição de uma nota fiscal
+type DecisaoRequest struct {
+	Revisor string `json:"revisor"`
+	Motivo  string `json:"motivo"`
+}
+
+// aplicarCorrecoes substitui os campos informados, validando os novos valores e preservando
+// em ValoresOriginais o valor extraído antes da primeira correção de cada campo
+func aplicarCorrecoes(nota *NotaFiscalData, campos map[string]json.RawMessage) error {
+	if len(campos) == 0 {
+		return errors.New("nenhum campo informado")
+	}
+
+	atual, err := json.Marshal(nota)
+	if err != nil {
+		return err
+	}
+	var valores map[string]json.RawMessage
+	if err := json.Unmarshal(atual, &valores); err != nil {
+		return err
+	}
+
+	originais := make(map[string]json.RawMessage, len(nota.ValoresOriginais)+len(campos))
+	for campo, valor := range nota.ValoresOriginais {
+		originais[campo] = valor
+	}
+
+	for campo, valor := range campos {
+		if !camposEditaveis[campo] {
+			return fmt.Errorf("campo %q não pode ser corrigido", campo)
+		}
+		if _, ok := originais[campo]; !ok {
+			originais[campo] = valores[campo]
+		}
+		valores[campo] = valor
+	}
+
+	corrigido, err := json.Marshal(valores)
+	if err != nil {
+		return err
+	}
+	var nova NotaFiscalData
+	if err := json.Unmarshal(corrigido, &nova); err != nil {
+		return fmt.Errorf("valor inválido: %v", err)
+	}
+	// Os valores corrigidos passam pelos mesmos limites dos extraídos no envio
+	sanitizarCamposNota(&nova)
+	if _, ok := campos["numeroNota"]; ok && nova.NumeroNota == "" {
+		return errors.New("numeroNota não pode ficar vazio")
+	}
+
+	nova.ValoresOriginais = originais
+	*nota = nova
+	return nil
+}
+
+// carregarNota carrega a nota pelo ID da rota, respondendo com erro se não for possível.
+// Notas removidas são tratadas como inexistentes.
+func carregarNota(c *gin.Context) (NotaFiscalData, bool) {
+	nota, err := notasRepo.BuscarPorID(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrNotaNaoEncontrada) || (err == nil && nota.RemovidoEm != nil) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Nota fiscal não encontrada")
+		return nota, false
+	}
+	if err != nil {
+		log.Printf("Erro ao carregar nota fiscal %s: %v", c.Param("id"), err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar nota fiscal")
+		return nota, false
+	}
+	return nota, true
+}
+
+// CorrigirNotaFiscal corrige campos de uma nota fiscal salva, marcando-a como corrigida.
+// Uma nota corrigida precisa ser aprovada novamente para ser exportada.
+func CorrigirNotaFiscal(c *gin.Context) {
+	var req CorrecaoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados da correção inválidos: "+err.Error())
+		return
+	}
+	req.Revisor = sanitizarTexto(req.Revisor, maxRevisor)
+	if req.Revisor == "" {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Revisor é obrigatório")
+		return
+	}
+
+	notasMu.Lock()
+	defer notasMu.Unlock()
+
+	nota, ok := carregarNota(c)
+	if !ok {
+		return
+	}
+	antes := nota
+
+	if err := aplicarCorrecoes(&nota, req.Campos); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Correção inválida: "+err.Error())
+		return
+	}
+
+	agora := time.Now()
+	nota.Status = StatusCorrigida
+	nota.Revisor = req.Revisor
+	nota.RevisadoEm = &agora
+	nota.MotivoRejeicao = ""
+
+	evento := novoEvento(OperacaoCorrecao, atorRequisicao(c, req.Revisor), &antes, nota)
+	if err := notasRepo.Atualizar(c.Request.Context(), nota, evento); errors.Is(err, ErrNotaDuplicada) {
+		responderErro(c, http.StatusConflict, CodigoNotaDuplicada, "A correção torna a nota duplicada de outra nota já salva")
+		return
+	} else if err != nil {
+		log.Printf("Erro ao salvar nota fiscal %s: %v", nota.ID, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar nota fiscal")
+		return
+	}
+
+	log.Printf("Nota fiscal %s corrigida por %s", nota.ID, req.Revisor)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Nota fiscal corrigida com sucesso",
+		"data":    nota,
+	})
+}
+
+// decidirRevisao aprova ou rejeita uma nota pendente ou corrigida
+func decidirRevisao(c *gin.Context, status string) {
+	var req DecisaoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados da revisão inválidos: "+err.Error())
+		return
+	}
+	req.Revisor = sanitizarTexto(req.Revisor, maxRevisor)
+	req.Motivo = sanitizarTexto(req.Motivo, maxMotivo)
+	if req.Revisor == "" {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Revisor é obrigatório")
+		return
+	}
+	if status == StatusRejeitada && req.Motivo == "" {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Motivo da rejeição é obrigatório")
+		return
+	}
+
+	notasMu.Lock()
+	defer notasMu.Unlock()
+
+	nota, ok := carregarNota(c)
+	if !ok {
+		return
+	}
+
+	antes := nota
+	if nota.Status != StatusPendente && nota.Status != StatusCorrigida {
+		responderErro(c, http.StatusConflict, CodigoSituacaoInvalida, fmt.Sprintf("Nota fiscal com status %q não pode ser revisada; corrija-a antes", nota.Status))
+		return
+	}
+
+	agora := time.Now()
+	nota.Status = status
+	nota.Revisor = req.Revisor
+	nota.RevisadoEm = &agora
+	nota.MotivoRejeicao = ""
+	operacao := OperacaoAprovacao
+	if status == StatusRejeitada {
+		nota.MotivoRejeicao = req.Motivo
+		operacao = OperacaoRejeicao
+	}
+
+	evento := novoEvento(operacao, atorRequisicao(c, req.Revisor), &antes, nota)
+	evento.Detalhes = req.Motivo
+	if err := notasRepo.Atualizar(c.Request.Context(), nota, evento); err != nil {
+		log.Printf("Erro ao salvar nota fiscal %s: %v", nota.ID, err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar nota fiscal")
+		return
+	}
+
+	log.Printf("Nota fiscal %s %s por %s", nota.ID, status, req.Revisor)
+	if status == StatusAprovada {
+		publicarEvento(EventoNotaAprovada, gin.H{"nota": nota})
+	} else {
+		publicarEvento(EventoNotaRejeitada, gin.H{"nota": nota})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Revisão registrada com sucesso",
+		"data":    nota,
+	})
+}
+
+// AprovarNotaFiscal aprova uma nota fiscal pendente ou corrigida
+func AprovarNotaFiscal(c *gin.Context) {
+	decidirRevisao(c, StatusAprovada)
+}
+
+// RejeitarNotaFiscal rejeita uma nota fiscal pendente ou corrigida, informando o motivo
+func RejeitarNotaFiscal(c *gin.Context) {
+	decidirRevisao(c, StatusRejeitada)
+}
+
+// celulaCSV protege um texto exportado contra injeção de fórmulas: planilhas interpretam
+// células iniciadas por =, +, -, @ (ou tabulação e retorno de carro) como fórmulas, então o
+// valor recebe um apóstrofo no início e é exibido como texto
+func celulaCSV(valor string) string {
+	if valor != "" && strings.ContainsRune("=+-@\t\r", rune(valor[0])) {
+		return "'" + valor
+	}
+	return valor
+}
+
+// ExportarNotasFiscais exporta apenas as notas aprovadas, em CSV (padrão) ou JSON (formato=json).
+// Aceita os mesmos filtros de período de BuscarNotasFiscais.
+func ExportarNotasFiscais(c *gin.Context) {
+	filtro, err := parseFiltroPeriodo(c)
+	if err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
+		return
+	}
+
+	aprovadas, err := notasRepo.Listar(c.Request.Context(), FiltroNotas{Periodo: filtro, Status: StatusAprovada})
+	if err != nil {
+		log.Printf("Erro ao carregar notas fiscais: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+	if aprovadas == nil {
+		aprovadas = []NotaFiscalData{}
+	}
+
+	if c.Query("formato") == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"notas_fiscais": aprovadas,
+			"total":         len(aprovadas),
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="notas-fiscais-aprovadas.csv"`)
+
+	// Ponto e vírgula como separador, padrão do Excel em português
+	w := csv.NewWriter(c.Writer)
+	w.Comma = ';'
+	w.Write([]string{"id", "numeroNota", "serie", "competencia", "dataNota", "prestador", "cnpj", "valorServicos", "issRetido", "valorLiquido", "email", "revisor", "revisadoEm"})
+	for _, nota := range aprovadas {
+		revisadoEm := ""
+		if nota.RevisadoEm != nil {
+			revisadoEm = nota.RevisadoEm.Format(time.RFC3339)
+		}
+		// Textos vindos da extração ou do usuário passam por celulaCSV; os demais são formatados aqui
+		w.Write([]string{
+			nota.ID,
+			celulaCSV(nota.NumeroNota),
+			celulaCSV(nota.Serie),
+			nota.Competencia.String(),
+			nota.DataNota.String(),
+			celulaCSV(nota.Prestador),
+			celulaCSV(nota.CNPJ),
+			strconv.FormatFloat(nota.ValorServicos, 'f', 2, 64),
+			strconv.FormatFloat(nota.ISSRetido, 'f', 2, 64),
+			strconv.FormatFloat(nota.ValorServicos-nota.ISSRetido, 'f', 2, 64),
+			celulaCSV(nota.Email),
+			celulaCSV(nota.Revisor),
+			revisadoEm,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Erro ao exportar notas fiscais: %v", err)
+	}
+}
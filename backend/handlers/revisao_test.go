@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCelulaCSV(t *testing.T) {
+	casos := map[string]string{
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+5511999999999":           "'+5511999999999",
+		"-2+3":                     "'-2+3",
+		"@SUM(A1:A2)":              "'@SUM(A1:A2)",
+		"\t=1":                     "'\t=1",
+		"Prestador LTDA":           "Prestador LTDA",
+		"12.345.678/0001-90":       "12.345.678/0001-90",
+		"":                         "",
+	}
+	for valor, esperado := range casos {
+		if obtido := celulaCSV(valor); obtido != esperado {
+			t.Errorf("celulaCSV(%q) = %q, esperado %q", valor, obtido, esperado)
+		}
+	}
+}
+
+func TestExportarNotasFiscaisEscapaFormulas(t *testing.T) {
+	t.Chdir(t.TempDir())
+	anterior := notasRepo
+	notasRepo = NovoRepositorioArquivos(uploadDir)
+	t.Cleanup(func() { notasRepo = anterior })
+
+	agora := time.Now()
+	nota := NotaFiscalData{
+		ID:         "n1",
+		NumeroNota: "123",
+		Prestador:  `=HYPERLINK("http://exemplo.invalid","clique")`,
+		CNPJ:       "12345678000190",
+		Email:      "@titular@exemplo.com",
+		Revisor:    "+revisor",
+		Status:     StatusAprovada,
+		RevisadoEm: &agora,
+		CriadoEm:   agora,
+	}
+	if err := notasRepo.Salvar(context.Background(), nota); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/notas-fiscais/exportar", ExportarNotasFiscais)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notas-fiscais/exportar", http.NoBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	leitor := csv.NewReader(w.Body)
+	leitor.Comma = ';'
+	linhas, err := leitor.ReadAll()
+	if err != nil || len(linhas) != 2 {
+		t.Fatalf("%d linhas, erro %v", len(linhas), err)
+	}
+	cabecalho, linha := linhas[0], linhas[1]
+	for i, coluna := range cabecalho {
+		if celulaCSV(linha[i]) != linha[i] {
+			t.Errorf("coluna %s começa com caractere de fórmula: %q", coluna, linha[i])
+		}
+	}
+	if linha[5] != `'=HYPERLINK("http://exemplo.invalid","clique")` {
+		t.Errorf("prestador exportado: %q", linha[5])
+	}
+}
+
+func TestAplicarCorrecoesSanitiza(t *testing.T) {
+	nota := NotaFiscalData{ID: "n1", NumeroNota: "123", Serie: "1", Prestador: "Prestador", CNPJ: "12345678000190"}
+	campos := map[string]json.RawMessage{
+		"prestador":  json.RawMessage(`"  Novo\u0000 Prestador\u001b[31m  "`),
+		"serie":      json.RawMessage(`"` + strings.Repeat("9", 50) + `"`),
+		"numeroNota": json.RawMessage(`" 456\r\n"`),
+	}
+	if err := aplicarCorrecoes(&nota, campos); err != nil {
+		t.Fatal(err)
+	}
+	if nota.Prestador != "Novo Prestador[31m" {
+		t.Errorf("prestador = %q", nota.Prestador)
+	}
+	if len(nota.Serie) != maxSerieNota {
+		t.Errorf("série com %d caracteres, máximo %d", len(nota.Serie), maxSerieNota)
+	}
+	if nota.NumeroNota != "456" {
+		t.Errorf("número = %q", nota.NumeroNota)
+	}
+
+	vazio := NotaFiscalData{ID: "n2", NumeroNota: "123"}
+	if err := aplicarCorrecoes(&vazio, map[string]json.RawMessage{"numeroNota": json.RawMessage(`" \u0007 "`)}); err == nil {
+		t.Error("número corrigido para vazio foi aceito")
+	}
+}
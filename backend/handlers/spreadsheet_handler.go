@@ -24,9 +24,7 @@ func ProcessSpreadsheet(c *gin.Context) {
 	// Obter o arquivo do formulário
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Erro ao obter arquivo: " + err.Error(),
-		})
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao obter arquivo: "+err.Error())
 		return
 	}
 	defer file.Close()
@@ -42,16 +40,13 @@ func ProcessSpreadsheet(c *gin.Context) {
 	case ".csv":
 		data, err = processCSVFile(file)
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Formato de arquivo não suportado. Use .xlsx, .xls ou .csv",
-		})
+		responderErro(c, http.StatusBadRequest, CodigoArquivoInvalido, "Formato de arquivo não suportado. Use .xlsx, .xls ou .csv")
 		return
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Erro ao processar arquivo: " + err.Error(),
-		})
+		// Falhas de leitura vêm do conteúdo enviado (planilha corrompida ou CSV malformado)
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao processar arquivo: "+err.Error())
 		return
 	}
 
@@ -170,9 +165,7 @@ func GetSpreadsheetPreview(c *gin.Context) {
 	// Obter o arquivo do formulário
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Erro ao obter arquivo: " + err.Error(),
-		})
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao obter arquivo: "+err.Error())
 		return
 	}
 	defer file.Close()
@@ -188,16 +181,13 @@ func GetSpreadsheetPreview(c *gin.Context) {
 	case ".csv":
 		data, err = processCSVFile(file)
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Formato de arquivo não suportado. Use .xlsx, .xls ou .csv",
-		})
+		responderErro(c, http.StatusBadRequest, CodigoArquivoInvalido, "Formato de arquivo não suportado. Use .xlsx, .xls ou .csv")
 		return
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Erro ao processar arquivo: " + err.Error(),
-		})
+		// Falhas de leitura vêm do conteúdo enviado (planilha corrompida ou CSV malformado)
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Erro ao processar arquivo: "+err.Error())
 		return
 	}
 
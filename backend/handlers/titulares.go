@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Atendimento aos titulares de dados pessoais (LGPD, art. 18): exportação e anonimização de
+// tudo o que está ligado a um e-mail de envio ou a um CPF de prestador pessoa física.
+
+// prestadorAnonimizado substitui o nome do prestador pessoa física nas notas anonimizadas
+const prestadorAnonimizado = "Titular anonimizado"
+
+// Titular identifica o titular dos dados por e-mail ou CPF (um dos dois)
+type Titular struct {
+	Email string `json:"email,omitempty"`
+	CPF   string `json:"cpf,omitempty"`
+}
+
+// validar normaliza e confere a identificação do titular
+func (t *Titular) validar() error {
+	t.Email = strings.ToLower(strings.TrimSpace(t.Email))
+	t.CPF = normalizarCNPJ(t.CPF)
+	if (t.Email == "") == (t.CPF == "") {
+		return errors.New("informe o e-mail ou o CPF do titular")
+	}
+	if t.Email != "" && !strings.Contains(t.Email, "@") {
+		return errors.New("e-mail inválido")
+	}
+	if t.Email == "" && len(t.CPF) != 11 {
+		return errors.New("CPF deve ter 11 dígitos")
+	}
+	return nil
+}
+
+// notasDoTitular retorna as notas (inclusive removidas) ligadas ao titular
+func notasDoTitular(ctx context.Context, titular Titular) ([]NotaFiscalData, error) {
+	filtro := FiltroNotas{Email: titular.Email, CNPJ: titular.CPF, IncluirRemovidas: true}
+	return notasRepo.Listar(ctx, filtro)
+}
+
+// NotaTitular é uma nota exportada ao titular, com sua trilha de auditoria
+type NotaTitular struct {
+	Nota      NotaFiscalData    `json:"nota"`
+	Historico []EventoAuditoria `json:"historico"`
+}
+
+// ExportarDadosTitular reúne as notas, os históricos e os contratos ligados ao e-mail
+// (email) ou CPF (cpf) informado. Cada nota exportada recebe um registro na auditoria.
+// Com download=true a resposta é enviada como arquivo.
+func ExportarDadosTitular(c *gin.Context) {
+	titular := Titular{Email: c.Query("email"), CPF: c.Query("cpf")}
+	if err := titular.validar(); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
+		return
+	}
+	ctx := c.Request.Context()
+	ator := atorRequisicao(c, "")
+
+	notasMu.Lock()
+	defer notasMu.Unlock()
+
+	notas, err := notasDoTitular(ctx, titular)
+	if err != nil {
+		log.Printf("Erro ao buscar notas do titular: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+
+	exportadas := []NotaTitular{}
+	for _, nota := range notas {
+		evento := novoEvento(OperacaoExportacaoTitular, ator, &nota, nota)
+		evento.Detalhes = "exportação de dados solicitada pelo titular"
+		if err := notasRepo.Atualizar(ctx, nota, evento); err != nil {
+			log.Printf("Erro ao registrar exportação da nota fiscal %s: %v", nota.ID, err)
+			responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao registrar exportação")
+			return
+		}
+		historico, err := notasRepo.Historico(ctx, nota.ID)
+		if err != nil {
+			log.Printf("Erro ao carregar histórico da nota fiscal %s: %v", nota.ID, err)
+			responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar histórico")
+			return
+		}
+		exportadas = append(exportadas, NotaTitular{Nota: nota, Historico: historico})
+	}
+
+	// Contratos e pedidos de compra com o titular como fornecedor pessoa física
+	contratosTitular := []Contrato{}
+	if titular.CPF != "" {
+		todos, err := contratos.listar()
+		if err != nil {
+			log.Printf("Erro ao listar contratos: %v", err)
+			responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar contratos")
+			return
+		}
+		for _, contrato := range todos {
+			if normalizarCNPJ(contrato.CNPJFornecedor) == titular.CPF {
+				contratosTitular = append(contratosTitular, contrato)
+			}
+		}
+	}
+
+	log.Printf("Dados do titular exportados: %d notas, %d contratos", len(exportadas), len(contratosTitular))
+	if download, _ := strconv.ParseBool(c.Query("download")); download {
+		c.Header("Content-Disposition", `attachment; filename="dados-titular.json"`)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"titular":       titular,
+		"gerado_em":     time.Now().UTC(),
+		"notas_fiscais": exportadas,
+		"contratos":     contratosTitular,
+	})
+}
+
+// AnonimizacaoRequest identifica o titular e o motivo da anonimização
+type AnonimizacaoRequest struct {
+	Titular
+	// Protocolo é a referência da solicitação do titular, registrada na auditoria
+	Protocolo string `json:"protocolo"`
+	Motivo    string `json:"motivo"`
+}
+
+// anonimizarNota remove da nota os dados pessoais do titular. Os dados fiscais (número,
+// valores, datas) são mantidos pelo prazo de guarda; o PDF, que contém os dados pessoais, é apagado.
+func anonimizarNota(nota NotaFiscalData, titular Titular) NotaFiscalData {
+	if titular.Email != "" && strings.EqualFold(nota.Email, titular.Email) {
+		nota.Email = ""
+	}
+	if titular.CPF != "" && normalizarCNPJ(nota.CNPJ) == titular.CPF {
+		nota.CNPJ = ""
+		nota.Prestador = prestadorAnonimizado
+	}
+	nota.Arquivo = ""
+	nota.HashArquivo = ""
+	nota.NomeArquivo = ""
+	for _, campo := range []string{"email", "cnpj", "prestador"} {
+		delete(nota.ValoresOriginais, campo)
+	}
+	return nota
+}
+
+// AnonimizarDadosTitular anonimiza todas as notas ligadas ao e-mail ou CPF informado e apaga
+// seus PDFs. A auditoria registra quais campos foram anonimizados, sem os valores anteriores,
+// e os dados apagados são redigidos da trilha e das demais cópias (ver redigirDadosPessoais).
+func AnonimizarDadosTitular(c *gin.Context) {
+	var req AnonimizacaoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados da solicitação inválidos: "+err.Error())
+		return
+	}
+	if err := req.Titular.validar(); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, err.Error())
+		return
+	}
+	ctx := c.Request.Context()
+	ator := atorRequisicao(c, "")
+
+	notasMu.Lock()
+	defer notasMu.Unlock()
+
+	notas, err := notasDoTitular(ctx, req.Titular)
+	if err != nil {
+		log.Printf("Erro ao buscar notas do titular: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+	todas, err := notasRepo.Listar(ctx, FiltroNotas{IncluirRemovidas: true})
+	if err != nil {
+		log.Printf("Erro ao listar notas fiscais: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao buscar notas fiscais")
+		return
+	}
+	referencias := referenciasArquivos(todas)
+
+	detalhes := "anonimização solicitada pelo titular"
+	if req.Protocolo != "" {
+		detalhes += ", protocolo " + req.Protocolo
+	}
+	if req.Motivo != "" {
+		detalhes += ": " + req.Motivo
+	}
+
+	anonimizadas, arquivosRemovidos := 0, 0
+	falhas := []string{}
+	var redacoes []redacaoNota
+	for _, nota := range notas {
+		anonima := anonimizarNota(nota, req.Titular)
+		evento := novoEvento(OperacaoAnonimizacao, ator, &nota, anonima)
+		// Os valores anteriores são justamente os dados a eliminar
+		for campo, alteracao := range evento.Alteracoes {
+			alteracao.Antes = json.RawMessage("null")
+			evento.Alteracoes[campo] = alteracao
+		}
+		evento.Detalhes = detalhes
+		if err := notasRepo.Atualizar(ctx, anonima, evento); err != nil {
+			log.Printf("Erro ao anonimizar nota fiscal %s: %v", nota.ID, err)
+			falhas = append(falhas, fmt.Sprintf("nota %s: erro ao anonimizar", nota.ID))
+			continue
+		}
+		anonimizadas++
+		redacoes = append(redacoes, novaRedacao(nota, &anonima))
+
+		// Notas antigas sem o campo arquivo usam <id>.pdf, exclusivo da nota
+		if nota.Arquivo != "" {
+			if referencias[nota.Arquivo]--; referencias[nota.Arquivo] > 0 {
+				continue
+			}
+		}
+		removido, err := removerArquivoNota(ctx, blobs, nota)
+		if err != nil {
+			log.Printf("Erro ao apagar arquivo da nota fiscal %s: %v", nota.ID, err)
+			falhas = append(falhas, fmt.Sprintf("nota %s: erro ao apagar arquivo", nota.ID))
+			continue
+		}
+		if removido {
+			arquivosRemovidos++
+		}
+	}
+
+	// Os dados apagados também saem das entradas anteriores da trilha e das cópias em lotes,
+	// webhooks e respostas idempotentes
+	copias, falhasRedacao := redigirDadosPessoais(ctx, notasRepo, redacoes, ator)
+	falhas = append(falhas, falhasRedacao...)
+
+	log.Printf("Titular anonimizado: %d notas, %d arquivos apagados, %d cópias redigidas, %d falhas", anonimizadas, arquivosRemovidos, copias.Total(), len(falhas))
+	resultado := gin.H{
+		"notas_anonimizadas": anonimizadas,
+		"arquivos_removidos": arquivosRemovidos,
+		"copias_redigidas":   copias,
+		"falhas":             falhas,
+	}
+	if len(falhas) > 0 {
+		responderErroDetalhes(c, http.StatusInternalServerError, CodigoFalhaParcial, "Anonimização concluída com falhas", resultado)
+		return
+	}
+	c.JSON(http.StatusOK, resultado)
+}
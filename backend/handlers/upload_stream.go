@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event types sent by /upload in the SSE and NDJSON protocols
+const (
+	EventoArquivoIniciado = "file_started"
+	EventoRegistro        = "record"
+	EventoArquivoFalhou   = "file_failed"
+	EventoProgresso       = "progress"
+	EventoConcluido       = "done"
+)
+
+// Stream formats accepted by /upload
+const (
+	formatoStreamLegado = "legado" // JSON objects separated by "\n---\n" (default)
+	formatoStreamSSE    = "sse"    // text/event-stream
+	formatoStreamNDJSON = "ndjson" // one JSON event per line
+)
+
+// EventoUpload is one event of the /upload stream. File and Index identify the source file
+// (Index is zero-based); done has no file and its Index equals Total.
+type EventoUpload struct {
+	Type      string        `json:"type"`
+	File      string        `json:"file,omitempty"`
+	Index     int           `json:"index"`
+	Total     int           `json:"total"`
+	Record    *NFSeData     `json:"record,omitempty"`
+	Code      string        `json:"code,omitempty"`  // file_failed: one of the Codigo* codes
+	Error     string        `json:"error,omitempty"` // file_failed: message for the user
+	Processed int           `json:"processed,omitempty"`
+	Summary   *ResumoUpload `json:"summary,omitempty"` // done
+}
+
+// FalhaUpload identifies a file that could not be processed
+type FalhaUpload struct {
+	File  string `json:"file"`
+	Index int    `json:"index"`
+	Code  string `json:"code"`
+}
+
+// ResumoUpload is the summary sent in the done event
+type ResumoUpload struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Records   int           `json:"records"`
+	Failures  []FalhaUpload `json:"failures"`
+}
+
+// mensagensErroUpload are the user-facing messages for each error code
+var mensagensErroUpload = map[string]string{
+	CodigoArquivoIlegivel:    "Não foi possível ler o arquivo",
+	CodigoArquivoInvalido:    "O arquivo não é um PDF nem um XML de NFS-e",
+	CodigoConversao:          "Não foi possível converter o PDF em imagem",
+	CodigoProvedor:           "O serviço de extração retornou um erro; tente novamente",
+	CodigoJSONInvalido:       "O serviço de extração retornou uma resposta em formato inválido",
+	CodigoXMLInvalido:        "O XML não está no layout de NFS-e esperado",
+	CodigoResultadoVazio:     "Nenhum dado de nota fiscal foi encontrado no arquivo",
+	CodigoFalhaExtracao:      "Erro ao extrair os dados do arquivo",
+	CodigoCompactadoInvalido: "O arquivo compactado está corrompido ou contém caminhos inválidos",
+	CodigoLimiteCompactado:   "O arquivo compactado excede o limite de arquivos ou de tamanho descompactado",
+}
+
+// formatoStreamUpload picks the stream format from the formato query parameter or the Accept
+// header; clients that ask for neither get the legacy format the frontend used to parse
+func formatoStreamUpload(c *gin.Context) (string, error) {
+	switch formato := c.Query("formato"); formato {
+	case formatoStreamSSE, formatoStreamNDJSON, formatoStreamLegado:
+		return formato, nil
+	case "":
+	default:
+		return "", fmt.Errorf("formato inválido: %q (use sse, ndjson ou legado)", formato)
+	}
+
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "text/event-stream"):
+		return formatoStreamSSE, nil
+	case strings.Contains(accept, "application/x-ndjson"):
+		return formatoStreamNDJSON, nil
+	default:
+		return formatoStreamLegado, nil
+	}
+}
+
+// streamUpload writes /upload events in the negotiated format and flushes each one
+type streamUpload struct {
+	w       gin.ResponseWriter
+	flusher http.Flusher
+	formato string
+	seq     int
+}
+
+// novoStreamUpload sets the response headers for the format; the status is sent with the first event
+func novoStreamUpload(c *gin.Context, flusher http.Flusher, formato string) *streamUpload {
+	switch formato {
+	case formatoStreamSSE:
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("X-Accel-Buffering", "no")
+	case formatoStreamNDJSON:
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("X-Accel-Buffering", "no")
+	default:
+		c.Header("Content-Type", "application/octet-stream")
+	}
+	return &streamUpload{w: c.Writer, flusher: flusher, formato: formato}
+}
+
+// enviar writes one event. The legacy format only carries records, as before.
+func (s *streamUpload) enviar(evento EventoUpload) error {
+	var err error
+	switch s.formato {
+	case formatoStreamSSE:
+		s.seq++
+		var dados []byte
+		if dados, err = json.Marshal(evento); err == nil {
+			_, err = fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", s.seq, evento.Type, dados)
+		}
+	case formatoStreamNDJSON:
+		var dados []byte
+		if dados, err = json.Marshal(evento); err == nil {
+			_, err = fmt.Fprintf(s.w, "%s\n", dados)
+		}
+	default:
+		if evento.Type != EventoRegistro {
+			return nil
+		}
+		var dados []byte
+		if dados, err = json.Marshal(evento.Record); err == nil {
+			// Use a separator to distinguish between JSON objects
+			_, err = fmt.Fprintf(s.w, "%s\n---\n", dados)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
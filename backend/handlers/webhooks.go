@@ -0,0 +1,730 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Webhooks: sistemas externos (ERP) assinam eventos do ciclo de vida das notas e recebem um
+// POST assinado com HMAC a cada evento, com novas tentativas e registro de cada entrega. Com o
+// repositório SQL, assinaturas e entregas são vistas por todas as instâncias: cada tentativa é
+// reservada pela instância que a faz, e entregas pendentes de uma instância que parou são
+// retomadas pelas outras. O registro das entregas concluídas expira com a política de retenção.
+
+// Eventos enviados aos webhooks
+const (
+	EventoNotaSalva      = "nota.salva"      // nota enviada e salva
+	EventoNotaValidada   = "nota.validada"   // conciliada com um contrato, sem divergências
+	EventoNotaDivergente = "nota.divergente" // sem contrato, contrato expirado ou teto excedido
+	EventoNotaAprovada   = "nota.aprovada"
+	EventoNotaRejeitada  = "nota.rejeitada"
+)
+
+// eventosWebhook são os eventos que podem ser assinados; "*" assina todos
+var eventosWebhook = []string{EventoNotaSalva, EventoNotaValidada, EventoNotaDivergente, EventoNotaAprovada, EventoNotaRejeitada}
+
+// Cabeçalhos das entregas
+const (
+	CabecalhoAssinaturaWebhook = "X-NF-Assinatura" // t=<unix>,v1=<hex HMAC-SHA256 de "<t>.<corpo>">
+	CabecalhoEventoWebhook     = "X-NF-Evento"
+	CabecalhoEntregaWebhook    = "X-NF-Entrega" // ID da entrega, para o receptor descartar repetições
+)
+
+// Situações de uma entrega
+const (
+	EntregaPendente = "pendente"
+	EntregaEntregue = "entregue"
+	EntregaFalhou   = "falhou"
+)
+
+// AssinaturaWebhook é a inscrição de uma URL em eventos
+type AssinaturaWebhook struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Eventos  []string  `json:"eventos"`
+	Segredo  string    `json:"segredo,omitempty"` // só retornado na criação
+	CriadoEm time.Time `json:"criadoEm"`
+}
+
+// TentativaEntrega registra uma chamada à URL da assinatura
+type TentativaEntrega struct {
+	Momento    time.Time     `json:"momento"`
+	StatusHTTP int           `json:"statusHttp,omitempty"`
+	Erro       string        `json:"erro,omitempty"`
+	Duracao    time.Duration `json:"duracao"`
+}
+
+// EntregaWebhook é o envio de um evento a uma assinatura
+type EntregaWebhook struct {
+	ID               string             `json:"id"`
+	AssinaturaID     string             `json:"assinaturaId"`
+	Evento           string             `json:"evento"`
+	Payload          json.RawMessage    `json:"payload"`
+	Status           string             `json:"status"`
+	Tentativas       []TentativaEntrega `json:"tentativas"`
+	ProximaTentativa *time.Time         `json:"proximaTentativa,omitempty"`
+	ReenvioDe        string             `json:"reenvioDe,omitempty"`
+	CriadoEm         time.Time          `json:"criadoEm"`
+	EntregueEm       *time.Time         `json:"entregueEm,omitempty"`
+	// ReservadaAte é o fim da reserva da instância que faz a tentativa em andamento; não é
+	// enviado nas respostas
+	ReservadaAte *time.Time `json:"reservadaAte,omitempty"`
+}
+
+// publico retorna a entrega como enviada nas respostas da API, sem os dados internos
+func (e EntregaWebhook) publico() EntregaWebhook {
+	e.ReservadaAte = nil
+	return e
+}
+
+// PayloadWebhook é o corpo enviado à URL da assinatura
+type PayloadWebhook struct {
+	ID       string    `json:"id"` // ID do evento; repete-se nos reenvios
+	Evento   string    `json:"evento"`
+	CriadoEm time.Time `json:"criadoEm"`
+	Dados    any       `json:"dados"`
+}
+
+// Assinaturas e entregas são guardadas no repositório, cifradas em repouso: contêm os
+// segredos e os dados das notas
+var (
+	assinaturasWebhook = novaColecaoCompartilhada[AssinaturaWebhook]("webhooks")
+	entregasWebhook    = novaColecaoCompartilhada[EntregaWebhook]("webhooks-entregas")
+)
+
+// ConfigWebhooks controla as entregas
+type ConfigWebhooks struct {
+	// MaxTentativas antes de a entrega ser marcada como falha
+	MaxTentativas int
+	// EsperaInicial antes da segunda tentativa; dobra a cada nova falha, até EsperaMaxima
+	EsperaInicial time.Duration
+	EsperaMaxima  time.Duration
+	// Timeout de cada chamada
+	Timeout time.Duration
+	// PermitirRedePrivada aceita URLs em endereços internos (loopback, redes privadas e
+	// link-local), que por padrão são recusados para que as assinaturas não alcancem a rede
+	// do servidor (SSRF); útil em desenvolvimento, com o receptor local
+	PermitirRedePrivada bool
+}
+
+// ConfigWebhooksEnv lê WEBHOOK_MAX_TENTATIVAS (padrão 8), WEBHOOK_ESPERA_INICIAL (padrão 30s),
+// WEBHOOK_ESPERA_MAXIMA (padrão 1h), WEBHOOK_TIMEOUT (padrão 10s) e
+// WEBHOOK_PERMITIR_REDE_PRIVADA (padrão false)
+func ConfigWebhooksEnv() (ConfigWebhooks, error) {
+	config := ConfigWebhooks{MaxTentativas: 8, EsperaInicial: 30 * time.Second, EsperaMaxima: time.Hour, Timeout: 10 * time.Second}
+	if valor := os.Getenv("WEBHOOK_PERMITIR_REDE_PRIVADA"); valor != "" {
+		permitir, err := strconv.ParseBool(valor)
+		if err != nil {
+			return config, fmt.Errorf("WEBHOOK_PERMITIR_REDE_PRIVADA inválido: %q", valor)
+		}
+		config.PermitirRedePrivada = permitir
+	}
+	if valor := os.Getenv("WEBHOOK_MAX_TENTATIVAS"); valor != "" {
+		n, err := strconv.Atoi(valor)
+		if err != nil || n < 1 {
+			return config, fmt.Errorf("WEBHOOK_MAX_TENTATIVAS inválido: %q", valor)
+		}
+		config.MaxTentativas = n
+	}
+	for _, param := range []struct {
+		nome    string
+		destino *time.Duration
+	}{
+		{"WEBHOOK_ESPERA_INICIAL", &config.EsperaInicial},
+		{"WEBHOOK_ESPERA_MAXIMA", &config.EsperaMaxima},
+		{"WEBHOOK_TIMEOUT", &config.Timeout},
+	} {
+		if valor := os.Getenv(param.nome); valor != "" {
+			duracao, err := time.ParseDuration(valor)
+			if err != nil || duracao <= 0 {
+				return config, fmt.Errorf("%s inválido: %q", param.nome, valor)
+			}
+			*param.destino = duracao
+		}
+	}
+	return config, nil
+}
+
+// espera retorna o intervalo antes da próxima tentativa, após n tentativas falhas
+func (c ConfigWebhooks) espera(n int) time.Duration {
+	espera := c.EsperaInicial
+	for i := 1; i < n && espera < c.EsperaMaxima; i++ {
+		espera *= 2
+	}
+	return min(espera, c.EsperaMaxima)
+}
+
+// AssinarWebhook calcula o cabeçalho de assinatura de um corpo
+func AssinarWebhook(segredo string, momento time.Time, corpo []byte) string {
+	t := strconv.FormatInt(momento.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(segredo))
+	mac.Write([]byte(t + "."))
+	mac.Write(corpo)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerificarAssinaturaWebhook confere o cabeçalho de assinatura recebido, rejeitando
+// assinaturas com mais de tolerancia de diferença (reenvio de requisições capturadas)
+func VerificarAssinaturaWebhook(segredo string, cabecalho string, corpo []byte, tolerancia time.Duration) error {
+	var t string
+	var assinaturas []string
+	for _, item := range strings.Split(cabecalho, ",") {
+		chave, valor, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch chave {
+		case "t":
+			t = valor
+		case "v1":
+			assinaturas = append(assinaturas, valor)
+		}
+	}
+	segundos, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(assinaturas) == 0 {
+		return errors.New("cabeçalho de assinatura inválido")
+	}
+	momento := time.Unix(segundos, 0)
+	if diferenca := time.Since(momento); diferenca > tolerancia || diferenca < -tolerancia {
+		return errors.New("assinatura fora da janela de tolerância")
+	}
+
+	esperado := AssinarWebhook(segredo, momento, corpo)
+	_, esperada, _ := strings.Cut(esperado, ",v1=")
+	for _, assinatura := range assinaturas {
+		if hmac.Equal([]byte(assinatura), []byte(esperada)) {
+			return nil
+		}
+	}
+	return errors.New("assinatura não confere")
+}
+
+// enderecoInterno indica se o endereço é da própria máquina ou da rede interna: loopback,
+// redes privadas, link-local (inclusive os metadados de nuvem em 169.254.169.254), multicast
+// ou não especificado
+func enderecoInterno(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// verificarDestinoWebhook resolve o host da URL e recusa hosts com algum endereço interno.
+// A conexão confere de novo o endereço usado (ver clienteWebhooks), pois o DNS pode mudar.
+func verificarDestinoWebhook(ctx context.Context, host string) error {
+	if webhooks != nil && webhooks.config.PermitirRedePrivada {
+		return nil
+	}
+	enderecos, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(enderecos) == 0 {
+		return fmt.Errorf("não foi possível resolver o host %s", host)
+	}
+	for _, endereco := range enderecos {
+		if enderecoInterno(endereco) {
+			return fmt.Errorf("o host %s aponta para um endereço interno (%s)", host, endereco.Unmap())
+		}
+	}
+	return nil
+}
+
+// clienteWebhooks cria o cliente HTTP das entregas. Sem PermitirRedePrivada, cada conexão é
+// recusada se o endereço resolvido for interno, inclusive após redirecionamentos; o proxy do
+// ambiente não é usado, para que a verificação valha para o destino real.
+func clienteWebhooks(config ConfigWebhooks) *http.Client {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.PermitirRedePrivada {
+		dialer.Control = func(_, endereco string, _ syscall.RawConn) error {
+			destino, err := netip.ParseAddrPort(endereco)
+			if err != nil {
+				return err
+			}
+			if enderecoInterno(destino.Addr()) {
+				return fmt.Errorf("destino em endereço interno recusado: %s", destino.Addr().Unmap())
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        16,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: config.Timeout,
+		},
+		// Redirecionamentos não são seguidos: a resposta 3xx conta como falha da entrega
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// despachanteWebhooks entrega os eventos em segundo plano
+type despachanteWebhooks struct {
+	ctx     context.Context
+	config  ConfigWebhooks
+	cliente *http.Client
+	fila    chan string
+	// naFila evita enfileirar de novo uma entrega que ainda aguarda na fila
+	naFila sync.Map
+}
+
+// webhooks é o despachante configurado em IniciarWebhooks; nil enquanto não iniciado
+var webhooks *despachanteWebhooks
+
+// intervaloVerificacaoEntregas é o intervalo entre as buscas por entregas pendentes vencidas:
+// criadas em outra instância, interrompidas por um reinício ou abandonadas por uma instância
+// que parou
+const intervaloVerificacaoEntregas = time.Minute
+
+// IniciarWebhooks inicia as entregas em segundo plano e retoma as entregas pendentes
+// (inclusive as interrompidas por um reinício). Assinaturas e entregas gravadas em arquivos
+// antes do armazenamento no repositório são importadas para ele.
+func IniciarWebhooks(ctx context.Context, config ConfigWebhooks) error {
+	for _, importar := range []func() (int, error){assinaturasWebhook.importarLocais, entregasWebhook.importarLocais} {
+		if importados, err := importar(); err != nil {
+			return err
+		} else if importados > 0 {
+			log.Printf("%d documentos de webhooks importados para o repositório", importados)
+		}
+	}
+
+	d := &despachanteWebhooks{
+		ctx:     ctx,
+		config:  config,
+		cliente: clienteWebhooks(config),
+		fila:    make(chan string, 256),
+	}
+	for range 4 {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-d.fila:
+					d.naFila.Delete(id)
+					d.entregar(id)
+				}
+			}
+		}()
+	}
+
+	retomadas, err := d.agendarPendentes()
+	if err != nil {
+		return err
+	}
+	if retomadas > 0 {
+		log.Printf("Retomando %d entregas de webhook pendentes", retomadas)
+	}
+	go func() {
+		ticker := time.NewTicker(intervaloVerificacaoEntregas)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := d.agendarPendentes(); err != nil {
+					log.Printf("Erro ao buscar entregas de webhook pendentes: %v", err)
+				}
+			}
+		}
+	}()
+	webhooks = d
+	return nil
+}
+
+// agendarPendentes enfileira as entregas pendentes cuja próxima tentativa já venceu e que não
+// estão reservadas por outra instância. Retorna quantas foram enfileiradas.
+func (d *despachanteWebhooks) agendarPendentes() (int, error) {
+	todas, err := entregasWebhook.listar()
+	if err != nil {
+		return 0, err
+	}
+	agora := time.Now()
+	agendadas := 0
+	for _, entrega := range todas {
+		if !entrega.disponivel(agora) {
+			continue
+		}
+		d.agendar(entrega.ID, 0)
+		agendadas++
+	}
+	return agendadas, nil
+}
+
+// disponivel indica se a entrega está pendente, com a próxima tentativa vencida e sem reserva válida
+func (e EntregaWebhook) disponivel(agora time.Time) bool {
+	if e.Status != EntregaPendente {
+		return false
+	}
+	if e.ProximaTentativa != nil && agora.Before(*e.ProximaTentativa) {
+		return false
+	}
+	return e.ReservadaAte == nil || !agora.Before(*e.ReservadaAte)
+}
+
+// reservarEntrega reserva a próxima tentativa da entrega para esta instância; false se ela já
+// foi concluída, ainda não venceu ou está reservada por outra instância. A reserva dura o
+// timeout da chamada com uma folga; vencida, outra instância pode tentar de novo, e o
+// receptor descarta a repetição pelo cabeçalho X-NF-Entrega.
+func (d *despachanteWebhooks) reservarEntrega(id string) (EntregaWebhook, bool, error) {
+	agora := time.Now().UTC()
+	entrega, resultado, err := entregasWebhook.atualizar(id, func(entrega *EntregaWebhook) int {
+		if !entrega.disponivel(agora) {
+			return documentoMantido
+		}
+		reserva := agora.Add(d.config.Timeout + time.Minute)
+		entrega.ReservadaAte = &reserva
+		return documentoAlterado
+	})
+	return entrega, resultado == documentoAlterado, err
+}
+
+// agendar coloca a entrega na fila após a espera
+func (d *despachanteWebhooks) agendar(id string, espera time.Duration) {
+	enfileirar := func() {
+		if _, naFila := d.naFila.LoadOrStore(id, true); naFila {
+			return
+		}
+		select {
+		case d.fila <- id:
+		case <-d.ctx.Done():
+		}
+	}
+	if espera <= 0 {
+		go enfileirar()
+		return
+	}
+	time.AfterFunc(espera, enfileirar)
+}
+
+// entregar reserva a entrega, faz uma tentativa e agenda a próxima em caso de falha
+func (d *despachanteWebhooks) entregar(id string) {
+	entrega, reservada, err := d.reservarEntrega(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao reservar entrega de webhook %s: %v", id, err)
+		return
+	}
+	if !reservada {
+		return
+	}
+
+	tentativa := TentativaEntrega{Momento: time.Now().UTC()}
+	// removida indica que não adianta tentar de novo
+	removida := false
+	assinatura, err := assinaturasWebhook.carregar(entrega.AssinaturaID)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		tentativa.Erro = "assinatura removida"
+		removida = true
+	case err != nil:
+		tentativa.Erro = "erro ao carregar assinatura: " + err.Error()
+	default:
+		tentativa.StatusHTTP, err = d.enviar(assinatura, entrega)
+		if err != nil {
+			tentativa.Erro = err.Error()
+		}
+	}
+	tentativa.Duracao = time.Since(tentativa.Momento)
+
+	// A tentativa é gravada sobre a versão atual da entrega, que pode ter sido redigida
+	// (retenção ou LGPD) durante a chamada
+	entrega, _, err = entregasWebhook.atualizar(entrega.ID, func(entrega *EntregaWebhook) int {
+		entrega.Tentativas = append(entrega.Tentativas, tentativa)
+		entrega.ProximaTentativa = nil
+		entrega.ReservadaAte = nil
+		switch {
+		case tentativa.Erro == "":
+			entrega.Status = EntregaEntregue
+			entrega.EntregueEm = &tentativa.Momento
+		case len(entrega.Tentativas) >= d.config.MaxTentativas || removida:
+			entrega.Status = EntregaFalhou
+			log.Printf("Entrega de webhook %s falhou após %d tentativas: %s", entrega.ID, len(entrega.Tentativas), tentativa.Erro)
+		default:
+			proxima := time.Now().UTC().Add(d.config.espera(len(entrega.Tentativas)))
+			entrega.ProximaTentativa = &proxima
+		}
+		return documentoAlterado
+	})
+	if err != nil {
+		log.Printf("Erro ao salvar entrega de webhook %s: %v", id, err)
+		return
+	}
+	if entrega.ProximaTentativa != nil {
+		d.agendar(entrega.ID, time.Until(*entrega.ProximaTentativa))
+	}
+}
+
+// enviar faz o POST assinado; respostas fora de 2xx são erros. O corpo da resposta é
+// descartado: não é guardado na entrega, pois o receptor pode devolver qualquer conteúdo.
+func (d *despachanteWebhooks) enviar(assinatura AssinaturaWebhook, entrega EntregaWebhook) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, assinatura.URL, bytes.NewReader(entrega.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NF-Decoder-Webhooks/1")
+	req.Header.Set(CabecalhoEventoWebhook, entrega.Evento)
+	req.Header.Set(CabecalhoEntregaWebhook, entrega.ID)
+	req.Header.Set(CabecalhoAssinaturaWebhook, AssinarWebhook(assinatura.Segredo, time.Now(), entrega.Payload))
+
+	resp, err := d.cliente.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Lido (até um limite) apenas para reaproveitar a conexão
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("resposta HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// novaEntrega grava uma entrega pendente e a coloca na fila
+func (d *despachanteWebhooks) novaEntrega(assinaturaID, evento string, payload []byte, reenvioDe string) (EntregaWebhook, error) {
+	entrega := EntregaWebhook{
+		ID:           novoIDNota(),
+		AssinaturaID: assinaturaID,
+		Evento:       evento,
+		Payload:      payload,
+		Status:       EntregaPendente,
+		Tentativas:   []TentativaEntrega{},
+		ReenvioDe:    reenvioDe,
+		CriadoEm:     time.Now().UTC(),
+	}
+	if err := entregasWebhook.salvar(entrega.ID, entrega); err != nil {
+		return entrega, err
+	}
+	d.agendar(entrega.ID, 0)
+	return entrega, nil
+}
+
+// publicarEvento envia o evento às assinaturas interessadas. Falhas são registradas no
+// log e não interrompem a operação que gerou o evento.
+func publicarEvento(evento string, dados any) {
+	if webhooks == nil {
+		return
+	}
+	lista, err := assinaturasWebhook.listar()
+	if err != nil {
+		log.Printf("Erro ao listar assinaturas de webhook: %v", err)
+		return
+	}
+
+	var payload []byte
+	for _, assinatura := range lista {
+		if !slices.Contains(assinatura.Eventos, evento) && !slices.Contains(assinatura.Eventos, "*") {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(PayloadWebhook{ID: novoIDNota(), Evento: evento, CriadoEm: time.Now().UTC(), Dados: dados})
+			if err != nil {
+				log.Printf("Erro ao serializar evento %s: %v", evento, err)
+				return
+			}
+		}
+		if _, err := webhooks.novaEntrega(assinatura.ID, evento, payload, ""); err != nil {
+			log.Printf("Erro ao registrar entrega de webhook para %s: %v", assinatura.ID, err)
+		}
+	}
+}
+
+// publicarConciliacao publica nota.validada ou nota.divergente conforme a conciliação
+func publicarConciliacao(nota NotaFiscalData, conciliacao ResultadoConciliacao) {
+	evento := EventoNotaDivergente
+	if conciliacao.Status == ConciliacaoConforme {
+		evento = EventoNotaValidada
+	}
+	publicarEvento(evento, gin.H{"nota": nota, "conciliacao": conciliacao})
+}
+
+// publico retorna a assinatura sem o segredo
+func (a AssinaturaWebhook) publico() AssinaturaWebhook {
+	a.Segredo = ""
+	return a
+}
+
+// AssinaturaWebhookRequest cria uma assinatura; sem segredo, um é gerado
+type AssinaturaWebhookRequest struct {
+	URL     string   `json:"url"`
+	Eventos []string `json:"eventos"`
+	Segredo string   `json:"segredo"`
+}
+
+// CriarAssinaturaWebhook inscreve uma URL em eventos. URLs que resolvem para endereços
+// internos são recusadas (ver verificarDestinoWebhook). O segredo usado nas assinaturas HMAC
+// só é retornado nesta resposta.
+func CriarAssinaturaWebhook(c *gin.Context) {
+	var req AssinaturaWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Dados da assinatura inválidos: "+err.Error())
+		return
+	}
+	destino, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (destino.Scheme != "http" && destino.Scheme != "https") || destino.Host == "" {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "URL deve ser absoluta, com http ou https")
+		return
+	}
+	if len(req.Eventos) == 0 {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "Informe os eventos: "+strings.Join(eventosWebhook, ", ")+" ou *")
+		return
+	}
+	for _, evento := range req.Eventos {
+		if evento != "*" && !slices.Contains(eventosWebhook, evento) {
+			responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, fmt.Sprintf("Evento desconhecido: %q", evento))
+			return
+		}
+	}
+	if err := verificarDestinoWebhook(c.Request.Context(), destino.Hostname()); err != nil {
+		responderErro(c, http.StatusBadRequest, CodigoRequisicaoInvalida, "URL recusada: "+err.Error())
+		return
+	}
+	if req.Segredo == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("Erro ao gerar segredo de webhook: %v", err)
+			responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao gerar segredo da assinatura")
+			return
+		}
+		req.Segredo = hex.EncodeToString(b)
+	}
+
+	assinatura := AssinaturaWebhook{
+		ID:       novoIDNota(),
+		URL:      destino.String(),
+		Eventos:  req.Eventos,
+		Segredo:  req.Segredo,
+		CriadoEm: time.Now().UTC(),
+	}
+	if err := assinaturasWebhook.salvar(assinatura.ID, assinatura); err != nil {
+		log.Printf("Erro ao salvar assinatura de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao salvar assinatura")
+		return
+	}
+	log.Printf("Assinatura de webhook %s criada para %s", assinatura.ID, destino.Host)
+	c.JSON(http.StatusCreated, assinatura)
+}
+
+// ListarAssinaturasWebhook lista as assinaturas, sem os segredos
+func ListarAssinaturasWebhook(c *gin.Context) {
+	lista, err := assinaturasWebhook.listar()
+	if err != nil {
+		log.Printf("Erro ao listar assinaturas de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao listar assinaturas")
+		return
+	}
+	publicas := make([]AssinaturaWebhook, 0, len(lista))
+	for _, assinatura := range lista {
+		publicas = append(publicas, assinatura.publico())
+	}
+	slices.SortFunc(publicas, func(a, b AssinaturaWebhook) int { return a.CriadoEm.Compare(b.CriadoEm) })
+	c.JSON(http.StatusOK, publicas)
+}
+
+// carregarAssinatura carrega a assinatura da rota, respondendo com erro se não for possível
+func carregarAssinatura(c *gin.Context) (AssinaturaWebhook, bool) {
+	assinatura, err := assinaturasWebhook.carregar(c.Param("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Assinatura não encontrada")
+		return assinatura, false
+	}
+	if err != nil {
+		log.Printf("Erro ao carregar assinatura de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar assinatura")
+		return assinatura, false
+	}
+	return assinatura, true
+}
+
+// RemoverAssinaturaWebhook cancela a assinatura; entregas pendentes são marcadas como falha
+func RemoverAssinaturaWebhook(c *gin.Context) {
+	assinatura, ok := carregarAssinatura(c)
+	if !ok {
+		return
+	}
+	if err := assinaturasWebhook.remover(assinatura.ID); err != nil {
+		log.Printf("Erro ao remover assinatura de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao remover assinatura")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListarEntregasWebhook retorna o registro de entregas da assinatura, da mais recente à mais
+// antiga; status filtra por situação (pendente, entregue, falhou)
+func ListarEntregasWebhook(c *gin.Context) {
+	assinatura, ok := carregarAssinatura(c)
+	if !ok {
+		return
+	}
+	todas, err := entregasWebhook.listar()
+	if err != nil {
+		log.Printf("Erro ao listar entregas de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao listar entregas")
+		return
+	}
+	status := c.Query("status")
+	entregas := []EntregaWebhook{}
+	for _, entrega := range todas {
+		if entrega.AssinaturaID == assinatura.ID && (status == "" || entrega.Status == status) {
+			entregas = append(entregas, entrega.publico())
+		}
+	}
+	slices.SortFunc(entregas, func(a, b EntregaWebhook) int { return b.CriadoEm.Compare(a.CriadoEm) })
+	c.JSON(http.StatusOK, entregas)
+}
+
+// ReenviarEntregaWebhook cria uma nova entrega com o mesmo payload (mesmo ID de evento) de uma
+// entrega anterior, por exemplo após o receptor ficar fora do ar por mais que as tentativas
+func ReenviarEntregaWebhook(c *gin.Context) {
+	assinatura, ok := carregarAssinatura(c)
+	if !ok {
+		return
+	}
+	original, err := entregasWebhook.carregar(c.Param("entrega"))
+	if errors.Is(err, os.ErrNotExist) || (err == nil && original.AssinaturaID != assinatura.ID) {
+		responderErro(c, http.StatusNotFound, CodigoNaoEncontrado, "Entrega não encontrada")
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao carregar entrega de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao carregar entrega")
+		return
+	}
+	if webhooks == nil {
+		responderErro(c, http.StatusServiceUnavailable, CodigoServicoIndisponivel, "Entregas de webhook não iniciadas")
+		return
+	}
+
+	entrega, err := webhooks.novaEntrega(assinatura.ID, original.Evento, original.Payload, original.ID)
+	if err != nil {
+		log.Printf("Erro ao registrar reenvio de webhook: %v", err)
+		responderErro(c, http.StatusInternalServerError, CodigoErroInterno, "Erro ao reenviar entrega")
+		return
+	}
+	c.JSON(http.StatusAccepted, entrega.publico())
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roteadorWebhooksTeste monta as rotas de webhooks com o token de administração, como em
+// rotas.go, com as coleções em um diretório temporário
+func roteadorWebhooksTeste(t *testing.T, token string) *gin.Engine {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("ADMIN_TOKEN", token)
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	admin := router.Group("", ExigirTokenAdmin())
+	admin.POST("/webhooks", CriarAssinaturaWebhook)
+	admin.GET("/webhooks", ListarAssinaturasWebhook)
+	return router
+}
+
+// requisicaoWebhooks envia uma requisição ao roteador com o token informado (vazio: sem Authorization)
+func requisicaoWebhooks(router http.Handler, metodo, caminho, token string, corpo any) *httptest.ResponseRecorder {
+	var leitor io.Reader = http.NoBody
+	if corpo != nil {
+		dados, _ := json.Marshal(corpo)
+		leitor = strings.NewReader(string(dados))
+	}
+	req := httptest.NewRequest(metodo, caminho, leitor)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// usarDespachanteTeste configura o despachante global durante o teste
+func usarDespachanteTeste(t *testing.T, config ConfigWebhooks) *despachanteWebhooks {
+	t.Helper()
+	anterior := webhooks
+	d := &despachanteWebhooks{ctx: context.Background(), config: config, cliente: clienteWebhooks(config), fila: make(chan string, 1)}
+	webhooks = d
+	t.Cleanup(func() { webhooks = anterior })
+	return d
+}
+
+func TestExigirTokenAdmin(t *testing.T) {
+	router := roteadorWebhooksTeste(t, "token-de-teste")
+
+	casos := []struct {
+		nome           string
+		autorizacao    string
+		statusEsperado int
+	}{
+		{"sem cabeçalho", "", http.StatusUnauthorized},
+		{"token errado", "Bearer outro-token", http.StatusUnauthorized},
+		{"prefixo do token", "Bearer token-de", http.StatusUnauthorized},
+		{"outro esquema", "Basic token-de-teste", http.StatusUnauthorized},
+		{"token correto", "Bearer token-de-teste", http.StatusOK},
+		{"esquema em minúsculas", "bearer token-de-teste", http.StatusOK},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/webhooks", http.NoBody)
+			if caso.autorizacao != "" {
+				req.Header.Set("Authorization", caso.autorizacao)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != caso.statusEsperado {
+				t.Fatalf("status %d, esperado %d: %s", w.Code, caso.statusEsperado, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("401 sem WWW-Authenticate: Bearer")
+			}
+		})
+	}
+}
+
+func TestExigirTokenAdminSemTokenConfigurado(t *testing.T) {
+	router := roteadorWebhooksTeste(t, "")
+
+	w := requisicaoWebhooks(router, http.MethodGet, "/webhooks", "qualquer", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, esperado 503: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestEnderecoInterno(t *testing.T) {
+	casos := map[string]bool{
+		"127.0.0.1":       true,
+		"::1":             true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.0.10":    true,
+		"169.254.169.254": true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"0.0.0.0":         true,
+		"::ffff:10.0.0.1": true,
+		"224.0.0.1":       true,
+		"203.0.113.10":    false,
+		"8.8.8.8":         false,
+		"2001:db8::1":     false,
+	}
+	for endereco, esperado := range casos {
+		if interno := enderecoInterno(netip.MustParseAddr(endereco)); interno != esperado {
+			t.Errorf("enderecoInterno(%s) = %v, esperado %v", endereco, interno, esperado)
+		}
+	}
+}
+
+func TestCriarAssinaturaWebhookRecusaEnderecosInternos(t *testing.T) {
+	router := roteadorWebhooksTeste(t, "token-de-teste")
+	usarDespachanteTeste(t, ConfigWebhooks{MaxTentativas: 1, Timeout: time.Second})
+
+	for _, destino := range []string{
+		"http://127.0.0.1:8080/receptor",
+		"http://localhost/receptor",
+		"http://[::1]/receptor",
+		"http://10.0.0.5/receptor",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://192.168.1.1/",
+	} {
+		w := requisicaoWebhooks(router, http.MethodPost, "/webhooks", "token-de-teste", map[string]any{"url": destino, "eventos": []string{"*"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, esperado 400: %s", destino, w.Code, w.Body.String())
+		}
+	}
+
+	// Endereço público (faixa de documentação): aceito sem conexão
+	w := requisicaoWebhooks(router, http.MethodPost, "/webhooks", "token-de-teste", map[string]any{"url": "https://203.0.113.10/receptor", "eventos": []string{EventoNotaSalva}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d, esperado 201: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCriarAssinaturaWebhookRedePrivadaPermitida(t *testing.T) {
+	router := roteadorWebhooksTeste(t, "token-de-teste")
+	usarDespachanteTeste(t, ConfigWebhooks{MaxTentativas: 1, Timeout: time.Second, PermitirRedePrivada: true})
+
+	w := requisicaoWebhooks(router, http.MethodPost, "/webhooks", "token-de-teste", map[string]any{"url": "http://127.0.0.1:9090/", "eventos": []string{"*"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d, esperado 201: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestClienteWebhooksRecusaConexaoInterna(t *testing.T) {
+	chamado := false
+	receptor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { chamado = true }))
+	defer receptor.Close()
+
+	// A URL passou na criação (por exemplo, o DNS mudou depois): a conexão é recusada
+	resp, err := clienteWebhooks(ConfigWebhooks{Timeout: time.Second}).Get(receptor.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("conexão com loopback deveria ser recusada")
+	}
+	if !strings.Contains(err.Error(), "endereço interno") || chamado {
+		t.Fatalf("erro inesperado (receptor chamado: %v): %v", chamado, err)
+	}
+
+	resp, err = clienteWebhooks(ConfigWebhooks{Timeout: time.Second, PermitirRedePrivada: true}).Get(receptor.URL)
+	if err != nil {
+		t.Fatalf("com PermitirRedePrivada: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClienteWebhooksNaoSegueRedirecionamento(t *testing.T) {
+	interno := false
+	receptor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/interno" {
+			interno = true
+			return
+		}
+		http.Redirect(w, r, "/interno", http.StatusFound)
+	}))
+	defer receptor.Close()
+
+	d := usarDespachanteTeste(t, ConfigWebhooks{Timeout: time.Second, PermitirRedePrivada: true})
+	status, err := d.enviar(AssinaturaWebhook{URL: receptor.URL, Segredo: "segredo"}, EntregaWebhook{ID: "e1", Evento: EventoNotaSalva, Payload: []byte(`{}`)})
+	if err == nil || status != http.StatusFound || interno {
+		t.Fatalf("status %d, erro %v, redirecionamento seguido: %v", status, err, interno)
+	}
+}
+
+func TestEnviarAssinaESemCorpoDaResposta(t *testing.T) {
+	const segredo = "segredo-de-teste"
+	var recebida error
+	receptor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		corpo, _ := io.ReadAll(r.Body)
+		recebida = VerificarAssinaturaWebhook(segredo, r.Header.Get(CabecalhoAssinaturaWebhook), corpo, time.Minute)
+		http.Error(w, "detalhe interno do receptor: senha=123", http.StatusInternalServerError)
+	}))
+	defer receptor.Close()
+
+	d := usarDespachanteTeste(t, ConfigWebhooks{Timeout: time.Second, PermitirRedePrivada: true})
+	status, err := d.enviar(AssinaturaWebhook{URL: receptor.URL, Segredo: segredo}, EntregaWebhook{ID: "e1", Evento: EventoNotaSalva, Payload: []byte(`{"evento":"nota.salva"}`)})
+	if recebida != nil {
+		t.Fatalf("assinatura recusada pelo receptor: %v", recebida)
+	}
+	if status != http.StatusInternalServerError || err == nil {
+		t.Fatalf("status %d, erro %v; esperado 500 com erro", status, err)
+	}
+	if strings.Contains(err.Error(), "senha") || strings.Contains(err.Error(), "detalhe") {
+		t.Fatalf("erro da entrega contém o corpo da resposta: %q", err)
+	}
+}
+
+func TestEntregarRespeitaReserva(t *testing.T) {
+	t.Chdir(t.TempDir())
+	chamadas := 0
+	receptor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { chamadas++ }))
+	defer receptor.Close()
+
+	d := usarDespachanteTeste(t, ConfigWebhooks{MaxTentativas: 3, Timeout: time.Second, PermitirRedePrivada: true})
+	assinatura := AssinaturaWebhook{ID: "a1", URL: receptor.URL, Segredo: "segredo", Eventos: []string{"*"}}
+	if err := assinaturasWebhook.salvar(assinatura.ID, assinatura); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reservada por outra instância: não é enviada
+	reserva := time.Now().UTC().Add(time.Minute)
+	entrega := EntregaWebhook{ID: "e1", AssinaturaID: "a1", Evento: EventoNotaSalva, Payload: []byte(`{}`),
+		Status: EntregaPendente, Tentativas: []TentativaEntrega{}, ReservadaAte: &reserva}
+	if err := entregasWebhook.salvar(entrega.ID, entrega); err != nil {
+		t.Fatal(err)
+	}
+	d.entregar(entrega.ID)
+	if chamadas != 0 {
+		t.Fatalf("entrega reservada por outra instância foi enviada")
+	}
+
+	// Reserva vencida: enviada uma vez, e a reserva é liberada
+	vencida := time.Now().UTC().Add(-time.Second)
+	entrega.ReservadaAte = &vencida
+	if err := entregasWebhook.salvar(entrega.ID, entrega); err != nil {
+		t.Fatal(err)
+	}
+	d.entregar(entrega.ID)
+	d.entregar(entrega.ID)
+	if chamadas != 1 {
+		t.Fatalf("%d chamadas ao receptor, esperada 1", chamadas)
+	}
+	salva, err := entregasWebhook.carregar(entrega.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if salva.Status != EntregaEntregue || len(salva.Tentativas) != 1 || salva.ReservadaAte != nil {
+		t.Fatalf("entrega salva: status %s, %d tentativas, reserva %v", salva.Status, len(salva.Tentativas), salva.ReservadaAte)
+	}
+	if salva.publico().ReservadaAte != nil {
+		t.Fatal("reserva enviada na resposta")
+	}
+}
+
+func TestExpurgarEntregasWebhook(t *testing.T) {
+	t.Chdir(t.TempDir())
+	agora := time.Now().UTC()
+	antiga := agora.AddDate(0, 0, -40)
+	entregas := []EntregaWebhook{
+		{ID: "entregue-antiga", Status: EntregaEntregue, CriadoEm: antiga, Tentativas: []TentativaEntrega{{Momento: antiga}}},
+		{ID: "falhou-antiga", Status: EntregaFalhou, CriadoEm: antiga, Tentativas: []TentativaEntrega{{Momento: antiga}}},
+		// Última tentativa recente: guardada
+		{ID: "falhou-recente", Status: EntregaFalhou, CriadoEm: antiga, Tentativas: []TentativaEntrega{{Momento: antiga}, {Momento: agora}}},
+		// Pendente: nunca expira
+		{ID: "pendente-antiga", Status: EntregaPendente, CriadoEm: antiga, Tentativas: []TentativaEntrega{}},
+	}
+	for _, entrega := range entregas {
+		if err := entregasWebhook.salvar(entrega.ID, entrega); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	limite := agora.AddDate(0, 0, -30)
+	if n, err := expurgarEntregasWebhook(limite, true); err != nil || n != 2 {
+		t.Fatalf("simulação: %d expurgadas, erro %v; esperadas 2", n, err)
+	}
+	if restantes, _ := entregasWebhook.listar(); len(restantes) != len(entregas) {
+		t.Fatalf("simulação apagou entregas: restam %d", len(restantes))
+	}
+	if n, err := expurgarEntregasWebhook(limite, false); err != nil || n != 2 {
+		t.Fatalf("%d expurgadas, erro %v; esperadas 2", n, err)
+	}
+	for _, id := range []string{"falhou-recente", "pendente-antiga"} {
+		if _, err := entregasWebhook.carregar(id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+	for _, id := range []string{"entregue-antiga", "falhou-antiga"} {
+		if _, err := entregasWebhook.carregar(id); err == nil {
+			t.Errorf("%s não foi expurgada", id)
+		}
+	}
+}
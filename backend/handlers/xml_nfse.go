@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// campoXML é um valor de texto encontrado no XML, com o caminho a partir de InfNfse
+type campoXML struct {
+	caminho []string
+	valor   string
+}
+
+// extrairXMLNFSe lê NFS-e no layout ABRASF (elementos InfNfse), sem uso de IA.
+// Cada InfNfse encontrado no documento gera um registro.
+func extrairXMLNFSe(conteudo []byte) ([]NFSeData, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(conteudo))
+	// Muitas prefeituras declaram ISO-8859-1 ou Windows-1252; o texto é convertido para UTF-8
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var (
+		notas        []NFSeData
+		pilha        []string
+		campos       []campoXML
+		texto        strings.Builder
+		dentro       bool
+		nivelInfNfse int
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("XML inválido: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			pilha = append(pilha, t.Name.Local)
+			texto.Reset()
+			if t.Name.Local == "InfNfse" && !dentro {
+				dentro = true
+				nivelInfNfse = len(pilha)
+				campos = nil
+			}
+		case xml.CharData:
+			texto.Write(t)
+		case xml.EndElement:
+			if dentro {
+				if valor := strings.TrimSpace(texto.String()); valor != "" {
+					caminho := append([]string(nil), pilha[nivelInfNfse:]...)
+					campos = append(campos, campoXML{caminho: caminho, valor: valor})
+				}
+				if t.Name.Local == "InfNfse" && len(pilha) == nivelInfNfse {
+					dentro = false
+					notas = append(notas, montarNFSeXML(campos))
+				}
+			}
+			texto.Reset()
+			if len(pilha) > 0 {
+				pilha = pilha[:len(pilha)-1]
+			}
+		}
+	}
+
+	if len(notas) == 0 {
+		return nil, fmt.Errorf("nenhuma NFS-e (InfNfse) encontrada no XML")
+	}
+	return notas, nil
+}
+
+// montarNFSeXML converte os campos de um InfNfse em NFSeData
+func montarNFSeXML(campos []campoXML) NFSeData {
+	// primeiro retorna o primeiro valor do elemento com o nome informado; se houver
+	// ancestrais, o elemento precisa estar dentro de um deles
+	primeiro := func(nome string, ancestrais ...string) string {
+		for _, campo := range campos {
+			if campo.caminho[len(campo.caminho)-1] != nome {
+				continue
+			}
+			if len(ancestrais) == 0 {
+				return campo.valor
+			}
+			for _, elemento := range campo.caminho[:len(campo.caminho)-1] {
+				for _, ancestral := range ancestrais {
+					if elemento == ancestral {
+						return campo.valor
+					}
+				}
+			}
+		}
+		return ""
+	}
+	// direto retorna o valor de um elemento filho direto de InfNfse
+	direto := func(nome string) string {
+		for _, campo := range campos {
+			if len(campo.caminho) == 1 && campo.caminho[0] == nome {
+				return campo.valor
+			}
+		}
+		return ""
+	}
+	valor := func(texto string) float64 {
+		v, _ := strconv.ParseFloat(strings.TrimSpace(texto), 64)
+		return v
+	}
+
+	nf := NFSeData{
+		NumeroNotaFiscal:  direto("Numero"),
+		SerieNotaFiscal:   direto("Serie"),
+		PrestadorServicos: primeiro("RazaoSocial", "PrestadorServico", "Prestador"),
+		ValorServicos:     valor(primeiro("ValorServicos")),
+	}
+
+	nf.CNPJ = primeiro("Cnpj", "PrestadorServico", "Prestador", "IdentificacaoPrestador")
+	if nf.CNPJ == "" {
+		nf.CNPJ = primeiro("Cpf", "PrestadorServico", "Prestador", "IdentificacaoPrestador")
+	}
+
+	if retido := primeiro("ValorIssRetido"); retido != "" {
+		nf.ISSRetido = valor(retido)
+	} else if primeiro("IssRetido") == "1" {
+		nf.ISSRetido = valor(primeiro("ValorIss"))
+	}
+
+	if emissao, err := parseDataXML(direto("DataEmissao")); err == nil {
+		nf.DataNotaFiscal = emissao.String()
+	}
+	if competencia, err := parseDataXML(primeiro("Competencia")); err == nil {
+		nf.CompetenciaNotaFiscal = CompetenciaDaData(competencia).String()
+	} else if emissao, err := ParseData(nf.DataNotaFiscal); err == nil {
+		// Sem competência no XML, usar o mês/ano da emissão, como no prompt da extração por IA
+		nf.CompetenciaNotaFiscal = CompetenciaDaData(emissao).String()
+	}
+
+	nf.ValorLiquidoNotaFiscal = nf.ValorServicos - nf.ISSRetido
+	return nf
+}
+
+// parseDataXML interpreta datas xs:date e xs:dateTime usadas no layout ABRASF
+func parseDataXML(valor string) (Data, error) {
+	valor = strings.TrimSpace(valor)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, valor); err == nil {
+			return Data{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}, nil
+		}
+	}
+	return Data{}, fmt.Errorf("data inválida no XML: %q", valor)
+}
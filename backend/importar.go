@@ -0,0 +1,101 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// executarImportacao importa as notas salvas em JSON + PDF (formato antigo) para o
+// repositório configurado em NOTAS_STORAGE.
+// Uso: go run . importar-legado [-dir uploads] [-dry-run] [-json relatorio.json]
+func executarImportacao(args []string) int {
+	flags := flag.NewFlagSet("importar-legado", flag.ContinueOnError)
+	dir := flags.String("dir", "uploads", "diretório com os pares <id>.json + <id>.pdf")
+	simular := flags.Bool("dry-run", false, "apenas relata o que seria importado, sem gravar")
+	saidaJSON := flags.String("json", "", "grava o relatório completo em JSON neste arquivo")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if os.Getenv("NOTAS_STORAGE") == "arquivos" {
+		fmt.Fprintln(os.Stderr, "NOTAS_STORAGE=arquivos já lê os JSONs de uploads/; configure sqlite ou postgres para importar")
+		return 2
+	}
+
+	cofre, err := handlers.AbrirCofre()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao carregar chaves de criptografia: %v\n", err)
+		return 1
+	}
+	handlers.ConfigurarCofre(cofre)
+
+	repo, err := handlers.AbrirRepositorio()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao abrir repositório de notas fiscais: %v\n", err)
+		return 1
+	}
+	defer repo.Close()
+
+	arquivos, err := handlers.AbrirBlobStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao abrir armazenamento de arquivos: %v\n", err)
+		return 1
+	}
+
+	relatorio, err := handlers.ImportarLegado(context.Background(), handlers.OpcoesImportacao{
+		Diretorio:   *dir,
+		Repositorio: repo,
+		Arquivos:    arquivos,
+		Simular:     *simular,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro na importação: %v\n", err)
+		return 1
+	}
+
+	if *simular {
+		fmt.Println("Simulação: nenhuma nota foi gravada")
+	}
+	fmt.Printf("Notas encontradas:   %d\n", relatorio.Encontradas)
+	fmt.Printf("Importadas:          %d\n", relatorio.Importadas)
+	fmt.Printf("Já existentes:       %d\n", relatorio.JaExistentes)
+	fmt.Printf("Duplicadas:          %d\n", len(relatorio.Duplicadas))
+	fmt.Printf("Não importadas:      %d\n", len(relatorio.Falhas))
+
+	imprimirLista := func(titulo string, itens []handlers.FalhaImportacao) {
+		if len(itens) == 0 {
+			return
+		}
+		fmt.Printf("\n%s:\n", titulo)
+		for _, item := range itens {
+			fmt.Printf("  - %s: %s\n", item.Arquivo, item.Motivo)
+		}
+	}
+	imprimirLista("Arquivos não importados", relatorio.Falhas)
+	imprimirLista("Duplicadas descartadas", relatorio.Duplicadas)
+	imprimirLista("Avisos", relatorio.Avisos)
+	if len(relatorio.ArquivosSemPar) > 0 {
+		fmt.Printf("\nPDFs sem nota correspondente:\n  - %s\n", strings.Join(relatorio.ArquivosSemPar, "\n  - "))
+	}
+
+	if *saidaJSON != "" {
+		conteudo, err := json.MarshalIndent(relatorio, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*saidaJSON, conteudo, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "erro ao gravar relatório JSON: %v\n", err)
+			return 1
+		}
+	}
+
+	if len(relatorio.Falhas) > 0 {
+		return 1
+	}
+	return 0
+}
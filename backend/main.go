@@ -2,10 +2,11 @@ package main
 
 import (
 	"NF-DECODER-AI/handlers"
+	"context"
+	"fmt"
 	"log"
-	"net/http"
+	"os"
 
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
@@ -15,28 +16,70 @@ func main() {
 		log.Println("Arquivo .env não encontrado, usando variáveis de ambiente do sistema")
 	}
 
-	router := gin.Default()
+	// Subcomandos de linha de comando; sem argumentos, inicia o servidor
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "avaliar":
+			os.Exit(executarAvaliacao(os.Args[2:]))
+		case "importar-legado":
+			os.Exit(executarImportacao(os.Args[2:]))
+		case "rotacionar-chaves":
+			os.Exit(executarRotacaoChaves(os.Args[2:]))
+		case "receptor-webhook":
+			os.Exit(executarReceptorWebhook(os.Args[2:]))
+		case "gerar-cliente":
+			os.Exit(executarGeracaoCliente(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "subcomando desconhecido: %s\nuso: %s [avaliar|importar-legado|rotacionar-chaves|receptor-webhook|gerar-cliente]\n", os.Args[1], os.Args[0])
+			os.Exit(2)
+		}
+	}
+
+	cofre, err := handlers.AbrirCofre()
+	if err != nil {
+		log.Fatalf("Erro ao carregar chaves de criptografia: %v", err)
+	}
+	if cofre == nil {
+		log.Println("Criptografia em repouso desativada: configure CRIPTOGRAFIA_CHAVES_ARQUIVO ou CRIPTOGRAFIA_CHAVES")
+	}
+	handlers.ConfigurarCofre(cofre)
 
-	// Configurar CORS global
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	repo, err := handlers.AbrirRepositorio()
+	if err != nil {
+		log.Fatalf("Erro ao abrir repositório de notas fiscais: %v", err)
+	}
+	defer repo.Close()
+	handlers.ConfigurarRepositorio(repo)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
+	arquivos, err := handlers.AbrirBlobStore()
+	if err != nil {
+		log.Fatalf("Erro ao abrir armazenamento de arquivos: %v", err)
+	}
+	handlers.ConfigurarBlobStore(arquivos)
 
-		c.Next()
-	})
+	politica, err := handlers.PoliticaRetencaoEnv()
+	if err != nil {
+		log.Fatalf("Erro na política de retenção: %v", err)
+	}
+	handlers.IniciarRetencao(context.Background(), politica)
+
+	if err := handlers.IniciarContratos(); err != nil {
+		log.Fatalf("Erro ao importar contratos: %v", err)
+	}
+
+	if err := handlers.IniciarLotes(context.Background()); err != nil {
+		log.Fatalf("Erro ao retomar lotes de extração: %v", err)
+	}
+
+	configWebhooks, err := handlers.ConfigWebhooksEnv()
+	if err != nil {
+		log.Fatalf("Erro na configuração dos webhooks: %v", err)
+	}
+	if err := handlers.IniciarWebhooks(context.Background(), configWebhooks); err != nil {
+		log.Fatalf("Erro ao iniciar entregas de webhook: %v", err)
+	}
 
-	router.POST("/upload", handlers.DecodeNotaFiscal)
-	router.POST("/save-nota-fiscal", handlers.SaveNotaFiscal)
-	router.GET("/buscar-notas-fiscais", handlers.BuscarNotasFiscais)
-	// Novas rotas para processamento de planilhas
-	router.POST("/process-spreadsheet", handlers.ProcessSpreadsheet)
-	router.POST("/spreadsheet-preview", handlers.GetSpreadsheetPreview)
+	router := novoRoteador(context.Background())
 
 	log.Println("Servidor iniciado na porta 8080")
 	log.Fatal(router.Run(":8080"))
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// executarReceptorWebhook sobe um receptor local de webhooks para testar as assinaturas:
+// confere a assinatura HMAC de cada entrega, descarta entregas repetidas e mostra os eventos.
+// Com -falhas N, responde 500 às N primeiras entregas, para exercitar as novas tentativas. O
+// servidor só entrega em localhost com WEBHOOK_PERMITIR_REDE_PRIVADA=true.
+// Uso: go run . receptor-webhook -segredo <segredo> [-porta 9090] [-falhas 0]
+func executarReceptorWebhook(args []string) int {
+	fs := flag.NewFlagSet("receptor-webhook", flag.ExitOnError)
+	porta := fs.Int("porta", 9090, "porta do receptor")
+	segredo := fs.String("segredo", "", "segredo da assinatura (retornado por POST /webhooks)")
+	falhas := fs.Int("falhas", 0, "número de entregas a recusar com 500 antes de aceitar")
+	fs.Parse(args)
+
+	if *segredo == "" {
+		fmt.Fprintln(os.Stderr, "uso: receptor-webhook -segredo <segredo> [-porta 9090] [-falhas 0]")
+		return 2
+	}
+
+	var mu sync.Mutex
+	recusadas := 0
+	recebidas := map[string]bool{}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		corpo, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "erro ao ler corpo", http.StatusBadRequest)
+			return
+		}
+		if err := handlers.VerificarAssinaturaWebhook(*segredo, r.Header.Get(handlers.CabecalhoAssinaturaWebhook), corpo, 5*time.Minute); err != nil {
+			log.Printf("Entrega rejeitada: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		entrega := r.Header.Get(handlers.CabecalhoEntregaWebhook)
+		if recusadas < *falhas {
+			recusadas++
+			log.Printf("Entrega %s recusada de propósito (%d de %d)", entrega, recusadas, *falhas)
+			http.Error(w, "falha simulada", http.StatusInternalServerError)
+			return
+		}
+		if recebidas[entrega] {
+			log.Printf("Entrega %s repetida, ignorada", entrega)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		recebidas[entrega] = true
+
+		var payload handlers.PayloadWebhook
+		json.Unmarshal(corpo, &payload)
+		log.Printf("Evento %s (id %s, entrega %s): %s", payload.Evento, payload.ID, entrega, corpo)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	log.Printf("Receptor de webhooks em http://localhost:%d", *porta)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", *porta), nil); err != nil {
+		fmt.Fprintf(os.Stderr, "erro no receptor: %v\n", err)
+		return 1
+	}
+	return 0
+}
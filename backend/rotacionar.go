@@ -0,0 +1,64 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"context"
+	"fmt"
+	"os"
+)
+
+// executarRotacaoChaves regrava notas, arquivos e documentos compartilhados com a chave de
+// criptografia ativa. Também
+// cifra os dados gravados antes da ativação da criptografia.
+// Uso: go run . rotacionar-chaves
+func executarRotacaoChaves(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "uso: rotacionar-chaves (sem argumentos; a chave ativa vem do chaveiro)")
+		return 2
+	}
+
+	cofre, err := handlers.AbrirCofre()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao carregar chaves de criptografia: %v\n", err)
+		return 1
+	}
+	if cofre == nil {
+		fmt.Fprintln(os.Stderr, "criptografia não configurada: defina CRIPTOGRAFIA_CHAVES_ARQUIVO ou CRIPTOGRAFIA_CHAVES")
+		return 2
+	}
+	handlers.ConfigurarCofre(cofre)
+
+	repo, err := handlers.AbrirRepositorio()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao abrir repositório de notas fiscais: %v\n", err)
+		return 1
+	}
+	defer repo.Close()
+
+	arquivos, err := handlers.AbrirBlobStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro ao abrir armazenamento de arquivos: %v\n", err)
+		return 1
+	}
+
+	relatorio, err := handlers.RotacionarChaves(context.Background(), repo, arquivos)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "erro na rotação de chaves: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Chave ativa:            %s\n", relatorio.ChaveAtiva)
+	fmt.Printf("Notas regravadas:       %d\n", relatorio.Notas)
+	fmt.Printf("Arquivos verificados:   %d\n", relatorio.Arquivos)
+	fmt.Printf("Arquivos recifrados:    %d\n", relatorio.ArquivosRecifrados)
+	fmt.Printf("Documentos verificados: %d\n", relatorio.Documentos)
+	fmt.Printf("Documentos recifrados:  %d\n", relatorio.DocumentosRecifrados)
+	if len(relatorio.Falhas) > 0 {
+		fmt.Println("\nFalhas:")
+		for _, falha := range relatorio.Falhas {
+			fmt.Printf("  - %s\n", falha)
+		}
+		return 1
+	}
+	return 0
+}
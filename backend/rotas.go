@@ -0,0 +1,95 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// novoRoteador monta o roteador da API, com os middlewares e as rotas. As rotas ficam em
+// /api/v1 e, sem o prefixo, para clientes antigos (com o formato de erro anterior). Devem
+// coincidir com handlers/openapi.json, o que é conferido por TestContrato (go test).
+func novoRoteador(ctx context.Context) *gin.Engine {
+	router := gin.Default()
+	router.Use(handlers.IdentificarRequisicao())
+
+	// Configurar CORS global
+	router.Use(func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Usuario, Idempotency-Key, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "Location, Idempotent-Replayed, X-Request-ID, Deprecation, Link")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	})
+
+	router.NoRoute(handlers.RotaInexistente)
+
+	// Idempotency-Key nas requisições de escrita: reenvios recebem a resposta já dada. Fica nos
+	// grupos, depois de RotasLegadas, para os erros do middleware saírem no formato da rota.
+	idempotencia := handlers.Idempotencia(ctx)
+	admin := handlers.ExigirTokenAdmin()
+	registrarRotas(router.Group(handlers.PrefixoAPI), idempotencia, admin)
+	registrarRotas(router.Group("", handlers.RotasLegadas()), idempotencia, admin)
+
+	return router
+}
+
+// registrarRotas registra as rotas da API no grupo. As rotas de administração exigem o token
+// (admin) antes da idempotência, para que uma resposta guardada não seja reproduzida sem ele.
+func registrarRotas(grupo *gin.RouterGroup, idempotencia, admin gin.HandlerFunc) {
+	router := grupo.Group("", idempotencia)
+	administracao := grupo.Group("", admin, idempotencia)
+
+	router.POST("/upload", handlers.DecodeNotaFiscal)
+	// Lotes de extração processados em segundo plano
+	router.POST("/jobs", handlers.CriarLote)
+	router.GET("/jobs", handlers.ListarLotes)
+	router.GET("/jobs/:id", handlers.ObterLote)
+	router.POST("/save-nota-fiscal", handlers.SaveNotaFiscal)
+	router.GET("/buscar-notas-fiscais", handlers.BuscarNotasFiscais)
+	router.GET("/notas-fiscais/duplicadas", handlers.ListarDuplicadas)
+	// Recurso REST de notas fiscais salvas
+	router.GET("/notas-fiscais", handlers.ListarNotasFiscais)
+	router.GET("/notas-fiscais/:id", handlers.ObterNotaFiscal)
+	router.PATCH("/notas-fiscais/:id", handlers.CorrigirNotaFiscal)
+	administracao.DELETE("/notas-fiscais/:id", handlers.RemoverNotaFiscal)
+	router.GET("/notas-fiscais/:id/historico", handlers.HistoricoNotaFiscal)
+	// Revisão manual das notas salvas; as decisões (aprovar, rejeitar) e a remoção exigem ADMIN_TOKEN
+	router.PATCH("/notas-fiscais/:id/campos", handlers.CorrigirNotaFiscal)
+	administracao.POST("/notas-fiscais/:id/aprovar", handlers.AprovarNotaFiscal)
+	administracao.POST("/notas-fiscais/:id/rejeitar", handlers.RejeitarNotaFiscal)
+	router.GET("/notas-fiscais/exportar", handlers.ExportarNotasFiscais)
+	router.GET("/notas-fiscais/:id/arquivo", handlers.BaixarArquivoNota)
+	router.GET("/notas-fiscais/:id/preview", handlers.PreviewArquivoNota)
+	router.GET("/arquivos/:chave", handlers.BaixarArquivo)
+	// Retenção e atendimento a titulares de dados (LGPD), com ADMIN_TOKEN: expõem e apagam dados pessoais
+	administracao.POST("/retencao/expurgo", handlers.ExpurgarNotasVencidas)
+	administracao.GET("/titulares/dados", handlers.ExportarDadosTitular)
+	administracao.POST("/titulares/anonimizar", handlers.AnonimizarDadosTitular)
+	// Webhooks de eventos das notas (ERP), com ADMIN_TOKEN
+	administracao.POST("/webhooks", handlers.CriarAssinaturaWebhook)
+	administracao.GET("/webhooks", handlers.ListarAssinaturasWebhook)
+	administracao.DELETE("/webhooks/:id", handlers.RemoverAssinaturaWebhook)
+	administracao.GET("/webhooks/:id/entregas", handlers.ListarEntregasWebhook)
+	administracao.POST("/webhooks/:id/entregas/:entrega/reenviar", handlers.ReenviarEntregaWebhook)
+	// Pedidos de compra e contratos para conciliação das notas
+	router.POST("/contratos", handlers.CriarContrato)
+	router.GET("/contratos", handlers.ListarContratos)
+	router.GET("/contratos/conciliacao", handlers.ConciliarContratos)
+	router.GET("/contratos/:id", handlers.ObterContrato)
+	router.PUT("/contratos/:id", handlers.AtualizarContrato)
+	// Novas rotas para processamento de planilhas
+	router.POST("/process-spreadsheet", handlers.ProcessSpreadsheet)
+	router.POST("/spreadsheet-preview", handlers.GetSpreadsheetPreview)
+	// Especificação OpenAPI e documentação interativa
+	router.GET("/openapi.json", handlers.ServirOpenAPI)
+	router.GET("/docs", handlers.DocumentacaoAPI)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"NF-DECODER-AI/handlers"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rotasAdministracao são as rotas que expõem, apagam ou decidem sobre dados e exigem ADMIN_TOKEN
+var rotasAdministracao = []struct{ metodo, caminho string }{
+	{"DELETE", "/notas-fiscais/inexistente"},
+	{"POST", "/notas-fiscais/inexistente/aprovar"},
+	{"POST", "/notas-fiscais/inexistente/rejeitar"},
+	{"POST", "/retencao/expurgo"},
+	{"GET", "/titulares/dados?cpf=12345678909"},
+	{"POST", "/titulares/anonimizar"},
+	{"POST", "/webhooks"},
+	{"GET", "/webhooks"},
+}
+
+// roteadorTeste monta o roteador da API em um diretório temporário, com o token informado
+func roteadorTeste(t *testing.T, token string) *gin.Engine {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("ADMIN_TOKEN", token)
+	gin.SetMode(gin.ReleaseMode)
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ctx, cancelar := context.WithCancel(context.Background())
+	t.Cleanup(cancelar)
+	return novoRoteador(ctx)
+}
+
+func TestRotasAdministracaoExigemToken(t *testing.T) {
+	router := roteadorTeste(t, "token-de-teste")
+
+	for _, prefixo := range []string{handlers.PrefixoAPI, ""} {
+		for _, rota := range rotasAdministracao {
+			for _, autorizacao := range []string{"", "Bearer outro-token"} {
+				req := httptest.NewRequest(rota.metodo, prefixo+rota.caminho, strings.NewReader(`{}`))
+				req.Header.Set("Content-Type", "application/json")
+				if autorizacao != "" {
+					req.Header.Set("Authorization", autorizacao)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != http.StatusUnauthorized {
+					t.Errorf("%s %s%s com %q: status %d, esperado 401", rota.metodo, prefixo, rota.caminho, autorizacao, w.Code)
+				}
+			}
+		}
+	}
+}
+
+func TestRotasAdministracaoSemTokenConfigurado(t *testing.T) {
+	router := roteadorTeste(t, "")
+
+	for _, rota := range rotasAdministracao {
+		req := httptest.NewRequest(rota.metodo, handlers.PrefixoAPI+rota.caminho, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer ")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s sem ADMIN_TOKEN no servidor: status %d, esperado 503", rota.metodo, rota.caminho, w.Code)
+		}
+	}
+}
@@ -0,0 +1,872 @@
+// Cliente da API gerado por `go run . gerar-cliente` a partir de backend/handlers/openapi.json.
+// Não edite este arquivo: altere a especificação e gere o cliente novamente.
+
+const apiUrl = process.env.REACT_APP_API_URL || '';
+// prefixoApi é a versão da API usada pelo cliente (servers da especificação)
+const prefixoApi = '/api/v1';
+
+// ErroApi é lançado quando a API responde com erro. codigo vem de error.code (ver CodigoErro)
+// e é o que o chamador deve comparar; detalhes e requestId completam o erro.
+export class ErroApi extends Error {
+  constructor(status, corpo) {
+    const erro = (corpo && corpo.error) || {};
+    super(erro.message || `Erro ${status}`);
+    this.name = 'ErroApi';
+    this.status = status;
+    this.codigo = erro.code || 'internal_error';
+    this.detalhes = erro.details || {};
+    this.requestId = erro.request_id || '';
+  }
+}
+
+// requisitar executa a requisição e retorna o JSON da resposta. Respostas em outros formatos
+// (streaming, CSV, arquivos) são retornadas como Response, para o chamador ler o corpo.
+const requisitar = async (metodo, caminho, { query, json, form, headers, signal } = {}) => {
+  const params = new URLSearchParams();
+  Object.entries(query || {}).forEach(([nome, valor]) => {
+    if (valor !== undefined && valor !== null && valor !== '') {
+      params.append(nome, valor);
+    }
+  });
+  const base = `${apiUrl}${prefixoApi}${caminho}`;
+  const url = params.toString() ? `${base}?${params}` : base;
+
+  const init = { method: metodo, headers: { ...headers }, signal };
+  if (json !== undefined) {
+    init.headers['Content-Type'] = 'application/json';
+    init.body = JSON.stringify(json);
+  } else if (form !== undefined) {
+    init.body = form;
+  }
+
+  const response = await fetch(url, init);
+  const tipo = response.headers.get('Content-Type') || '';
+  if (!response.ok) {
+    const corpo = tipo.includes('application/json') ? await response.json().catch(() => null) : null;
+    throw new ErroApi(response.status, corpo);
+  }
+  if (response.status === 204) {
+    return null;
+  }
+  if (tipo.includes('application/json')) {
+    return response.json();
+  }
+  return response;
+};
+
+/**
+ * @typedef {Object} Alteracao
+ * @property {*} antes - Valor anterior (null se ausente)
+ * @property {*} depois - Novo valor (null se ausente)
+ */
+
+/**
+ * @typedef {Object} AnonimizacaoRequest
+ * @property {string} [cpf] - CPF do titular (ou email)
+ * @property {string} [email] - E-mail do titular (ou cpf)
+ * @property {string} [motivo]
+ * @property {string} [protocolo] - Referência da solicitação, registrada na auditoria
+ */
+
+/**
+ * @typedef {Object} ArquivoLote
+ * @property {CodigoErroArquivo} [code]
+ * @property {string} [error]
+ * @property {string} file
+ * @property {number} index
+ * @property {Array<DadosExtraidos>} [records]
+ * @property {'pending'|'processing'|'succeeded'|'failed'} status
+ */
+
+/**
+ * @typedef {Object} AssinaturaWebhook
+ * @property {string} criadoEm
+ * @property {Array<string>} eventos
+ * @property {string} id
+ * @property {string} [segredo] - Só retornado na criação
+ * @property {string} url
+ */
+
+/**
+ * @typedef {Object} AssinaturaWebhookRequest
+ * @property {Array<'nota.salva'|'nota.validada'|'nota.divergente'|'nota.aprovada'|'nota.rejeitada'|'*'>} eventos
+ * @property {string} [segredo] - Segredo das assinaturas HMAC; gerado se omitido
+ * @property {string} url - URL http(s) que recebe os eventos
+ */
+
+/**
+ * @typedef {Object} BuscaNotas
+ * @property {string} competencia
+ * @property {Array<NotaFiscal>} notas_fiscais
+ * @property {number} total
+ */
+
+/**
+ * Código do erro; falhas de extração usam os códigos de `CodigoErroArquivo`
+ * @typedef {'invalid_request'|'not_found'|'unauthorized'|'invalid_state'|'duplicate_note'|'idempotency_in_progress'|'idempotency_key_reused'|'invalid_extracted_data'|'extraction_unavailable'|'service_unavailable'|'partial_failure'|'internal_error'|'file_unreadable'|'unsupported_file'|'conversion_failed'|'provider_error'|'invalid_json'|'invalid_xml'|'empty_result'|'extraction_failed'} CodigoErro
+ */
+
+/** @typedef {'file_unreadable'|'unsupported_file'|'conversion_failed'|'provider_error'|'invalid_json'|'invalid_xml'|'empty_result'|'extraction_failed'|'invalid_archive'|'archive_limit_exceeded'} CodigoErroArquivo */
+
+/**
+ * @typedef {Object} Conciliacao
+ * @property {Array<ResultadoConciliacao>} conciliacao
+ * @property {Object<string, number>} resumo - Quantidade de notas por resultado
+ * @property {number} total
+ */
+
+/**
+ * @typedef {Object} ContagemRedacao
+ * @property {number} entregasWebhook
+ * @property {number} lotes
+ * @property {number} respostasIdempotentes
+ */
+
+/**
+ * @typedef {Object} Contrato
+ * @property {string} cnpjFornecedor
+ * @property {Array<string>} competencias
+ * @property {string} criadoEm
+ * @property {string} descricao
+ * @property {boolean} encerrado
+ * @property {string} id
+ * @property {string} numero
+ * @property {'contrato'|'pedido'} tipo
+ * @property {number} valorTeto
+ * @property {string} vigenciaFim
+ * @property {string} vigenciaInicio
+ */
+
+/**
+ * @typedef {Object} ContratoRequest
+ * @property {string} cnpjFornecedor
+ * @property {Array<string>} [competencias] - Vazio: todas as competências da vigência
+ * @property {string} [descricao]
+ * @property {boolean} [encerrado]
+ * @property {string} [numero]
+ * @property {'contrato'|'pedido'} [tipo]
+ * @property {number} valorTeto
+ * @property {string} vigenciaFim - DD/MM/AAAA
+ * @property {string} vigenciaInicio - DD/MM/AAAA
+ */
+
+/**
+ * @typedef {Object} CorrecaoRequest
+ * @property {Object} campos - Novos valores: numeroNota, serie, competencia, prestador, cnpj, valorServicos, dataNota ou issRetido
+ * @property {string} revisor
+ */
+
+/**
+ * Dados extraídos de uma nota, como retornados pelo modelo
+ * @typedef {Object} DadosExtraidos
+ * @property {string} CNPJ (NF)
+ * @property {string} Competência da Nota Fiscal
+ * @property {string} Data da Nota Fiscal
+ * @property {string} [Duplicada De] - ID da nota já salva com os mesmos dados
+ * @property {number} ISS Retido
+ * @property {string} Número da Nota (NF)
+ * @property {string} Prestador de Serviços
+ * @property {string} Série da Nota (NF)
+ * @property {number} Valor Líquido da Nota Fiscal
+ * @property {number} Valor dos Serviços
+ */
+
+/**
+ * @typedef {Object} DadosPlanilha
+ * @property {Array<string>} headers
+ * @property {Array<Object>} rows
+ * @property {number} total
+ */
+
+/**
+ * @typedef {Object} DecisaoRequest
+ * @property {string} [motivo] - Obrigatório na rejeição
+ * @property {string} revisor
+ */
+
+/**
+ * @typedef {Object} EntregaWebhook
+ * @property {string} assinaturaId
+ * @property {string} criadoEm
+ * @property {string} [entregueEm]
+ * @property {string} evento
+ * @property {string} id
+ * @property {PayloadWebhook} payload
+ * @property {string} [proximaTentativa]
+ * @property {string} [reenvioDe] - Entrega original, nos reenvios
+ * @property {StatusEntrega} status
+ * @property {Array<TentativaEntrega>} tentativas
+ */
+
+/**
+ * @typedef {Object} Erro
+ * @property {Object} error
+ */
+
+/**
+ * @typedef {Object} ErroDataExtraida
+ * @property {Object} error
+ */
+
+/**
+ * @typedef {Object} ErroNotaDuplicada
+ * @property {Object} error
+ */
+
+/**
+ * @typedef {Object} EventoAuditoria
+ * @property {Object<string, Alteracao>} [alteracoes]
+ * @property {string} ator
+ * @property {string} [detalhes]
+ * @property {string} momento
+ * @property {string} notaId
+ * @property {'upload'|'extracao'|'importacao'|'correcao'|'aprovacao'|'rejeicao'|'remocao'|'expurgo'|'exportacao_titular'|'anonimizacao'|'redacao'} operacao
+ * @property {string} [versaoExtrator]
+ */
+
+/**
+ * @typedef {Object} EventoUpload
+ * @property {CodigoErroArquivo} [code]
+ * @property {string} [error]
+ * @property {string} [file]
+ * @property {number} index - Índice do arquivo (a partir de 0); em done, igual a total
+ * @property {number} [processed]
+ * @property {DadosExtraidos} [record]
+ * @property {ResumoUpload} [summary]
+ * @property {number} total
+ * @property {'file_started'|'record'|'file_failed'|'progress'|'done'} type
+ */
+
+/**
+ * @typedef {Object} ExportacaoAprovadas
+ * @property {Array<NotaFiscal>} notas_fiscais
+ * @property {number} total
+ */
+
+/**
+ * @typedef {Object} ExportacaoTitular
+ * @property {Array<Contrato>} contratos
+ * @property {string} gerado_em
+ * @property {Array<NotaTitular>} notas_fiscais
+ * @property {Titular} titular
+ */
+
+/**
+ * @typedef {Object} FalhaUpload
+ * @property {CodigoErroArquivo} code
+ * @property {string} file
+ * @property {number} index
+ */
+
+/**
+ * @typedef {Object} GrupoDuplicidade
+ * @property {string} chave
+ * @property {Array<NotaFiscal>} notas
+ * @property {'exata'|'arquivo'|'aproximada'} tipo
+ */
+
+/**
+ * @typedef {Object} Historico
+ * @property {Array<EventoAuditoria>} historico
+ * @property {string} nota_id
+ * @property {number} total
+ */
+
+/**
+ * @typedef {Object} ListaContratos
+ * @property {Array<Contrato>} contratos
+ * @property {number} total
+ */
+
+/**
+ * @typedef {Object} ListaDuplicadas
+ * @property {Array<GrupoDuplicidade>} duplicadas
+ * @property {number} total
+ */
+
+/**
+ * @typedef {Object} ListaNotas
+ * @property {Array<NotaFiscal>} notas_fiscais
+ * @property {string|null} proximo_cursor - Cursor da próxima página; null na última
+ * @property {number} quantidade
+ */
+
+/**
+ * @typedef {Object} Lote
+ * @property {string} actor
+ * @property {string} createdAt
+ * @property {string} [error]
+ * @property {Array<ArquivoLote>} [files]
+ * @property {string} [finishedAt]
+ * @property {string} id
+ * @property {number} processed
+ * @property {'queued'|'processing'|'completed'|'failed'} status
+ * @property {ResumoUpload} [summary]
+ * @property {number} total
+ * @property {string} updatedAt
+ */
+
+/**
+ * @typedef {Object} Mensagem
+ * @property {string} message
+ */
+
+/**
+ * @typedef {Object} NotaAlterada
+ * @property {NotaFiscal} data
+ * @property {string} message
+ */
+
+/**
+ * @typedef {Object} NotaExpurgada
+ * @property {string} id
+ * @property {string} numeroNota
+ * @property {string} status
+ * @property {string} venceuEm
+ */
+
+/**
+ * @typedef {Object} NotaFiscal
+ * @property {string} arquivo - Chave do arquivo (ver `/arquivos/{chave}`)
+ * @property {string} cnpj - CNPJ ou CPF do prestador
+ * @property {string} competencia - MM/AAAA; vazia se não informada
+ * @property {string} [criadoEm]
+ * @property {string} dataNota - DD/MM/AAAA; vazia se não informada
+ * @property {string} email - E-mail do envio (vazio após anonimização)
+ * @property {string} hashArquivo - SHA-256 do arquivo
+ * @property {string} id
+ * @property {number} issRetido
+ * @property {string} [motivoRejeicao]
+ * @property {string} [nomeArquivo] - Nome do arquivo enviado
+ * @property {string} numeroNota
+ * @property {string} prestador
+ * @property {string} [removidoEm]
+ * @property {string} [revisadoEm]
+ * @property {string} [revisor]
+ * @property {string} serie
+ * @property {StatusNota} status
+ * @property {number} valorServicos
+ * @property {Object} [valoresOriginais] - Valores extraídos dos campos corrigidos na revisão
+ * @property {string} [versaoExtrator] - Modelo e prompt usados na extração
+ */
+
+/**
+ * @typedef {Object} NotaResposta
+ * @property {NotaFiscal} data
+ */
+
+/**
+ * @typedef {Object} NotaSalva
+ * @property {ResultadoConciliacao} conciliacao
+ * @property {NotaFiscal} data
+ * @property {DadosExtraidos} extracted_data
+ * @property {string} filename - Chave do arquivo salvo
+ * @property {string} message
+ */
+
+/**
+ * @typedef {Object} NotaTitular
+ * @property {Array<EventoAuditoria>} historico
+ * @property {NotaFiscal} nota
+ */
+
+/**
+ * @typedef {Object} PayloadWebhook
+ * @property {string} criadoEm
+ * @property {Object} dados
+ * @property {string} evento
+ * @property {string} id - ID do evento; repete-se nos reenvios
+ */
+
+/**
+ * @typedef {Object} PoliticaRetencao
+ * @property {number} anos
+ * @property {Object<string, number>} [anosPorStatus]
+ * @property {number} diasEntregasWebhook
+ * @property {number} diasLotes
+ */
+
+/**
+ * @typedef {Object} RelatorioExpurgo
+ * @property {number} arquivosRemovidos
+ * @property {ContagemRedacao} copiasRedigidas
+ * @property {number} entregasWebhookExpurgadas
+ * @property {Array<NotaExpurgada>} expurgadas
+ * @property {Array<string>} falhas
+ * @property {number} lotesExpurgados
+ * @property {boolean} simulacao
+ * @property {number} verificadas
+ */
+
+/**
+ * @typedef {Object} RespostaPlanilha
+ * @property {DadosPlanilha} data
+ * @property {string} message
+ * @property {boolean} success
+ */
+
+/**
+ * @typedef {Object} ResultadoAnonimizacao
+ * @property {number} arquivos_removidos
+ * @property {ContagemRedacao} copias_redigidas
+ * @property {Array<string>} falhas
+ * @property {number} notas_anonimizadas
+ */
+
+/**
+ * @typedef {Object} ResultadoConciliacao
+ * @property {string} cnpj
+ * @property {string} competencia
+ * @property {string} [contratoId]
+ * @property {string} [contratoNumero]
+ * @property {number} [excedente]
+ * @property {string} mensagem
+ * @property {string} notaId
+ * @property {string} numeroNota
+ * @property {StatusConciliacao} status
+ * @property {number} valor
+ * @property {number} [valorFaturado] - Acumulado no contrato, incluindo esta nota
+ * @property {number} [valorTeto]
+ */
+
+/**
+ * @typedef {Object} ResultadoExpurgo
+ * @property {PoliticaRetencao} politica
+ * @property {RelatorioExpurgo} relatorio
+ */
+
+/**
+ * @typedef {Object} ResumoUpload
+ * @property {number} failed
+ * @property {Array<FalhaUpload>} failures
+ * @property {number} records
+ * @property {number} succeeded
+ */
+
+/** @typedef {'conforme'|'sem_contrato'|'contrato_expirado'|'valor_excedido'} StatusConciliacao */
+
+/** @typedef {'pendente'|'entregue'|'falhou'} StatusEntrega */
+
+/** @typedef {'pendente'|'corrigida'|'aprovada'|'rejeitada'} StatusNota */
+
+/**
+ * @typedef {Object} TentativaEntrega
+ * @property {number} duracao - Nanossegundos
+ * @property {string} [erro]
+ * @property {string} momento
+ * @property {number} [statusHttp]
+ */
+
+/**
+ * @typedef {Object} Titular
+ * @property {string} [cpf]
+ * @property {string} [email]
+ */
+
+/**
+ * Arquivo armazenado, pela chave (campo `arquivo` da nota)
+ * GET /arquivos/{chave}
+ * @param {string} chave
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Response>}
+ */
+export const baixarArquivo = (chave, opcoes = {}) =>
+  requisitar('GET', `/arquivos/${encodeURIComponent(chave)}`, { ...opcoes });
+
+/**
+ * Busca notas por competência ou período (ao menos um filtro é obrigatório)
+ * GET /buscar-notas-fiscais
+ * @param {Object} [query]
+ * @param {string} [query.competencia] - Competência exata (MM/AAAA)
+ * @param {string} [query.competencia_inicio] - Competência inicial (MM/AAAA)
+ * @param {string} [query.competencia_fim] - Competência final (MM/AAAA)
+ * @param {string} [query.data_inicio] - Data inicial da nota (DD/MM/AAAA)
+ * @param {string} [query.data_fim] - Data final da nota (DD/MM/AAAA)
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<BuscaNotas>}
+ */
+export const buscarNotasFiscais = (query = {}, opcoes = {}) =>
+  requisitar('GET', `/buscar-notas-fiscais`, { ...opcoes, query });
+
+/**
+ * Lista pedidos de compra e contratos
+ * GET /contratos
+ * @param {Object} [query]
+ * @param {string} [query.cnpj] - CNPJ do fornecedor
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ListaContratos>}
+ */
+export const listarContratos = (query = {}, opcoes = {}) =>
+  requisitar('GET', `/contratos`, { ...opcoes, query });
+
+/**
+ * Cadastra um pedido de compra ou contrato
+ * POST /contratos
+ * @param {ContratoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Contrato>}
+ */
+export const criarContrato = (corpo, opcoes = {}) =>
+  requisitar('POST', `/contratos`, { ...opcoes, json: corpo });
+
+/**
+ * Conciliação das notas salvas com os contratos
+ * GET /contratos/conciliacao
+ * @param {Object} [query]
+ * @param {string} [query.competencia] - Competência exata (MM/AAAA)
+ * @param {string} [query.competencia_inicio] - Competência inicial (MM/AAAA)
+ * @param {string} [query.competencia_fim] - Competência final (MM/AAAA)
+ * @param {string} [query.data_inicio] - Data inicial da nota (DD/MM/AAAA)
+ * @param {string} [query.data_fim] - Data final da nota (DD/MM/AAAA)
+ * @param {StatusConciliacao} [query.status] - Resultado da conciliação
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Conciliacao>}
+ */
+export const conciliarContratos = (query = {}, opcoes = {}) =>
+  requisitar('GET', `/contratos/conciliacao`, { ...opcoes, query });
+
+/**
+ * Dados de um contrato
+ * GET /contratos/{id}
+ * @param {string} id
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Contrato>}
+ */
+export const obterContrato = (id, opcoes = {}) =>
+  requisitar('GET', `/contratos/${encodeURIComponent(id)}`, { ...opcoes });
+
+/**
+ * Atualiza um contrato (ex.: `"encerrado": true`)
+ * PUT /contratos/{id}
+ * @param {string} id
+ * @param {ContratoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Contrato>}
+ */
+export const atualizarContrato = (id, corpo, opcoes = {}) =>
+  requisitar('PUT', `/contratos/${encodeURIComponent(id)}`, { ...opcoes, json: corpo });
+
+/**
+ * Documentação interativa (Swagger UI)
+ * GET /docs
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Response>}
+ */
+export const documentacao = (opcoes = {}) =>
+  requisitar('GET', `/docs`, { ...opcoes });
+
+/**
+ * Lista os lotes, do mais recente ao mais antigo, sem os resultados por arquivo
+ * GET /jobs
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Array<Lote>>}
+ */
+export const listarLotes = (opcoes = {}) =>
+  requisitar('GET', `/jobs`, { ...opcoes });
+
+/**
+ * Cria um lote de extração processado em segundo plano
+ * POST /jobs
+ * @param {FormData} form
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Lote>}
+ */
+export const criarLote = (form, opcoes = {}) =>
+  requisitar('POST', `/jobs`, { ...opcoes, form });
+
+/**
+ * Andamento e resultado de um lote
+ * GET /jobs/{id}
+ * @param {string} id
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Lote>}
+ */
+export const obterLote = (id, opcoes = {}) =>
+  requisitar('GET', `/jobs/${encodeURIComponent(id)}`, { ...opcoes });
+
+/**
+ * Lista as notas salvas com filtros, ordenação e paginação por cursor
+ * GET /notas-fiscais
+ * @param {Object} [query]
+ * @param {string} [query.cnpj] - CNPJ ou CPF do prestador (com ou sem pontuação)
+ * @param {string} [query.prestador] - Trecho do nome do prestador
+ * @param {string} [query.email] - E-mail do envio
+ * @param {StatusNota} [query.status] - Situação da revisão
+ * @param {string} [query.competencia] - Competência exata (MM/AAAA)
+ * @param {string} [query.competencia_inicio] - Competência inicial (MM/AAAA)
+ * @param {string} [query.competencia_fim] - Competência final (MM/AAAA)
+ * @param {string} [query.data_inicio] - Data inicial da nota (DD/MM/AAAA)
+ * @param {string} [query.data_fim] - Data final da nota (DD/MM/AAAA)
+ * @param {string} [query.valor_min] - Valor mínimo dos serviços (ponto ou vírgula decimal)
+ * @param {string} [query.valor_max] - Valor máximo dos serviços
+ * @param {'dataNota'|'-dataNota'|'competencia'|'-competencia'|'valorServicos'|'-valorServicos'|'criadoEm'|'-criadoEm'|'prestador'|'-prestador'} [query.ordenar] - Campo de ordenação; prefixo `-` para ordem decrescente
+ * @param {number} [query.limite] - Tamanho da página
+ * @param {string} [query.cursor] - Valor de `proximo_cursor` da página anterior
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ListaNotas>}
+ */
+export const listarNotasFiscais = (query = {}, opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais`, { ...opcoes, query });
+
+/**
+ * Lista grupos de notas suspeitas de duplicidade
+ * GET /notas-fiscais/duplicadas
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ListaDuplicadas>}
+ */
+export const listarDuplicadas = (opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais/duplicadas`, { ...opcoes });
+
+/**
+ * Exporta as notas aprovadas em CSV (padrão) ou JSON
+ * GET /notas-fiscais/exportar
+ * @param {Object} [query]
+ * @param {string} [query.competencia] - Competência exata (MM/AAAA)
+ * @param {string} [query.competencia_inicio] - Competência inicial (MM/AAAA)
+ * @param {string} [query.competencia_fim] - Competência final (MM/AAAA)
+ * @param {string} [query.data_inicio] - Data inicial da nota (DD/MM/AAAA)
+ * @param {string} [query.data_fim] - Data final da nota (DD/MM/AAAA)
+ * @param {'csv'|'json'} [query.formato] - Formato da exportação
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ExportacaoAprovadas|Response>}
+ */
+export const exportarNotasFiscais = (query = {}, opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais/exportar`, { ...opcoes, query });
+
+/**
+ * Dados de uma nota
+ * GET /notas-fiscais/{id}
+ * @param {string} id
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<NotaResposta>}
+ */
+export const obterNotaFiscal = (id, opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais/${encodeURIComponent(id)}`, { ...opcoes });
+
+/**
+ * Corrige campos da nota (mesmo que `PATCH /notas-fiscais/{id}/campos`)
+ * PATCH /notas-fiscais/{id}
+ * @param {string} id
+ * @param {CorrecaoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<NotaAlterada>}
+ */
+export const corrigirNotaFiscal = (id, corpo, opcoes = {}) =>
+  requisitar('PATCH', `/notas-fiscais/${encodeURIComponent(id)}`, { ...opcoes, json: corpo });
+
+/**
+ * Exclusão lógica: a nota deixa de ser listada e de bloquear reenvios
+ * DELETE /notas-fiscais/{id}
+ * @param {string} id
+ * @param {Object} [query]
+ * @param {string} [query.motivo] - Motivo, registrado na auditoria
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Mensagem>}
+ */
+export const removerNotaFiscal = (id, query = {}, opcoes = {}) =>
+  requisitar('DELETE', `/notas-fiscais/${encodeURIComponent(id)}`, { ...opcoes, query });
+
+/**
+ * Aprova uma nota pendente ou corrigida
+ * POST /notas-fiscais/{id}/aprovar
+ * @param {string} id
+ * @param {DecisaoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<NotaAlterada>}
+ */
+export const aprovarNotaFiscal = (id, corpo, opcoes = {}) =>
+  requisitar('POST', `/notas-fiscais/${encodeURIComponent(id)}/aprovar`, { ...opcoes, json: corpo });
+
+/**
+ * Arquivo original da nota (exibido no navegador, ou anexo com `download=true`)
+ * GET /notas-fiscais/{id}/arquivo
+ * @param {string} id
+ * @param {Object} [query]
+ * @param {boolean} [query.download] - Envia como anexo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Response>}
+ */
+export const baixarArquivoNota = (id, query = {}, opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais/${encodeURIComponent(id)}/arquivo`, { ...opcoes, query });
+
+/**
+ * Corrige campos da nota, preservando o valor extraído em `valoresOriginais`
+ * PATCH /notas-fiscais/{id}/campos
+ * @param {string} id
+ * @param {CorrecaoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<NotaAlterada>}
+ */
+export const corrigirCamposNotaFiscal = (id, corpo, opcoes = {}) =>
+  requisitar('PATCH', `/notas-fiscais/${encodeURIComponent(id)}/campos`, { ...opcoes, json: corpo });
+
+/**
+ * Trilha de auditoria da nota, inclusive de notas removidas
+ * GET /notas-fiscais/{id}/historico
+ * @param {string} id
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Historico>}
+ */
+export const historicoNotaFiscal = (id, opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais/${encodeURIComponent(id)}/historico`, { ...opcoes });
+
+/**
+ * Página do PDF da nota renderizada como PNG
+ * GET /notas-fiscais/{id}/preview
+ * @param {string} id
+ * @param {Object} [query]
+ * @param {number} [query.pagina] - Página (a partir de 1)
+ * @param {number} [query.dpi] - Resolução
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Response>}
+ */
+export const previewArquivoNota = (id, query = {}, opcoes = {}) =>
+  requisitar('GET', `/notas-fiscais/${encodeURIComponent(id)}/preview`, { ...opcoes, query });
+
+/**
+ * Rejeita uma nota pendente ou corrigida (`motivo` obrigatório)
+ * POST /notas-fiscais/{id}/rejeitar
+ * @param {string} id
+ * @param {DecisaoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<NotaAlterada>}
+ */
+export const rejeitarNotaFiscal = (id, corpo, opcoes = {}) =>
+  requisitar('POST', `/notas-fiscais/${encodeURIComponent(id)}/rejeitar`, { ...opcoes, json: corpo });
+
+/**
+ * Esta especificação
+ * GET /openapi.json
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Object>}
+ */
+export const obterEspecificacao = (opcoes = {}) =>
+  requisitar('GET', `/openapi.json`, { ...opcoes });
+
+/**
+ * Lê todas as linhas de uma planilha
+ * POST /process-spreadsheet
+ * @param {FormData} form
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<RespostaPlanilha>}
+ */
+export const processarPlanilha = (form, opcoes = {}) =>
+  requisitar('POST', `/process-spreadsheet`, { ...opcoes, form });
+
+/**
+ * Executa o expurgo das notas com prazo de guarda vencido
+ * POST /retencao/expurgo
+ * @param {Object} [query]
+ * @param {boolean} [query.simular] - Apenas lista as notas que seriam apagadas
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ResultadoExpurgo>}
+ */
+export const expurgarNotasVencidas = (query = {}, opcoes = {}) =>
+  requisitar('POST', `/retencao/expurgo`, { ...opcoes, query });
+
+/**
+ * Extrai e salva uma nota fiscal enviada pelo prestador
+ * POST /save-nota-fiscal
+ * @param {FormData} form
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<NotaSalva>}
+ */
+export const salvarNotaFiscal = (form, opcoes = {}) =>
+  requisitar('POST', `/save-nota-fiscal`, { ...opcoes, form });
+
+/**
+ * Lê as 10 primeiras linhas de uma planilha
+ * POST /spreadsheet-preview
+ * @param {FormData} form
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<RespostaPlanilha>}
+ */
+export const previaPlanilha = (form, opcoes = {}) =>
+  requisitar('POST', `/spreadsheet-preview`, { ...opcoes, form });
+
+/**
+ * Anonimiza as notas do titular e apaga seus arquivos
+ * POST /titulares/anonimizar
+ * @param {AnonimizacaoRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ResultadoAnonimizacao>}
+ */
+export const anonimizarDadosTitular = (corpo, opcoes = {}) =>
+  requisitar('POST', `/titulares/anonimizar`, { ...opcoes, json: corpo });
+
+/**
+ * Exporta as notas, os históricos e os contratos ligados ao titular (informe `email` ou `cpf`)
+ * GET /titulares/dados
+ * @param {Object} [query]
+ * @param {string} [query.email] - E-mail do titular
+ * @param {string} [query.cpf] - CPF do titular
+ * @param {boolean} [query.download] - Envia como arquivo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<ExportacaoTitular>}
+ */
+export const exportarDadosTitular = (query = {}, opcoes = {}) =>
+  requisitar('GET', `/titulares/dados`, { ...opcoes, query });
+
+/**
+ * Extrai os dados de notas fiscais enviadas, com o resultado em streaming
+ * POST /upload
+ * @param {FormData} form
+ * @param {Object} [query]
+ * @param {'sse'|'ndjson'|'legado'} [query.formato] - Formato do streaming
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Response>}
+ */
+export const extrairNotasFiscais = (form, query = {}, opcoes = {}) =>
+  requisitar('POST', `/upload`, { ...opcoes, query, form });
+
+/**
+ * Lista as assinaturas, sem os segredos
+ * GET /webhooks
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Array<AssinaturaWebhook>>}
+ */
+export const listarAssinaturasWebhook = (opcoes = {}) =>
+  requisitar('GET', `/webhooks`, { ...opcoes });
+
+/**
+ * Inscreve uma URL em eventos das notas; o segredo só é retornado nesta resposta
+ * POST /webhooks
+ * @param {AssinaturaWebhookRequest} corpo
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<AssinaturaWebhook>}
+ */
+export const criarAssinaturaWebhook = (corpo, opcoes = {}) =>
+  requisitar('POST', `/webhooks`, { ...opcoes, json: corpo });
+
+/**
+ * Remove a assinatura; entregas pendentes deixam de ser tentadas
+ * DELETE /webhooks/{id}
+ * @param {string} id
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<null>}
+ */
+export const removerAssinaturaWebhook = (id, opcoes = {}) =>
+  requisitar('DELETE', `/webhooks/${encodeURIComponent(id)}`, { ...opcoes });
+
+/**
+ * Registro das entregas da assinatura, da mais recente à mais antiga
+ * GET /webhooks/{id}/entregas
+ * @param {string} id
+ * @param {Object} [query]
+ * @param {StatusEntrega} [query.status] - Situação da entrega
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<Array<EntregaWebhook>>}
+ */
+export const listarEntregasWebhook = (id, query = {}, opcoes = {}) =>
+  requisitar('GET', `/webhooks/${encodeURIComponent(id)}/entregas`, { ...opcoes, query });
+
+/**
+ * Reenvia uma entrega como nova entrega, com o mesmo ID de evento
+ * POST /webhooks/{id}/entregas/{entrega}/reenviar
+ * @param {string} id
+ * @param {string} entrega
+ * @param {{headers?: Object<string, string>, signal?: AbortSignal}} [opcoes]
+ * @returns {Promise<EntregaWebhook>}
+ */
+export const reenviarEntregaWebhook = (id, entrega, opcoes = {}) =>
+  requisitar('POST', `/webhooks/${encodeURIComponent(id)}/entregas/${encodeURIComponent(entrega)}/reenviar`, { ...opcoes });
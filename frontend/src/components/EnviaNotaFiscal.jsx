@@ -1,5 +1,7 @@
-import React, { useState, useCallback } from 'react';
+import React, { useState, useCallback, useMemo } from 'react';
 import { useDropzone } from 'react-dropzone';
+import { novaChaveIdempotencia } from '../idempotencia';
+import { salvarNotaFiscal } from '../api';
 
 const EnviaNotaFiscal = () => {
   const [email, setEmail] = useState('');
@@ -10,6 +12,11 @@ const EnviaNotaFiscal = () => {
   const [error, setError] = useState('');
   const [success, setSuccess] = useState('');
   const [extractedData, setExtractedData] = useState(null);
+  // Mesma chave enquanto o formulário não muda: reenviar após uma falha não duplica a nota
+  const idempotencyKey = useMemo(
+    () => novaChaveIdempotencia(),
+    [email, numeroNota, competencia, notaFiscal]
+  );
 
   const onDrop = useCallback((acceptedFiles) => {
     if (acceptedFiles.length > 0) {
@@ -47,18 +54,9 @@ const EnviaNotaFiscal = () => {
       formData.append('competencia', competencia);
       formData.append('notaFiscal', notaFiscal);
 
-      const apiUrl = process.env.REACT_APP_API_URL || '';
-      const response = await fetch(`${apiUrl}/save-nota-fiscal`, {
-        method: 'POST',
-        body: formData,
+      const result = await salvarNotaFiscal(formData, {
+        headers: { 'Idempotency-Key': idempotencyKey },
       });
-
-      if (!response.ok) {
-        const errData = await response.json();
-        throw new Error(errData.error || 'Erro ao salvar nota fiscal.');
-      }
-
-      const result = await response.json();
       setSuccess('Nota fiscal enviada e salva com sucesso!');
       setExtractedData(result.extracted_data);
       
@@ -69,7 +67,11 @@ const EnviaNotaFiscal = () => {
       setNotaFiscal(null);
       setExtractedData(null);
     } catch (err) {
-      setError(err.message);
+      if (err.codigo === 'duplicate_note') {
+        setError(`Esta nota fiscal já foi enviada anteriormente (registro ${err.detalhes.duplicada_de}).`);
+      } else {
+        setError(err.message);
+      }
     } finally {
       setLoading(false);
     }
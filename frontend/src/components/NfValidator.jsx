@@ -1,6 +1,7 @@
 import React, { useState, useCallback } from 'react';
 import { useDropzone } from 'react-dropzone';
 import ConfirmDialog from './ConfirmDialog';
+import { buscarNotasFiscais, extrairNotasFiscais, processarPlanilha } from '../api';
 
 const parseCurrency = (value) => {
   if (typeof value === 'number') return value;
@@ -34,6 +35,7 @@ const NfValidator = () => {
   const [comparisonData, setComparisonData] = useState([]);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState('');
+  const [uploadSummary, setUploadSummary] = useState(null);
   const [newNf, setNewNf] = useState({ cnpj: '', numero: '', valor: '', prestador: '', issRetido: '' });
   const [searchCompetencia, setSearchCompetencia] = useState('');
   const [searching, setSearching] = useState(false);
@@ -82,22 +84,7 @@ const NfValidator = () => {
     setSpreadsheetData(null);
 
     try {
-      const apiUrl = process.env.REACT_APP_API_URL || '';
-      const response = await fetch(`${apiUrl}/buscar-notas-fiscais?competencia=${encodeURIComponent(searchCompetencia)}`);
-
-      if (!response.ok) {
-        let errMsg = 'Erro ao buscar notas fiscais.';
-        try {
-          const errData = await response.json();
-          errMsg = errData.error || errMsg;
-        } catch {
-          const text = await response.text();
-          errMsg = `Erro ${response.status}: ${text}`;
-        }
-        throw new Error(errMsg);
-      }
-
-      const result = await response.json();
+      const result = await buscarNotasFiscais({ competencia: searchCompetencia });
       
       const notasConvertidas = (result.notas_fiscais || []).map(nota => ({
         nfCnpj: nota.cnpj || 'N/A',
@@ -149,6 +136,7 @@ const NfValidator = () => {
 
     setLoading(true);
     setError('');
+    setUploadSummary(null);
 
     const formData = new FormData();
     acceptedFiles.forEach(file => {
@@ -156,16 +144,82 @@ const NfValidator = () => {
     });
 
     try {
-      const apiUrl = process.env.REACT_APP_API_URL || '';
-      const response = await fetch(`${apiUrl}/upload`, {
-        method: 'POST',
-        body: formData,
+      const response = await extrairNotasFiscais(formData, {}, {
+        headers: { Accept: 'application/x-ndjson' },
       });
 
-      if (!response.ok) {
-        const errData = await response.json();
-        throw new Error(errData.error || 'Erro no servidor ao processar PDFs.');
-      }
+      const handleRecord = (nf) => {
+        console.log('JSON recebido do PDF:', nf);
+
+        const newNfData = {
+          cnpj: nf['CNPJ (NF)'],
+          prestador: nf['Prestador de Serviços'],
+          numero: nf['Número da Nota (NF)'],
+          valor: parseCurrency(nf['Valor Líquido da Nota Fiscal']),
+          issRetido: parseCurrency(nf['ISS Retido']),
+        };
+
+        setComparisonData(prevData => {
+          const key = `${normalizeString(newNfData.cnpj)}-${normalizeString(newNfData.numero)}`;
+          
+          const matchIndex = prevData.findIndex(item => 
+              item.status === 'Aguardando PDF' &&
+              `${normalizeString(item.plCnpj)}-${normalizeString(item.plNumero)}` === key
+          );
+
+          if (matchIndex !== -1) {
+            const updatedData = [...prevData];
+            const existingItem = updatedData[matchIndex];
+            
+            existingItem.nfCnpj = newNfData.cnpj;
+            if (newNfData.prestador && newNfData.prestador !== 'N/A') {
+              existingItem.nfPrestador = newNfData.prestador;
+            }
+            existingItem.nfNumero = newNfData.numero;
+            existingItem.nfValor = newNfData.valor;
+
+            const nfValor = existingItem.nfValor;
+            const plValor = existingItem.plValor;
+            existingItem.status = Math.abs(nfValor - plValor) < 0.01 ? 'Validada' : 'Divergente';
+
+            return updatedData;
+          } else {
+            const pdfExists = prevData.some(item => `${normalizeString(item.nfCnpj)}-${normalizeString(item.nfNumero)}` === key);
+            if (!pdfExists) {
+              const newRow = {
+                nfCnpj: newNfData.cnpj,
+                nfPrestador: newNfData.prestador,
+                nfNumero: newNfData.numero,
+                nfValor: newNfData.valor,
+                plCnpj: 'N/A',
+                plNumero: 'N/A',
+                plValor: 0.0,
+                status: 'Importada',
+              };
+              return [...prevData, newRow];
+            }
+            return prevData;
+          }
+        });
+      };
+
+      // Cada linha é um evento: file_started, record, file_failed, progress ou done
+      const handleEvent = (event) => {
+        switch (event.type) {
+          case 'record':
+            handleRecord(event.record);
+            break;
+          case 'file_failed':
+            setError(prev => `${prev ? `${prev}\n` : ''}${event.file}: ${event.error}`);
+            break;
+          case 'done':
+            setUploadSummary(event.summary);
+            setLoading(false);
+            break;
+          default:
+            break;
+        }
+      };
 
       const reader = response.body.getReader();
       const decoder = new TextDecoder();
@@ -180,70 +234,18 @@ const NfValidator = () => {
           }
 
           buffer += decoder.decode(value, { stream: true });
-          const parts = buffer.split('\n---\n');
-          
-          parts.slice(0, -1).forEach(part => {
-            if (part.trim() === '') return;
-            try {
-              const nf = JSON.parse(part);
-              console.log('JSON recebido do PDF:', nf);
-
-              const newNfData = {
-                cnpj: nf['CNPJ (NF)'],
-                prestador: nf['Prestador de Serviços'],
-                numero: nf['Número da Nota (NF)'],
-                valor: parseCurrency(nf['Valor Líquido da Nota Fiscal']),
-                issRetido: parseCurrency(nf['ISS Retido']),
-              };
+          const lines = buffer.split('\n');
 
-              setComparisonData(prevData => {
-                const key = `${normalizeString(newNfData.cnpj)}-${normalizeString(newNfData.numero)}`;
-                
-                const matchIndex = prevData.findIndex(item => 
-                    item.status === 'Aguardando PDF' &&
-                    `${normalizeString(item.plCnpj)}-${normalizeString(item.plNumero)}` === key
-                );
-
-                if (matchIndex !== -1) {
-                  const updatedData = [...prevData];
-                  const existingItem = updatedData[matchIndex];
-                  
-                  existingItem.nfCnpj = newNfData.cnpj;
-                  if (newNfData.prestador && newNfData.prestador !== 'N/A') {
-                    existingItem.nfPrestador = newNfData.prestador;
-                  }
-                  existingItem.nfNumero = newNfData.numero;
-                  existingItem.nfValor = newNfData.valor;
-
-                  const nfValor = existingItem.nfValor;
-                  const plValor = existingItem.plValor;
-                  existingItem.status = Math.abs(nfValor - plValor) < 0.01 ? 'Validada' : 'Divergente';
-
-                  return updatedData;
-                } else {
-                  const pdfExists = prevData.some(item => `${normalizeString(item.nfCnpj)}-${normalizeString(item.nfNumero)}` === key);
-                  if (!pdfExists) {
-                    const newRow = {
-                      nfCnpj: newNfData.cnpj,
-                      nfPrestador: newNfData.prestador,
-                      nfNumero: newNfData.numero,
-                      nfValor: newNfData.valor,
-                      plCnpj: 'N/A',
-                      plNumero: 'N/A',
-                      plValor: 0.0,
-                      status: 'Importada',
-                    };
-                    return [...prevData, newRow];
-                  }
-                  return prevData;
-                }
-              });
+          lines.slice(0, -1).forEach(line => {
+            if (line.trim() === '') return;
+            try {
+              handleEvent(JSON.parse(line));
             } catch (e) {
-              console.error("Failed to parse JSON chunk", e);
+              console.error("Failed to parse stream event", e);
             }
           });
 
-          buffer = parts[parts.length - 1];
+          buffer = lines[lines.length - 1];
         }
       };
 
@@ -266,18 +268,7 @@ const NfValidator = () => {
     formData.append('file', file);
 
     try {
-      const apiUrl = process.env.REACT_APP_API_URL || '';
-      const response = await fetch(`${apiUrl}/process-spreadsheet`, {
-        method: 'POST',
-        body: formData,
-      });
-
-      if (!response.ok) {
-        const errData = await response.json();
-        throw new Error(errData.error || 'Erro ao processar planilha.');
-      }
-
-      const result = await response.json();
+      const result = await processarPlanilha(formData);
 
       if (result.success) {
         setSpreadsheetData(result.data);
@@ -362,7 +353,11 @@ const NfValidator = () => {
 
   const { getRootProps: getPdfRootProps, getInputProps: getPdfInputProps } = useDropzone({
     onDrop: onPdfDrop,
-    accept: { 'application/pdf': ['.pdf'] },
+    accept: {
+      'application/pdf': ['.pdf'],
+      'application/zip': ['.zip'],
+      'application/gzip': ['.tar.gz', '.tgz']
+    },
     multiple: true
   });
   
@@ -420,7 +415,13 @@ const NfValidator = () => {
           </div>
         )}
 
-        {error && <div className="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded relative mb-4" role="alert">{error}</div>}
+        {uploadSummary && (
+          <div className={`${uploadSummary.failed > 0 ? 'bg-yellow-100 border-yellow-400 text-yellow-800' : 'bg-green-100 border-green-400 text-green-800'} border px-4 py-3 rounded relative mb-4`} role="status">
+            {uploadSummary.succeeded + uploadSummary.failed} arquivo(s) processado(s): {uploadSummary.succeeded} com sucesso, {uploadSummary.failed} com falha ({uploadSummary.records} nota(s) extraída(s))
+          </div>
+        )}
+
+        {error && <div className="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded relative mb-4 whitespace-pre-line" role="alert">{error}</div>}
 
         <div className="mb-6 flex gap-4 items-end">
           <div className="flex-1 max-w-xs">
@@ -449,7 +450,7 @@ const NfValidator = () => {
           <div {...getPdfRootProps()} className="border-2 border-dashed border-gray-300 rounded-lg p-6 text-center cursor-pointer hover:border-blue-500 bg-gray-50 transition-colors">
             <input {...getPdfInputProps()} />
             <p className="text-blue-600 font-semibold">IMPORTAR NOTAS FISCAIS (PDF)</p>
-            <p className="text-sm text-gray-500 mt-1">Arraste e solte os PDFs aqui, ou clique para selecionar</p>
+            <p className="text-sm text-gray-500 mt-1">Arraste e solte os PDFs (ou arquivos .zip com PDFs) aqui, ou clique para selecionar</p>
           </div>
           <div {...getSpreadsheetRootProps()} className="border-2 border-dashed border-gray-300 rounded-lg p-6 text-center cursor-pointer hover:border-purple-500 bg-gray-50 transition-colors">
             <input {...getSpreadsheetInputProps()} />
@@ -0,0 +1,8 @@
+// Gera uma chave para o cabeçalho Idempotency-Key: reenvios com a mesma chave recebem a
+// resposta do primeiro envio em vez de criar outra nota fiscal
+export const novaChaveIdempotencia = () => {
+  if (window.crypto && window.crypto.randomUUID) {
+    return window.crypto.randomUUID();
+  }
+  return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+};